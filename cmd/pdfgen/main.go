@@ -4,19 +4,210 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/jeffbrennan/pdfgen/internal/auth"
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/jobs"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/patch"
+	"github.com/jeffbrennan/pdfgen/internal/recipe"
+	"github.com/jeffbrennan/pdfgen/internal/search"
 	"github.com/jeffbrennan/pdfgen/internal/server"
+	"github.com/jeffbrennan/pdfgen/internal/store"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
 )
 
+const usage = `usage:
+  pdfgen [serve] [flags]     start the server
+  pdfgen config print [flags] print the effective config
+  pdfgen recipe check [flags] <owner>/<repo> <checkout dir>
+                             dry run a recipe's patches against a checkout
+
+run "pdfgen serve -h" to list all flags`
+
 func main() {
+	args := os.Args[1:]
+	command := "serve"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		command = args[0]
+		args = args[1:]
+	}
+
+	switch command {
+	case "serve":
+		serve(args)
+	case "config":
+		printConfig(args)
+	case "recipe":
+		checkRecipe(args)
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func loadConfig(name string, args []string) *config.Config {
+	cfg, err := config.Load(name, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config: %s\n", err)
+		os.Exit(2)
+	}
+	return cfg
+}
+
+func printConfig(args []string) {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg := loadConfig("config print", args[1:])
+	out, err := cfg.Print()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(out)
+}
+
+func checkRecipe(args []string) {
+	if len(args) < 3 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// flags come first, followed by the two positional arguments
+	positional := args[len(args)-2:]
+	cfg := loadConfig("recipe check", args[1:len(args)-2])
+
+	ownerRepo := strings.SplitN(positional[0], "/", 2)
+	if len(ownerRepo) != 2 {
+		fmt.Fprintf(os.Stderr, "expected <owner>/<repo>, got %s\n", positional[0])
+		os.Exit(2)
+	}
+	parts := &models.RepoParts{Owner: ownerRepo[0], Repo: ownerRepo[1]}
+	rootDir := positional[1]
+
+	rec, err := recipe.Load(cfg.Recipes, parts, rootDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report := patch.NewEngine(rootDir, true).Apply(rec.Patches)
+	for _, result := range report.Results {
+		fmt.Println(result.String())
+	}
+	fmt.Println(report.Summary())
+
+	if report.Err() != nil {
+		os.Exit(1)
+	}
+}
+
+func serve(args []string) {
+	cfg := loadConfig("serve", args)
+
+	// a missing sandbox would otherwise only fail the first build
+	_, err := utils.NewSandbox(cfg.Sandbox)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	artifacts, err := store.New(cfg.Store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go pruneArtifacts(artifacts, cfg.Store.Retention)
+
+	var index *search.Index
+	if cfg.Search.Index != "" {
+		index, err = search.Open(cfg.Search.Index)
+		if err != nil {
+			log.Printf("search disabled: %s", err)
+		} else {
+			defer index.Close()
+		}
+	}
+
+	var history *jobs.DB
+	if cfg.Jobs.DB != "" {
+		history, err = jobs.Open(cfg.Jobs.DB)
+		if err != nil {
+			log.Printf("job history disabled: %s", err)
+		} else {
+			defer history.Close()
+			recovered, err := history.RecoverInterrupted()
+			if err != nil {
+				log.Printf("error recovering interrupted jobs: %s", err)
+			} else if recovered > 0 {
+				log.Printf("marked %d jobs interrupted by the last shutdown as failed", recovered)
+			}
+		}
+	}
+
+	var tokens *auth.DB
+	if cfg.Auth.Enabled {
+		// an auth database that can't be opened mustn't leave the server open
+		tokens, err = auth.Open(cfg.Auth, artifacts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer tokens.Close()
+	}
+	srv := server.New(cfg, artifacts, index, history, tokens)
+
 	r := mux.NewRouter()
-	r.HandleFunc("/generate-pdf", server.GeneratePDFHandler).Methods("POST")
-	r.HandleFunc("/stream-logs", server.StreamLogsHandler)
+	r.HandleFunc("/generate", srv.Build(srv.GenerateHandler)).Methods("POST")
+	// kept for clients that predate output formats
+	r.HandleFunc("/generate-pdf", srv.Build(srv.GenerateHandler)).Methods("POST")
+	r.HandleFunc("/bundle", srv.Build(srv.BundleHandler)).Methods("POST")
+	r.HandleFunc("/diff", srv.Build(srv.DiffHandler)).Methods("POST")
+	r.HandleFunc("/search", srv.Require(auth.ScopeReadArtifacts, srv.SearchHandler)).Methods("GET")
+	r.HandleFunc("/artifacts/{id}", srv.Require(auth.ScopeReadArtifacts, srv.ArtifactHandler)).Methods("GET", "HEAD")
+	r.HandleFunc("/jobs", srv.Require(auth.ScopeReadArtifacts, srv.JobsHandler)).Methods("GET")
+	r.HandleFunc("/jobs/{id}", srv.Require(auth.ScopeReadArtifacts, srv.JobHandler)).Methods("GET")
+	r.HandleFunc("/jobs/{id}", srv.Require(auth.ScopeBuild, srv.CancelJobHandler)).Methods("DELETE")
+	r.HandleFunc("/jobs/{id}/log", srv.Require(auth.ScopeReadArtifacts, srv.JobLogHandler)).Methods("GET")
+	r.HandleFunc("/stream-logs", srv.Require(auth.ScopeBuild, srv.StreamLogsHandler))
+	if tokens != nil {
+		r.HandleFunc("/tokens", srv.Require(auth.ScopeAdmin, srv.TokensHandler)).Methods("GET")
+		r.HandleFunc("/tokens", srv.Require(auth.ScopeAdmin, srv.CreateTokenHandler)).Methods("POST")
+		r.HandleFunc("/tokens/{id}", srv.Require(auth.ScopeAdmin, srv.RevokeTokenHandler)).Methods("DELETE")
+	}
 
-	fs := http.FileServer(http.Dir("./static"))
-	r.PathPrefix("/").Handler(fs)
+	srv.APIRoutes(r.PathPrefix(server.APIPrefix).Subrouter())
+
+	fs := http.FileServer(http.Dir(cfg.Server.StaticDir))
+	r.PathPrefix("/").Handler(fs).Name(server.StaticRoute)
+	r.Use(srv.Protect)
+
+	httpServer := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Server.Port),
+		Handler:           r,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+	fmt.Printf("Starting server on %s\n", httpServer.Addr)
+	log.Fatal(httpServer.ListenAndServe())
+}
 
-	fmt.Println("Starting server on :8081")
-	log.Fatal(http.ListenAndServe(":8081", r))
+// pruneArtifacts deletes artifacts past their retention once an hour
+func pruneArtifacts(artifacts store.ArtifactStore, retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	for {
+		deleted, err := store.Prune(artifacts, retention)
+		if err != nil {
+			log.Printf("error pruning artifacts: %s", err)
+		} else if deleted > 0 {
+			log.Printf("pruned %d artifacts older than %s", deleted, retention)
+		}
+		time.Sleep(time.Hour)
+	}
 }
@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/store"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	ScopeBuild         = "build"
+	ScopeReadArtifacts = "read-artifacts"
+	// ScopeAdmin grants every other scope and manages tokens
+	ScopeAdmin = "admin"
+
+	// tokenPrefix marks issued tokens, so they are easy to spot in a leak
+	tokenPrefix = "pdfgen_"
+)
+
+var (
+	ErrUnauthorized = errors.New("invalid or missing token")
+	ErrNotFound     = errors.New("token not found")
+	ErrStatic       = errors.New("static tokens are removed from the config, not revoked")
+
+	// IDPattern matches token ids, the first 16 hex digits of the token's hash
+	IDPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+	// UserPattern is what user names may look like
+	UserPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,63}$`)
+
+	tokensBucket    = []byte("tokens")
+	buildsBucket    = []byte("builds")
+	artifactsBucket = []byte("artifacts")
+)
+
+// Token is what a bearer token grants. The token itself is only known to its holder,
+// tokens are looked up by their hash.
+type Token struct {
+	ID      string             `json:"id"`
+	User    string             `json:"user"`
+	Scopes  []string           `json:"scopes"`
+	Quota   config.QuotaConfig `json:"quota"`
+	Static  bool               `json:"static"`
+	Created time.Time          `json:"created"`
+}
+
+// Allows reports whether t grants scope, admin grants them all
+func (t Token) Allows(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// DB keeps issued tokens and the usage of every token in a bbolt file. Static tokens
+// come from the config and are only kept in memory.
+type DB struct {
+	db        *bolt.DB
+	artifacts store.ArtifactStore
+	static    map[string]Token
+
+	mu sync.Mutex
+	// running counts the jobs each token has running on this server
+	running map[string]int
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Open opens the token database at cfg.DB, creating it when missing. artifacts is where
+// the storage quota is measured.
+func Open(cfg config.AuthConfig, artifacts store.ArtifactStore) (*DB, error) {
+	err := os.MkdirAll(filepath.Dir(cfg.DB), 0755)
+	if err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(cfg.DB, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening token database %s: %s", cfg.DB, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{tokensBucket, buildsBucket, artifactsBucket} {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	d := &DB{db: db, artifacts: artifacts, static: map[string]Token{}, running: map[string]int{}}
+	for _, t := range cfg.Tokens {
+		h := hash(t.Token)
+		d.static[h] = Token{ID: h[:16], User: t.User, Scopes: t.Scopes, Quota: t.Quota, Static: true}
+	}
+	return d, nil
+}
+
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Authenticate returns the token of secret
+func (d *DB) Authenticate(secret string) (Token, error) {
+	if secret == "" {
+		return Token{}, ErrUnauthorized
+	}
+	h := hash(secret)
+	if t, ok := d.static[h]; ok {
+		return t, nil
+	}
+
+	var t Token
+	err := d.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(tokensBucket).Get([]byte(h))
+		if data == nil {
+			return ErrUnauthorized
+		}
+		return json.Unmarshal(data, &t)
+	})
+	return t, err
+}
+
+// Issue creates a token for user. The returned secret is the only copy, only its hash
+// is stored.
+func (d *DB) Issue(user string, scopes []string, quota config.QuotaConfig) (Token, string, error) {
+	if !UserPattern.MatchString(user) {
+		return Token{}, "", fmt.Errorf("invalid user: %q", user)
+	}
+	if len(scopes) == 0 {
+		return Token{}, "", fmt.Errorf("a token needs at least one scope")
+	}
+	for _, scope := range scopes {
+		if !utils.Contains(config.TokenScopes, scope) {
+			return Token{}, "", fmt.Errorf("unknown scope: %q", scope)
+		}
+	}
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return Token{}, "", err
+	}
+	secret := tokenPrefix + hex.EncodeToString(b)
+	h := hash(secret)
+	t := Token{ID: h[:16], User: user, Scopes: scopes, Quota: quota, Created: time.Now().UTC()}
+
+	data, err := json.Marshal(t)
+	if err != nil {
+		return Token{}, "", err
+	}
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(tokensBucket).Put([]byte(h), data)
+	})
+	return t, secret, err
+}
+
+// Tokens lists static and issued tokens, oldest first
+func (d *DB) Tokens() ([]Token, error) {
+	tokens := []Token{}
+	for _, t := range d.static {
+		tokens = append(tokens, t)
+	}
+	err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(tokensBucket).ForEach(func(k []byte, v []byte) error {
+			var t Token
+			err := json.Unmarshal(v, &t)
+			if err != nil {
+				return fmt.Errorf("error reading token %x: %s", k[:8], err)
+			}
+			tokens = append(tokens, t)
+			return nil
+		})
+	})
+	sort.SliceStable(tokens, func(i, j int) bool {
+		if tokens[i].Created.Equal(tokens[j].Created) {
+			return tokens[i].ID < tokens[j].ID
+		}
+		return tokens[i].Created.Before(tokens[j].Created)
+	})
+	return tokens, err
+}
+
+// Revoke deletes the issued token with id
+func (d *DB) Revoke(id string) error {
+	for _, t := range d.static {
+		if t.ID == id {
+			return ErrStatic
+		}
+	}
+	return d.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(tokensBucket).Cursor()
+		// ids are hash prefixes, so the token sorts right at its id
+		k, _ := c.Seek([]byte(id))
+		if k == nil || string(k[:len(id)]) != id {
+			return ErrNotFound
+		}
+		return c.Delete()
+	})
+}
+
+type tokenKey struct{}
+
+// WithToken returns a copy of ctx carrying the token of the request
+func WithToken(ctx context.Context, t Token) context.Context {
+	return context.WithValue(ctx, tokenKey{}, t)
+}
+
+// FromContext returns the token of ctx, false when auth is disabled
+func FromContext(ctx context.Context) (Token, bool) {
+	t, ok := ctx.Value(tokenKey{}).(Token)
+	return t, ok
+}
+
+// User returns the user of the token of ctx, "" when auth is disabled
+func User(ctx context.Context) string {
+	t, _ := FromContext(ctx)
+	return t.User
+}
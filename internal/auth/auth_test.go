@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/store"
+)
+
+const staticSecret = "0123456789abcdef-static"
+
+func openTestDB(t *testing.T, artifacts store.ArtifactStore) *DB {
+	dir := t.TempDir()
+	db, err := Open(config.AuthConfig{
+		DB: filepath.Join(dir, "auth.db"),
+		Tokens: []config.TokenConfig{
+			{User: "ops", Token: staticSecret, Scopes: []string{ScopeAdmin}},
+		},
+	}, artifacts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestTokens(t *testing.T) {
+	db := openTestDB(t, store.NewFS(t.TempDir()))
+
+	static, err := db.Authenticate(staticSecret)
+	if err != nil || static.User != "ops" || !static.Allows(ScopeBuild) {
+		t.Fatalf("expected the static admin token, got %+v: %v", static, err)
+	}
+
+	issued, secret, err := db.Issue("ada", []string{ScopeBuild}, config.QuotaConfig{BuildsPerDay: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, tokenPrefix) || !IDPattern.MatchString(issued.ID) {
+		t.Errorf("unexpected token %s with id %s", secret, issued.ID)
+	}
+
+	var tests = []struct {
+		name       string
+		secret     string
+		user       string
+		shouldPass bool
+	}{
+		{"static", staticSecret, "ops", true},
+		{"issued", secret, "ada", true},
+		{"unknown should fail", tokenPrefix + strings.Repeat("0", 64), "", false},
+		{"empty should fail", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := db.Authenticate(tt.secret)
+			if (err != nil) && tt.shouldPass {
+				t.Fatalf("should pass: got %v", err)
+			}
+			if (err == nil) && !tt.shouldPass {
+				t.Fatalf("should fail")
+			}
+			if token.User != tt.user {
+				t.Errorf("expected user %q, got %q", tt.user, token.User)
+			}
+		})
+	}
+
+	if issued.Allows(ScopeReadArtifacts) {
+		t.Errorf("expected a build token not to read artifacts")
+	}
+	_, _, err = db.Issue("ada", []string{"root"}, config.QuotaConfig{})
+	if err == nil {
+		t.Errorf("expected an unknown scope to fail")
+	}
+
+	tokens, err := db.Tokens()
+	if err != nil || len(tokens) != 2 {
+		t.Fatalf("expected 2 tokens, got %v: %v", tokens, err)
+	}
+
+	if err := db.Revoke(static.ID); !errors.Is(err, ErrStatic) {
+		t.Errorf("expected static tokens to stay, got %v", err)
+	}
+	if err := db.Revoke(issued.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Authenticate(secret); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected the revoked token to fail, got %v", err)
+	}
+	if err := db.Revoke(issued.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a second revoke to find nothing, got %v", err)
+	}
+}
+
+func TestBegin(t *testing.T) {
+	artifacts := store.NewFS(t.TempDir())
+	db := openTestDB(t, artifacts)
+
+	var tests = []struct {
+		name     string
+		quota    config.QuotaConfig
+		stored   int
+		finished int
+		running  int
+		expected string
+	}{
+		{"unlimited", config.QuotaConfig{}, 2 << 20, 10, 3, ""},
+		{"within quota", config.QuotaConfig{BuildsPerDay: 3, ConcurrentJobs: 2, StorageMB: 2}, 1 << 20, 1, 1, ""},
+		{"builds per day", config.QuotaConfig{BuildsPerDay: 2}, 0, 2, 0, "exceeded quota builds_per_day (2)"},
+		{"concurrent jobs", config.QuotaConfig{ConcurrentJobs: 2}, 0, 0, 2, "exceeded quota concurrent_jobs (2)"},
+		{"storage", config.QuotaConfig{StorageMB: 1}, 1 << 20, 0, 0, "exceeded quota storage_mb (1)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, _, err := db.Issue("ada", []string{ScopeBuild}, tt.quota)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.stored > 0 {
+				key := token.ID + "/out.pdf"
+				err = artifacts.Put(key, strings.NewReader(strings.Repeat("x", tt.stored)), store.Info{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = db.ChargeStorage(token, key, int64(tt.stored))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i := 0; i < tt.finished+tt.running; i++ {
+				end, err := db.Begin(token)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// the running builds end with the test
+				if i < tt.finished {
+					end()
+				} else {
+					defer end()
+				}
+			}
+
+			end, err := db.Begin(token)
+			if tt.expected == "" {
+				if err != nil {
+					t.Fatalf("should pass: got %v", err)
+				}
+				end()
+				return
+			}
+			var quotaErr *QuotaError
+			if !errors.As(err, &quotaErr) || err.Error() != tt.expected {
+				t.Fatalf("expected %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestStorageUsed(t *testing.T) {
+	artifacts := store.NewFS(t.TempDir())
+	db := openTestDB(t, artifacts)
+	token, _, err := db.Issue("ada", []string{ScopeBuild}, config.QuotaConfig{StorageMB: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"a/out.pdf", "b/out.pdf"} {
+		err = artifacts.Put(key, strings.NewReader(strings.Repeat("x", 1<<19)), store.Info{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.ChargeStorage(token, key, 1<<19)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Begin(token); err == nil {
+		t.Fatalf("expected the storage quota to be used up")
+	}
+
+	// pruned artifacts stop counting
+	err = artifacts.Delete("a/out.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage, err := db.Usage(token)
+	if err != nil || usage.StorageBytes != 1<<19 {
+		t.Fatalf("expected %d bytes used, got %+v: %v", 1<<19, usage, err)
+	}
+	end, err := db.Begin(token)
+	if err != nil {
+		t.Fatalf("expected the quota to be free again, got %v", err)
+	}
+	end()
+}
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffbrennan/pdfgen/internal/store"
+	bolt "go.etcd.io/bbolt"
+)
+
+// QuotaError is returned by Begin for a token that used up one of its quotas
+type QuotaError struct {
+	Name  string
+	Limit int
+	// RetryAfter is about when the quota frees up again
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("exceeded quota %s (%d)", e.Name, e.Limit)
+}
+
+// Usage is what a token used of its quota
+type Usage struct {
+	BuildsToday  int   `json:"builds_today"`
+	Running      int   `json:"running"`
+	StorageBytes int64 `json:"storage_bytes"`
+}
+
+func buildsKey(id string, day time.Time) []byte {
+	return []byte(id + "/" + day.UTC().Format(time.DateOnly))
+}
+
+// untilTomorrow is how long until the next UTC day, when the daily builds reset
+func untilTomorrow(now time.Time) time.Duration {
+	today := now.UTC().Truncate(24 * time.Hour)
+	return today.Add(24 * time.Hour).Sub(now)
+}
+
+// Begin counts a build of t against its quotas. The returned func ends the build and must
+// be called once it finished.
+func (d *DB) Begin(t Token) (func(), error) {
+	q := t.Quota
+
+	d.mu.Lock()
+	if q.ConcurrentJobs > 0 && d.running[t.ID] >= q.ConcurrentJobs {
+		d.mu.Unlock()
+		return nil, &QuotaError{Name: "concurrent_jobs", Limit: q.ConcurrentJobs, RetryAfter: 30 * time.Second}
+	}
+	d.running[t.ID]++
+	d.mu.Unlock()
+
+	var once sync.Once
+	end := func() {
+		once.Do(func() {
+			d.mu.Lock()
+			d.running[t.ID]--
+			if d.running[t.ID] <= 0 {
+				delete(d.running, t.ID)
+			}
+			d.mu.Unlock()
+		})
+	}
+
+	if q.StorageMB > 0 {
+		used, err := d.storageUsed(t.ID)
+		if err != nil {
+			end()
+			return nil, err
+		}
+		if used >= int64(q.StorageMB)<<20 {
+			end()
+			return nil, &QuotaError{Name: "storage_mb", Limit: q.StorageMB, RetryAfter: time.Hour}
+		}
+	}
+
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(buildsBucket)
+		now := time.Now()
+		key := buildsKey(t.ID, now)
+		count, _ := strconv.Atoi(string(bucket.Get(key)))
+		if q.BuildsPerDay > 0 && count >= q.BuildsPerDay {
+			return &QuotaError{Name: "builds_per_day", Limit: q.BuildsPerDay, RetryAfter: untilTomorrow(now)}
+		}
+		return bucket.Put(key, []byte(strconv.Itoa(count+1)))
+	})
+	if err != nil {
+		end()
+		return nil, err
+	}
+	return end, nil
+}
+
+// ChargeStorage counts the stored artifact at key against the storage quota of t. An
+// artifact is counted once per token, also when it came from the cache.
+func (d *DB) ChargeStorage(t Token, key string, size int64) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(artifactsBucket).Put([]byte(t.ID+"/"+key), []byte(strconv.FormatInt(size, 10)))
+	})
+}
+
+// storageUsed adds up the artifacts charged to id, dropping the ones pruned since
+func (d *DB) storageUsed(id string) (int64, error) {
+	prefix := []byte(id + "/")
+	var total int64
+	var pruned [][]byte
+	err := d.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(artifactsBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, v = c.Next() {
+			_, err := d.artifacts.Stat(strings.TrimPrefix(string(k), string(prefix)))
+			if errors.Is(err, store.ErrNotFound) {
+				pruned = append(pruned, append([]byte{}, k...))
+				continue
+			}
+			if err != nil {
+				log.Printf("error checking artifact %s: %s", k, err)
+			}
+			size, _ := strconv.ParseInt(string(v), 10, 64)
+			total += size
+		}
+		return nil
+	})
+	if err != nil || len(pruned) == 0 {
+		return total, err
+	}
+
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(artifactsBucket)
+		for _, k := range pruned {
+			err := bucket.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return total, err
+}
+
+// Usage returns what t used of its quota
+func (d *DB) Usage(t Token) (Usage, error) {
+	d.mu.Lock()
+	usage := Usage{Running: d.running[t.ID]}
+	d.mu.Unlock()
+
+	err := d.db.View(func(tx *bolt.Tx) error {
+		usage.BuildsToday, _ = strconv.Atoi(string(tx.Bucket(buildsBucket).Get(buildsKey(t.ID, time.Now()))))
+		return nil
+	})
+	if err != nil {
+		return usage, err
+	}
+	usage.StorageBytes, err = d.storageUsed(t.ID)
+	return usage, err
+}
@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/recipe"
+	"github.com/jeffbrennan/pdfgen/internal/store"
+)
+
+// Key identifies a build: the same commit, docs directory, recipe, and options give the
+// same artifact
+func Key(parts *models.RepoParts, commit string, rec *recipe.Recipe, opts models.BuildOptions) (string, error) {
+	if commit == "" {
+		return "", fmt.Errorf("builds without a commit can't be cached")
+	}
+
+	data, err := json.Marshal(struct {
+		Provider  string
+		Owner     string
+		Repo      string
+		Directory string
+		Commit    string
+		Recipe    *recipe.Recipe
+		Options   models.BuildOptions
+	}{parts.Provider, parts.Owner, parts.Repo, parts.Directory, commit, rec, opts})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// NewID returns a random id for artifacts that aren't cacheable builds, like bundles
+func NewID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Store uploads the artifact's file as <id>/<file> with what is known about it in the
+// metadata, and returns the artifact with ID and Key set
+func Store(artifacts store.ArtifactStore, id string, artifact models.Artifact) (models.Artifact, error) {
+	f, err := os.Open(artifact.Path)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+	defer f.Close()
+
+	documents, err := json.Marshal(artifact.Documents)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+	warnings, err := json.Marshal(artifact.Warnings)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+
+	artifact.Extension = filepath.Ext(artifact.Path)
+	artifact.ContentType = models.ContentTypes[artifact.Extension]
+	if artifact.ContentType == "" {
+		artifact.ContentType = "application/octet-stream"
+	}
+
+	key := id + "/" + filepath.Base(artifact.Path)
+	err = artifacts.Put(key, f, store.Info{
+		ContentType: artifact.ContentType,
+		Metadata: map[string]string{
+			"documents": string(documents),
+			"renderer":  artifact.Renderer,
+			"warnings":  string(warnings),
+			"degraded":  strconv.FormatBool(artifact.Degraded),
+			"commit":    artifact.Commit,
+		},
+	})
+	if err != nil {
+		return models.Artifact{}, err
+	}
+
+	// a rebuild can name its file differently, an id holds a single artifact
+	infos, err := artifacts.List(id + "/")
+	if err != nil {
+		return models.Artifact{}, err
+	}
+	for _, info := range infos {
+		if info.Key != key {
+			err = artifacts.Delete(info.Key)
+			if err != nil {
+				return models.Artifact{}, err
+			}
+		}
+	}
+
+	artifact.ID = id
+	artifact.Key = key
+	return artifact, nil
+}
+
+// Find returns the artifact stored under id along with when it was stored
+func Find(artifacts store.ArtifactStore, id string) (models.Artifact, time.Time, bool) {
+	infos, err := artifacts.List(id + "/")
+	if err != nil {
+		log.Printf("error looking up artifact %s: %s", id, err)
+		return models.Artifact{}, time.Time{}, false
+	}
+	if len(infos) == 0 {
+		return models.Artifact{}, time.Time{}, false
+	}
+	info, err := artifacts.Stat(infos[0].Key)
+	if err != nil {
+		log.Printf("error looking up artifact %s: %s", id, err)
+		return models.Artifact{}, time.Time{}, false
+	}
+
+	artifact := models.Artifact{
+		ID:          id,
+		Key:         info.Key,
+		Commit:      info.Metadata["commit"],
+		Renderer:    info.Metadata["renderer"],
+		Degraded:    info.Metadata["degraded"] == "true",
+		Extension:   path.Ext(info.Key),
+		ContentType: info.ContentType,
+	}
+	err = errors.Join(
+		json.Unmarshal([]byte(info.Metadata["documents"]), &artifact.Documents),
+		json.Unmarshal([]byte(info.Metadata["warnings"]), &artifact.Warnings),
+	)
+	if err != nil {
+		log.Printf("error reading metadata of artifact %s: %s", id, err)
+		return models.Artifact{}, time.Time{}, false
+	}
+	return artifact, info.ModTime, true
+}
+
+// Load returns the cached build for key. Degraded builds and entries older than MaxAge
+// miss, so they are built again.
+func Load(artifacts store.ArtifactStore, cfg config.CacheConfig, key string) (models.Artifact, bool) {
+	if !cfg.Enabled || key == "" {
+		return models.Artifact{}, false
+	}
+
+	artifact, stored, ok := Find(artifacts, key)
+	if !ok {
+		return models.Artifact{}, false
+	}
+	if artifact.Degraded {
+		return models.Artifact{}, false
+	}
+	if cfg.MaxAge > 0 && time.Since(stored) > cfg.MaxAge {
+		log.Printf("cache entry %s expired", key)
+		return models.Artifact{}, false
+	}
+	return artifact, true
+}
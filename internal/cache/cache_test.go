@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/recipe"
+	"github.com/jeffbrennan/pdfgen/internal/store"
+)
+
+func TestKey(t *testing.T) {
+	parts := &models.RepoParts{Provider: "github.com", Owner: "apache", Repo: "airflow", Directory: "docs"}
+	base, err := Key(parts, "abc123", &recipe.Recipe{}, models.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherDir := *parts
+	otherDir.Directory = "providers/docs"
+
+	var tests = []struct {
+		name   string
+		parts  *models.RepoParts
+		commit string
+		rec    *recipe.Recipe
+		opts   models.BuildOptions
+		same   bool
+	}{
+		{"same build", parts, "abc123", &recipe.Recipe{}, models.BuildOptions{}, true},
+		{"new commit", parts, "def456", &recipe.Recipe{}, models.BuildOptions{}, false},
+		{"other directory", &otherDir, "abc123", &recipe.Recipe{}, models.BuildOptions{}, false},
+		{"changed recipe", parts, "abc123", &recipe.Recipe{Group: "doc"}, models.BuildOptions{}, false},
+		{"other options", parts, "abc123", &recipe.Recipe{}, models.BuildOptions{Cover: true}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := Key(tt.parts, tt.commit, tt.rec, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (key == base) != tt.same {
+				t.Errorf("expected same=%v, got %s and %s", tt.same, key, base)
+			}
+		})
+	}
+
+	_, err = Key(parts, "", &recipe.Recipe{}, models.BuildOptions{})
+	if err == nil {
+		t.Errorf("expected a key without a commit to fail")
+	}
+}
+
+func TestStoreLoad(t *testing.T) {
+	dir := t.TempDir()
+	artifactPath := filepath.Join(dir, "airflow.pdf")
+	err := os.WriteFile(artifactPath, []byte("%PDF-1.7"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	artifacts := store.NewFS(filepath.Join(dir, "artifacts"))
+	cfg := config.CacheConfig{Enabled: true, MaxAge: time.Hour}
+	artifact := models.Artifact{
+		Path:      artifactPath,
+		Renderer:  models.RendererLatex,
+		Documents: []models.Document{{Name: "index", Title: "Airflow"}},
+		Commit:    "abc123",
+	}
+
+	stored, err := Store(artifacts, "key", artifact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ID != "key" || stored.Key != "key/airflow.pdf" || stored.ContentType != "application/pdf" {
+		t.Errorf("unexpected stored artifact %+v", stored)
+	}
+	// the build directory is removed after every request
+	os.Remove(artifactPath)
+
+	cached, ok := Load(artifacts, cfg, "key")
+	if !ok {
+		t.Fatal("expected a cache hit")
+	}
+	r, _, err := artifacts.Get(cached.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != "%PDF-1.7" {
+		t.Errorf("unexpected cached file %q: %v", data, err)
+	}
+	if cached.Key != "key/airflow.pdf" || cached.Extension != ".pdf" || cached.Commit != "abc123" ||
+		cached.Renderer != models.RendererLatex || len(cached.Documents) != 1 || cached.Path != "" {
+		t.Errorf("unexpected cached artifact %+v", cached)
+	}
+
+	if _, ok := Load(artifacts, cfg, "other"); ok {
+		t.Errorf("expected a miss for an unknown key")
+	}
+	if _, ok := Load(artifacts, config.CacheConfig{Enabled: true, MaxAge: time.Nanosecond}, "key"); ok {
+		t.Errorf("expected an expired entry to miss")
+	}
+	if _, ok := Load(artifacts, config.CacheConfig{}, "key"); ok {
+		t.Errorf("expected a disabled cache to miss")
+	}
+
+	// a degraded rebuild under another file name replaces the entry
+	degradedPath := filepath.Join(dir, "airflow-degraded.pdf")
+	err = os.WriteFile(degradedPath, []byte("%PDF-1.7 partial"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifact.Path = degradedPath
+	artifact.Degraded = true
+	_, err = Store(artifacts, "key", artifact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Load(artifacts, cfg, "key"); ok {
+		t.Errorf("expected degraded builds not to be served from the cache")
+	}
+	found, _, ok := Find(artifacts, "key")
+	if !ok || found.Key != "key/airflow-degraded.pdf" || !found.Degraded {
+		t.Errorf("expected only the degraded artifact under the id, got %+v", found)
+	}
+}
@@ -0,0 +1,621 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const EnvPrefix = "PDFGEN_"
+
+type Config struct {
+	Server     ServerConfig     `yaml:"server"`
+	RateLimit  RateLimitConfig  `yaml:"rate_limit"`
+	Repo       RepoConfig       `yaml:"repo"`
+	Validation ValidationConfig `yaml:"validation"`
+	Sphinx     SphinxConfig     `yaml:"sphinx"`
+	Latex      LatexConfig      `yaml:"latex"`
+	Recipes    RecipesConfig    `yaml:"recipes"`
+	HTML       HTMLConfig       `yaml:"html"`
+	Store      StoreConfig      `yaml:"store"`
+	Cache      CacheConfig      `yaml:"cache"`
+	Search     SearchConfig     `yaml:"search"`
+	Jobs       JobsConfig       `yaml:"jobs"`
+	Limits     LimitsConfig     `yaml:"limits"`
+	Sandbox    SandboxConfig    `yaml:"sandbox"`
+	Auth       AuthConfig       `yaml:"auth"`
+	// Path is the file the config was loaded from, if any. It holds secrets.
+	Path string `yaml:"-"`
+}
+
+type ServerConfig struct {
+	Port      int    `yaml:"port"`
+	StaticDir string `yaml:"static_dir"`
+	// the timeouts of every connection, builds and log streams lift the write timeout
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	// MaxBodyKB bounds request bodies
+	MaxBodyKB int `yaml:"max_body_kb"`
+	// MaxStreams bounds the open /stream-logs connections, in all and per client IP.
+	// StreamMaxAge closes streams after a while, EventSource reconnects on its own.
+	MaxStreams      int           `yaml:"max_streams"`
+	MaxStreamsPerIP int           `yaml:"max_streams_per_ip"`
+	StreamMaxAge    time.Duration `yaml:"stream_max_age"`
+}
+
+// RateLimitConfig is a token bucket per client IP and per API token. The rates are
+// requests per minute, Burst is how many may come at once. A rate of 0 disables it.
+type RateLimitConfig struct {
+	PerIP    float64 `yaml:"per_ip"`
+	PerToken float64 `yaml:"per_token"`
+	Burst    int     `yaml:"burst"`
+	// TrustProxy takes the client IP from the last X-Forwarded-For entry, only set it
+	// behind a proxy that sets the header
+	TrustProxy bool `yaml:"trust_proxy"`
+}
+
+type RepoConfig struct {
+	// checkouts live in Dir/<owner>/<repo>/<job> and are removed from there after a build,
+	// so jobs building the same repo at once each have their own
+	Dir string `yaml:"dir"`
+}
+
+type ValidationConfig struct {
+	MinStars        int     `yaml:"min_stars"`
+	MinStarsNewRepo int     `yaml:"min_stars_new_repo"`
+	MinAgeYears     float64 `yaml:"min_age_years"`
+}
+
+type SphinxConfig struct {
+	DocsGroup string `yaml:"docs_group"`
+}
+
+type LatexConfig struct {
+	// Engine is used when neither the recipe nor conf.py choose one
+	Engine  string `yaml:"engine"`
+	Latexmk bool   `yaml:"latexmk"`
+	// MaxPasses bounds the engine reruns when latexmk is disabled or not installed
+	MaxPasses int `yaml:"max_passes"`
+}
+
+type RecipesConfig struct {
+	// server side recipes are looked up as CatalogDir/<owner>/<repo>.yml
+	CatalogDir string `yaml:"catalog_dir"`
+}
+
+type HTMLConfig struct {
+	// Engine renders HTML to PDF, one of HTMLEngines
+	Engine string `yaml:"engine"`
+	// PrintCSS replaces the built-in print stylesheet
+	PrintCSS string `yaml:"print_css"`
+	// Fallback renders HTML when a LaTeX build fails and no renderer was requested
+	Fallback bool `yaml:"fallback"`
+}
+
+type StoreConfig struct {
+	// Backend is one of StoreBackends
+	Backend string `yaml:"backend"`
+	// Dir holds the artifacts of the fs backend
+	Dir string `yaml:"dir"`
+	// Retention deletes artifacts older than this, 0 keeps them forever
+	Retention time.Duration `yaml:"retention"`
+	S3        S3Config      `yaml:"s3"`
+}
+
+type S3Config struct {
+	// Endpoint is the service's base url, e.g. https://s3.eu-west-1.amazonaws.com or
+	// http://localhost:9000 for MinIO. Buckets are addressed path-style.
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+}
+
+type CacheConfig struct {
+	// Enabled serves finished artifacts from the store when the same build is requested again
+	Enabled bool `yaml:"enabled"`
+	// MaxAge is how long an entry is served, 0 keeps entries forever
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+type SearchConfig struct {
+	// Index is the full-text index of cached PDFs, empty disables search
+	Index string `yaml:"index"`
+}
+
+type JobsConfig struct {
+	// DB records every build for GET /jobs, empty disables the history
+	DB string `yaml:"db"`
+}
+
+// LimitsConfig bounds what a single build can use, 0 disables a limit
+type LimitsConfig struct {
+	// Total bounds a whole build, the others a single stage of it. Build covers the docs
+	// build including LaTeX, Latex every single LaTeX run.
+	Total time.Duration `yaml:"total"`
+	Clone time.Duration `yaml:"clone"`
+	Env   time.Duration `yaml:"env"`
+	Build time.Duration `yaml:"build"`
+	Latex time.Duration `yaml:"latex"`
+	// CPUSeconds, MemoryMB, FileSizeMB, and Processes are rlimits of every command. They
+	// hold per process, Processes counts every process of the server's user.
+	CPUSeconds int `yaml:"cpu_seconds"`
+	MemoryMB   int `yaml:"memory_mb"`
+	FileSizeMB int `yaml:"file_size_mb"`
+	Processes  int `yaml:"processes"`
+	// CheckoutMB stops clones that grow past this size
+	CheckoutMB int `yaml:"checkout_mb"`
+}
+
+// SandboxConfig isolates the commands that run repository code: conf.py, build backends,
+// pre-build steps, and the renderers
+type SandboxConfig struct {
+	// Backend is none, running commands as the server, or bwrap
+	Backend string `yaml:"backend"`
+	// UID and GID are the user commands run as inside the sandbox
+	UID int `yaml:"uid"`
+	GID int `yaml:"gid"`
+	// Writable directories besides the checkout
+	Writable []string `yaml:"writable"`
+	// Hidden paths are emptied in the sandbox, on top of the repo, store, and database directories
+	Hidden []string `yaml:"hidden"`
+}
+
+// AuthConfig requires a bearer token on every API request when enabled
+type AuthConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// DB keeps issued tokens and what each token used
+	DB string `yaml:"db"`
+	// Tokens are static, they can't be revoked through the API
+	Tokens []TokenConfig `yaml:"tokens"`
+	// DefaultQuota applies to tokens issued without a quota of their own
+	DefaultQuota QuotaConfig `yaml:"default_quota"`
+}
+
+type TokenConfig struct {
+	User   string      `yaml:"user"`
+	Token  string      `yaml:"token"`
+	Scopes []string    `yaml:"scopes"`
+	Quota  QuotaConfig `yaml:"quota"`
+}
+
+// QuotaConfig bounds what a single token can use, 0 is unlimited
+type QuotaConfig struct {
+	// BuildsPerDay counts builds started per UTC day
+	BuildsPerDay   int `yaml:"builds_per_day"`
+	ConcurrentJobs int `yaml:"concurrent_jobs"`
+	// StorageMB is the size of the stored artifacts the token built
+	StorageMB int `yaml:"storage_mb"`
+}
+
+var TokenScopes = []string{"build", "read-artifacts", "admin"}
+
+// minTokenLength keeps static tokens from being guessable
+const minTokenLength = 16
+
+var SandboxBackends = []string{"none", "bwrap"}
+
+var StoreBackends = []string{"fs", "s3"}
+
+var HTMLEngines = []string{"weasyprint", "chromium"}
+
+var LatexEngines = []string{"pdflatex", "xelatex", "lualatex", "platex", "uplatex"}
+
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:              8081,
+			StaticDir:         "./static",
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      10 * time.Minute,
+			IdleTimeout:       2 * time.Minute,
+			MaxBodyKB:         1024,
+			MaxStreams:        100,
+			MaxStreamsPerIP:   4,
+			StreamMaxAge:      time.Hour,
+		},
+		RateLimit: RateLimitConfig{
+			PerIP:    60,
+			PerToken: 120,
+			Burst:    20,
+		},
+		Repo: RepoConfig{
+			Dir: "./repos",
+		},
+		Validation: ValidationConfig{
+			MinStars:        100,
+			MinStarsNewRepo: 1000,
+			MinAgeYears:     1.0,
+		},
+		Sphinx: SphinxConfig{
+			DocsGroup: "docs",
+		},
+		Latex: LatexConfig{
+			Engine:    "pdflatex",
+			Latexmk:   true,
+			MaxPasses: 4,
+		},
+		Recipes: RecipesConfig{
+			CatalogDir: "./recipes",
+		},
+		HTML: HTMLConfig{
+			Engine:   "weasyprint",
+			Fallback: true,
+		},
+		Store: StoreConfig{
+			Backend:   "fs",
+			Dir:       "./artifacts",
+			Retention: 30 * 24 * time.Hour,
+			S3: S3Config{
+				Region: "us-east-1",
+			},
+		},
+		Cache: CacheConfig{
+			Enabled: true,
+			MaxAge:  7 * 24 * time.Hour,
+		},
+		Search: SearchConfig{
+			Index: "./artifacts/search.db",
+		},
+		Jobs: JobsConfig{
+			DB: "./artifacts/jobs.db",
+		},
+		Limits: LimitsConfig{
+			Total:      time.Hour,
+			Clone:      10 * time.Minute,
+			Env:        15 * time.Minute,
+			Build:      30 * time.Minute,
+			Latex:      10 * time.Minute,
+			CPUSeconds: 1800,
+			FileSizeMB: 1024,
+			Processes:  1024,
+			CheckoutMB: 2048,
+		},
+		Sandbox: SandboxConfig{
+			Backend: "none",
+			UID:     65534,
+			GID:     65534,
+			Hidden:  []string{"/run/secrets"},
+		},
+		Auth: AuthConfig{
+			DB: "./artifacts/auth.db",
+		},
+	}
+}
+
+// Load builds the effective config in order of precedence:
+// defaults < config file < PDFGEN_* env vars < command line flags.
+// The config file is taken from -config, then PDFGEN_CONFIG, then ./pdfgen.yml if present.
+func Load(name string, args []string) (*Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to a YAML config file")
+	flagValues := map[string]*string{}
+	for _, s := range cfg.settings() {
+		flagValues[s.key] = fs.String(s.key, "", fmt.Sprintf("%s (default %v)", s.key, s.value.Interface()))
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	path := *configPath
+	if path == "" {
+		path = os.Getenv(EnvPrefix + "CONFIG")
+	}
+	if path == "" {
+		if _, err := os.Stat("pdfgen.yml"); err == nil {
+			path = "pdfgen.yml"
+		}
+	}
+
+	if path != "" {
+		err = cfg.loadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Path = path
+	}
+
+	for _, s := range cfg.settings() {
+		v, ok := os.LookupEnv(s.envName())
+		if !ok {
+			continue
+		}
+		err = s.set(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", s.envName(), err)
+		}
+	}
+
+	setFlags := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	for _, s := range cfg.settings() {
+		if !setFlags[s.key] {
+			continue
+		}
+		err = s.set(*flagValues[s.key])
+		if err != nil {
+			return nil, fmt.Errorf("invalid -%s: %s", s.key, err)
+		}
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %s", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err = dec.Decode(c)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error parsing config file %s: %s", path, err)
+	}
+	return nil
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port must be between 1 and 65535, got %d", c.Server.Port))
+	}
+	if c.Server.StaticDir == "" {
+		errs = append(errs, fmt.Errorf("server.static_dir is required"))
+	}
+	sc := c.Server
+	if sc.ReadHeaderTimeout < 0 || sc.ReadTimeout < 0 || sc.WriteTimeout < 0 || sc.IdleTimeout < 0 || sc.StreamMaxAge < 0 {
+		errs = append(errs, fmt.Errorf("server timeouts must not be negative"))
+	}
+	if sc.MaxBodyKB < 1 {
+		errs = append(errs, fmt.Errorf("server.max_body_kb must be at least 1, got %d", sc.MaxBodyKB))
+	}
+	if sc.MaxStreams < 0 || sc.MaxStreamsPerIP < 0 {
+		errs = append(errs, fmt.Errorf("server stream caps must not be negative"))
+	}
+	if c.RateLimit.PerIP < 0 || c.RateLimit.PerToken < 0 {
+		errs = append(errs, fmt.Errorf("rate_limit rates must not be negative"))
+	}
+	if (c.RateLimit.PerIP > 0 || c.RateLimit.PerToken > 0) && c.RateLimit.Burst < 1 {
+		errs = append(errs, fmt.Errorf("rate_limit.burst must be at least 1 when rate limiting, got %d", c.RateLimit.Burst))
+	}
+	if c.Repo.Dir == "" {
+		errs = append(errs, fmt.Errorf("repo.dir is required"))
+	}
+	if c.Validation.MinStars < 0 || c.Validation.MinStarsNewRepo < 0 {
+		errs = append(errs, fmt.Errorf("validation star thresholds must not be negative"))
+	}
+	if c.Validation.MinAgeYears < 0 {
+		errs = append(errs, fmt.Errorf("validation.min_age_years must not be negative"))
+	}
+	if c.Sphinx.DocsGroup == "" {
+		errs = append(errs, fmt.Errorf("sphinx.docs_group is required"))
+	}
+	if c.Latex.MaxPasses < 2 {
+		errs = append(errs, fmt.Errorf("latex.max_passes must be at least 2, got %d", c.Latex.MaxPasses))
+	}
+	if !contains(LatexEngines, c.Latex.Engine) {
+		errs = append(errs, fmt.Errorf("latex.engine must be one of %s, got %q", strings.Join(LatexEngines, ", "), c.Latex.Engine))
+	}
+
+	if !contains(HTMLEngines, c.HTML.Engine) {
+		errs = append(errs, fmt.Errorf("html.engine must be one of %s, got %q", strings.Join(HTMLEngines, ", "), c.HTML.Engine))
+	}
+
+	switch c.Store.Backend {
+	case "fs":
+		if c.Store.Dir == "" {
+			errs = append(errs, fmt.Errorf("store.dir is required for the fs backend"))
+		}
+	case "s3":
+		if c.Store.S3.Endpoint == "" || c.Store.S3.Bucket == "" {
+			errs = append(errs, fmt.Errorf("store.s3.endpoint and store.s3.bucket are required for the s3 backend"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("store.backend must be one of %s, got %q", strings.Join(StoreBackends, ", "), c.Store.Backend))
+	}
+	if c.Store.Retention < 0 {
+		errs = append(errs, fmt.Errorf("store.retention must not be negative"))
+	}
+
+	if c.Cache.MaxAge < 0 {
+		errs = append(errs, fmt.Errorf("cache.max_age must not be negative"))
+	}
+
+	l := c.Limits
+	if l.Total < 0 || l.Clone < 0 || l.Env < 0 || l.Build < 0 || l.Latex < 0 {
+		errs = append(errs, fmt.Errorf("limits timeouts must not be negative"))
+	}
+	if l.CPUSeconds < 0 || l.MemoryMB < 0 || l.FileSizeMB < 0 || l.Processes < 0 || l.CheckoutMB < 0 {
+		errs = append(errs, fmt.Errorf("limits must not be negative"))
+	}
+
+	if !contains(SandboxBackends, c.Sandbox.Backend) {
+		errs = append(errs, fmt.Errorf("sandbox.backend must be one of %s, got %q", strings.Join(SandboxBackends, ", "), c.Sandbox.Backend))
+	}
+	if c.Sandbox.UID < 0 || c.Sandbox.GID < 0 {
+		errs = append(errs, fmt.Errorf("sandbox.uid and sandbox.gid must not be negative"))
+	}
+
+	errs = append(errs, c.Auth.validate()...)
+
+	return errors.Join(errs...)
+}
+
+func (a AuthConfig) validate() []error {
+	var errs []error
+	if a.Enabled && a.DB == "" {
+		errs = append(errs, fmt.Errorf("auth.db is required when auth is enabled"))
+	}
+	if a.Enabled && len(a.Tokens) == 0 {
+		errs = append(errs, fmt.Errorf("auth.tokens needs at least one token when auth is enabled"))
+	}
+	for i, t := range a.Tokens {
+		if t.User == "" {
+			errs = append(errs, fmt.Errorf("auth.tokens[%d].user is required", i))
+		}
+		if len(t.Token) < minTokenLength {
+			errs = append(errs, fmt.Errorf("auth.tokens[%d].token must be at least %d characters", i, minTokenLength))
+		}
+		for _, scope := range t.Scopes {
+			if !contains(TokenScopes, scope) {
+				errs = append(errs, fmt.Errorf("auth.tokens[%d].scopes must be of %s, got %q", i, strings.Join(TokenScopes, ", "), scope))
+			}
+		}
+		if t.Quota.negative() {
+			errs = append(errs, fmt.Errorf("auth.tokens[%d].quota must not be negative", i))
+		}
+	}
+	if a.DefaultQuota.negative() {
+		errs = append(errs, fmt.Errorf("auth.default_quota must not be negative"))
+	}
+	return errs
+}
+
+func (q QuotaConfig) negative() bool {
+	return q.BuildsPerDay < 0 || q.ConcurrentJobs < 0 || q.StorageMB < 0
+}
+
+const redacted = "<redacted>"
+
+// Print returns the config as yaml with its secrets redacted, the dump ends up in
+// terminals and CI logs
+func (c *Config) Print() (string, error) {
+	printed := *c
+	if printed.Store.S3.SecretKey != "" {
+		printed.Store.S3.SecretKey = redacted
+	}
+	printed.Auth.Tokens = make([]TokenConfig, len(c.Auth.Tokens))
+	for i, t := range c.Auth.Tokens {
+		if t.Token != "" {
+			t.Token = redacted
+		}
+		printed.Auth.Tokens[i] = t
+	}
+	out, err := yaml.Marshal(&printed)
+	return string(out), err
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// setting is a single leaf field addressed by its dotted yaml path, e.g. server.port
+type setting struct {
+	key   string
+	value reflect.Value
+}
+
+func (s setting) envName() string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(s.key, ".", "_"))
+}
+
+func (s setting) set(raw string) error {
+	if s.value.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(int64(d))
+		return nil
+	}
+
+	switch s.value.Kind() {
+	case reflect.String:
+		s.value.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		s.value.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		s.value.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+		s.value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", s.value.Kind())
+	}
+	return nil
+}
+
+func (c *Config) settings() []setting {
+	return collectSettings("", reflect.ValueOf(c).Elem())
+}
+
+func collectSettings(prefix string, v reflect.Value) []setting {
+	var out []setting
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			out = append(out, collectSettings(key, field)...)
+			continue
+		}
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.String {
+			// lists of structs are file-only
+			continue
+		}
+		if field.Kind() == reflect.Map {
+			continue
+		}
+		out = append(out, setting{key: key, value: field})
+	}
+	return out
+}
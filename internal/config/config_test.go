@@ -0,0 +1,126 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "pdfgen.yml")
+	err := os.WriteFile(configPath, []byte("server:\n  port: 9000\nlatex:\n  engine: xelatex\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name       string
+		args       []string
+		env        map[string]string
+		port       int
+		engine     string
+		shouldPass bool
+	}{
+		{"defaults", []string{}, nil, 8081, "pdflatex", true},
+		{"file overrides defaults", []string{"-config", configPath}, nil, 9000, "xelatex", true},
+		{
+			"env overrides file",
+			[]string{"-config", configPath},
+			map[string]string{"PDFGEN_SERVER_PORT": "9001"},
+			9001, "xelatex", true,
+		},
+		{
+			"flags override env",
+			[]string{"-config", configPath, "-server.port", "9002", "-latex.engine", "lualatex"},
+			map[string]string{"PDFGEN_SERVER_PORT": "9001"},
+			9002, "lualatex", true,
+		},
+		{"invalid engine should fail", []string{"-latex.engine", "troff"}, nil, 0, "", false},
+		{"invalid port should fail", []string{"-server.port", "70000"}, nil, 0, "", false},
+		{"non numeric env should fail", []string{}, map[string]string{"PDFGEN_SERVER_PORT": "abc"}, 0, "", false},
+		{"negative limit should fail", []string{"-limits.latex", "-1m"}, nil, 0, "", false},
+		{"unknown sandbox should fail", []string{"-sandbox.backend", "chroot"}, nil, 0, "", false},
+		{"auth without tokens should fail", []string{"-auth.enabled", "true"}, nil, 0, "", false},
+		{"rate limit from env", []string{}, map[string]string{"PDFGEN_RATE_LIMIT_PER_IP": "30.5"}, 8081, "pdflatex", true},
+		{"rate limit without burst should fail", []string{"-rate_limit.burst", "0"}, nil, 0, "", false},
+		{"empty body limit should fail", []string{"-server.max_body_kb", "0"}, nil, 0, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			cfg, err := Load("test", tt.args)
+			if (err != nil) && tt.shouldPass {
+				t.Fatalf("should pass: got %v", err)
+			}
+			if (err == nil) && !tt.shouldPass {
+				t.Fatalf("should fail")
+			}
+			if !tt.shouldPass {
+				return
+			}
+
+			if cfg.Server.Port != tt.port {
+				t.Errorf("expected port %d, got %d", tt.port, cfg.Server.Port)
+			}
+			if cfg.Latex.Engine != tt.engine {
+				t.Errorf("expected engine %s, got %s", tt.engine, cfg.Latex.Engine)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "pdfgen.yml")
+	err := os.WriteFile(configPath, []byte("server:\n  prot: 9000\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Load("test", []string{"-config", configPath})
+	if err == nil {
+		t.Errorf("expected unknown key to fail")
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "pdfgen.yml")
+	err := os.WriteFile(configPath, []byte(`store:
+  s3:
+    access_key: AKIAEXAMPLE
+    secret_key: s3cr3t-key
+auth:
+  tokens:
+    - user: ada
+      token: adm1n-t0ken-0123456789
+      scopes: [admin]
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load("test", []string{"-config", configPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := cfg.Print()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"s3cr3t-key", "adm1n-t0ken-0123456789"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("expected %s to be redacted in %s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "user: ada") || !strings.Contains(out, "access_key: AKIAEXAMPLE") {
+		t.Errorf("expected the rest of the config to be printed, got %s", out)
+	}
+	if cfg.Store.S3.SecretKey != "s3cr3t-key" || cfg.Auth.Tokens[0].Token != "adm1n-t0ken-0123456789" {
+		t.Errorf("expected printing to leave the config alone, got %+v", cfg)
+	}
+}
@@ -0,0 +1,302 @@
+package diff
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jeffbrennan/pdfgen/internal/pdf"
+)
+
+const (
+	Added    = "added"
+	Removed  = "removed"
+	Modified = "modified"
+
+	// maxSamples bounds the example lines kept per change
+	maxSamples = 5
+)
+
+// Section is the text under one outline entry
+type Section struct {
+	Title string
+	Level int
+	Page  int
+	// Key matches the section across versions: the titles of the section and its
+	// ancestors without their numbers
+	Key   string
+	Lines []string
+}
+
+// Change is a section that was added, removed, or whose text changed
+type Change struct {
+	Kind         string   `json:"kind"`
+	Title        string   `json:"title"`
+	Level        int      `json:"level"`
+	BasePage     int      `json:"base_page,omitempty"`
+	HeadPage     int      `json:"head_page,omitempty"`
+	AddedLines   int      `json:"added_lines"`
+	RemovedLines int      `json:"removed_lines"`
+	Added        []string `json:"added,omitempty"`
+	Removed      []string `json:"removed,omitempty"`
+}
+
+// Document is the extracted text and outline of one version
+type Document struct {
+	Outline []pdf.OutlineEntry
+	Pages   []string
+}
+
+var (
+	spaceRe     = regexp.MustCompile(`\s+`)
+	numberingRe = regexp.MustCompile(`^(?:[0-9]+(?:\.[0-9]+)*\.?|[A-Z]\.(?:[0-9]+\.?)*)\s+`)
+)
+
+func normalizeLine(line string) string {
+	return strings.TrimSpace(spaceRe.ReplaceAllString(line, " "))
+}
+
+func normalizeTitle(title string) string {
+	return strings.ToLower(numberingRe.ReplaceAllString(normalizeLine(title), ""))
+}
+
+// boilerplate finds running headers and footers: lines repeated on most pages
+func boilerplate(pages []string) map[string]bool {
+	repeated := map[string]bool{}
+	if len(pages) < 4 {
+		return repeated
+	}
+
+	counts := map[string]int{}
+	for _, page := range pages {
+		seen := map[string]bool{}
+		for _, line := range strings.Split(page, "\n") {
+			line = normalizeLine(line)
+			if line != "" && !seen[line] {
+				seen[line] = true
+				counts[line]++
+			}
+		}
+	}
+	for line, n := range counts {
+		if n*2 > len(pages) {
+			repeated[line] = true
+		}
+	}
+	return repeated
+}
+
+// pageLines returns the meaningful lines of every page: no blank lines, bare page
+// numbers, or running headers
+func pageLines(pages []string) [][]string {
+	skip := boilerplate(pages)
+	out := make([][]string, len(pages))
+	for i, page := range pages {
+		for _, line := range strings.Split(page, "\n") {
+			line = normalizeLine(line)
+			if line == "" || skip[line] || isPageNumber(line) {
+				continue
+			}
+			out[i] = append(out[i], line)
+		}
+	}
+	return out
+}
+
+func isPageNumber(line string) bool {
+	return strings.Trim(line, "0123456789ivxlcdm ") == ""
+}
+
+// Sections cuts the document's text at its outline entries. A section runs from the line
+// with its title to the line with the next entry's title, or to whole page boundaries
+// when the titles can't be found in the text.
+func Sections(doc Document) []Section {
+	lines := pageLines(doc.Pages)
+
+	var sections []Section
+	var ancestors []string
+	for _, entry := range doc.Outline {
+		if entry.Page < 1 || entry.Page > len(lines) {
+			continue
+		}
+		if entry.Level-1 < len(ancestors) {
+			ancestors = ancestors[:entry.Level-1]
+		}
+		for len(ancestors) < entry.Level-1 {
+			ancestors = append(ancestors, "")
+		}
+		ancestors = append(ancestors, normalizeTitle(entry.Title))
+		sections = append(sections, Section{
+			Title: entry.Title,
+			Level: entry.Level,
+			Page:  entry.Page,
+			Key:   strings.Join(ancestors, " / "),
+		})
+	}
+
+	// start positions as (page, line) pairs
+	type position struct{ page, line int }
+	starts := make([]position, len(sections))
+	for i, section := range sections {
+		starts[i] = position{section.Page - 1, findTitle(lines[section.Page-1], section.Title)}
+	}
+	for i := range sections {
+		start := starts[i]
+		end := position{len(lines) - 1, len(lines[len(lines)-1])}
+		if i+1 < len(sections) {
+			end = starts[i+1]
+			if end.page < start.page || (end.page == start.page && end.line < start.line) {
+				end = start
+			}
+		}
+
+		var text []string
+		for page := start.page; page <= end.page; page++ {
+			from, to := 0, len(lines[page])
+			if page == start.page {
+				from = start.line
+			}
+			if page == end.page && i+1 < len(sections) {
+				to = end.line
+			}
+			if from < to {
+				text = append(text, lines[page][from:to]...)
+			}
+		}
+		sections[i].Lines = text
+	}
+
+	// keys have to be unique to be matched, e.g. several "Examples" sections
+	seen := map[string]int{}
+	for i := range sections {
+		seen[sections[i].Key]++
+		if n := seen[sections[i].Key]; n > 1 {
+			sections[i].Key += " #" + strconv.Itoa(n)
+		}
+	}
+	return sections
+}
+
+// findTitle returns the index of the line holding title, 0 when it isn't found
+func findTitle(lines []string, title string) int {
+	want := normalizeTitle(title)
+	if want == "" {
+		return 0
+	}
+	for i, line := range lines {
+		if strings.Contains(normalizeTitle(line), want) {
+			return i
+		}
+	}
+	return 0
+}
+
+// lineDiff counts the lines only in a and only in b, treating both as multisets so
+// reflowed pages don't count as changes
+func lineDiff(a []string, b []string) (onlyA []string, onlyB []string) {
+	counts := map[string]int{}
+	for _, line := range a {
+		counts[line]++
+	}
+	for _, line := range b {
+		if counts[line] > 0 {
+			counts[line]--
+			continue
+		}
+		onlyB = append(onlyB, line)
+	}
+	for _, line := range a {
+		if counts[line] > 0 {
+			counts[line]--
+			onlyA = append(onlyA, line)
+		}
+	}
+	return onlyA, onlyB
+}
+
+func samples(lines []string) []string {
+	if len(lines) > maxSamples {
+		return lines[:maxSamples]
+	}
+	return lines
+}
+
+// Compare lists the sections added in head, removed from base, and changed between them,
+// in head's order followed by the removed sections
+func Compare(base []Section, head []Section) []Change {
+	baseByKey := map[string]Section{}
+	for _, section := range base {
+		baseByKey[section.Key] = section
+	}
+	headKeys := map[string]bool{}
+
+	var changes []Change
+	for _, section := range head {
+		headKeys[section.Key] = true
+		old, ok := baseByKey[section.Key]
+		if !ok {
+			changes = append(changes, Change{
+				Kind:       Added,
+				Title:      section.Title,
+				Level:      section.Level,
+				HeadPage:   section.Page,
+				AddedLines: len(section.Lines),
+				Added:      samples(section.Lines),
+			})
+			continue
+		}
+
+		removed, added := lineDiff(old.Lines, section.Lines)
+		if len(removed) == 0 && len(added) == 0 {
+			continue
+		}
+		changes = append(changes, Change{
+			Kind:         Modified,
+			Title:        section.Title,
+			Level:        section.Level,
+			BasePage:     old.Page,
+			HeadPage:     section.Page,
+			AddedLines:   len(added),
+			RemovedLines: len(removed),
+			Added:        samples(added),
+			Removed:      samples(removed),
+		})
+	}
+
+	for _, section := range base {
+		if headKeys[section.Key] {
+			continue
+		}
+		changes = append(changes, Change{
+			Kind:         Removed,
+			Title:        section.Title,
+			Level:        section.Level,
+			BasePage:     section.Page,
+			RemovedLines: len(section.Lines),
+			Removed:      samples(section.Lines),
+		})
+	}
+	return changes
+}
+
+// ChangedPages counts, for every page of head, the lines that don't appear anywhere in base
+func ChangedPages(base Document, head Document) map[int]int {
+	remaining := map[string]int{}
+	for _, lines := range pageLines(base.Pages) {
+		for _, line := range lines {
+			remaining[line]++
+		}
+	}
+
+	changed := map[int]int{}
+	for i, lines := range pageLines(head.Pages) {
+		for _, line := range lines {
+			if remaining[line] > 0 {
+				remaining[line]--
+				continue
+			}
+			changed[i+1]++
+		}
+	}
+	return changed
+}
@@ -0,0 +1,139 @@
+package diff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jeffbrennan/pdfgen/internal/pdf"
+)
+
+var baseDoc = Document{
+	Outline: []pdf.OutlineEntry{
+		{Title: "1 Install", Level: 1, Page: 1},
+		{Title: "1.1 Requirements", Level: 2, Page: 1},
+		{Title: "2 Usage", Level: 1, Page: 2},
+		{Title: "3 Legacy", Level: 1, Page: 3},
+	},
+	Pages: []string{
+		"1 Install\nrun the installer\n1.1 Requirements\ngo 1.21\n  1\n",
+		"2 Usage\ncall generate\n  2\n",
+		"3 Legacy\nold api\n  3\n",
+	},
+}
+
+var headDoc = Document{
+	Outline: []pdf.OutlineEntry{
+		{Title: "1 Install", Level: 1, Page: 1},
+		{Title: "1.1 Requirements", Level: 2, Page: 1},
+		{Title: "2 Usage", Level: 1, Page: 2},
+		{Title: "3 Search", Level: 1, Page: 3},
+	},
+	Pages: []string{
+		"1 Install\nrun the   installer\n1.1 Requirements\ngo 1.22\n  1\n",
+		"2 Usage\ncall generate\n  2\n",
+		"3 Search\nfind text\n  3\n",
+	},
+}
+
+func TestSections(t *testing.T) {
+	sections := Sections(baseDoc)
+	expected := []struct {
+		key   string
+		lines []string
+	}{
+		{"install", []string{"1 Install", "run the installer"}},
+		{"install / requirements", []string{"1.1 Requirements", "go 1.21"}},
+		{"usage", []string{"2 Usage", "call generate"}},
+		{"legacy", []string{"3 Legacy", "old api"}},
+	}
+	if len(sections) != len(expected) {
+		t.Fatalf("expected %d sections, got %v", len(expected), sections)
+	}
+	for i, e := range expected {
+		if sections[i].Key != e.key || !reflect.DeepEqual(sections[i].Lines, e.lines) {
+			t.Errorf("section %d: expected %s %q, got %s %q", i, e.key, e.lines, sections[i].Key, sections[i].Lines)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	changes := Compare(Sections(baseDoc), Sections(headDoc))
+	expected := []Change{
+		{Kind: Modified, Title: "1.1 Requirements", Level: 2, BasePage: 1, HeadPage: 1,
+			AddedLines: 1, RemovedLines: 1, Added: []string{"go 1.22"}, Removed: []string{"go 1.21"}},
+		{Kind: Added, Title: "3 Search", Level: 1, HeadPage: 3,
+			AddedLines: 2, Added: []string{"3 Search", "find text"}},
+		{Kind: Removed, Title: "3 Legacy", Level: 1, BasePage: 3,
+			RemovedLines: 2, Removed: []string{"3 Legacy", "old api"}},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, changes)
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	var tests = []struct {
+		name    string
+		a       []string
+		b       []string
+		removed []string
+		added   []string
+	}{
+		{"same", []string{"a", "b"}, []string{"a", "b"}, nil, nil},
+		{"reordered", []string{"a", "b"}, []string{"b", "a"}, nil, nil},
+		{"duplicate added", []string{"a"}, []string{"a", "a"}, nil, []string{"a"}},
+		{"replaced", []string{"a", "b"}, []string{"a", "c"}, []string{"b"}, []string{"c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			removed, added := lineDiff(tt.a, tt.b)
+			if !reflect.DeepEqual(removed, tt.removed) || !reflect.DeepEqual(added, tt.added) {
+				t.Errorf("expected -%q +%q, got -%q +%q", tt.removed, tt.added, removed, added)
+			}
+		})
+	}
+}
+
+func TestChangedPages(t *testing.T) {
+	changed := ChangedPages(baseDoc, headDoc)
+	expected := map[int]int{1: 1, 3: 2}
+	if !reflect.DeepEqual(changed, expected) {
+		t.Errorf("expected %v, got %v", expected, changed)
+	}
+}
+
+func TestBoilerplate(t *testing.T) {
+	pages := []string{
+		"pdfgen 2.9\nintro\n1",
+		"pdfgen 2.9\nsetup\n2",
+		"pdfgen 2.9\nusage\n3",
+		"pdfgen 2.9\nintro\n4",
+	}
+	repeated := boilerplate(pages)
+	if !repeated["pdfgen 2.9"] || repeated["intro"] || len(repeated) != 1 {
+		t.Errorf("expected only the running header, got %v", repeated)
+	}
+}
+
+func TestReportHTML(t *testing.T) {
+	report := NewReport(baseDoc, headDoc, ChangedPages(baseDoc, headDoc))
+	report.Repo = "pdfgen"
+	report.Base.Ref = "v1"
+	report.Head.Ref = "<main>"
+
+	if report.Summary.Added != 1 || report.Summary.Removed != 1 || report.Summary.Modified != 1 {
+		t.Errorf("unexpected summary %+v", report.Summary)
+	}
+	if !reflect.DeepEqual(report.Summary.ChangedPages, []int{1, 3}) {
+		t.Errorf("unexpected changed pages %v", report.Summary.ChangedPages)
+	}
+
+	page := report.HTML()
+	for _, expected := range []string{"pdfgen: v1..&lt;main&gt;", "<del>go 1.21</del>", "<ins>go 1.22</ins>", "1, 3"} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("expected %q in report", expected)
+		}
+	}
+}
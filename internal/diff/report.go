@@ -0,0 +1,153 @@
+package diff
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Version is one side of a comparison
+type Version struct {
+	Ref    string `json:"ref"`
+	Commit string `json:"commit,omitempty"`
+	Pages  int    `json:"pages"`
+}
+
+// Summary counts the changes by kind
+type Summary struct {
+	Added        int   `json:"added"`
+	Removed      int   `json:"removed"`
+	Modified     int   `json:"modified"`
+	ChangedPages []int `json:"changed_pages"`
+}
+
+// Report is the changelog between two builds of the same docs
+type Report struct {
+	Repo      string    `json:"repo"`
+	Directory string    `json:"directory"`
+	Base      Version   `json:"base"`
+	Head      Version   `json:"head"`
+	Generated time.Time `json:"generated"`
+	Summary   Summary   `json:"summary"`
+	Changes   []Change  `json:"changes"`
+}
+
+// NewReport compares base and head and summarizes the result. changedPages is
+// ChangedPages(base, head), passed in since callers need it to annotate head as well.
+func NewReport(base Document, head Document, changedPages map[int]int) Report {
+	changes := Compare(Sections(base), Sections(head))
+	report := Report{
+		Base:      Version{Pages: len(base.Pages)},
+		Head:      Version{Pages: len(head.Pages)},
+		Generated: time.Now().UTC(),
+		Changes:   changes,
+		Summary:   Summary{ChangedPages: []int{}},
+	}
+	if report.Changes == nil {
+		report.Changes = []Change{}
+	}
+
+	for _, change := range changes {
+		switch change.Kind {
+		case Added:
+			report.Summary.Added++
+		case Removed:
+			report.Summary.Removed++
+		case Modified:
+			report.Summary.Modified++
+		}
+	}
+	for page := range changedPages {
+		report.Summary.ChangedPages = append(report.Summary.ChangedPages, page)
+	}
+	sort.Ints(report.Summary.ChangedPages)
+	return report
+}
+
+func versionLabel(v Version) string {
+	if v.Commit == "" {
+		return html.EscapeString(v.Ref)
+	}
+	commit := v.Commit
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	return fmt.Sprintf("%s <code>%s</code>", html.EscapeString(v.Ref), html.EscapeString(commit))
+}
+
+func pageLabel(page int) string {
+	if page == 0 {
+		return ""
+	}
+	return fmt.Sprint(page)
+}
+
+// HTML renders the report as a standalone page
+func (r Report) HTML() string {
+	var rows strings.Builder
+	for _, change := range r.Changes {
+		var lines strings.Builder
+		for _, line := range change.Removed {
+			fmt.Fprintf(&lines, "<del>%s</del>\n", html.EscapeString(line))
+		}
+		for _, line := range change.Added {
+			fmt.Fprintf(&lines, "<ins>%s</ins>\n", html.EscapeString(line))
+		}
+		fmt.Fprintf(&rows, "<tr class=\"%s\"><td>%s</td><td style=\"padding-left: %.1fem\">%s</td><td>%s</td><td>%s</td><td>+%d / -%d</td><td>%s</td></tr>\n",
+			change.Kind, change.Kind, float64(change.Level-1)*1.5+1, html.EscapeString(change.Title),
+			pageLabel(change.BasePage), pageLabel(change.HeadPage), change.AddedLines, change.RemovedLines, lines.String())
+	}
+	if len(r.Changes) == 0 {
+		rows.WriteString("<tr><td colspan=\"6\">no changes</td></tr>\n")
+	}
+
+	pages := make([]string, len(r.Summary.ChangedPages))
+	for i, page := range r.Summary.ChangedPages {
+		pages[i] = fmt.Sprint(page)
+	}
+	changedPages := strings.Join(pages, ", ")
+	if changedPages == "" {
+		changedPages = "none"
+	}
+
+	title := fmt.Sprintf("%s: %s..%s", r.Repo, r.Base.Ref, r.Head.Ref)
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%[1]s</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.3em 1em; text-align: left; vertical-align: top; border-bottom: 1px solid #ddd; }
+tr.added td:first-child { color: #1a7f37; }
+tr.removed td:first-child { color: #cf222e; }
+tr.modified td:first-child { color: #9a6700; }
+del, ins { display: block; font-family: monospace; white-space: pre-wrap; text-decoration: none; }
+del { background: #ffebe9; }
+ins { background: #dafbe1; }
+</style>
+</head>
+<body>
+<h1>%[1]s</h1>
+<table>
+<tr><th>directory</th><td><code>%[2]s</code></td></tr>
+<tr><th>base</th><td>%[3]s, %[4]d pages</td></tr>
+<tr><th>head</th><td>%[5]s, %[6]d pages</td></tr>
+<tr><th>sections</th><td>%[7]d added, %[8]d removed, %[9]d modified</td></tr>
+<tr><th>changed pages</th><td>%[10]s</td></tr>
+<tr><th>generated</th><td>%[11]s</td></tr>
+</table>
+<h2>changes</h2>
+<table>
+<tr><th>kind</th><th>section</th><th>base page</th><th>head page</th><th>lines</th><th>sample</th></tr>
+%[12]s</table>
+</body>
+</html>
+`, html.EscapeString(title), html.EscapeString(r.Directory),
+		versionLabel(r.Base), r.Base.Pages, versionLabel(r.Head), r.Head.Pages,
+		r.Summary.Added, r.Summary.Removed, r.Summary.Modified, changedPages,
+		r.Generated.Format(time.RFC3339), rows.String())
+}
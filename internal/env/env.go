@@ -1,58 +1,98 @@
 package env
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strings"
 
+	"github.com/jeffbrennan/pdfgen/internal/config"
 	"github.com/jeffbrennan/pdfgen/internal/logging"
 	"github.com/jeffbrennan/pdfgen/internal/models"
 	"github.com/jeffbrennan/pdfgen/internal/utils"
 )
 
-func setupPythonEnvPip(dirParts *models.DirectoryParts) error {
+func setupPythonEnvPip(ctx context.Context, dirParts *models.DirectoryParts, extraEnv map[string]string) error {
 
-	_, err := utils.RunCommand(
+	_, err := utils.RunCommandEnv(
+		ctx,
 		[]string{"uv", "pip", "install", "-r", "requirements.txt"},
 		dirParts.Base,
+		extraEnv,
 	)
 	return err
 
 }
 
-func setupPythonEnvPoetry(dirParts *models.DirectoryParts) error {
+func setupPythonEnvPoetry(ctx context.Context, dirParts *models.DirectoryParts, extraEnv map[string]string) error {
 	// TODO: parse pyproject.toml to look for a docs group
-	_, err := utils.RunCommand(
+	_, err := utils.RunCommandEnv(
+		ctx,
 		[]string{"uvx", "migrate-to-uv"},
 		dirParts.Base,
+		extraEnv,
 	)
 
 	if err != nil {
 		return err
 	}
 
-	_, err = utils.RunCommand(
+	_, err = utils.RunCommandEnv(
+		ctx,
 		[]string{"uv", "sync"},
 		dirParts.Base,
+		extraEnv,
 	)
 
 	return err
 }
 
-func setupPythonEnvUV(dirParts *models.DirectoryParts) error {
-	_, err := utils.RunCommand(
+func setupPythonEnvUV(ctx context.Context, dirParts *models.DirectoryParts, extraEnv map[string]string) error {
+	_, err := utils.RunCommandEnv(
+		ctx,
 		[]string{"uv", "sync"},
 		dirParts.Base,
+		extraEnv,
 	)
 
 	return err
 }
 
-func SetupPythonEnv(dirParts *models.DirectoryParts, env models.PythonEnv) error {
-	logging.PublishLog("Setting up Python environment...")
-	_, err := utils.RunCommand(
+// Spec is what a build asks of its Python environment on top of the config, from its
+// recipe
+type Spec struct {
+	// Group replaces the docs group of the config when set
+	Group         string
+	ExtraPackages []string
+	Env           map[string]string
+}
+
+// RunArgs is the uv run prefix of the build's Python commands: the docs group of cfg or
+// spec, with the spec's extra packages on top
+func RunArgs(cfg config.SphinxConfig, spec Spec) []string {
+	group := cfg.DocsGroup
+	if spec.Group != "" {
+		group = spec.Group
+	}
+
+	// TODO: handle case where docs group does not exist
+	args := []string{"uv", "run", "--group", group}
+	for _, pkg := range spec.ExtraPackages {
+		args = append(args, "--with", pkg)
+	}
+	return args
+}
+
+// SetupPythonEnv installs the project's dependencies, then resolves the docs group and
+// extra packages of RunArgs while the network is allowed, so the build's uv run commands
+// find them installed
+func SetupPythonEnv(ctx context.Context, cfg config.SphinxConfig, dirParts *models.DirectoryParts, env models.PythonEnv, spec Spec) error {
+	logging.PublishLog(ctx, "Setting up Python environment...")
+	_, err := utils.RunCommandEnv(
+		ctx,
 		[]string{"uv", "venv"},
 		dirParts.Base,
+		spec.Env,
 	)
 
 	if err != nil {
@@ -61,22 +101,29 @@ func SetupPythonEnv(dirParts *models.DirectoryParts, env models.PythonEnv) error
 
 	switch env {
 	case models.PIP:
-		return setupPythonEnvPip(dirParts)
+		err = setupPythonEnvPip(ctx, dirParts, spec.Env)
 	case models.POETRY:
-		return setupPythonEnvPoetry(dirParts)
+		err = setupPythonEnvPoetry(ctx, dirParts, spec.Env)
 	case models.UV:
-		return setupPythonEnvUV(dirParts)
+		err = setupPythonEnvUV(ctx, dirParts, spec.Env)
 	default:
-		return fmt.Errorf("unknown python env")
+		err = fmt.Errorf("unknown python env")
+	}
+	if err != nil {
+		return err
 	}
+
+	args := append(RunArgs(cfg, spec), "python", "-c", "")
+	_, err = utils.RunCommandEnv(ctx, args, dirParts.Base, spec.Env)
+	return err
 }
 
 func setupNodeEnv(dirParts *models.DirectoryParts) error {
 	return fmt.Errorf("node env setup not implemented")
 }
 
-func ParseEnvType(dirParts *models.DirectoryParts) (models.EnvType, error) {
-	out, err := utils.RunCommand([]string{"ls"}, dirParts.Base)
+func ParseEnvType(ctx context.Context, dirParts *models.DirectoryParts) (models.EnvType, error) {
+	out, err := utils.RunCommand(ctx, []string{"ls"}, dirParts.Base)
 	if err != nil {
 		return -1, err
 	}
@@ -99,9 +146,9 @@ func ParseEnvType(dirParts *models.DirectoryParts) (models.EnvType, error) {
 	return -1, fmt.Errorf("unknown env")
 }
 
-func ParsePythonEnv(dirParts *models.DirectoryParts) (models.PythonEnv, error) {
-	logging.PublishLog("Parsing Python env...")
-	out, err := utils.RunCommand([]string{"ls"}, dirParts.Base)
+func ParsePythonEnv(ctx context.Context, dirParts *models.DirectoryParts) (models.PythonEnv, error) {
+	logging.PublishLog(ctx, "Parsing Python env...")
+	out, err := utils.RunCommand(ctx, []string{"ls"}, dirParts.Base)
 	if err != nil {
 		return -1, err
 	}
@@ -0,0 +1,34 @@
+package env
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+)
+
+func TestRunArgs(t *testing.T) {
+	cfg := config.SphinxConfig{DocsGroup: "docs"}
+
+	var tests = []struct {
+		name     string
+		spec     Spec
+		expected []string
+	}{
+		{"docs group of the config", Spec{}, []string{"uv", "run", "--group", "docs"}},
+		{"group of the recipe", Spec{Group: "documentation"}, []string{"uv", "run", "--group", "documentation"}},
+		{
+			"extra packages",
+			Spec{ExtraPackages: []string{"sphinx-rtd-theme", "myst-parser"}},
+			[]string{"uv", "run", "--group", "docs", "--with", "sphinx-rtd-theme", "--with", "myst-parser"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RunArgs(cfg, tt.spec); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
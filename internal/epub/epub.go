@@ -0,0 +1,263 @@
+package epub
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	coverImageName = "pdfgen-cover.svg"
+	coverPageName  = "pdfgen-cover.xhtml"
+)
+
+// Metadata describes where a book came from
+type Metadata struct {
+	Title     string
+	Repo      string
+	Ref       string
+	BuildDate time.Time
+}
+
+type container struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+var (
+	metadataEndRe  = regexp.MustCompile(`</(?:opf:)?metadata>`)
+	manifestEndRe  = regexp.MustCompile(`</(?:opf:)?manifest>`)
+	spineStartRe   = regexp.MustCompile(`<(?:opf:)?spine[^>]*>`)
+	titleRe        = regexp.MustCompile(`<dc:title[^>]*>[^<]*</dc:title>`)
+	navPropertyRe  = regexp.MustCompile(`properties="[^"]*\bnav\b[^"]*"`)
+	coverImageRe   = regexp.MustCompile(`properties="[^"]*\bcover-image\b[^"]*"`)
+	itemrefFirstRe = regexp.MustCompile(`<(?:opf:)?itemref`)
+)
+
+// Finalize rewrites the EPUB at path so every generator's output carries the same
+// provenance metadata and a generated cover. The navigation document is required.
+func Finalize(epubPath string, meta Metadata) error {
+	r, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return fmt.Errorf("error opening epub: %s", err)
+	}
+
+	files := map[string][]byte{}
+	var order []string
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			r.Close()
+			return err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			r.Close()
+			return err
+		}
+		files[f.Name] = data
+		order = append(order, f.Name)
+	}
+	r.Close()
+
+	var c container
+	err = xml.Unmarshal(files["META-INF/container.xml"], &c)
+	if err != nil || len(c.Rootfiles) == 0 {
+		return fmt.Errorf("epub has no package document")
+	}
+	opfPath := c.Rootfiles[0].FullPath
+	opf, ok := files[opfPath]
+	if !ok {
+		return fmt.Errorf("epub package document %s is missing", opfPath)
+	}
+
+	updated, err := updatePackage(string(opf), meta)
+	if err != nil {
+		return err
+	}
+	files[opfPath] = []byte(updated)
+
+	opfDir := path.Dir(opfPath)
+	for name, data := range map[string][]byte{
+		coverImageName: coverSVG(meta),
+		coverPageName:  coverXHTML(meta),
+	} {
+		full := path.Join(opfDir, name)
+		if _, exists := files[full]; !exists {
+			order = append(order, full)
+		}
+		files[full] = data
+	}
+
+	return writeEPUB(epubPath, files, order)
+}
+
+func updatePackage(opf string, meta Metadata) (string, error) {
+	if !navPropertyRe.MatchString(opf) {
+		return "", fmt.Errorf("epub has no navigation document")
+	}
+
+	title := html.EscapeString(meta.Title)
+	if meta.Title != "" {
+		if titleRe.MatchString(opf) {
+			opf = titleRe.ReplaceAllString(opf, "<dc:title>"+title+"</dc:title>")
+		} else {
+			opf = insertBefore(opf, metadataEndRe, "<dc:title>"+title+"</dc:title>")
+		}
+	}
+
+	var extra strings.Builder
+	if meta.Repo != "" {
+		fmt.Fprintf(&extra, "<dc:source>%s</dc:source>\n", html.EscapeString(meta.Repo))
+	}
+	fmt.Fprintf(&extra, "<dc:description>%s</dc:description>\n", html.EscapeString(description(meta)))
+	if !strings.Contains(opf, "<dc:date") {
+		fmt.Fprintf(&extra, "<dc:date>%s</dc:date>\n", meta.BuildDate.UTC().Format(time.RFC3339))
+	}
+	if meta.Ref != "" {
+		fmt.Fprintf(&extra, "<meta property=\"pdfgen:ref\">%s</meta>\n", html.EscapeString(meta.Ref))
+	}
+
+	// pandoc and sphinx may already declare a cover image, ours replaces the role
+	opf = coverImageRe.ReplaceAllStringFunc(opf, func(s string) string {
+		return strings.TrimSpace(strings.Replace(s, "cover-image", "", 1))
+	})
+	extra.WriteString(`<meta name="cover" content="pdfgen-cover-image"/>` + "\n")
+
+	opf = insertBefore(opf, metadataEndRe, extra.String())
+	opf = insertBefore(opf, manifestEndRe, fmt.Sprintf(
+		"<item id=\"pdfgen-cover-image\" href=\"%s\" media-type=\"image/svg+xml\" properties=\"cover-image\"/>\n"+
+			"<item id=\"pdfgen-cover\" href=\"%s\" media-type=\"application/xhtml+xml\" properties=\"svg\"/>\n",
+		coverImageName, coverPageName,
+	))
+
+	loc := spineStartRe.FindStringIndex(opf)
+	if loc == nil {
+		return "", fmt.Errorf("epub has no spine")
+	}
+	spineEnd := loc[1]
+	if first := itemrefFirstRe.FindStringIndex(opf[spineEnd:]); first != nil {
+		spineEnd += first[0]
+	}
+	opf = opf[:spineEnd] + `<itemref idref="pdfgen-cover"/>` + "\n" + opf[spineEnd:]
+
+	// the properties attribute is only valid in EPUB 3 packages
+	if !strings.Contains(opf, `version="3`) {
+		opf = strings.ReplaceAll(opf, ` properties="cover-image"`, "")
+		opf = strings.ReplaceAll(opf, ` properties="svg"`, "")
+	}
+
+	return opf, nil
+}
+
+func insertBefore(s string, re *regexp.Regexp, insert string) string {
+	loc := re.FindStringIndex(s)
+	if loc == nil {
+		return s
+	}
+	return s[:loc[0]] + insert + s[loc[0]:]
+}
+
+func description(meta Metadata) string {
+	desc := "Built by pdfgen"
+	if meta.Repo != "" {
+		desc += " from " + meta.Repo
+	}
+	if meta.Ref != "" {
+		desc += " at " + meta.Ref
+	}
+	return desc + " on " + meta.BuildDate.UTC().Format("2006-01-02")
+}
+
+func coverLines(meta Metadata) []string {
+	lines := []string{meta.Title}
+	if meta.Repo != "" {
+		lines = append(lines, meta.Repo)
+	}
+	if meta.Ref != "" {
+		lines = append(lines, meta.Ref)
+	}
+	return append(lines, meta.BuildDate.UTC().Format("2006-01-02"))
+}
+
+func coverSVG(meta Metadata) []byte {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" width="600" height="800" viewBox="0 0 600 800">` + "\n")
+	b.WriteString(`<rect width="600" height="800" fill="#f7f5f0"/>` + "\n")
+	b.WriteString(`<rect x="40" y="40" width="520" height="720" fill="none" stroke="#333" stroke-width="2"/>` + "\n")
+	for i, line := range coverLines(meta) {
+		size := 22
+		y := 360 + i*40
+		if i == 0 {
+			size = 36
+			y = 300
+		}
+		fmt.Fprintf(&b, `<text x="300" y="%d" font-family="serif" font-size="%d" text-anchor="middle" fill="#222">%s</text>`+"\n",
+			y, size, html.EscapeString(line))
+	}
+	b.WriteString("</svg>\n")
+	return []byte(b.String())
+}
+
+func coverXHTML(meta Metadata) []byte {
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>%s</title><style>body { margin: 0; } img { width: 100%%; height: 100%%; }</style></head>
+<body><img src="%s" alt="%s"/></body>
+</html>
+`, html.EscapeString(meta.Title), coverImageName, html.EscapeString(meta.Title)))
+}
+
+// writeEPUB writes files with the mimetype entry first and uncompressed, as the OCF spec requires
+func writeEPUB(epubPath string, files map[string][]byte, order []string) error {
+	tmpPath := epubPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(f)
+	write := func(name string, method uint16) error {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(files[name])
+		return err
+	}
+
+	files["mimetype"] = []byte("application/epub+zip")
+	err = write("mimetype", zip.Store)
+	for _, name := range order {
+		if err != nil {
+			break
+		}
+		if name == "mimetype" {
+			continue
+		}
+		err = write(name, zip.Deflate)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, epubPath)
+}
@@ -0,0 +1,124 @@
+package epub
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPackage = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:title>Project</dc:title>
+<dc:identifier id="id">urn:uuid:1</dc:identifier>
+</metadata>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+<item id="index" href="index.xhtml" media-type="application/xhtml+xml"/>
+</manifest>
+<spine toc="ncx">
+<itemref idref="index"/>
+</spine>
+</package>
+`
+
+func writeTestEPUB(t *testing.T, epubPath string, opf string) {
+	t.Helper()
+	f, err := os.Create(epubPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	files := [][2]string{
+		{"mimetype", "application/epub+zip"},
+		{"META-INF/container.xml", `<?xml version="1.0"?><container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container"><rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`},
+		{"OEBPS/content.opf", opf},
+		{"OEBPS/nav.xhtml", "<html/>"},
+		{"OEBPS/index.xhtml", "<html/>"},
+	}
+	for _, file := range files {
+		w, err := zw.Create(file[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(file[1]))
+	}
+	err = zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFinalize(t *testing.T) {
+	epubPath := filepath.Join(t.TempDir(), "book.epub")
+	writeTestEPUB(t, epubPath, testPackage)
+
+	meta := Metadata{
+		Title:     "Airflow & Friends",
+		Repo:      "https://github.com/apache/airflow",
+		Ref:       "main",
+		BuildDate: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	err := Finalize(epubPath, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.OpenReader(epubPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if r.File[0].Name != "mimetype" || r.File[0].Method != zip.Store {
+		t.Errorf("mimetype must be the first, uncompressed entry")
+	}
+
+	contents := map[string]string{}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		contents[f.Name] = string(data)
+	}
+
+	opf := contents["OEBPS/content.opf"]
+	for _, expected := range []string{
+		"<dc:title>Airflow &amp; Friends</dc:title>",
+		"<dc:source>https://github.com/apache/airflow</dc:source>",
+		"<dc:date>2025-01-02T03:04:05Z</dc:date>",
+		`<meta property="pdfgen:ref">main</meta>`,
+		`properties="cover-image"`,
+		"<itemref idref=\"pdfgen-cover\"/>\n<itemref idref=\"index\"/>",
+	} {
+		if !strings.Contains(opf, expected) {
+			t.Errorf("expected %q in package document:\n%s", expected, opf)
+		}
+	}
+
+	if !strings.Contains(contents["OEBPS/pdfgen-cover.svg"], "https://github.com/apache/airflow") {
+		t.Errorf("expected the repo on the cover")
+	}
+	if _, ok := contents["OEBPS/pdfgen-cover.xhtml"]; !ok {
+		t.Errorf("expected a cover page")
+	}
+}
+
+func TestFinalizeRequiresNav(t *testing.T) {
+	epubPath := filepath.Join(t.TempDir(), "book.epub")
+	writeTestEPUB(t, epubPath, strings.Replace(testPackage, ` properties="nav"`, "", 1))
+
+	err := Finalize(epubPath, Metadata{Title: "x", BuildDate: time.Now()})
+	if err == nil {
+		t.Errorf("expected missing nav to fail")
+	}
+}
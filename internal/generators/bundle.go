@@ -0,0 +1,171 @@
+package generators
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jeffbrennan/pdfgen/internal/cache"
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/jobs"
+	"github.com/jeffbrennan/pdfgen/internal/logging"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/pdf"
+	"github.com/jeffbrennan/pdfgen/internal/repo"
+	"github.com/jeffbrennan/pdfgen/internal/store"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+const maxBundleItems = 20
+
+func ValidateBundle(items []models.BundleItem, numbering string, opts models.BuildOptions) error {
+	if len(items) == 0 {
+		return fmt.Errorf("a bundle needs at least one url")
+	}
+	if len(items) > maxBundleItems {
+		return fmt.Errorf("a bundle can have at most %d urls, got %d", maxBundleItems, len(items))
+	}
+	for _, item := range items {
+		if item.URL == "" {
+			return fmt.Errorf("bundle urls must not be empty")
+		}
+	}
+	if numbering != "" && !utils.Contains(models.Numberings, numbering) {
+		return fmt.Errorf("unknown numbering %q, available: %s", numbering, strings.Join(models.Numberings, ", "))
+	}
+	if opts.Output != "" && opts.Output != models.OutputPDF {
+		return fmt.Errorf("bundles are only available as pdf")
+	}
+	if opts.Documents == models.DocumentsZip {
+		return fmt.Errorf("bundles merge every document, documents=zip is not supported")
+	}
+	return ValidateOptions(opts)
+}
+
+// HandleBundle builds every item through the normal pipeline, so cached builds are reused,
+// and binds them into one PDF with a combined table of contents
+func HandleBundle(ctx context.Context, cfg *config.Config, artifacts store.ArtifactStore, name string, items []models.BundleItem, numbering string, opts models.BuildOptions) (models.Artifact, error) {
+	err := ValidateBundle(items, numbering, opts)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+	if name == "" {
+		name = "bundle"
+	}
+
+	bundleDir, err := os.MkdirTemp("", "pdfgen-bundle-")
+	if err != nil {
+		return models.Artifact{}, err
+	}
+	defer os.RemoveAll(bundleDir)
+
+	// the same repo can appear more than once, so checkouts stay until every part is built
+	var checkouts []*models.RepoParts
+	defer func() {
+		for _, parts := range checkouts {
+			utils.CleanupDir(ctx, cfg.Repo, parts)
+		}
+	}()
+
+	itemOpts := opts
+	itemOpts.Output = models.OutputPDF
+	itemOpts.Split = 0
+
+	// the bundle is the job, its parts are stages rather than jobs of their own
+	tracker := jobs.FromContext(ctx)
+	itemCtx := jobs.WithTracker(ctx, nil)
+
+	var response models.Artifact
+	var bindParts []pdf.Part
+	var sources []string
+	for i, item := range items {
+		logging.PublishLog(ctx, fmt.Sprintf("Building part %d of %d: %s", i+1, len(items), item.URL))
+		if parts, err := repo.ParseRepoURL(item.URL); err == nil {
+			checkouts = append(checkouts, parts)
+		}
+		err = stopped(ctx)
+		if err != nil {
+			return models.Artifact{}, err
+		}
+		tracker.Stage(fmt.Sprintf("part %d", i+1))
+		built, err := HandleGeneration(itemCtx, cfg, artifacts, item.URL, itemOpts)
+		if err != nil {
+			return models.Artifact{}, fmt.Errorf("error building %s: %s", item.URL, err)
+		}
+
+		title := item.Title
+		if title == "" && len(built.Documents) == 1 {
+			title = built.Documents[0].Title
+		}
+		if title == "" {
+			title = built.Parts.Owner + "/" + built.Parts.Repo
+		}
+
+		partPath := filepath.Join(bundleDir, fmt.Sprintf("%02d-%s.pdf", i+1, pdf.Slug(title)))
+		err = store.Download(artifacts, built.Key, partPath)
+		if err != nil {
+			return models.Artifact{}, err
+		}
+		bindParts = append(bindParts, pdf.Part{Path: partPath, Title: title})
+		sources = append(sources, built.Parts.Owner+"/"+built.Parts.Repo)
+
+		response.Documents = append(response.Documents, models.Document{Name: pdf.Slug(title), Title: title})
+		response.Degraded = response.Degraded || built.Degraded
+		for _, warning := range built.Warnings {
+			response.Warnings = append(response.Warnings, title+": "+warning)
+		}
+		if response.Renderer == "" || response.Renderer == built.Renderer {
+			response.Renderer = built.Renderer
+		} else {
+			response.Renderer = "mixed"
+		}
+	}
+
+	tracker.Stage("bind")
+	logging.PublishLog(ctx, fmt.Sprintf("Binding %d parts...", len(bindParts)))
+	response.Path = filepath.Join(bundleDir, pdf.Slug(name)+".pdf")
+	err = pdf.Bind(bindParts, response.Path, numbering == models.NumberingPart)
+	if err != nil {
+		return models.Artifact{}, fmt.Errorf("error binding bundle: %s", err)
+	}
+
+	err = pdf.Finalize(response.Path, bundleMetadata(name, sources), pdf.Structure{Title: name})
+	if err != nil {
+		postMsg := fmt.Sprintf("Warning: PDF post-processing failed: %s", err)
+		log.Print(postMsg)
+		logging.PublishLog(ctx, postMsg)
+	}
+
+	if opts.Split > 0 {
+		response = splitPDF(ctx, response, opts.Split)
+	}
+
+	tracker.Stage("store")
+	response, err = cache.Store(artifacts, cache.NewID(), response)
+	if err != nil {
+		return models.Artifact{}, fmt.Errorf("error storing artifact: %s", err)
+	}
+	recordStored(ctx, response.Key)
+	logging.PublishLog(ctx, "done!")
+	return response, nil
+}
+
+func bundleMetadata(name string, sources []string) pdf.Metadata {
+	var unique []string
+	for _, source := range sources {
+		if !utils.Contains(unique, source) {
+			unique = append(unique, source)
+		}
+	}
+	return pdf.Metadata{
+		Title:     name,
+		Subject:   "Documentation for " + strings.Join(unique, ", "),
+		Keywords:  unique,
+		Generator: "pdfgen (bundle)",
+		BuildTime: time.Now(),
+	}
+}
@@ -0,0 +1,49 @@
+package generators
+
+import (
+	"testing"
+
+	"github.com/jeffbrennan/pdfgen/internal/models"
+)
+
+func TestValidateBundle(t *testing.T) {
+	airflow := models.BundleItem{URL: "https://github.com/apache/airflow/tree/main/airflow-core/docs", Title: "Airflow"}
+	providers := models.BundleItem{URL: "https://github.com/apache/airflow/tree/main/providers/amazon/docs"}
+
+	var tests = []struct {
+		name       string
+		items      []models.BundleItem
+		numbering  string
+		opts       models.BuildOptions
+		shouldPass bool
+	}{
+		{"two parts", []models.BundleItem{airflow, providers}, "", models.BuildOptions{}, true},
+		{"restart numbering", []models.BundleItem{airflow, providers}, models.NumberingPart, models.BuildOptions{}, true},
+		{"split by part", []models.BundleItem{airflow, providers}, models.NumberingContinuous, models.BuildOptions{Split: 1}, true},
+		{"no parts", nil, "", models.BuildOptions{}, false},
+		{"empty url", []models.BundleItem{airflow, {Title: "Missing"}}, "", models.BuildOptions{}, false},
+		{"unknown numbering", []models.BundleItem{airflow}, "roman", models.BuildOptions{}, false},
+		{"epub", []models.BundleItem{airflow}, "", models.BuildOptions{Output: models.OutputEPUB}, false},
+		{"zip of documents", []models.BundleItem{airflow}, "", models.BuildOptions{Documents: models.DocumentsZip}, false},
+		{"invalid layout", []models.BundleItem{airflow}, "", models.BuildOptions{Layout: models.Layout{Paper: "a3"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateBundle(tt.items, tt.numbering, tt.opts)
+			if (err == nil) != tt.shouldPass {
+				t.Errorf("expected pass=%v, got %v", tt.shouldPass, err)
+			}
+		})
+	}
+}
+
+func TestBundleMetadata(t *testing.T) {
+	meta := bundleMetadata("Onboarding", []string{"apache/airflow", "apache/airflow", "astronomer/cosmos"})
+	if meta.Title != "Onboarding" || len(meta.Keywords) != 2 {
+		t.Errorf("unexpected metadata %+v", meta)
+	}
+	if meta.Subject != "Documentation for apache/airflow, astronomer/cosmos" {
+		t.Errorf("unexpected subject %q", meta.Subject)
+	}
+}
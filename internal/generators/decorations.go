@@ -0,0 +1,337 @@
+package generators
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/pdf"
+	"github.com/jeffbrennan/pdfgen/internal/repo"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+const (
+	latexPackageName = "pdfgen"
+	qrCodeName       = "pdfgen-qr.png"
+	coverName        = "pdfgen-cover"
+)
+
+// provenance identifies the exact source a document was built from
+type provenance struct {
+	Title     string
+	Owner     string
+	Repo      string
+	URL       string
+	Ref       string
+	Commit    string
+	DocDir    string
+	BuildDate time.Time
+}
+
+// decorations are added on top of the project's own styling, per request
+type decorations struct {
+	Cover   bool
+	Headers bool
+	Layout  models.Layout
+	Source  provenance
+}
+
+func newDecorations(ctx context.Context, cfg *config.Config, opts models.BuildOptions, parts *models.RepoParts, dirParts *models.DirectoryParts) decorations {
+	deco := decorations{Cover: opts.Cover, Headers: opts.Headers, Layout: opts.Layout}
+	if !deco.Cover && !deco.Headers {
+		return deco
+	}
+
+	commit, err := repo.HeadCommit(ctx, cfg.Repo, parts)
+	if err != nil {
+		log.Print(err)
+	}
+
+	ref := parts.Branch
+	if ref == "" {
+		ref = "HEAD"
+	}
+	deco.Source = provenance{
+		Title:     parts.Repo,
+		Owner:     parts.Owner,
+		Repo:      parts.Repo,
+		URL:       repoURL(parts),
+		Ref:       ref,
+		Commit:    commit,
+		DocDir:    dirParts.Doc,
+		BuildDate: time.Now(),
+	}
+	if commit != "" {
+		deco.Source.URL = fmt.Sprintf("%s/tree/%s/%s", repoURL(parts), commit, strings.Trim(dirParts.Doc, "/"))
+	}
+	return deco
+}
+
+func (d decorations) enabled() bool {
+	return d.Cover || d.Headers || d.Layout != models.Layout{}
+}
+
+// withTitle returns a copy naming the document being built
+func (d decorations) withTitle(title string) decorations {
+	if title != "" {
+		d.Source.Title = title
+	}
+	return d
+}
+
+// version is the short form shown in running headers, e.g. "main @ 1a2b3c4"
+func (p provenance) version() string {
+	commit := p.Commit
+	if len(commit) > 7 {
+		commit = commit[:7]
+	}
+	if commit == "" {
+		return p.Ref
+	}
+	return p.Ref + " @ " + commit
+}
+
+func (p provenance) fields() [][2]string {
+	return [][2]string{
+		{"Repository", p.Owner + "/" + p.Repo},
+		{"Ref", p.Ref},
+		{"Commit", p.Commit},
+		{"Docs", p.DocDir},
+		{"Built", p.BuildDate.UTC().Format("2006-01-02 15:04 MST")},
+	}
+}
+
+func writeQRCode(ctx context.Context, url string, path string) error {
+	png, err := qrcode.Encode(url, qrcode.Medium, 256)
+	if err != nil {
+		return fmt.Errorf("error generating qr code: %s", err)
+	}
+	return utils.WriteFile(ctx, path, png, 0644)
+}
+
+var latexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`$`, `\$`,
+	`&`, `\&`,
+	`#`, `\#`,
+	`^`, `\textasciicircum{}`,
+	`_`, `\_`,
+	`~`, `\textasciitilde{}`,
+	`%`, `\%`,
+)
+
+func latexEscape(s string) string {
+	return latexEscaper.Replace(s)
+}
+
+// latexPackage renders pdfgen.sty. The cover is typeset before Sphinx's own title page and
+// the running headers replace the normal and plain page styles Sphinx defines.
+func (d decorations) latexPackage() string {
+	var b strings.Builder
+	b.WriteString("\\NeedsTeXFormat{LaTeX2e}\n\\ProvidesPackage{" + latexPackageName + "}\n")
+	b.WriteString("\\RequirePackage{graphicx}\n\\RequirePackage{fancyhdr}\n")
+	b.WriteString(latexLayout(d.Layout))
+
+	if d.Cover {
+		b.WriteString("\\AtBeginDocument{%\n\\begin{titlepage}\n\\centering\n\\vspace*{3cm}\n")
+		fmt.Fprintf(&b, "{\\Huge\\bfseries %s\\par}\n\\vspace{2cm}\n", latexEscape(d.Source.Title))
+		b.WriteString("\\begin{tabular}{rl}\n")
+		for _, field := range d.Source.fields() {
+			if field[1] == "" {
+				continue
+			}
+			fmt.Fprintf(&b, "\\textbf{%s} & \\texttt{%s}\\\\\n", field[0], latexEscape(field[1]))
+		}
+		b.WriteString("\\end{tabular}\n\\vfill\n")
+		fmt.Fprintf(&b, "\\includegraphics[width=3cm]{%s}\\par\n", qrCodeName)
+		fmt.Fprintf(&b, "{\\small\\texttt{%s}\\par}\n", latexEscape(d.Source.URL))
+		b.WriteString("\\end{titlepage}}\n")
+	}
+
+	if d.Headers {
+		style := fmt.Sprintf(
+			"\\fancyhf{}\\fancyhead[L]{\\small %s}\\fancyhead[R]{\\small %s}"+
+				"\\fancyfoot[L]{\\small\\nouppercase{\\leftmark}}\\fancyfoot[R]{\\thepage}"+
+				"\\renewcommand{\\headrulewidth}{0.4pt}\\renewcommand{\\footrulewidth}{0.4pt}",
+			latexEscape(d.Source.Owner+"/"+d.Source.Repo), latexEscape(d.Source.version()),
+		)
+		b.WriteString("\\AtBeginDocument{%\n")
+		fmt.Fprintf(&b, "\\fancypagestyle{normal}{%s}%%\n", style)
+		fmt.Fprintf(&b, "\\fancypagestyle{plain}{%s}%%\n", style)
+		b.WriteString("}\n")
+	}
+
+	b.WriteString("\\endinput\n")
+	return b.String()
+}
+
+// decorateLatex adds pdfgen.sty to a generated .tex file, loaded last in the preamble
+func (d decorations) decorateLatex(ctx context.Context, latexDir string, texFile string) error {
+	if !d.enabled() {
+		return nil
+	}
+
+	err := utils.WriteFile(ctx, filepath.Join(latexDir, latexPackageName+".sty"), []byte(d.latexPackage()), 0644)
+	if err != nil {
+		return err
+	}
+	if d.Cover {
+		err = writeQRCode(ctx, d.Source.URL, filepath.Join(latexDir, qrCodeName))
+		if err != nil {
+			return err
+		}
+	}
+
+	texPath := filepath.Join(latexDir, texFile)
+	texBytes, err := os.ReadFile(texPath)
+	if err != nil {
+		return err
+	}
+	tex := string(texBytes)
+
+	usePackage := "\\usepackage{" + latexPackageName + "}\n"
+	if strings.Contains(tex, usePackage) {
+		return nil
+	}
+	begin := strings.Index(tex, "\\begin{document}")
+	if begin == -1 {
+		return fmt.Errorf("no \\begin{document} in %s", texFile)
+	}
+	tex = tex[:begin] + usePackage + tex[begin:]
+	return utils.WriteFile(ctx, texPath, []byte(tex), 0644)
+}
+
+func cssString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + strings.ReplaceAll(s, "\n", " ") + `"`
+}
+
+// printCSS is appended to the print stylesheet of every page rendered from HTML
+func (d decorations) printCSS() string {
+	css := layoutCSS(d.Layout)
+	if !d.Headers {
+		return css
+	}
+
+	return css + fmt.Sprintf(`
+/* running headers added by pdfgen */
+@page {
+    @top-left {
+        content: %s;
+        font-size: 8pt;
+        color: #555;
+    }
+    @top-right {
+        content: %s;
+        font-size: 8pt;
+        color: #555;
+    }
+}
+`, cssString(d.Source.Owner+"/"+d.Source.Repo), cssString(d.Source.version()))
+}
+
+// coverHTML is a standalone cover page with the QR code inlined
+func (d decorations) coverHTML() (string, error) {
+	png, err := qrcode.Encode(d.Source.URL, qrcode.Medium, 256)
+	if err != nil {
+		return "", fmt.Errorf("error generating qr code: %s", err)
+	}
+
+	var rows strings.Builder
+	for _, field := range d.Source.fields() {
+		if field[1] == "" {
+			continue
+		}
+		fmt.Fprintf(&rows, "<tr><th>%s</th><td><code>%s</code></td></tr>\n", field[0], html.EscapeString(field[1]))
+	}
+
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%[1]s</title>
+<style>
+@page :first { @bottom-center { content: none; } }
+body { text-align: center; font-family: sans-serif; }
+h1 { margin-top: 30mm; font-size: 28pt; }
+table { margin: 20mm auto; text-align: left; border-collapse: collapse; }
+th { padding-right: 6mm; text-align: right; }
+th, td { padding-bottom: 2mm; }
+.source { margin-top: 30mm; font-size: 9pt; }
+.source img { width: 35mm; display: block; margin: 0 auto 3mm; }
+</style>
+</head>
+<body>
+<h1>%[1]s</h1>
+<table>
+%[2]s</table>
+<div class="source"><img src="data:image/png;base64,%[3]s" alt="source"><code>%[4]s</code></div>
+</body>
+</html>
+`, html.EscapeString(d.Source.Title), rows.String(), base64.StdEncoding.EncodeToString(png), html.EscapeString(d.Source.URL)), nil
+}
+
+// renderHTMLCover renders the cover page into dir and returns the PDF path
+func (d decorations) renderHTMLCover(ctx context.Context, cfg config.HTMLConfig, dir string) (string, error) {
+	page, err := d.coverHTML()
+	if err != nil {
+		return "", err
+	}
+	coverHTMLPath := filepath.Join(dir, coverName+".html")
+	err = utils.WriteFile(ctx, coverHTMLPath, []byte(page), 0644)
+	if err != nil {
+		return "", err
+	}
+
+	coverPDFPath := filepath.Join(dir, coverName+".pdf")
+	// the cover shares the document's paper size but none of its other decorations
+	cover := decorations{Layout: models.Layout{Paper: d.Layout.Paper}}
+	err = renderHTML(ctx, cfg, cover, coverHTMLPath, coverPDFPath)
+	if err != nil {
+		return "", fmt.Errorf("error rendering cover: %s", err)
+	}
+	return coverPDFPath, nil
+}
+
+// addHTMLCover prepends the cover page to a PDF rendered from HTML
+func (d decorations) addHTMLCover(ctx context.Context, cfg config.HTMLConfig, pdfPath string) error {
+	if !d.Cover {
+		return nil
+	}
+
+	coverPath, err := d.renderHTMLCover(ctx, cfg, filepath.Dir(pdfPath))
+	if err != nil {
+		return err
+	}
+
+	bodyPath := strings.TrimSuffix(pdfPath, ".pdf") + ".body.pdf"
+	err = os.Rename(pdfPath, bodyPath)
+	if err != nil {
+		return err
+	}
+	return pdf.Merge([]pdf.Part{
+		{Path: coverPath, Title: "Cover"},
+		{Path: bodyPath, Title: d.Source.Title},
+	}, pdfPath)
+}
+
+// frontMatter is the number of pages an HTML cover adds in front of the document
+func (d decorations) frontMatter() int {
+	if d.Cover {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,125 @@
+package generators
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testDecorations() decorations {
+	return decorations{
+		Cover:   true,
+		Headers: true,
+		Source: provenance{
+			Title:     "Docs & Guides",
+			Owner:     "owner",
+			Repo:      "my_repo",
+			URL:       "https://github.com/owner/my_repo/tree/0123456789abcdef/docs",
+			Ref:       "main",
+			Commit:    "0123456789abcdef",
+			DocDir:    "docs",
+			BuildDate: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		},
+	}
+}
+
+func TestProvenanceVersion(t *testing.T) {
+	var tests = []struct {
+		name     string
+		source   provenance
+		expected string
+	}{
+		{"short sha", provenance{Ref: "main", Commit: "0123456789abcdef"}, "main @ 0123456"},
+		{"no commit", provenance{Ref: "v1.0"}, "v1.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.source.version(); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestLatexPackage(t *testing.T) {
+	sty := testDecorations().latexPackage()
+	for _, s := range []string{
+		`\ProvidesPackage{pdfgen}`,
+		`{\Huge\bfseries Docs \& Guides\par}`,
+		`\textbf{Repository} & \texttt{owner/my\_repo}`,
+		`\includegraphics[width=3cm]{pdfgen-qr.png}`,
+		`\fancyhead[R]{\small main @ 0123456}`,
+		`\fancypagestyle{plain}`,
+	} {
+		if !strings.Contains(sty, s) {
+			t.Errorf("expected %q in:\n%s", s, sty)
+		}
+	}
+}
+
+func TestDecorateLatex(t *testing.T) {
+	dir := t.TempDir()
+	texPath := filepath.Join(dir, "doc.tex")
+	err := os.WriteFile(texPath, []byte("\\documentclass{sphinxmanual}\n\\begin{document}\nhi\n\\end{document}\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deco := testDecorations()
+	// a second run must not load the package twice
+	for i := 0; i < 2; i++ {
+		err = deco.decorateLatex(context.Background(), dir, "doc.tex")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tex, err := os.ReadFile(texPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(tex), "\\usepackage{pdfgen}\n\\begin{document}") != 1 {
+		t.Errorf("expected pdfgen loaded once before the document body:\n%s", tex)
+	}
+	for _, name := range []string{"pdfgen.sty", qrCodeName} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s: %s", name, err)
+		}
+	}
+}
+
+func TestDecorationsDisabled(t *testing.T) {
+	dir := t.TempDir()
+	err := decorations{}.decorateLatex(context.Background(), dir, "missing.tex")
+	if err != nil {
+		t.Errorf("expected no work without cover or headers, got %s", err)
+	}
+	if css := (decorations{}).printCSS(); css != "" {
+		t.Errorf("expected no css, got %q", css)
+	}
+}
+
+func TestPrintCSSHeaders(t *testing.T) {
+	deco := testDecorations()
+	deco.Source.Ref = `say "hi"`
+	css := deco.printCSS()
+	if !strings.Contains(css, `content: "say \"hi\" @ 0123456";`) {
+		t.Errorf("expected escaped header in:\n%s", css)
+	}
+}
+
+func TestCoverHTML(t *testing.T) {
+	cover, err := testDecorations().coverHTML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"<h1>Docs &amp; Guides</h1>", "<code>0123456789abcdef</code>", "data:image/png;base64,"} {
+		if !strings.Contains(cover, s) {
+			t.Errorf("expected %q in cover", s)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package generators
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/env"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+)
+
+// Format is a documentation format pdfgen can build, with what it can build it into
+type Format struct {
+	Name      string   `json:"name"`
+	Renderers []string `json:"renderers"`
+	Outputs   []string `json:"outputs"`
+}
+
+// Formats lists the documentation formats generateArtifact builds, others are only detected
+var Formats = []Format{
+	{
+		Name:      models.DocumentationName[models.Sphinx],
+		Renderers: []string{models.RendererLatex, models.RendererHTML},
+		Outputs:   models.Outputs,
+	},
+	{
+		Name:      models.DocumentationName[models.MkDocs],
+		Renderers: []string{models.RendererHTML},
+		Outputs:   models.Outputs,
+	},
+}
+
+// Detection is what a checkout looks like to the build
+type Detection struct {
+	Format string `json:"format"`
+	// Supported is set for the formats in Formats
+	Supported bool `json:"supported"`
+	// Env is the detected environment, e.g. "python" or "python/uv"
+	Env       string `json:"env,omitempty"`
+	Directory string `json:"directory"`
+	Ref       string `json:"ref,omitempty"`
+	Commit    string `json:"commit,omitempty"`
+}
+
+// StageError is an error along with the stage it happened in, e.g. clone
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// Detect clones url at ref and finds its documentation format and environment without
+// building anything. Errors are *StageError.
+func Detect(ctx context.Context, cfg *config.Config, url string, ref string) (d Detection, err error) {
+	// prepareCheckout tags its own errors, the rest happen while detecting
+	defer func() {
+		var stageErr *StageError
+		if err != nil && !errors.As(err, &stageErr) {
+			err = &StageError{Stage: "detect", Err: err}
+		}
+	}()
+
+	ctx, cancel := withLimit(ctx, "total", cfg.Limits.Total)
+	defer cancel()
+	ctx = withResourceLimits(ctx, cfg.Limits)
+
+	parts, dirParts, _, commit, err := prepareCheckout(ctx, cfg, url, ref)
+	if err != nil {
+		return Detection{}, err
+	}
+
+	ctx, err = withSandbox(ctx, cfg, parts)
+	if err != nil {
+		return Detection{}, err
+	}
+	docName, err := ParseDocumentationFormat(ctx, dirParts)
+	if err != nil {
+		return Detection{}, fmt.Errorf("error parsing documentation format: %s", err)
+	}
+	d = Detection{
+		Format:    models.DocumentationName[docName],
+		Directory: parts.Directory,
+		Ref:       parts.Branch,
+		Commit:    commit,
+	}
+	for _, format := range Formats {
+		d.Supported = d.Supported || format.Name == d.Format
+	}
+
+	// an unknown environment is left out, the build fails on it in its env stage
+	envType, err := env.ParseEnvType(ctx, dirParts)
+	if err != nil {
+		return d, stopped(ctx)
+	}
+	d.Env = models.EnvName[envType]
+	if envType == models.PYTHON {
+		pythonEnv, err := env.ParsePythonEnv(ctx, dirParts)
+		if err == nil {
+			d.Env += "/" + models.PythonEnvName[pythonEnv]
+		}
+	}
+	return d, nil
+}
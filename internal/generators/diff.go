@@ -0,0 +1,197 @@
+package generators
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jeffbrennan/pdfgen/internal/cache"
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/diff"
+	"github.com/jeffbrennan/pdfgen/internal/jobs"
+	"github.com/jeffbrennan/pdfgen/internal/logging"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/pdf"
+	"github.com/jeffbrennan/pdfgen/internal/repo"
+	"github.com/jeffbrennan/pdfgen/internal/store"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+const (
+	DiffHTML = "html"
+	DiffJSON = "json"
+	DiffPDF  = "pdf"
+)
+
+// DiffFormats lists the ways a diff can be downloaded, html is the default
+var DiffFormats = []string{DiffHTML, DiffJSON, DiffPDF}
+
+func ValidateDiff(base string, head string, format string, opts models.BuildOptions) error {
+	for _, ref := range []string{base, head} {
+		err := repo.ValidateRef(ref)
+		if err != nil {
+			return err
+		}
+	}
+	if base == head {
+		return fmt.Errorf("base and head are both %q", base)
+	}
+	if format != "" && !utils.Contains(DiffFormats, format) {
+		return fmt.Errorf("unknown diff format %q, available: %s", format, strings.Join(DiffFormats, ", "))
+	}
+	if opts.Output != "" && opts.Output != models.OutputPDF {
+		return fmt.Errorf("diffs compare pdf builds, output=%s is not supported", opts.Output)
+	}
+	if opts.Documents == models.DocumentsZip {
+		return fmt.Errorf("diffs compare one merged pdf, documents=zip is not supported")
+	}
+	if opts.Ref != "" {
+		return fmt.Errorf("diffs take base and head instead of ref")
+	}
+	return ValidateOptions(opts)
+}
+
+// HandleDiff builds the docs at base and head and reports the sections that changed
+// between them. format picks the download: the report as html or json, or the head
+// build with its changed pages highlighted.
+func HandleDiff(ctx context.Context, cfg *config.Config, artifacts store.ArtifactStore, url string, base string, head string, format string, opts models.BuildOptions) (models.Artifact, error) {
+	err := ValidateDiff(base, head, format, opts)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+	if format == "" {
+		format = DiffHTML
+	}
+
+	diffDir, err := os.MkdirTemp("", "pdfgen-diff-")
+	if err != nil {
+		return models.Artifact{}, err
+	}
+	defer os.RemoveAll(diffDir)
+
+	// both builds share one checkout, so it stays until head is built
+	if parts, err := repo.ParseRepoURL(url); err == nil {
+		defer utils.CleanupDir(ctx, cfg.Repo, parts)
+	}
+
+	buildOpts := opts
+	buildOpts.Output = models.OutputPDF
+	buildOpts.Split = 0
+
+	tracker := jobs.FromContext(ctx)
+	buildCtx := jobs.WithTracker(ctx, nil)
+
+	var docs [2]diff.Document
+	var versions [2]diff.Version
+	var built models.Artifact
+	for i, ref := range []string{base, head} {
+		logging.PublishLog(ctx, fmt.Sprintf("Building %s...", ref))
+		buildOpts.Ref = ref
+		tracker.Stage("build " + ref)
+		built, err = HandleGeneration(buildCtx, cfg, artifacts, url, buildOpts)
+		if err != nil {
+			return models.Artifact{}, fmt.Errorf("error building %s: %s", ref, err)
+		}
+		tracker.SetParts(built.Parts)
+		tracker.SetCommit(built.Commit)
+
+		versions[i] = diff.Version{Ref: ref, Commit: built.Commit}
+
+		path := filepath.Join(diffDir, fmt.Sprintf("%d.pdf", i))
+		err = store.Download(artifacts, built.Key, path)
+		if err != nil {
+			return models.Artifact{}, err
+		}
+		docs[i], err = diffDocument(ctx, path)
+		if err != nil {
+			return models.Artifact{}, err
+		}
+	}
+
+	err = stopped(ctx)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+	tracker.Stage("compare")
+	logging.PublishLog(ctx, fmt.Sprintf("Comparing %s and %s...", base, head))
+	changedPages := diff.ChangedPages(docs[0], docs[1])
+	report := diff.NewReport(docs[0], docs[1], changedPages)
+	report.Repo = built.Parts.Owner + "/" + built.Parts.Repo
+	report.Directory = built.Parts.Directory
+	report.Base.Ref, report.Base.Commit = versions[0].Ref, versions[0].Commit
+	report.Head.Ref, report.Head.Commit = versions[1].Ref, versions[1].Commit
+
+	name := fmt.Sprintf("%s-%s-%s", built.Parts.Repo, pdf.Slug(base), pdf.Slug(head))
+	response := models.Artifact{
+		Parts:    built.Parts,
+		DirParts: built.DirParts,
+		Renderer: built.Renderer,
+		Warnings: built.Warnings,
+		Degraded: built.Degraded,
+	}
+
+	switch format {
+	case DiffJSON:
+		response.Path = filepath.Join(diffDir, name+".json")
+		reportJSON, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return models.Artifact{}, err
+		}
+		err = os.WriteFile(response.Path, reportJSON, 0644)
+		if err != nil {
+			return models.Artifact{}, err
+		}
+	case DiffHTML:
+		response.Path = filepath.Join(diffDir, name+".html")
+		err = os.WriteFile(response.Path, []byte(report.HTML()), 0644)
+		if err != nil {
+			return models.Artifact{}, err
+		}
+	case DiffPDF:
+		response.Path = filepath.Join(diffDir, name+".pdf")
+		err = os.Rename(filepath.Join(diffDir, "1.pdf"), response.Path)
+		if err != nil {
+			return models.Artifact{}, err
+		}
+		err = pdf.HighlightPages(response.Path, diffNotes(changedPages, base), "Changes since "+base)
+		if err != nil {
+			return models.Artifact{}, fmt.Errorf("error highlighting changes: %s", err)
+		}
+	}
+
+	tracker.Stage("store")
+	response, err = cache.Store(artifacts, cache.NewID(), response)
+	if err != nil {
+		return models.Artifact{}, fmt.Errorf("error storing artifact: %s", err)
+	}
+	recordStored(ctx, response.Key)
+	logging.PublishLog(ctx, "done!")
+	return response, nil
+}
+
+func diffDocument(ctx context.Context, path string) (diff.Document, error) {
+	pages, err := pdf.PageTexts(ctx, path)
+	if err != nil {
+		return diff.Document{}, err
+	}
+	outline, err := pdf.Outline(path)
+	if err != nil {
+		return diff.Document{}, fmt.Errorf("error reading outline: %s", err)
+	}
+	return diff.Document{Outline: outline, Pages: pages}, nil
+}
+
+func diffNotes(changedPages map[int]int, base string) map[int]string {
+	notes := map[int]string{}
+	for page, lines := range changedPages {
+		noun := "lines"
+		if lines == 1 {
+			noun = "line"
+		}
+		notes[page] = fmt.Sprintf("%d new or changed %s since %s", lines, noun, base)
+	}
+	return notes
+}
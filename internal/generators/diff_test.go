@@ -0,0 +1,45 @@
+package generators
+
+import (
+	"testing"
+
+	"github.com/jeffbrennan/pdfgen/internal/models"
+)
+
+func TestValidateDiff(t *testing.T) {
+	var tests = []struct {
+		name       string
+		base       string
+		head       string
+		format     string
+		opts       models.BuildOptions
+		shouldPass bool
+	}{
+		{"tags", "v2.8.0", "v2.9.0", "", models.BuildOptions{}, true},
+		{"json", "v2.8.0", "main", DiffJSON, models.BuildOptions{}, true},
+		{"annotated pdf", "0123abc", "main", DiffPDF, models.BuildOptions{Output: models.OutputPDF}, true},
+		{"same ref", "main", "main", "", models.BuildOptions{}, false},
+		{"missing base", "", "main", "", models.BuildOptions{}, false},
+		{"option as ref", "main", "--output=/tmp/x", "", models.BuildOptions{}, false},
+		{"unknown format", "v1", "v2", "xml", models.BuildOptions{}, false},
+		{"epub", "v1", "v2", "", models.BuildOptions{Output: models.OutputEPUB}, false},
+		{"zip of documents", "v1", "v2", "", models.BuildOptions{Documents: models.DocumentsZip}, false},
+		{"ref", "v1", "v2", "", models.BuildOptions{Ref: "v3"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateDiff(tt.base, tt.head, tt.format, tt.opts)
+			if (err == nil) != tt.shouldPass {
+				t.Errorf("expected pass=%v, got %v", tt.shouldPass, err)
+			}
+		})
+	}
+}
+
+func TestDiffNotes(t *testing.T) {
+	notes := diffNotes(map[int]int{2: 1, 5: 12}, "v1")
+	if notes[2] != "1 new or changed line since v1" || notes[5] != "12 new or changed lines since v1" || len(notes) != 2 {
+		t.Errorf("unexpected notes %v", notes)
+	}
+}
@@ -0,0 +1,121 @@
+package generators
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jeffbrennan/pdfgen/internal/logging"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/pdf"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+type builtDocument struct {
+	doc      models.Document
+	response models.Artifact
+}
+
+// selectDocuments returns the documents to build for the requested delivery mode
+func selectDocuments(docs []models.Document, mode string) ([]models.Document, error) {
+	if mode == "" || mode == models.DocumentsMerge || mode == models.DocumentsZip {
+		return docs, nil
+	}
+
+	for _, doc := range docs {
+		if doc.Name == mode || doc.Name+".tex" == mode {
+			return []models.Document{doc}, nil
+		}
+	}
+
+	var names []string
+	for _, doc := range docs {
+		names = append(names, doc.Name)
+	}
+	return nil, fmt.Errorf("unknown document %q, available: %s", mode, strings.Join(names, ", "))
+}
+
+func publishDocuments(ctx context.Context, docs []models.Document) {
+	var listed []string
+	for _, doc := range docs {
+		listed = append(listed, fmt.Sprintf("%s (%s)", doc.Name, doc.Title))
+	}
+	docsMsg := "Available documents: " + strings.Join(listed, ", ")
+	log.Print(docsMsg)
+	logging.PublishLog(ctx, docsMsg)
+}
+
+// deliverDocuments turns the built documents into the single file returned to the user
+func deliverDocuments(ctx context.Context, built []builtDocument, outDir string, outputName string, mode string) (models.Artifact, error) {
+	if len(built) == 0 {
+		return models.Artifact{}, fmt.Errorf("no documents were built")
+	}
+	if len(built) == 1 && mode != models.DocumentsZip {
+		return built[0].response, nil
+	}
+
+	response := models.Artifact{}
+	for _, b := range built {
+		response.Degraded = response.Degraded || b.response.Degraded
+		for _, warning := range b.response.Warnings {
+			response.Warnings = append(response.Warnings, b.doc.Name+": "+warning)
+		}
+	}
+
+	if mode == models.DocumentsZip {
+		response.Path = filepath.Join(outDir, outputName+".zip")
+		logging.PublishLog(ctx, fmt.Sprintf("Packaging %d documents as a zip...", len(built)))
+		var paths []string
+		for _, b := range built {
+			paths = append(paths, b.response.Path)
+		}
+		return response, zipFiles(ctx, paths, response.Path)
+	}
+
+	var parts []pdf.Part
+	for _, b := range built {
+		parts = append(parts, pdf.Part{Path: b.response.Path, Title: b.doc.Title})
+	}
+
+	response.Path = filepath.Join(outDir, outputName+".pdf")
+	logging.PublishLog(ctx, fmt.Sprintf("Merging %d documents...", len(built)))
+	err := utils.CheckPath(ctx, response.Path)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+	return response, pdf.Merge(parts, response.Path)
+}
+
+// zipFiles stores paths at the top level of a new zip under their base names
+func zipFiles(ctx context.Context, paths []string, zipPath string) error {
+	f, err := utils.Create(ctx, zipPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for _, path := range paths {
+		w, err := zw.Create(filepath.Base(path))
+		if err != nil {
+			return err
+		}
+
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, src)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
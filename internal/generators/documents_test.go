@@ -0,0 +1,49 @@
+package generators
+
+import (
+	"testing"
+
+	"github.com/jeffbrennan/pdfgen/internal/models"
+)
+
+func TestSelectDocuments(t *testing.T) {
+	docs := []models.Document{
+		{Name: "guide", Title: "User Guide"},
+		{Name: "api", Title: "API Reference"},
+	}
+
+	var tests = []struct {
+		name       string
+		mode       string
+		expected   []string
+		shouldPass bool
+	}{
+		{"default builds all", "", []string{"guide", "api"}, true},
+		{"merge builds all", models.DocumentsMerge, []string{"guide", "api"}, true},
+		{"zip builds all", models.DocumentsZip, []string{"guide", "api"}, true},
+		{"name picks one", "api", []string{"api"}, true},
+		{"tex file name picks one", "guide.tex", []string{"guide"}, true},
+		{"unknown name should fail", "dev", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := selectDocuments(docs, tt.mode)
+			if (err != nil) && tt.shouldPass {
+				t.Fatalf("should pass: got %v", err)
+			}
+			if (err == nil) && !tt.shouldPass {
+				t.Fatalf("should fail")
+			}
+
+			if len(selected) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, selected)
+			}
+			for i, doc := range selected {
+				if doc.Name != tt.expected[i] {
+					t.Errorf("expected %s, got %s", tt.expected[i], doc.Name)
+				}
+			}
+		})
+	}
+}
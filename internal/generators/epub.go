@@ -0,0 +1,179 @@
+package generators
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/epub"
+	"github.com/jeffbrennan/pdfgen/internal/logging"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/recipe"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+type mkdocsConfig struct {
+	SiteName   string `yaml:"site_name"`
+	SiteAuthor string `yaml:"site_author"`
+	DocsDir    string `yaml:"docs_dir"`
+}
+
+// generateEPUB builds a reflowable book with the format's own tooling, then gives it the
+// same provenance metadata and cover regardless of generator
+func generateEPUB(ctx context.Context, cfg *config.Config, rec *recipe.Recipe, parts *models.RepoParts, dirParts *models.DirectoryParts, docType models.DocumentationFormat) (models.Artifact, error) {
+	var response models.Artifact
+	var title string
+	var err error
+
+	switch docType {
+	case models.Sphinx:
+		response, title, err = generateSphinxEPUB(ctx, cfg, rec, parts, dirParts)
+	case models.MkDocs:
+		response, title, err = generateMkDocsEPUB(ctx, cfg, rec, parts, dirParts)
+	default:
+		err = fmt.Errorf("epub output is not supported for %s", models.DocumentationName[docType])
+	}
+	if err != nil {
+		return models.Artifact{}, err
+	}
+
+	logging.PublishLog(ctx, "Adding EPUB metadata and cover...")
+	err = epub.Finalize(response.Path, epub.Metadata{
+		Title:     title,
+		Repo:      repoURL(parts),
+		Ref:       parts.Branch,
+		BuildDate: time.Now(),
+	})
+	if err != nil {
+		return models.Artifact{}, fmt.Errorf("error finalizing epub: %s", err)
+	}
+
+	return response, nil
+}
+
+func generateSphinxEPUB(ctx context.Context, cfg *config.Config, rec *recipe.Recipe, parts *models.RepoParts, dirParts *models.DirectoryParts) (models.Artifact, string, error) {
+	settings, err := readSphinxSettings(ctx, cfg, rec, dirParts)
+	if err != nil {
+		return models.Artifact{}, "", err
+	}
+
+	args, err := sphinxBuildArgs(cfg, rec, dirParts, "epub", "")
+	if err != nil {
+		return models.Artifact{}, "", err
+	}
+	args = append(args, "-D", "epub_basename="+outputFileName(parts))
+
+	logging.PublishLog(ctx, "Generating docs as EPUB...")
+	out, err := utils.RunCommandEnv(ctx, args, dirParts.Base, rec.Env)
+	if err != nil {
+		log.Printf("Error running sphinx-build: %s", out)
+		return models.Artifact{}, "", err
+	}
+
+	return models.Artifact{
+		Path: filepath.Join(dirParts.Base, "_build", "epub", outputFileName(parts)+".epub"),
+		Documents: []models.Document{
+			{Name: settings.RootDoc, Title: settings.Project},
+		},
+	}, settings.Project, nil
+}
+
+func readMkDocsConfig(dirParts *models.DirectoryParts) (*mkdocsConfig, error) {
+	data, err := os.ReadFile(mkdocsConfigFile(dirParts))
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &mkdocsConfig{}
+	err = yaml.Unmarshal(data, conf)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing mkdocs config: %s", err)
+	}
+	if conf.DocsDir == "" {
+		conf.DocsDir = "docs"
+	}
+	return conf, nil
+}
+
+// mkdocsSource maps a built page location back to its Markdown source
+func mkdocsSource(docsDir string, location string) (string, error) {
+	location = strings.TrimSuffix(location, ".html")
+	location = strings.TrimSuffix(location, "/")
+
+	var candidates []string
+	if location == "" {
+		candidates = []string{"index.md", "README.md"}
+	} else {
+		candidates = []string{
+			location + ".md",
+			filepath.Join(location, "index.md"),
+			filepath.Join(location, "README.md"),
+		}
+	}
+
+	for _, candidate := range candidates {
+		_, err := os.Stat(filepath.Join(docsDir, candidate))
+		if err == nil {
+			return candidate, nil
+		}
+	}
+	return "", errors.New("no markdown source for page " + location)
+}
+
+// generateMkDocsEPUB converts the Markdown sources with pandoc in navigation order
+func generateMkDocsEPUB(ctx context.Context, cfg *config.Config, rec *recipe.Recipe, parts *models.RepoParts, dirParts *models.DirectoryParts) (models.Artifact, string, error) {
+	conf, err := readMkDocsConfig(dirParts)
+	if err != nil {
+		return models.Artifact{}, "", err
+	}
+
+	// the built site is only needed for its navigation order
+	siteDir, err := buildMkDocsSite(ctx, cfg, rec, dirParts)
+	if err != nil {
+		return models.Artifact{}, "", err
+	}
+
+	docsDir := filepath.Join(filepath.Dir(mkdocsConfigFile(dirParts)), conf.DocsDir)
+	var sources []string
+	for _, page := range mkdocsPages(siteDir) {
+		source, err := mkdocsSource(docsDir, page.Location)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		sources = append(sources, source)
+	}
+	if len(sources) == 0 {
+		return models.Artifact{}, "", fmt.Errorf("no markdown sources found in %s", docsDir)
+	}
+
+	epubPath, err := filepath.Abs(filepath.Join(filepath.Dir(siteDir), outputFileName(parts)+".epub"))
+	if err != nil {
+		return models.Artifact{}, "", err
+	}
+
+	logging.PublishLog(ctx, fmt.Sprintf("Converting %d pages to EPUB...", len(sources)))
+	args := []string{
+		"pandoc",
+		"--from", "markdown",
+		"--to", "epub3",
+		"--toc",
+		"--metadata", "title=" + conf.SiteName,
+		"--output", epubPath,
+	}
+	out, err := utils.RunCommandEnv(ctx, append(args, sources...), docsDir, rec.Env)
+	if err != nil {
+		log.Printf("Error running pandoc: %s", out)
+		return models.Artifact{}, "", err
+	}
+
+	return models.Artifact{Path: epubPath}, conf.SiteName, nil
+}
@@ -0,0 +1,407 @@
+package generators
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/logging"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/recipe"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+var (
+	stylesheetLink = regexp.MustCompile(`<link[^>]*rel="stylesheet"[^>]*>`)
+	hrefAttr       = regexp.MustCompile(`href="([^"]+)"`)
+	imgSrc         = regexp.MustCompile(`(<img[^>]*\ssrc=")([^"]+)(")`)
+	localScript    = regexp.MustCompile(`<script[^>]*\ssrc="[^":]+"[^>]*>\s*</script>`)
+	cssURL         = regexp.MustCompile(`url\(\s*['"]?([^'")]+)['"]?\s*\)`)
+	markdownLink   = regexp.MustCompile(`(!?)\[([^\]]*)\]\(([^)\s]+)\)`)
+	markdownHead   = regexp.MustCompile(`^(#{1,6})\s+(.+?)\s*#*\s*$`)
+)
+
+// generateExport produces a single self-contained HTML or Markdown file from the same
+// sources the PDF builds use
+func generateExport(ctx context.Context, cfg *config.Config, rec *recipe.Recipe, opts models.BuildOptions, parts *models.RepoParts, dirParts *models.DirectoryParts, docType models.DocumentationFormat) (models.Artifact, error) {
+	switch docType {
+	case models.Sphinx:
+		return generateSphinxExport(ctx, cfg, rec, opts, parts, dirParts)
+	case models.MkDocs:
+		return generateMkDocsExport(ctx, cfg, rec, opts, parts, dirParts)
+	}
+	return models.Artifact{}, fmt.Errorf("%s output is not supported for %s", opts.Output, models.DocumentationName[docType])
+}
+
+func generateSphinxExport(ctx context.Context, cfg *config.Config, rec *recipe.Recipe, opts models.BuildOptions, parts *models.RepoParts, dirParts *models.DirectoryParts) (models.Artifact, error) {
+	settings, err := readSphinxSettings(ctx, cfg, rec, dirParts)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+
+	args, err := sphinxBuildArgs(cfg, rec, dirParts, "singlehtml", "")
+	if err != nil {
+		return models.Artifact{}, err
+	}
+
+	logging.PublishLog(ctx, "Generating docs as HTML...")
+	out, err := utils.RunCommandEnv(ctx, args, dirParts.Base, rec.Env)
+	if err != nil {
+		log.Printf("Error running sphinx-build: %s", out)
+		return models.Artifact{}, err
+	}
+
+	htmlDir, err := filepath.Abs(filepath.Join(dirParts.Base, "_build", "singlehtml"))
+	if err != nil {
+		return models.Artifact{}, err
+	}
+	htmlPath := filepath.Join(htmlDir, settings.RootDoc+".html")
+	response := models.Artifact{
+		Documents: []models.Document{
+			{Name: settings.RootDoc, Title: settings.Project},
+		},
+	}
+
+	if opts.Output == models.OutputMarkdown {
+		response.Path = filepath.Join(htmlDir, outputFileName(parts)+".md")
+		logging.PublishLog(ctx, "Converting HTML to Markdown...")
+		args := []string{
+			"pandoc",
+			"--from", "html",
+			"--to", "gfm",
+			"--standalone",
+			"--toc",
+			"--metadata", "title=" + settings.Project,
+			"--output", response.Path,
+			htmlPath,
+		}
+		out, err := utils.RunCommandEnv(ctx, args, htmlDir, rec.Env)
+		if err != nil {
+			log.Printf("Error running pandoc: %s", out)
+			return models.Artifact{}, err
+		}
+		return response, nil
+	}
+
+	logging.PublishLog(ctx, "Inlining stylesheets and images...")
+	page, err := os.ReadFile(htmlPath)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+	response.Path = filepath.Join(htmlDir, outputFileName(parts)+".html")
+	err = utils.WriteFile(ctx, response.Path, []byte(inlineHTML(string(page), htmlDir)), 0644)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+	return response, nil
+}
+
+func generateMkDocsExport(ctx context.Context, cfg *config.Config, rec *recipe.Recipe, opts models.BuildOptions, parts *models.RepoParts, dirParts *models.DirectoryParts) (models.Artifact, error) {
+	conf, err := readMkDocsConfig(dirParts)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+
+	// the built site is only needed for its navigation order
+	siteDir, err := buildMkDocsSite(ctx, cfg, rec, dirParts)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+
+	docsDir := filepath.Join(filepath.Dir(mkdocsConfigFile(dirParts)), conf.DocsDir)
+	var sources []string
+	for _, page := range mkdocsPages(siteDir) {
+		source, err := mkdocsSource(docsDir, page.Location)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		sources = append(sources, source)
+	}
+	if len(sources) == 0 {
+		return models.Artifact{}, fmt.Errorf("no markdown sources found in %s", docsDir)
+	}
+
+	outDir, err := filepath.Abs(filepath.Dir(siteDir))
+	if err != nil {
+		return models.Artifact{}, err
+	}
+
+	if opts.Output == models.OutputMarkdown {
+		logging.PublishLog(ctx, fmt.Sprintf("Concatenating %d pages...", len(sources)))
+		pages := make([]markdownPage, 0, len(sources))
+		for _, source := range sources {
+			content, err := readLocal(dirParts.Root, docsDir, source)
+			if err != nil {
+				return models.Artifact{}, err
+			}
+			pages = append(pages, markdownPage{Source: filepath.ToSlash(source), Content: string(content)})
+		}
+
+		relDocsDir, err := filepath.Rel(dirParts.Base, docsDir)
+		if err != nil {
+			return models.Artifact{}, err
+		}
+		assetBase := rawContentURL(parts, filepath.ToSlash(relDocsDir))
+
+		mdPath := filepath.Join(outDir, outputFileName(parts)+".md")
+		err = utils.WriteFile(ctx, mdPath, []byte(concatMarkdown(conf.SiteName, pages, assetBase)), 0644)
+		if err != nil {
+			return models.Artifact{}, err
+		}
+		return models.Artifact{Path: mdPath}, nil
+	}
+
+	// pandoc only converts, images are inlined by inlineHTML which keeps to the docs dir
+	htmlPath := filepath.Join(outDir, outputFileName(parts)+".html")
+	logging.PublishLog(ctx, fmt.Sprintf("Converting %d pages to HTML...", len(sources)))
+	args := []string{
+		"pandoc",
+		"--from", "markdown",
+		"--to", "html5",
+		"--standalone",
+		"--toc",
+		"--metadata", "title=" + conf.SiteName,
+		"--output", htmlPath,
+	}
+	out, err := utils.RunCommandEnv(ctx, append(args, sources...), docsDir, rec.Env)
+	if err != nil {
+		log.Printf("Error running pandoc: %s", out)
+		return models.Artifact{}, err
+	}
+
+	logging.PublishLog(ctx, "Inlining images...")
+	page, err := os.ReadFile(htmlPath)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+	err = utils.WriteFile(ctx, htmlPath, []byte(inlineHTML(string(page), docsDir)), 0644)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+	return models.Artifact{Path: htmlPath}, nil
+}
+
+// rawContentURL points relative assets of a Markdown export back at the repository
+func rawContentURL(parts *models.RepoParts, dir string) string {
+	ref := parts.Branch
+	if ref == "" {
+		ref = "HEAD"
+	}
+	base := fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s", parts.Owner, parts.Repo, ref)
+	if dir != "" && dir != "." {
+		base += "/" + strings.Trim(dir, "/")
+	}
+	return base
+}
+
+func isRelativeRef(ref string) bool {
+	return ref != "" &&
+		!strings.HasPrefix(ref, "#") &&
+		!strings.HasPrefix(ref, "/") &&
+		!strings.HasPrefix(ref, "data:") &&
+		!strings.Contains(ref, "://") &&
+		!strings.HasPrefix(ref, "mailto:")
+}
+
+// readLocal reads ref relative to dir. Built pages and sources come from the repository,
+// so only regular files that resolve to somewhere under root are read.
+func readLocal(root string, dir string, ref string) ([]byte, error) {
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(dir, filepath.FromSlash(ref)))
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(resolvedRoot, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("%s is outside of %s", ref, root)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", ref)
+	}
+	return os.ReadFile(resolved)
+}
+
+// dataURI reads a file under dir and encodes it inline, returning "" when it can't be read
+// or is outside of root
+func dataURI(root string, dir string, ref string) string {
+	ref = strings.SplitN(strings.SplitN(ref, "#", 2)[0], "?", 2)[0]
+	data, err := readLocal(root, dir, ref)
+	if err != nil {
+		log.Printf("not inlining %s: %s", ref, err)
+		return ""
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(ref))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// inlineCSS embeds the files a stylesheet references so it no longer depends on cssDir
+func inlineCSS(css string, root string, cssDir string) string {
+	return cssURL.ReplaceAllStringFunc(css, func(match string) string {
+		ref := cssURL.FindStringSubmatch(match)[1]
+		if !isRelativeRef(ref) {
+			return match
+		}
+		uri := dataURI(root, cssDir, ref)
+		if uri == "" {
+			return match
+		}
+		return `url("` + uri + `")`
+	})
+}
+
+// inlineHTML turns a built page into one self-contained file: local stylesheets become
+// <style> blocks, local images become data URIs and local scripts are dropped. Nothing
+// outside of htmlDir is inlined.
+func inlineHTML(page string, htmlDir string) string {
+	page = stylesheetLink.ReplaceAllStringFunc(page, func(link string) string {
+		href := hrefAttr.FindStringSubmatch(link)
+		if href == nil || !isRelativeRef(href[1]) {
+			return link
+		}
+		css, err := readLocal(htmlDir, htmlDir, href[1])
+		if err != nil {
+			log.Printf("not inlining %s: %s", href[1], err)
+			return link
+		}
+		cssDir := filepath.Dir(filepath.Join(htmlDir, filepath.FromSlash(href[1])))
+		return "<style>\n" + inlineCSS(string(css), htmlDir, cssDir) + "\n</style>"
+	})
+
+	page = imgSrc.ReplaceAllStringFunc(page, func(img string) string {
+		m := imgSrc.FindStringSubmatch(img)
+		if !isRelativeRef(m[2]) {
+			return img
+		}
+		uri := dataURI(htmlDir, htmlDir, m[2])
+		if uri == "" {
+			return img
+		}
+		return m[1] + uri + m[3]
+	})
+
+	return localScript.ReplaceAllString(page, "")
+}
+
+type markdownPage struct {
+	// Source is the page path relative to the docs dir, with forward slashes
+	Source  string
+	Content string
+}
+
+type markdownHeading struct {
+	Level int
+	Text  string
+}
+
+// pageAnchor is the id a page gets in the concatenated document
+func pageAnchor(source string) string {
+	source = strings.TrimSuffix(source, path.Ext(source))
+	var b strings.Builder
+	for _, r := range strings.ToLower(source) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('-')
+		}
+	}
+	return "page-" + b.String()
+}
+
+// markdownHeadings lists the ATX headings outside fenced code blocks
+func markdownHeadings(content string) []markdownHeading {
+	var headings []markdownHeading
+	inFence := false
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+		m := markdownHead.FindStringSubmatch(line)
+		if m != nil {
+			headings = append(headings, markdownHeading{Level: len(m[1]), Text: m[2]})
+		}
+	}
+	return headings
+}
+
+// rewriteMarkdownLinks points links between pages at their anchors in the concatenated
+// document and relative assets at assetBase
+func rewriteMarkdownLinks(page markdownPage, known map[string]bool, assetBase string) string {
+	pageDir := path.Dir(page.Source)
+	return markdownLink.ReplaceAllStringFunc(page.Content, func(match string) string {
+		m := markdownLink.FindStringSubmatch(match)
+		bang, text, target := m[1], m[2], m[3]
+		if !isRelativeRef(target) {
+			return match
+		}
+
+		file, fragment, _ := strings.Cut(target, "#")
+		resolved := path.Clean(path.Join(pageDir, file))
+		if bang == "" && strings.HasSuffix(file, ".md") {
+			if !known[resolved] {
+				return match
+			}
+			if fragment != "" {
+				return "[" + text + "](#" + fragment + ")"
+			}
+			return "[" + text + "](#" + pageAnchor(resolved) + ")"
+		}
+		return bang + "[" + text + "](" + assetBase + "/" + resolved + ")"
+	})
+}
+
+// concatMarkdown joins pages in navigation order behind a generated table of contents
+func concatMarkdown(title string, pages []markdownPage, assetBase string) string {
+	known := map[string]bool{}
+	for _, page := range pages {
+		known[page.Source] = true
+	}
+
+	var b strings.Builder
+	if title != "" {
+		fmt.Fprintf(&b, "# %s\n\n", title)
+	}
+
+	b.WriteString("## Contents\n\n")
+	for _, page := range pages {
+		headings := markdownHeadings(page.Content)
+		name := page.Source
+		if len(headings) > 0 {
+			name = headings[0].Text
+		}
+		fmt.Fprintf(&b, "- [%s](#%s)\n", name, pageAnchor(page.Source))
+	}
+
+	for _, page := range pages {
+		fmt.Fprintf(&b, "\n<a id=\"%s\"></a>\n\n", pageAnchor(page.Source))
+		b.WriteString(strings.TrimSpace(rewriteMarkdownLinks(page, known, assetBase)))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
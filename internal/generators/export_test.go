@@ -0,0 +1,164 @@
+package generators
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInlineHTML(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"_static/theme.css": `body { background: url("bg.png"); }`,
+		"_static/bg.png":    "bg",
+		"_images/logo.png":  "logo",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// files outside of the built site are never inlined, however they are reached
+	outside := filepath.Join(t.TempDir(), "secret.png")
+	err := os.WriteFile(outside, []byte("secret"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink(outside, filepath.Join(dir, "_images", "link.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "_static", "leak.css"), []byte(`a { background: url("`+outside+`") } b { background: url("../../`+filepath.Base(outside)+`") }`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	traversal, err := filepath.Rel(dir, outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := base64.StdEncoding.EncodeToString([]byte("secret"))
+
+	var tests = []struct {
+		name     string
+		html     string
+		contains []string
+		excludes []string
+	}{
+		{
+			"stylesheet and its images inlined",
+			`<link rel="stylesheet" href="_static/theme.css" type="text/css" />`,
+			[]string{"<style>", "data:image/png;base64,Ymc="},
+			[]string{"<link"},
+		},
+		{
+			"remote stylesheet kept",
+			`<link rel="stylesheet" href="https://example.com/theme.css" />`,
+			[]string{`href="https://example.com/theme.css"`},
+			nil,
+		},
+		{
+			"image inlined",
+			`<img alt="logo" src="_images/logo.png" />`,
+			[]string{`src="data:image/png;base64,bG9nbw=="`},
+			[]string{"_images/logo.png"},
+		},
+		{
+			"missing image kept",
+			`<img src="_images/missing.png" />`,
+			[]string{`src="_images/missing.png"`},
+			nil,
+		},
+		{
+			"image outside the site kept",
+			`<img src="` + filepath.ToSlash(traversal) + `" />`,
+			[]string{`src="` + filepath.ToSlash(traversal) + `"`},
+			[]string{secret},
+		},
+		{
+			"symlinked image kept",
+			`<img src="_images/link.png" />`,
+			[]string{`src="_images/link.png"`},
+			[]string{secret},
+		},
+		{
+			"directory kept",
+			`<img src="_images" />`,
+			[]string{`src="_images"`},
+			nil,
+		},
+		{
+			"stylesheet references outside the site kept",
+			`<link rel="stylesheet" href="_static/leak.css" />`,
+			[]string{"<style>"},
+			[]string{secret, "data:"},
+		},
+		{
+			"local script dropped",
+			`<script src="_static/searchtools.js"></script><p>hi</p>`,
+			[]string{"<p>hi</p>"},
+			[]string{"searchtools"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := inlineHTML(tt.html, dir)
+			for _, s := range tt.contains {
+				if !strings.Contains(got, s) {
+					t.Errorf("expected %q in %q", s, got)
+				}
+			}
+			for _, s := range tt.excludes {
+				if strings.Contains(got, s) {
+					t.Errorf("unexpected %q in %q", s, got)
+				}
+			}
+		})
+	}
+}
+
+func TestConcatMarkdown(t *testing.T) {
+	pages := []markdownPage{
+		{Source: "index.md", Content: "# Home\n\nSee [usage](guide/usage.md) and [flags](guide/usage.md#flags).\n"},
+		{Source: "guide/usage.md", Content: "# Usage\n\n![diagram](img/flow.png)\n\n```sh\n# not a heading\n```\n\n[home](../index.md) [site](https://example.com/a.md) [gone](missing.md)\n"},
+	}
+
+	got := concatMarkdown("Project", pages, "https://raw.githubusercontent.com/o/r/main/docs")
+
+	var tests = []struct {
+		name     string
+		expected string
+	}{
+		{"title", "# Project\n"},
+		{"toc entry", "- [Home](#page-index)\n"},
+		{"toc uses first heading", "- [Usage](#page-guide-usage)\n"},
+		{"page anchor", `<a id="page-guide-usage"></a>`},
+		{"page link", "[usage](#page-guide-usage)"},
+		{"fragment link", "[flags](#flags)"},
+		{"parent link", "[home](#page-index)"},
+		{"asset", "![diagram](https://raw.githubusercontent.com/o/r/main/docs/guide/img/flow.png)"},
+		{"absolute link kept", "[site](https://example.com/a.md)"},
+		{"unknown page kept", "[gone](missing.md)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(got, tt.expected) {
+				t.Errorf("expected %q in:\n%s", tt.expected, got)
+			}
+		})
+	}
+
+	if strings.Contains(got, "not a heading](") {
+		t.Errorf("heading inside code fence listed in toc:\n%s", got)
+	}
+}
@@ -1,71 +1,223 @@
 package generators
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/jeffbrennan/pdfgen/internal/cache"
+	"github.com/jeffbrennan/pdfgen/internal/config"
 	"github.com/jeffbrennan/pdfgen/internal/env"
+	"github.com/jeffbrennan/pdfgen/internal/jobs"
 	"github.com/jeffbrennan/pdfgen/internal/logging"
 	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/recipe"
 	"github.com/jeffbrennan/pdfgen/internal/repo"
+	"github.com/jeffbrennan/pdfgen/internal/store"
 	"github.com/jeffbrennan/pdfgen/internal/utils"
 )
 
-func HandlePdfGeneration(url string) (models.PDFGenResponse, error) {
+// HandleGeneration builds url into artifacts, recording its stages on the job tracker of
+// ctx if any. The build runs within cfg.Limits.
+func HandleGeneration(ctx context.Context, cfg *config.Config, artifacts store.ArtifactStore, url string, opts models.BuildOptions) (models.Artifact, error) {
+	tracker := jobs.FromContext(ctx)
+	ctx, cancel := withLimit(ctx, "total", cfg.Limits.Total)
+	defer cancel()
+	ctx = withResourceLimits(ctx, cfg.Limits)
+
+	err := ValidateOptions(opts)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+
+	parts, dirParts, rec, commit, err := prepareCheckout(ctx, cfg, url, opts.Ref)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+	tracker.SetCommit(commit)
+
+	cacheKey := buildCacheKey(cfg, parts, commit, rec, opts)
+	response, cached := cache.Load(artifacts, cfg.Cache, cacheKey)
+	if cached {
+		cacheMsg := fmt.Sprintf("Using cached build of %s/%s", parts.Owner, parts.Repo)
+		log.Print(cacheMsg)
+		logging.PublishLog(ctx, cacheMsg)
+	} else {
+		buildCtx, err := withSandbox(ctx, cfg, parts)
+		if err != nil {
+			return models.Artifact{}, err
+		}
+		response, err = buildArtifact(buildCtx, cfg, rec, opts, parts, dirParts)
+		if err != nil {
+			return models.Artifact{}, err
+		}
+		response.Commit = commit
+
+		err = stopped(ctx)
+		if err != nil {
+			return models.Artifact{}, err
+		}
+		tracker.Stage("store")
+		// builds that can't be cached still go to the store, that's where they are served from
+		id := cacheKey
+		if id == "" {
+			id = cache.NewID()
+		}
+		log.Printf("storing artifact: %s", response.Path)
+		response, err = cache.Store(artifacts, id, response)
+		if err != nil {
+			return models.Artifact{}, fmt.Errorf("error storing artifact: %s", err)
+		}
+	}
+	logging.PublishLog(ctx, "done!")
+
+	response.Parts = parts
+	response.DirParts = dirParts
+	recordStored(ctx, response.Key)
+	return response, nil
+}
+
+// prepareCheckout validates url and checks it out at ref, then loads its recipe and
+// finds its docs directory and commit. Builds and detection go through it alike, the
+// stages go on the job tracker of ctx if any. Errors are *StageError.
+func prepareCheckout(ctx context.Context, cfg *config.Config, url string, ref string) (*models.RepoParts, *models.DirectoryParts, *recipe.Recipe, string, error) {
+	tracker := jobs.FromContext(ctx)
+	stageError := func(stage string, err error) error {
+		return &StageError{Stage: stage, Err: err}
+	}
+
+	if ref != "" {
+		err := repo.ValidateRef(ref)
+		if err != nil {
+			return nil, nil, nil, "", stageError("validate", err)
+		}
+	}
 	parts, err := repo.ParseRepoURL(url)
 	if err != nil {
-		return models.PDFGenResponse{}, fmt.Errorf("error parsing URL: %s", err)
+		return nil, nil, nil, "", stageError("validate", fmt.Errorf("error parsing URL: %s", err))
 	}
+	tracker.SetParts(parts)
 
-	err = repo.ValidateRepo(parts)
+	tracker.Stage("validate")
+	err = repo.ValidateRepo(ctx, cfg.Validation, parts)
 	if err != nil {
-		return models.PDFGenResponse{}, fmt.Errorf("error validating repo: %s", err)
+		return nil, nil, nil, "", stageError("validate", fmt.Errorf("error validating repo: %s", err))
 	}
 
-	err = repo.UpdateRepo(parts)
+	tracker.Stage("clone")
+	err = updateCheckout(ctx, cfg, parts, ref)
 	if err != nil {
-		return models.PDFGenResponse{}, fmt.Errorf("error updating repo: %s", err)
+		return nil, nil, nil, "", stageError("clone", err)
+	}
+	if ref != "" {
+		// provenance and metadata name the ref that was built
+		parts.Branch = ref
+		tracker.SetParts(parts)
 	}
 
-	dirParts, err := repo.ParseRepoDir(parts)
+	rec, err := recipe.Load(cfg.Recipes, parts, utils.CheckoutDir(ctx, cfg.Repo, parts))
 	if err != nil {
-		return models.PDFGenResponse{}, fmt.Errorf("error parsing repo directory: %s", err)
+		return nil, nil, nil, "", stageError("clone", fmt.Errorf("error loading recipe: %s", err))
 	}
 
-	docName, err := ParseDocumentationFormat(dirParts)
+	// "docs" is the parser default, so only an explicit directory in the url beats the recipe
+	if rec.DocDir != "" && (parts.Directory == "" || parts.Directory == "docs") {
+		log.Printf("Using recipe doc dir: %s", rec.DocDir)
+		parts.Directory = strings.TrimSuffix(rec.DocDir, "/")
+	}
+
+	dirParts, err := repo.ParseRepoDir(ctx, cfg.Repo, parts)
 	if err != nil {
-		return models.PDFGenResponse{}, fmt.Errorf("error parsing documentation format: %s", err)
+		return nil, nil, nil, "", stageError("clone", fmt.Errorf("error parsing repo directory: %s", err))
 	}
 
-	log.Printf("Documentation format: %s\n", models.DocumentationName[docName])
-	pdfPath, err := generatePDF(parts, dirParts, docName)
+	commit, err := repo.HeadCommit(ctx, cfg.Repo, parts)
 	if err != nil {
-		return models.PDFGenResponse{}, fmt.Errorf("error generating PDF: %s", err)
+		log.Print(err)
 	}
+	return parts, dirParts, rec, commit, nil
+}
 
-	log.Printf("reading PDF file: %s", pdfPath)
-	pdfBytes, err := os.ReadFile(pdfPath)
+// updateCheckout clones or pulls the repo and pins it to ref if set, within the clone
+// time and checkout size limits
+func updateCheckout(ctx context.Context, cfg *config.Config, parts *models.RepoParts, ref string) error {
+	ctx, cancel := withLimit(ctx, "clone", cfg.Limits.Clone)
+	defer cancel()
+	checkoutDir := utils.CheckoutDir(ctx, cfg.Repo, parts)
+	ctx, stopWatching := watchCheckout(ctx, checkoutDir, cfg.Limits.CheckoutMB)
+	defer stopWatching()
 
+	err := repo.UpdateRepo(ctx, cfg.Repo, parts, ref)
+	if err != nil {
+		return fmt.Errorf("error updating repo: %s", err)
+	}
+	return checkCheckout(checkoutDir, cfg.Limits.CheckoutMB)
+}
+
+// buildArtifact runs the build for a checkout, including any PDF post-processing
+func buildArtifact(ctx context.Context, cfg *config.Config, rec *recipe.Recipe, opts models.BuildOptions, parts *models.RepoParts, dirParts *models.DirectoryParts) (models.Artifact, error) {
+	tracker := jobs.FromContext(ctx)
+	tracker.Stage("detect")
+	docName, err := ParseDocumentationFormat(ctx, dirParts)
+	if err != nil {
+		return models.Artifact{}, fmt.Errorf("error parsing documentation format: %s", err)
+	}
+	tracker.SetFormat(docName)
+
+	log.Printf("Documentation format: %s\n", models.DocumentationName[docName])
+	response, err := generateArtifact(ctx, cfg, rec, opts, parts, dirParts, docName)
+	if err != nil {
+		return models.Artifact{}, fmt.Errorf("error generating output: %s", err)
+	}
+	// the artifact is post-processed and stored from where the build left it
+	err = utils.CheckPath(ctx, response.Path)
+	if err != nil {
+		return models.Artifact{}, fmt.Errorf("error generating output: %s", err)
+	}
+	err = stopped(ctx)
 	if err != nil {
-		return models.PDFGenResponse{}, fmt.Errorf("error reading PDF file: %s", err)
+		return models.Artifact{}, err
 	}
-	logging.PublishLog("done!")
 
-	return models.PDFGenResponse{
-		Parts:    parts,
-		DirParts: dirParts,
-		PdfPath:  pdfPath,
-		PdfBytes: pdfBytes,
-	}, nil
+	if filepath.Ext(response.Path) == ".pdf" {
+		tracker.Stage("postprocess")
+		// the PDF is still usable without its metadata
+		err = postProcessPDF(ctx, cfg, response, parts, docName)
+		if err != nil {
+			postMsg := fmt.Sprintf("Warning: PDF post-processing failed: %s", err)
+			log.Print(postMsg)
+			logging.PublishLog(ctx, postMsg)
+		}
+
+		if opts.Split > 0 {
+			response = splitPDF(ctx, response, opts.Split)
+		}
+	}
 
+	return response, nil
+}
+
+// buildCacheKey returns "" when the build can't be cached
+func buildCacheKey(cfg *config.Config, parts *models.RepoParts, commit string, rec *recipe.Recipe, opts models.BuildOptions) string {
+	if !cfg.Cache.Enabled || commit == "" {
+		return ""
+	}
+	key, err := cache.Key(parts, commit, rec, opts)
+	if err != nil {
+		log.Print(err)
+		return ""
+	}
+	return key
 }
 
 func ParseDocumentationFormat(
+	ctx context.Context,
 	dirParts *models.DirectoryParts,
 ) (models.DocumentationFormat, error) {
-	out, err := utils.RunCommand([]string{"ls", dirParts.Doc}, dirParts.Base)
+	out, err := utils.RunCommand(ctx, []string{"ls", dirParts.Doc}, dirParts.Base)
 	if err != nil {
 		return -1, err
 	}
@@ -79,7 +231,7 @@ func ParseDocumentationFormat(
 				file,
 			)
 			log.Print(sphinxMsg)
-			logging.PublishLog(sphinxMsg)
+			logging.PublishLog(ctx, sphinxMsg)
 
 			return models.Sphinx, nil
 		}
@@ -103,26 +255,125 @@ func ParseDocumentationFormat(
 	return -1, fmt.Errorf("unknown documentation format")
 
 }
-func generatePDF(parts *models.RepoParts, dirParts *models.DirectoryParts, docType models.DocumentationFormat) (string, error) {
-	logging.PublishLog("Generating PDF...")
-	envType, err := env.ParseEnvType(dirParts)
+func generateArtifact(ctx context.Context, cfg *config.Config, rec *recipe.Recipe, opts models.BuildOptions, parts *models.RepoParts, dirParts *models.DirectoryParts, docType models.DocumentationFormat) (models.Artifact, error) {
+	tracker := jobs.FromContext(ctx)
+	logging.PublishLog(ctx, fmt.Sprintf("Generating %s...", outputLabel(opts.Output)))
+	tracker.Stage("env")
+	envCtx, cancelEnv := withLimit(ctx, "env", cfg.Limits.Env)
+	defer cancelEnv()
+	// installing dependencies is the only stage with network access
+	envCtx = utils.WithNetwork(envCtx)
+	envType, err := env.ParseEnvType(envCtx, dirParts)
 	if err != nil {
-		return "", err
+		return models.Artifact{}, err
 	}
 	if envType == models.PYTHON {
-		pythonEnv, err := env.ParsePythonEnv(dirParts)
+		pythonEnv, err := env.ParsePythonEnv(envCtx, dirParts)
+		if err != nil {
+			return models.Artifact{}, err
+		}
+		tracker.SetEnv(models.EnvName[envType], models.PythonEnvName[pythonEnv])
+
+		// a build without its dependencies only fails later on an unrelated import error
+		err = env.SetupPythonEnv(envCtx, cfg.Sphinx, dirParts, pythonEnv, envSpec(rec))
 		if err != nil {
-			return "", err
+			envMsg := fmt.Sprintf("error setting up environment: %s", err)
+			log.Print(envMsg)
+			logging.PublishLog(ctx, envMsg)
+			return models.Artifact{}, &StageError{Stage: "env", Err: errors.New(envMsg)}
 		}
+	} else {
+		tracker.SetEnv(models.EnvName[envType])
+	}
 
-		env.SetupPythonEnv(dirParts, pythonEnv)
+	tracker.Stage("build")
+	ctx, cancel := withLimit(ctx, "build", cfg.Limits.Build)
+	defer cancel()
+
+	engine, err := applyRecipe(ctx, rec, dirParts)
+	defer revertPatches(engine)
+	if err != nil {
+		return models.Artifact{}, fmt.Errorf("error applying recipe: %s", err)
 	}
 
+	switch opts.Output {
+	case models.OutputEPUB:
+		return generateEPUB(ctx, cfg, rec, parts, dirParts, docType)
+	case models.OutputHTML, models.OutputMarkdown:
+		return generateExport(ctx, cfg, rec, opts, parts, dirParts, docType)
+	}
+
+	deco := newDecorations(ctx, cfg, opts, parts, dirParts)
+
 	switch docType {
 	case models.Sphinx:
-		return generateSphinxPDF(parts, dirParts)
+		if opts.Renderer == models.RendererHTML {
+			return generateSphinxHTMLPDF(ctx, cfg, rec, deco, parts, dirParts)
+		}
+
+		response, err := generateSphinxPDF(ctx, cfg, rec, opts, deco, parts, dirParts)
+		if err == nil || opts.Renderer == models.RendererLatex || !cfg.HTML.Fallback || ctx.Err() != nil {
+			return response, err
+		}
+
+		fallbackMsg := fmt.Sprintf("LaTeX build failed (%s), falling back to HTML", err)
+		log.Print(fallbackMsg)
+		logging.PublishLog(ctx, fallbackMsg)
+
+		response, htmlErr := generateSphinxHTMLPDF(ctx, cfg, rec, deco, parts, dirParts)
+		if htmlErr != nil {
+			return models.Artifact{}, fmt.Errorf("%s; html fallback failed: %s", err, htmlErr)
+		}
+		response.Warnings = append(response.Warnings, fallbackMsg)
+		return response, nil
+	case models.MkDocs:
+		if opts.Renderer == models.RendererLatex {
+			return models.Artifact{}, fmt.Errorf("mkdocs only supports the html renderer")
+		}
+		return generateMkDocsPDF(ctx, cfg, rec, deco, parts, dirParts)
 	}
 
-	return "", fmt.Errorf("unknown documentation format")
+	return models.Artifact{}, fmt.Errorf("unknown documentation format")
+
+}
+
+func outputFileName(parts *models.RepoParts) string {
+	return parts.Repo + "_" + strings.ReplaceAll(parts.Directory, "/", "_")
+}
+
+func ValidateOptions(opts models.BuildOptions) error {
+	if opts.Renderer != "" && opts.Renderer != models.RendererLatex && opts.Renderer != models.RendererHTML {
+		return fmt.Errorf("unknown renderer: %s", opts.Renderer)
+	}
+	if opts.Output != "" && !utils.Contains(models.Outputs, opts.Output) {
+		return fmt.Errorf("unknown output: %s", opts.Output)
+	}
+	if opts.Ref != "" {
+		err := repo.ValidateRef(opts.Ref)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.Split < 0 || opts.Split > MaxSplitDepth {
+		return fmt.Errorf("split depth must be between 0 and %d, got %d", MaxSplitDepth, opts.Split)
+	}
+	if opts.Split > 0 && opts.Output != "" && opts.Output != models.OutputPDF {
+		return fmt.Errorf("only pdf output can be split")
+	}
+	return validateLayout(opts.Layout)
+}
+
+// outputLabel names an output format in progress messages
+func outputLabel(output string) string {
+	if output == "" {
+		output = models.OutputPDF
+	}
+	if output == models.OutputMarkdown {
+		return "Markdown"
+	}
+	return strings.ToUpper(output)
+}
 
+func repoURL(parts *models.RepoParts) string {
+	return fmt.Sprintf("https://%s/%s/%s", parts.Provider, parts.Owner, parts.Repo)
 }
@@ -0,0 +1,113 @@
+package generators
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/logging"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+//go:embed print.css
+var defaultPrintCSS []byte
+
+const printCSSName = "pdfgen-print.css"
+
+func printCSS(cfg config.HTMLConfig) ([]byte, error) {
+	if cfg.PrintCSS == "" {
+		return defaultPrintCSS, nil
+	}
+	return os.ReadFile(cfg.PrintCSS)
+}
+
+// preparePrintHTML writes a copy of htmlPath next to it with the print stylesheet linked last,
+// so relative assets still resolve and every engine sees the same styles
+func preparePrintHTML(ctx context.Context, cfg config.HTMLConfig, deco decorations, htmlPath string) (string, error) {
+	css, err := printCSS(cfg)
+	if err != nil {
+		return "", fmt.Errorf("error reading print css: %s", err)
+	}
+	css = append(css, deco.printCSS()...)
+
+	err = utils.WriteFile(ctx, filepath.Join(filepath.Dir(htmlPath), printCSSName), css, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	contentBytes, err := os.ReadFile(htmlPath)
+	if err != nil {
+		return "", err
+	}
+	content := string(contentBytes)
+	if deco.Layout.LineNumbers {
+		content = numberCodeLines(content)
+	}
+
+	link := fmt.Sprintf(`<link rel="stylesheet" href="%s">`, printCSSName)
+	headEnd := strings.Index(strings.ToLower(content), "</head>")
+	if headEnd == -1 {
+		content = link + content
+	} else {
+		content = content[:headEnd] + link + content[headEnd:]
+	}
+
+	printPath := strings.TrimSuffix(htmlPath, filepath.Ext(htmlPath)) + ".pdfgen.html"
+	return printPath, utils.WriteFile(ctx, printPath, []byte(content), 0644)
+}
+
+// renderHTML converts an HTML page to PDF with the configured headless engine
+func renderHTML(ctx context.Context, cfg config.HTMLConfig, deco decorations, htmlPath string, pdfPath string) error {
+	printPath, err := preparePrintHTML(ctx, cfg, deco, htmlPath)
+	if err != nil {
+		return err
+	}
+
+	absHTML, err := filepath.Abs(printPath)
+	if err != nil {
+		return err
+	}
+	absPDF, err := filepath.Abs(pdfPath)
+	if err != nil {
+		return err
+	}
+
+	var args []string
+	switch cfg.Engine {
+	case "weasyprint":
+		args = []string{"weasyprint", absHTML, absPDF}
+	case "chromium":
+		args = []string{
+			"chromium",
+			"--headless",
+			"--no-sandbox",
+			"--disable-gpu",
+			"--no-pdf-header-footer",
+			"--print-to-pdf=" + absPDF,
+			"file://" + absHTML,
+		}
+	default:
+		return fmt.Errorf("unknown html engine: %s", cfg.Engine)
+	}
+
+	renderMsg := fmt.Sprintf("Rendering HTML to PDF with %s...", cfg.Engine)
+	log.Print(renderMsg)
+	logging.PublishLog(ctx, renderMsg)
+
+	out, err := utils.RunCommand(ctx, args, "")
+	if err != nil {
+		log.Printf("%s output: %s", cfg.Engine, out)
+		return fmt.Errorf("%s failed: %s", cfg.Engine, err)
+	}
+
+	info, err := os.Stat(absPDF)
+	if err != nil || info.Size() == 0 {
+		return fmt.Errorf("%s produced no PDF", cfg.Engine)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package generators
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+)
+
+func TestPreparePrintHTML(t *testing.T) {
+	var tests = []struct {
+		name     string
+		html     string
+		expected string
+	}{
+		{
+			"link goes last in head",
+			`<html><head><link rel="stylesheet" href="theme.css"></HEAD><body></body></html>`,
+			`<html><head><link rel="stylesheet" href="theme.css"><link rel="stylesheet" href="pdfgen-print.css"></HEAD><body></body></html>`,
+		},
+		{
+			"no head",
+			`<p>hi</p>`,
+			`<link rel="stylesheet" href="pdfgen-print.css"><p>hi</p>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			htmlPath := filepath.Join(dir, "index.html")
+			err := os.WriteFile(htmlPath, []byte(tt.html), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			printPath, err := preparePrintHTML(context.Background(), config.Default().HTML, decorations{}, htmlPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if printPath != filepath.Join(dir, "index.pdfgen.html") {
+				t.Errorf("unexpected print path %s", printPath)
+			}
+
+			got, err := os.ReadFile(printPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+
+			css, err := os.ReadFile(filepath.Join(dir, printCSSName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(css), "@page") {
+				t.Errorf("expected the default print css")
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package generators
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/latex"
+	"github.com/jeffbrennan/pdfgen/internal/logging"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+const maxLatexWarnings = 10
+
+// latexNamePattern is what job and file names handed to LaTeX may look like. latexmk runs
+// the engine through a shell, and TeX reads the names itself.
+var latexNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+
+func validateLatexName(name string) error {
+	if !latexNamePattern.MatchString(name) {
+		return fmt.Errorf("unsafe LaTeX file name: %q", name)
+	}
+	return nil
+}
+
+// checkLatexOutput inspects the LaTeX log after a run. A missing PDF is an error carrying
+// the first diagnostic, a PDF produced despite errors is returned flagged as degraded.
+func checkLatexOutput(ctx context.Context, latexDir string, jobName string, runErr error) (models.Artifact, error) {
+	pdfPath := filepath.Join(latexDir, jobName+".pdf")
+	logPath := filepath.Join(latexDir, jobName+".log")
+
+	logBytes, err := os.ReadFile(logPath)
+	if err != nil {
+		return models.Artifact{}, fmt.Errorf("LaTeX produced no log (%v): %s", runErr, err)
+	}
+	diags := latex.ParseLog(logBytes)
+
+	info, err := os.Stat(pdfPath)
+	if err != nil || info.Size() == 0 {
+		if first := diags.First(); first != nil {
+			return models.Artifact{}, fmt.Errorf("LaTeX failed: %s", first)
+		}
+		return models.Artifact{}, fmt.Errorf("LaTeX produced no PDF: %v", runErr)
+	}
+
+	response := models.Artifact{
+		Path:        pdfPath,
+		Headings:    latexHeadings(latexDir, jobName),
+		FrontMatter: diags.FrontMatter,
+	}
+	if len(diags.Errors) == 0 && runErr == nil {
+		return response, nil
+	}
+
+	response.Degraded = true
+	for _, diag := range diags.Errors {
+		if len(response.Warnings) == maxLatexWarnings {
+			break
+		}
+		response.Warnings = append(response.Warnings, diag.String())
+	}
+
+	warnMsg := fmt.Sprintf("Warning: PDF has %d pages but LaTeX reported %d errors", diags.Pages, len(diags.Errors))
+	if first := diags.First(); first != nil {
+		warnMsg += fmt.Sprintf(", first: %s", first)
+	}
+	log.Print(warnMsg)
+	logging.PublishLog(ctx, warnMsg)
+
+	return response, nil
+}
+
+// latexHeadings reads the sections LaTeX wrote to <job>.toc for the PDF outline
+func latexHeadings(latexDir string, jobName string) []models.Heading {
+	tocBytes, err := os.ReadFile(filepath.Join(latexDir, jobName+".toc"))
+	if err != nil {
+		return nil
+	}
+
+	var headings []models.Heading
+	for _, entry := range latex.ParseTOC(tocBytes) {
+		headings = append(headings, models.Heading{
+			Title: entry.Title,
+			Level: entry.Level,
+			Dest:  entry.Anchor,
+			Label: entry.Page,
+		})
+	}
+	return headings
+}
+
+var latexmkEngineFlags = map[string]string{
+	"pdflatex": "-pdf",
+	"xelatex":  "-pdfxe",
+	"lualatex": "-pdflua",
+	"platex":   "-pdfdvi",
+	"uplatex":  "-pdfdvi",
+}
+
+var latexRerunMessages = []string{
+	"Rerun to get",
+	"Label(s) may have changed",
+	"Rerun LaTeX",
+}
+
+// runLatex compiles texFile until cross references, the TOC, and the index settle.
+// latexmk is preferred since it also picks up the latexmkrc Sphinx writes next to the sources.
+func runLatex(ctx context.Context, cfg config.LatexConfig, engine string, latexDir string, texFile string, jobName string, env map[string]string) error {
+	for _, name := range []string{texFile, jobName} {
+		err := validateLatexName(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.Latexmk {
+		_, err := exec.LookPath("latexmk")
+		if err == nil {
+			out, err := utils.RunCommandEnv(
+				ctx,
+				[]string{
+					"latexmk",
+					latexmkEngineFlags[engine],
+					"-dvi-",
+					"-ps-",
+					"-f",
+					"-interaction=nonstopmode",
+					"-jobname=" + jobName,
+					texFile,
+				},
+				latexDir,
+				env,
+			)
+			if err != nil {
+				log.Printf("latexmk output: %s\n", out)
+			}
+			return err
+		}
+		log.Print("latexmk not found, falling back to repeated passes")
+	}
+
+	return runLatexPasses(ctx, cfg, engine, latexDir, texFile, jobName, env)
+}
+
+func runLatexPasses(ctx context.Context, cfg config.LatexConfig, engine string, latexDir string, texFile string, jobName string, env map[string]string) error {
+	var runErr error
+	for pass := 1; pass <= cfg.MaxPasses; pass++ {
+		passMsg := fmt.Sprintf("Running %s pass %d...", engine, pass)
+		log.Print(passMsg)
+		logging.PublishLog(ctx, passMsg)
+
+		var out []byte
+		out, runErr = utils.RunCommandEnv(
+			ctx,
+			[]string{engine, "-interaction=nonstopmode", "-jobname=" + jobName, texFile},
+			latexDir,
+			env,
+		)
+		if runErr != nil {
+			log.Printf("%s output: %s\n", engine, out)
+		}
+
+		// the first pass only writes the .toc, .aux, and .idx files
+		if pass == 1 {
+			runMakeindex(ctx, latexDir, jobName, env)
+			continue
+		}
+
+		logBytes, err := os.ReadFile(filepath.Join(latexDir, jobName+".log"))
+		if err != nil || !needsRerun(string(logBytes)) {
+			break
+		}
+	}
+
+	if engine == "platex" || engine == "uplatex" {
+		out, err := utils.RunCommandEnv(ctx, []string{"dvipdfmx", jobName + ".dvi"}, latexDir, env)
+		if err != nil {
+			log.Printf("dvipdfmx output: %s\n", out)
+			return err
+		}
+	}
+
+	return runErr
+}
+
+func runMakeindex(ctx context.Context, latexDir string, jobName string, env map[string]string) {
+	_, err := os.Stat(filepath.Join(latexDir, jobName+".idx"))
+	if err != nil {
+		return
+	}
+
+	args := []string{"makeindex"}
+	// Sphinx ships its own index style next to the sources
+	if _, err := os.Stat(filepath.Join(latexDir, "python.ist")); err == nil {
+		args = append(args, "-s", "python.ist")
+	}
+	args = append(args, jobName+".idx")
+
+	out, err := utils.RunCommandEnv(ctx, args, latexDir, env)
+	if err != nil {
+		log.Printf("makeindex failed: %s\n%s", err, out)
+	}
+}
+
+func needsRerun(logContents string) bool {
+	for _, msg := range latexRerunMessages {
+		if strings.Contains(logContents, msg) {
+			return true
+		}
+	}
+	return false
+}
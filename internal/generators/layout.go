@@ -0,0 +1,214 @@
+package generators
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+type paperSize struct {
+	geometry string
+	css      string
+}
+
+var paperSizes = map[string]paperSize{
+	models.PaperA4:     {"paper=a4paper", "A4"},
+	models.PaperLetter: {"paper=letterpaper", "letter"},
+	models.PaperA5:     {"paper=a5paper", "A5"},
+	// roughly the 3:4 screen of a 10 inch tablet
+	models.PaperTablet: {"papersize={150mm,200mm}", "150mm 200mm"},
+}
+
+type pageMargins struct {
+	geometry string
+	css      string
+}
+
+var margins = map[string]pageMargins{
+	models.MarginNormal: {"margin=20mm", "20mm"},
+	models.MarginNarrow: {"margin=12mm", "12mm"},
+	// one-sided documents put the outer margin on the right
+	models.MarginWide: {"top=20mm,bottom=20mm,inner=18mm,outer=65mm,marginparwidth=50mm", "20mm 65mm 20mm 18mm"},
+}
+
+// FontSizes are the base font sizes the standard LaTeX classes support
+var FontSizes = []int{10, 11, 12}
+
+func validateLayout(layout models.Layout) error {
+	if layout.Paper != "" && !utils.Contains(models.Papers, layout.Paper) {
+		return fmt.Errorf("unknown paper size %q, available: %s", layout.Paper, strings.Join(models.Papers, ", "))
+	}
+	if layout.Margin != "" && !utils.Contains(models.Margins, layout.Margin) {
+		return fmt.Errorf("unknown margin %q, available: %s", layout.Margin, strings.Join(models.Margins, ", "))
+	}
+	if layout.Theme != "" && !utils.Contains(models.Themes, layout.Theme) {
+		return fmt.Errorf("unknown theme %q, available: %s", layout.Theme, strings.Join(models.Themes, ", "))
+	}
+	if layout.FontSize != 0 && !slices.Contains(FontSizes, layout.FontSize) {
+		return fmt.Errorf("font size must be 10, 11, or 12, got %d", layout.FontSize)
+	}
+	if layout.Columns < 0 || layout.Columns > 2 {
+		return fmt.Errorf("columns must be 1 or 2, got %d", layout.Columns)
+	}
+	return nil
+}
+
+// layoutMargin falls back to narrow margins on tablet paper, where every millimetre counts
+func layoutMargin(layout models.Layout) string {
+	if layout.Margin == "" && layout.Paper == models.PaperTablet {
+		return models.MarginNarrow
+	}
+	return layout.Margin
+}
+
+// sphinxLayoutArgs are the -D overrides a layout needs. Options of the document class
+// can't be changed from the preamble, so they replace the project's latex_elements keys.
+func sphinxLayoutArgs(layout models.Layout, builder string) []string {
+	var args []string
+	if builder == "latex" {
+		if layout.FontSize != 0 {
+			args = append(args, "-D", fmt.Sprintf("latex_elements.pointsize=%dpt", layout.FontSize))
+		}
+		if layout.Columns == 2 {
+			args = append(args, "-D", "latex_elements.extraclassoptions=twocolumn")
+		}
+	}
+	if layout.Theme == models.ThemeContrast {
+		args = append(args, "-D", "pygments_style=bw")
+	}
+	return args
+}
+
+// latexLayout is the part of pdfgen.sty that sets up the page
+func latexLayout(layout models.Layout) string {
+	var b strings.Builder
+
+	var geometry []string
+	if paper, ok := paperSizes[layout.Paper]; ok {
+		geometry = append(geometry, paper.geometry)
+	}
+	if margin, ok := margins[layoutMargin(layout)]; ok {
+		geometry = append(geometry, margin.geometry)
+	}
+	if len(geometry) > 0 {
+		// Sphinx already loads geometry, \geometry adjusts it from the preamble
+		fmt.Fprintf(&b, "\\RequirePackage{geometry}\n\\geometry{%s}\n", strings.Join(geometry, ","))
+	}
+
+	if layout.Theme == models.ThemeContrast {
+		b.WriteString("\\sphinxsetup{TitleColor={rgb}{0,0,0},InnerLinkColor={rgb}{0,0,0},OuterLinkColor={rgb}{0,0,0}," +
+			"VerbatimColor={rgb}{1,1,1},VerbatimBorderColor={rgb}{0,0,0}}\n")
+	}
+	if layout.LineNumbers {
+		// after Sphinx's own \fvset so code blocks default to numbered lines
+		b.WriteString("\\RequirePackage{fancyvrb}\n\\AtBeginDocument{\\fvset{numbers=left,numbersep=6pt}}\n")
+	}
+	return b.String()
+}
+
+// layoutCSS is appended to the print stylesheet for the html renderer
+func layoutCSS(layout models.Layout) string {
+	var b strings.Builder
+
+	var page []string
+	if paper, ok := paperSizes[layout.Paper]; ok {
+		page = append(page, "size: "+paper.css+";")
+	}
+	if margin, ok := margins[layoutMargin(layout)]; ok {
+		page = append(page, "margin: "+margin.css+";")
+	}
+	if len(page) > 0 {
+		fmt.Fprintf(&b, "@page {\n    %s\n}\n", strings.Join(page, "\n    "))
+	}
+
+	if layout.FontSize != 0 {
+		fmt.Fprintf(&b, "html {\n    font-size: %dpt;\n}\n", layout.FontSize)
+	}
+	if layout.Columns == 2 {
+		b.WriteString("body {\n    column-count: 2;\n    column-gap: 8mm;\n}\n")
+	}
+	if layout.LineNumbers {
+		b.WriteString(`.pdfgen-numbered {
+    display: flex;
+}
+.pdfgen-numbered > pre.pdfgen-lineno {
+    flex: none;
+    margin-right: 0;
+    padding-right: 0.6em;
+    border-right: 1px solid #999;
+    color: #777;
+    text-align: right;
+    user-select: none;
+}
+.pdfgen-numbered > pre:not(.pdfgen-lineno) {
+    flex: 1;
+    margin-left: 0;
+}
+`)
+	}
+	if layout.Theme == models.ThemeContrast {
+		b.WriteString(`body, .body, .md-content {
+    color: #000 !important;
+    background: #fff !important;
+}
+a {
+    color: #000 !important;
+    text-decoration: underline !important;
+}
+pre, code, .highlight {
+    color: #000 !important;
+    background: #fff !important;
+}
+pre {
+    border: 1px solid #000 !important;
+}
+.highlight * {
+    color: #000 !important;
+    background: transparent !important;
+}
+`)
+	}
+
+	if b.Len() == 0 {
+		return ""
+	}
+	return "\n/* layout added by pdfgen */\n" + b.String()
+}
+
+var preBlock = regexp.MustCompile(`(?s)<pre(\s[^>]*)?>(.*?)</pre>`)
+
+// numberCodeLines puts a line number gutter next to every code block. Highlighted code
+// has spans crossing lines, so the numbers go in a separate block instead of per line.
+// Blocks Sphinx already numbered (inside a linenos table) are left alone.
+func numberCodeLines(page string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range preBlock.FindAllStringSubmatchIndex(page, -1) {
+		start, end := m[0], m[1]
+		before := page[:start]
+		if strings.LastIndex(before, "<td") > strings.LastIndex(before, "</td>") {
+			continue
+		}
+
+		code := strings.TrimSuffix(page[m[4]:m[5]], "\n")
+		lines := strings.Count(code, "\n") + 1
+		numbers := make([]string, lines)
+		for i := range numbers {
+			numbers[i] = fmt.Sprint(i + 1)
+		}
+
+		b.WriteString(page[last:start])
+		b.WriteString(`<div class="pdfgen-numbered"><pre class="pdfgen-lineno" aria-hidden="true">`)
+		b.WriteString(strings.Join(numbers, "\n"))
+		b.WriteString("</pre>")
+		b.WriteString(page[start:end])
+		b.WriteString("</div>")
+		last = end
+	}
+	b.WriteString(page[last:])
+	return b.String()
+}
@@ -0,0 +1,121 @@
+package generators
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jeffbrennan/pdfgen/internal/models"
+)
+
+func TestValidateLayout(t *testing.T) {
+	var tests = []struct {
+		name       string
+		layout     models.Layout
+		shouldPass bool
+	}{
+		{"empty", models.Layout{}, true},
+		{"full", models.Layout{Paper: "a5", Margin: "wide", FontSize: 11, Columns: 2, LineNumbers: true, Theme: "contrast"}, true},
+		{"unknown paper", models.Layout{Paper: "a3"}, false},
+		{"unknown margin", models.Layout{Margin: "huge"}, false},
+		{"unsupported font size", models.Layout{FontSize: 14}, false},
+		{"three columns", models.Layout{Columns: 3}, false},
+		{"unknown theme", models.Layout{Theme: "dark"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateLayout(tt.layout)
+			if (err == nil) != tt.shouldPass {
+				t.Errorf("expected pass=%v, got %v", tt.shouldPass, err)
+			}
+		})
+	}
+}
+
+func TestLatexLayout(t *testing.T) {
+	var tests = []struct {
+		name     string
+		layout   models.Layout
+		expected []string
+	}{
+		{"empty", models.Layout{}, nil},
+		{"paper and wide margin", models.Layout{Paper: "a5", Margin: "wide"}, []string{`\geometry{paper=a5paper,top=20mm,bottom=20mm,inner=18mm,outer=65mm,marginparwidth=50mm}`}},
+		{"tablet defaults to narrow", models.Layout{Paper: "tablet"}, []string{`\geometry{papersize={150mm,200mm},margin=12mm}`}},
+		{"line numbers", models.Layout{LineNumbers: true}, []string{`\fvset{numbers=left`}},
+		{"contrast", models.Layout{Theme: "contrast"}, []string{`\sphinxsetup{TitleColor={rgb}{0,0,0}`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := latexLayout(tt.layout)
+			if tt.expected == nil && got != "" {
+				t.Errorf("expected nothing, got %q", got)
+			}
+			for _, s := range tt.expected {
+				if !strings.Contains(got, s) {
+					t.Errorf("expected %q in %q", s, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSphinxLayoutArgs(t *testing.T) {
+	layout := models.Layout{FontSize: 12, Columns: 2, Theme: "contrast"}
+
+	latexArgs := strings.Join(sphinxLayoutArgs(layout, "latex"), " ")
+	expected := "-D latex_elements.pointsize=12pt -D latex_elements.extraclassoptions=twocolumn -D pygments_style=bw"
+	if latexArgs != expected {
+		t.Errorf("expected %q, got %q", expected, latexArgs)
+	}
+
+	htmlArgs := strings.Join(sphinxLayoutArgs(layout, "singlehtml"), " ")
+	if htmlArgs != "-D pygments_style=bw" {
+		t.Errorf("expected only the pygments style for html, got %q", htmlArgs)
+	}
+}
+
+func TestLayoutCSS(t *testing.T) {
+	css := layoutCSS(models.Layout{Paper: "letter", Margin: "narrow", FontSize: 12, Columns: 2})
+	for _, s := range []string{"size: letter;", "margin: 12mm;", "font-size: 12pt;", "column-count: 2;"} {
+		if !strings.Contains(css, s) {
+			t.Errorf("expected %q in:\n%s", s, css)
+		}
+	}
+	if layoutCSS(models.Layout{}) != "" {
+		t.Errorf("expected no css for an empty layout")
+	}
+}
+
+func TestNumberCodeLines(t *testing.T) {
+	var tests = []struct {
+		name     string
+		html     string
+		expected string
+	}{
+		{
+			"code block",
+			"<div class=\"highlight\"><pre><span></span>a = 1\nb = 2\n</pre></div>",
+			"<div class=\"highlight\"><div class=\"pdfgen-numbered\"><pre class=\"pdfgen-lineno\" aria-hidden=\"true\">1\n2</pre><pre><span></span>a = 1\nb = 2\n</pre></div></div>",
+		},
+		{
+			"already numbered",
+			"<table><tr><td class=\"linenos\"><pre>1</pre></td><td><pre>x</pre></td></tr></table>",
+			"<table><tr><td class=\"linenos\"><pre>1</pre></td><td><pre>x</pre></td></tr></table>",
+		},
+		{
+			"not a pre",
+			"<p>text</p><preface>x</preface>",
+			"<p>text</p><preface>x</preface>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := numberCodeLines(tt.html)
+			if got != tt.expected {
+				t.Errorf("expected\n%q\ngot\n%q", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package generators
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+// checkoutPollInterval is how often a running clone's size is checked
+var checkoutPollInterval = time.Second
+
+// stopped returns an error once ctx is done. Commands are killed when their context ends,
+// this stops the stages in between.
+func stopped(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("build stopped: %s", context.Cause(ctx))
+	}
+	return nil
+}
+
+// withLimit bounds ctx to limit, named after its limits key in the error. A zero limit
+// only adds a cancel func.
+func withLimit(ctx context.Context, name string, limit time.Duration) (context.Context, context.CancelFunc) {
+	if limit <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, limit, fmt.Errorf("exceeded limit limits.%s (%s)", name, limit))
+}
+
+// withResourceLimits applies the configured rlimits to every command run with ctx
+func withResourceLimits(ctx context.Context, cfg config.LimitsConfig) context.Context {
+	return utils.WithLimits(ctx, utils.Limits{
+		CPUSeconds: cfg.CPUSeconds,
+		MemoryMB:   cfg.MemoryMB,
+		FileSizeMB: cfg.FileSizeMB,
+		Processes:  cfg.Processes,
+	})
+}
+
+// checkoutLimitError is the cause of clones stopped for their size
+func checkoutLimitError(maxMB int) error {
+	return fmt.Errorf("exceeded limit limits.checkout_mb (%d MB)", maxMB)
+}
+
+// watchCheckout cancels ctx as soon as dir grows past maxMB, so an oversized clone stops
+// while it is still downloading
+func watchCheckout(ctx context.Context, dir string, maxMB int) (context.Context, context.CancelFunc) {
+	if maxMB <= 0 {
+		return context.WithCancel(ctx)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	go func() {
+		ticker := time.NewTicker(checkoutPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if dirSize(dir) > int64(maxMB)<<20 {
+					cancel(checkoutLimitError(maxMB))
+					return
+				}
+			}
+		}
+	}()
+	return ctx, func() { cancel(nil) }
+}
+
+// checkCheckout fails a finished checkout that is larger than maxMB, for clones faster
+// than the watcher
+func checkCheckout(dir string, maxMB int) error {
+	if maxMB > 0 && dirSize(dir) > int64(maxMB)<<20 {
+		return checkoutLimitError(maxMB)
+	}
+	return nil
+}
+
+// dirSize adds up the sizes of the files under dir, files vanishing during the walk are skipped
+func dirSize(dir string) int64 {
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("error measuring %s: %s", dir, err)
+	}
+	return total
+}
@@ -0,0 +1,75 @@
+package generators
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWithLimit(t *testing.T) {
+	ctx, cancel := withLimit(context.Background(), "latex", time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+
+	err := stopped(ctx)
+	if err == nil || err.Error() != "build stopped: exceeded limit limits.latex (1ms)" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	ctx, cancel = withLimit(context.Background(), "latex", 0)
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok || stopped(ctx) != nil {
+		t.Errorf("expected a zero limit not to set a deadline")
+	}
+}
+
+func TestWatchCheckout(t *testing.T) {
+	dir := t.TempDir()
+	checkoutPollInterval = 10 * time.Millisecond
+	defer func() { checkoutPollInterval = time.Second }()
+
+	var tests = []struct {
+		name    string
+		size    int
+		maxMB   int
+		stopped bool
+	}{
+		{"small checkout", 1024, 1, false},
+		{"oversized checkout", 2 << 20, 1, true},
+		{"no limit", 2 << 20, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkout := filepath.Join(dir, tt.name)
+			err := os.MkdirAll(filepath.Join(checkout, ".git"), 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, stop := watchCheckout(context.Background(), checkout, tt.maxMB)
+			defer stop()
+			// the clone writes while it is being watched
+			err = os.WriteFile(filepath.Join(checkout, ".git", "pack"), make([]byte, tt.size), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(20 * checkoutPollInterval):
+			}
+			if (ctx.Err() != nil) != tt.stopped {
+				t.Fatalf("expected stopped=%v, got %v", tt.stopped, context.Cause(ctx))
+			}
+			if tt.stopped && context.Cause(ctx).Error() != "exceeded limit limits.checkout_mb (1 MB)" {
+				t.Errorf("unexpected cause %v", context.Cause(ctx))
+			}
+			if (checkCheckout(checkout, tt.maxMB) != nil) != tt.stopped {
+				t.Errorf("expected the finished checkout check to agree")
+			}
+		})
+	}
+}
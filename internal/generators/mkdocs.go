@@ -0,0 +1,153 @@
+package generators
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/logging"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/pdf"
+	"github.com/jeffbrennan/pdfgen/internal/recipe"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+type mkdocsPage struct {
+	Location string
+	Title    string
+}
+
+type mkdocsSearchIndex struct {
+	Docs []struct {
+		Location string `json:"location"`
+		Title    string `json:"title"`
+	} `json:"docs"`
+}
+
+func mkdocsConfigFile(dirParts *models.DirectoryParts) string {
+	for _, name := range []string{"mkdocs.yml", "mkdocs.yaml"} {
+		path := filepath.Join(dirParts.Base, dirParts.Doc, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(dirParts.Base, dirParts.Doc, "mkdocs.yml")
+}
+
+// buildMkDocsSite runs mkdocs build and returns the site dir
+func buildMkDocsSite(ctx context.Context, cfg *config.Config, rec *recipe.Recipe, dirParts *models.DirectoryParts) (string, error) {
+	configFile, err := filepath.Abs(mkdocsConfigFile(dirParts))
+	if err != nil {
+		return "", err
+	}
+	siteDir, err := filepath.Abs(filepath.Join(dirParts.Base, "_build", "site"))
+	if err != nil {
+		return "", err
+	}
+
+	logging.PublishLog(ctx, "Generating docs as HTML...")
+	args := append(uvRunArgs(cfg, rec), "mkdocs", "build", "-f", configFile, "-d", siteDir)
+	out, err := utils.RunCommandEnv(ctx, args, dirParts.Base, rec.Env)
+	if err != nil {
+		log.Printf("Error running mkdocs build: %s", out)
+		return "", err
+	}
+	return siteDir, nil
+}
+
+// mkdocsPages lists the site's pages in navigation order. The search plugin writes its
+// index in nav order, sections within a page carry a #fragment and are skipped.
+func mkdocsPages(siteDir string) []mkdocsPage {
+	data, err := os.ReadFile(filepath.Join(siteDir, "search", "search_index.json"))
+	if err != nil {
+		log.Printf("no search index, rendering the index page only: %s", err)
+		return []mkdocsPage{{Location: "", Title: "index"}}
+	}
+
+	var index mkdocsSearchIndex
+	err = json.Unmarshal(data, &index)
+	if err != nil {
+		log.Printf("invalid search index, rendering the index page only: %s", err)
+		return []mkdocsPage{{Location: "", Title: "index"}}
+	}
+
+	var pages []mkdocsPage
+	seen := map[string]bool{}
+	for _, doc := range index.Docs {
+		if strings.Contains(doc.Location, "#") || seen[doc.Location] {
+			continue
+		}
+		seen[doc.Location] = true
+		pages = append(pages, mkdocsPage{Location: doc.Location, Title: doc.Title})
+	}
+	return pages
+}
+
+func (p mkdocsPage) htmlPath(siteDir string) string {
+	if p.Location == "" || strings.HasSuffix(p.Location, "/") {
+		return filepath.Join(siteDir, p.Location, "index.html")
+	}
+	return filepath.Join(siteDir, p.Location)
+}
+
+// generateMkDocsPDF renders every page of the built site and merges them in nav order
+func generateMkDocsPDF(ctx context.Context, cfg *config.Config, rec *recipe.Recipe, deco decorations, parts *models.RepoParts, dirParts *models.DirectoryParts) (models.Artifact, error) {
+	conf, err := readMkDocsConfig(dirParts)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+
+	siteDir, err := buildMkDocsSite(ctx, cfg, rec, dirParts)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+
+	pagesDir := filepath.Join(filepath.Dir(siteDir), "pages")
+	err = utils.MkdirAll(ctx, pagesDir, 0755)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+
+	pages := mkdocsPages(siteDir)
+	var pdfParts []pdf.Part
+	if deco.Cover {
+		coverPath, err := deco.withTitle(conf.SiteName).renderHTMLCover(ctx, cfg.HTML, pagesDir)
+		if err != nil {
+			return models.Artifact{}, err
+		}
+		pdfParts = append(pdfParts, pdf.Part{Path: coverPath, Title: "Cover"})
+	}
+	for i, page := range pages {
+		pdfPath := filepath.Join(pagesDir, fmt.Sprintf("%04d.pdf", i))
+		err = renderHTML(ctx, cfg.HTML, deco, page.htmlPath(siteDir), pdfPath)
+		if err != nil {
+			return models.Artifact{}, fmt.Errorf("error rendering %s: %s", page.Location, err)
+		}
+		pdfParts = append(pdfParts, pdf.Part{Path: pdfPath, Title: page.Title})
+	}
+
+	pdfPath := filepath.Join(filepath.Dir(siteDir), outputFileName(parts)+".pdf")
+	logging.PublishLog(ctx, fmt.Sprintf("Merging %d pages...", len(pdfParts)))
+	err = utils.CheckPath(ctx, pdfPath)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+	err = pdf.Merge(pdfParts, pdfPath)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+
+	return models.Artifact{
+		Path:        pdfPath,
+		Renderer:    models.RendererHTML,
+		FrontMatter: deco.frontMatter(),
+		Documents: []models.Document{
+			{Name: "index", Title: conf.SiteName, Author: conf.SiteAuthor},
+		},
+	}, nil
+}
@@ -0,0 +1,69 @@
+package generators
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/logging"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/pdf"
+	"github.com/jeffbrennan/pdfgen/internal/repo"
+)
+
+// pdfMetadata describes where a PDF came from. A document's own title and author win over
+// the repository's
+func pdfMetadata(ctx context.Context, cfg *config.Config, response models.Artifact, parts *models.RepoParts, docType models.DocumentationFormat) pdf.Metadata {
+	commit, err := repo.HeadCommit(ctx, cfg.Repo, parts)
+	if err != nil {
+		log.Print(err)
+	}
+
+	meta := pdf.Metadata{
+		Title:     parts.Repo,
+		Author:    parts.Owner,
+		Subject:   fmt.Sprintf("Documentation for %s/%s", parts.Owner, parts.Repo),
+		Keywords:  []string{parts.Owner, parts.Repo, models.DocumentationName[docType]},
+		Repo:      repoURL(parts),
+		Commit:    commit,
+		Generator: fmt.Sprintf("pdfgen (%s, %s)", models.DocumentationName[docType], response.Renderer),
+		BuildTime: time.Now(),
+	}
+	if parts.Branch != "" {
+		meta.Keywords = append(meta.Keywords, parts.Branch)
+	}
+	if len(response.Documents) == 1 {
+		doc := response.Documents[0]
+		if doc.Title != "" {
+			meta.Title = doc.Title
+		}
+		if doc.Author != "" {
+			meta.Author = doc.Author
+		}
+	}
+	return meta
+}
+
+// postProcessPDF writes provenance metadata and makes sure the PDF has an outline and
+// page labels, whichever generator produced it
+func postProcessPDF(ctx context.Context, cfg *config.Config, response models.Artifact, parts *models.RepoParts, docType models.DocumentationFormat) error {
+	logging.PublishLog(ctx, "Writing PDF metadata and outline...")
+
+	meta := pdfMetadata(ctx, cfg, response, parts, docType)
+	structure := pdf.Structure{
+		FrontMatter: response.FrontMatter,
+		Title:       meta.Title,
+	}
+	for _, heading := range response.Headings {
+		structure.Headings = append(structure.Headings, pdf.Heading{
+			Title: heading.Title,
+			Level: heading.Level,
+			Dest:  heading.Dest,
+			Label: heading.Label,
+		})
+	}
+
+	return pdf.Finalize(response.Path, meta, structure)
+}
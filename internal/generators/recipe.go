@@ -0,0 +1,57 @@
+package generators
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/jeffbrennan/pdfgen/internal/logging"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/patch"
+	"github.com/jeffbrennan/pdfgen/internal/recipe"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+// applyRecipe runs the recipe's source patches and pre-build steps before any generator builds.
+// The returned engine must be reverted once the build is finished, even on error.
+func applyRecipe(ctx context.Context, rec *recipe.Recipe, dirParts *models.DirectoryParts) (*patch.Engine, error) {
+	engine := patch.NewEngine(dirParts.Root, false)
+	if len(rec.Patches) > 0 {
+		report := engine.Apply(rec.Patches)
+		publishPatchReport(ctx, report)
+		err := report.Err()
+		if err != nil {
+			return engine, err
+		}
+	}
+
+	for _, step := range rec.PreBuild {
+		stepMsg := fmt.Sprintf("Running pre-build step: %s", step)
+		log.Print(stepMsg)
+		logging.PublishLog(ctx, stepMsg)
+
+		out, err := utils.RunCommandEnv(ctx, []string{"/bin/sh", "-c", step}, dirParts.Base, rec.Env)
+		if err != nil {
+			log.Printf("pre-build step output: %s", out)
+			return engine, fmt.Errorf("pre-build step %q failed: %s", step, err)
+		}
+	}
+
+	return engine, nil
+}
+
+func publishPatchReport(ctx context.Context, report *patch.Report) {
+	for _, result := range report.Results {
+		log.Print(result.String())
+		logging.PublishLog(ctx, result.String())
+	}
+	log.Print(report.Summary())
+	logging.PublishLog(ctx, report.Summary())
+}
+
+func revertPatches(engine *patch.Engine) {
+	err := engine.Revert()
+	if err != nil {
+		log.Printf("Error reverting patches: %s", err)
+	}
+}
@@ -0,0 +1,82 @@
+package generators
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+// withSandbox runs the later commands of ctx in the configured sandbox. They may only
+// write to the checkout and don't see other checkouts or the server's data.
+func withSandbox(ctx context.Context, cfg *config.Config, parts *models.RepoParts) (context.Context, error) {
+	sandbox, err := utils.NewSandbox(cfg.Sandbox)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := sandboxPolicy(ctx, cfg, parts)
+	if err != nil {
+		return nil, err
+	}
+	return utils.WithSandbox(ctx, sandbox, policy), nil
+}
+
+func sandboxPolicy(ctx context.Context, cfg *config.Config, parts *models.RepoParts) (utils.SandboxPolicy, error) {
+	workspace, err := filepath.Abs(utils.CheckoutDir(ctx, cfg.Repo, parts))
+	if err != nil {
+		return utils.SandboxPolicy{}, err
+	}
+
+	hidden := append([]string{cfg.Repo.Dir}, cfg.Sandbox.Hidden...)
+	if cfg.Path != "" {
+		// the config holds the S3 keys and auth tokens, hide it wherever a symlink points
+		hidden = append(hidden, cfg.Path)
+		resolved, err := filepath.EvalSymlinks(cfg.Path)
+		if err == nil {
+			hidden = append(hidden, resolved)
+		}
+	}
+	if cfg.Store.Backend == "fs" {
+		hidden = append(hidden, cfg.Store.Dir)
+	}
+	if cfg.Search.Index != "" {
+		hidden = append(hidden, filepath.Dir(cfg.Search.Index))
+	}
+	if cfg.Jobs.DB != "" {
+		hidden = append(hidden, filepath.Dir(cfg.Jobs.DB))
+	}
+	if cfg.Auth.DB != "" {
+		hidden = append(hidden, filepath.Dir(cfg.Auth.DB))
+	}
+	hidden, err = absPaths(hidden)
+	if err != nil {
+		return utils.SandboxPolicy{}, err
+	}
+	writable, err := absPaths(cfg.Sandbox.Writable)
+	if err != nil {
+		return utils.SandboxPolicy{}, err
+	}
+
+	return utils.SandboxPolicy{
+		Workspace: workspace,
+		Writable:  writable,
+		Hidden:    hidden,
+	}, nil
+}
+
+// absPaths makes paths absolute, dropping duplicates
+func absPaths(paths []string) ([]string, error) {
+	var out []string
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		if !utils.Contains(out, abs) {
+			out = append(out, abs)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,60 @@
+package generators
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+func TestSandboxHidesConfig(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "pdfgen.yml")
+	err := os.WriteFile(configPath, []byte("store:\n  s3:\n    secret_key: s3cr3t\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load("test", []string{"-config", configPath, "-repo.dir", filepath.Join(dir, "repos")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := &models.RepoParts{Owner: "alice", Repo: "docs"}
+	ctx := utils.WithWorkspace(context.Background(), "job")
+	workspace := utils.CheckoutDir(ctx, cfg.Repo, parts)
+	err = os.MkdirAll(workspace, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := sandboxPolicy(ctx, cfg, parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bwrap := utils.Bubblewrap{Path: "bwrap", UID: 65534, GID: 65534}
+	args, _, err := bwrap.Wrap([]string{"cat", configPath}, workspace, nil, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(strings.Join(args, " "), "--ro-bind /dev/null "+configPath) {
+		t.Errorf("expected %s to be hidden in %v", configPath, args)
+	}
+
+	bwrap.Path, err = exec.LookPath("bwrap")
+	if err != nil {
+		t.Skip("bwrap not installed")
+	}
+	ctx = utils.WithSandbox(ctx, bwrap, policy)
+	out, err := utils.RunCommand(ctx, []string{"cat", configPath}, workspace)
+	if err != nil {
+		t.Fatalf("should pass: got %v: %s", err, out)
+	}
+	if strings.Contains(string(out), "s3cr3t") {
+		t.Errorf("config is readable in the sandbox: %s", out)
+	}
+}
@@ -1,121 +1,282 @@
 package generators
 
 import (
-	"io"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
 	"log"
-	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/env"
+	"github.com/jeffbrennan/pdfgen/internal/latex"
 	"github.com/jeffbrennan/pdfgen/internal/logging"
 	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/recipe"
 	"github.com/jeffbrennan/pdfgen/internal/utils"
 )
 
-func handleSphinxIssuesVersionKeyError(dirParts *models.DirectoryParts) error {
-	// workaround for airflow build - should generalize after testing other sphinx builds
-	extDir := "devel-common/src/sphinx_exts/"
-	out, err := utils.RunCommand([]string{"ls", extDir}, dirParts.Root)
-	log.Print(out)
+//go:embed sphinx_conf.py
+var sphinxConfScript string
+
+const sphinxConfMarker = "PDFGEN_CONF "
+
+type sphinxLatexDocument struct {
+	Start  string `json:"start"`
+	Target string `json:"target"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	Theme  string `json:"theme"`
+}
+
+type sphinxSettings struct {
+	Project   string                `json:"project"`
+	Author    string                `json:"author"`
+	RootDoc   string                `json:"root_doc"`
+	Engine    string                `json:"latex_engine"`
+	Documents []sphinxLatexDocument `json:"latex_documents"`
+}
+
+// uvRunArgs is the uv run prefix of the build's Python commands, see env.RunArgs
+func uvRunArgs(cfg *config.Config, rec *recipe.Recipe) []string {
+	return env.RunArgs(cfg.Sphinx, envSpec(rec))
+}
+
+func envSpec(rec *recipe.Recipe) env.Spec {
+	return env.Spec{Group: rec.Group, ExtraPackages: rec.ExtraPackages, Env: rec.Env}
+}
+
+func sphinxConfDir(rec *recipe.Recipe, dirParts *models.DirectoryParts) (string, error) {
+	if rec.ConfPath != "" {
+		return filepath.Abs(filepath.Join(dirParts.Root, rec.ConfPath))
+	}
+	return filepath.Abs(filepath.Join(dirParts.Base, dirParts.Doc))
+}
+
+// sphinxOverrides merges the recipe's -D overrides with the engine pdfgen settled on, so
+// the generated preamble matches the engine that compiles it
+func sphinxOverrides(rec *recipe.Recipe, engine string) map[string]string {
+	overrides := map[string]string{}
+	for k, v := range rec.SphinxOverrides {
+		overrides[k] = v
+	}
+	if engine != "" {
+		overrides["latex_engine"] = engine
+	}
+	return overrides
+}
+
+func sortedOverrides(overrides map[string]string) []string {
+	var pairs []string
+	for k, v := range overrides {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// readSphinxSettings evaluates conf.py in the project's environment to find the root doc,
+// latex_engine, and latex_documents, including Sphinx's defaults for each
+func readSphinxSettings(ctx context.Context, cfg *config.Config, rec *recipe.Recipe, dirParts *models.DirectoryParts) (*sphinxSettings, error) {
+	confDir, err := sphinxConfDir(rec, dirParts)
 	if err != nil {
-		log.Print("Error running ls: ", err)
-		return err
+		return nil, err
 	}
 
-	files := strings.Split(string(out), "\n")
-	for _, file := range files {
-		if !strings.Contains(file, "substitution_extensions.py") {
+	args := append(uvRunArgs(cfg, rec), "python", "-c", sphinxConfScript, confDir)
+	args = append(args, sortedOverrides(sphinxOverrides(rec, ""))...)
+	out, err := utils.RunCommandEnv(ctx, args, dirParts.Base, rec.Env)
+	if err != nil {
+		return nil, fmt.Errorf("error reading conf.py: %s", err)
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if !strings.HasPrefix(line, sphinxConfMarker) {
 			continue
 		}
 
-		filePath := dirParts.Root + "/" + extDir + file
-		fileHandle, err := os.Open(filePath)
+		settings := &sphinxSettings{}
+		err = json.Unmarshal([]byte(strings.TrimPrefix(line, sphinxConfMarker)), settings)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("error parsing conf.py settings: %s", err)
+		}
+		if len(settings.Documents) == 0 {
+			return nil, fmt.Errorf("conf.py defines no latex_documents")
 		}
-		defer fileHandle.Close()
+		return settings, nil
+	}
+
+	return nil, fmt.Errorf("conf.py settings not found in output")
+}
+
+// latexEngine picks the recipe override, then conf.py's latex_engine, then the server default
+func latexEngine(cfg *config.Config, rec *recipe.Recipe, settings *sphinxSettings) string {
+	if rec.LatexEngine != "" {
+		return rec.LatexEngine
+	}
+	if settings.Engine != "" {
+		return settings.Engine
+	}
+	return cfg.Latex.Engine
+}
 
-		contentBytes, err := io.ReadAll(fileHandle)
+func sphinxBuildArgs(cfg *config.Config, rec *recipe.Recipe, dirParts *models.DirectoryParts, builder string, engine string) ([]string, error) {
+	args := append(uvRunArgs(cfg, rec), "sphinx-build", "-M", builder, dirParts.Doc, "_build/")
+	if rec.ConfPath != "" {
+		confDir, err := sphinxConfDir(rec, dirParts)
 		if err != nil {
-			log.Printf("Error reading file: %s", err)
-			return err
+			return nil, err
 		}
+		args = append(args, "-c", confDir)
+	}
+
+	for _, pair := range sortedOverrides(sphinxOverrides(rec, engine)) {
+		args = append(args, "-D", pair)
+	}
+	return args, nil
+}
+
+func generateSphinxPDF(ctx context.Context, cfg *config.Config, rec *recipe.Recipe, opts models.BuildOptions, deco decorations, parts *models.RepoParts, dirParts *models.DirectoryParts) (models.Artifact, error) {
+	settings, err := readSphinxSettings(ctx, cfg, rec, dirParts)
+	if err != nil {
+		return models.Artifact{}, err
+	}
 
-		log.Print("writing file...")
-		newFileContents := strings.ReplaceAll(
-			string(contentBytes),
-			"version = substitution_defs[\"version\"].astext()",
-			"version = substitution_defs.get(\"version\", \"unknown\")",
-		)
-		err = os.WriteFile(filePath, []byte(newFileContents), 0644)
+	engine := latexEngine(cfg, rec, settings)
+	if !utils.Contains(config.LatexEngines, engine) {
+		return models.Artifact{}, fmt.Errorf("unsupported latex engine: %s", engine)
+	}
+
+	args, err := sphinxBuildArgs(cfg, rec, dirParts, "latex", engine)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+	args = append(args, sphinxLayoutArgs(deco.Layout, "latex")...)
+
+	logging.PublishLog(ctx, "Generating docs as LaTeX...")
+	out, err := utils.RunCommandEnv(ctx, args, dirParts.Base, rec.Env)
+
+	log.Printf("Sphinx build output: %s\n", out)
+	if err != nil {
+		log.Printf("Error running sphinx-build: %s", out)
+		log.Printf("Error: %s", err)
+		return models.Artifact{}, err
+	}
+
+	var docs []models.Document
+	for _, doc := range settings.Documents {
+		// targets come from conf.py and name files in the build directory
+		err = validateLatexName(doc.Target)
 		if err != nil {
-			print("Error writing file: %s", err)
-			return err
+			return models.Artifact{}, err
 		}
+		docs = append(docs, models.Document{
+			Name:   strings.TrimSuffix(doc.Target, ".tex"),
+			Title:  latex.PlainText(doc.Title),
+			Author: latex.PlainText(strings.ReplaceAll(doc.Author, `\and`, ",")),
+		})
+	}
+	publishDocuments(ctx, docs)
 
+	selected, err := selectDocuments(docs, opts.Documents)
+	if err != nil {
+		return models.Artifact{}, err
 	}
 
-	return nil
-}
+	outputName := outputFileName(parts)
+	latexDir := dirParts.Base + "/_build/latex"
+
+	var built []builtDocument
+	for _, doc := range selected {
+		jobName := outputName
+		if len(selected) > 1 {
+			jobName = outputName + "_" + doc.Name
+		}
+
+		err = deco.withTitle(doc.Title).decorateLatex(ctx, latexDir, doc.Name+".tex")
+		if err != nil {
+			return models.Artifact{}, fmt.Errorf("error adding cover and headers: %s", err)
+		}
+
+		logging.PublishLog(ctx, fmt.Sprintf("Converting %s to PDF with %s...", doc.Name, engine))
+		latexCtx, cancelLatex := withLimit(ctx, "latex", cfg.Limits.Latex)
+		err = runLatex(latexCtx, cfg.Latex, engine, latexDir, doc.Name+".tex", jobName, rec.Env)
+		stopErr := stopped(latexCtx)
+		cancelLatex()
+		// a killed engine can leave a partial PDF behind, it mustn't pass as degraded
+		if stopErr != nil {
+			return models.Artifact{}, stopErr
+		}
+
+		response, err := checkLatexOutput(ctx, latexDir, jobName, err)
+		if err != nil && len(selected) == 1 {
+			return models.Artifact{}, err
+		}
+		if err != nil {
+			skipMsg := fmt.Sprintf("Warning: skipping %s: %s", doc.Name, err)
+			log.Print(skipMsg)
+			logging.PublishLog(ctx, skipMsg)
+			continue
+		}
+		built = append(built, builtDocument{doc: doc, response: response})
+	}
 
-func handleSphinxIssues(dirParts *models.DirectoryParts) error {
-	err := handleSphinxIssuesVersionKeyError(dirParts)
-	return err
+	response, err := deliverDocuments(ctx, built, latexDir, outputName, opts.Documents)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+	response.Renderer = models.RendererLatex
+	if len(built) < len(selected) {
+		response.Degraded = true
+		response.Warnings = append(response.Warnings, fmt.Sprintf("%d of %d documents failed to build", len(selected)-len(built), len(selected)))
+	}
+	response.Documents = docs
+
+	log.Printf("PDF path: %s", response.Path)
+	return response, nil
 }
 
-func generateSphinxPDF(parts *models.RepoParts, dirParts *models.DirectoryParts) (string, error) {
-	err := handleSphinxIssues(dirParts)
+// generateSphinxHTMLPDF builds the project as a single HTML page and renders it headless
+func generateSphinxHTMLPDF(ctx context.Context, cfg *config.Config, rec *recipe.Recipe, deco decorations, parts *models.RepoParts, dirParts *models.DirectoryParts) (models.Artifact, error) {
+	settings, err := readSphinxSettings(ctx, cfg, rec, dirParts)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+
+	args, err := sphinxBuildArgs(cfg, rec, dirParts, "singlehtml", "")
 	if err != nil {
-		log.Printf("Error handling Sphinx issues: %s", err)
-		return "", err
-	}
-
-	// TODO: handle case where docs group does not exist
-	logging.PublishLog("Generating docs as LaTeX...")
-	out, err := utils.RunCommand([]string{
-		"uv",
-		"run",
-		"--group",
-		"docs",
-		"sphinx-build",
-		"-M",
-		"latex",
-		dirParts.Doc,
-		"_build/",
-	},
-		dirParts.Base,
-	)
+		return models.Artifact{}, err
+	}
+	args = append(args, sphinxLayoutArgs(deco.Layout, "singlehtml")...)
 
-	log.Printf("Sphinx build output: %s\n", out)
+	logging.PublishLog(ctx, "Generating docs as HTML...")
+	out, err := utils.RunCommandEnv(ctx, args, dirParts.Base, rec.Env)
 	if err != nil {
 		log.Printf("Error running sphinx-build: %s", out)
-		log.Printf("Error: %s", err)
-		return "", err
+		return models.Artifact{}, err
 	}
 
-	outputName := parts.Repo + "_" + strings.ReplaceAll(parts.Directory, "/", "_")
+	htmlDir := dirParts.Base + "/_build/singlehtml"
+	pdfPath := filepath.Join(htmlDir, outputFileName(parts)+".pdf")
+	err = renderHTML(ctx, cfg.HTML, deco, filepath.Join(htmlDir, settings.RootDoc+".html"), pdfPath)
+	if err != nil {
+		return models.Artifact{}, err
+	}
+
+	err = deco.withTitle(settings.Project).addHTMLCover(ctx, cfg.HTML, pdfPath)
+	if err != nil {
+		return models.Artifact{}, err
+	}
 
-	logging.PublishLog("Converting LaTeX to PDF...")
-	out, err = utils.RunCommand(
-		[]string{
-			"/bin/sh",
-			"-c",
-			"pdflatex -interaction=nonstopmode -jobname=" + outputName + " $(find -maxdepth 1 -name '*.tex' | head -n 1)",
+	return models.Artifact{
+		Path:        pdfPath,
+		Renderer:    models.RendererHTML,
+		FrontMatter: deco.frontMatter(),
+		Documents: []models.Document{
+			{Name: settings.RootDoc, Title: settings.Project, Author: settings.Author},
 		},
-		dirParts.Base+"/_build/latex",
-	)
-	log.Printf("finished running pdflatex in %s\n", dirParts.Base+"/_build/latex")
-
-	// ignore for now
-	log.Printf("uncaught pdflatex output: %s\n", out)
-	log.Printf("uncaught pdflatex error: %s\n", err)
-
-	// if err != nil {
-	// 	log.Printf("Error running pdflatex: %s", out)
-	// 	log.Printf("Error: %s", err)
-	// 	return "", err
-	// }
-
-	pdfPath := dirParts.Base + "/_build/latex/" + outputName + ".pdf"
-	log.Printf("PDF path: %s", pdfPath)
-	return pdfPath, nil
+	}, nil
 }
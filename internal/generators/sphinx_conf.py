@@ -0,0 +1,69 @@
+# Prints the settings pdfgen needs from a Sphinx project as JSON.
+# usage: python -c <this script> <confdir> [name=value overrides passed to sphinx-build -D]
+import json
+import os
+import runpy
+import sys
+
+from sphinx.util.osutil import make_filename_from_project
+from sphinx.util.tags import Tags
+
+confdir = os.path.abspath(sys.argv[1])
+overrides = dict(arg.split("=", 1) for arg in sys.argv[2:])
+
+# sphinx evaluates conf.py from inside the conf dir with tags available
+cwd = os.getcwd()
+os.chdir(confdir)
+sys.path.insert(0, confdir)
+try:
+    ns = runpy.run_path(os.path.join(confdir, "conf.py"), init_globals={"tags": Tags()})
+finally:
+    os.chdir(cwd)
+
+ns.update(overrides)
+
+project = ns.get("project", "Project name not set")
+author = ns.get("author", "Author name not set")
+root_doc = ns.get("root_doc", ns.get("master_doc", "index"))
+language = ns.get("language") or "en"
+
+latex_engine = ns.get("latex_engine")
+if latex_engine is None:
+    # mirrors sphinx.builders.latex.default_latex_engine
+    if language == "ja":
+        latex_engine = "uplatex"
+    elif language.startswith("zh") or language.startswith("el"):
+        latex_engine = "xelatex"
+
+documents = ns.get("latex_documents") or [
+    (
+        root_doc,
+        make_filename_from_project(project) + ".tex",
+        project + " Documentation",
+        author,
+        "manual",
+    )
+]
+
+# conf.py may print on its own, so the result is marked
+print(
+    "PDFGEN_CONF "
+    + json.dumps(
+        {
+            "project": project,
+            "author": str(author),
+            "root_doc": root_doc,
+            "latex_engine": latex_engine,
+            "latex_documents": [
+                {
+                    "start": doc[0],
+                    "target": doc[1],
+                    "title": str(doc[2]),
+                    "author": str(doc[3]),
+                    "theme": doc[4],
+                }
+                for doc in documents
+            ],
+        }
+    )
+)
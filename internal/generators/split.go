@@ -0,0 +1,110 @@
+package generators
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jeffbrennan/pdfgen/internal/logging"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/pdf"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+// MaxSplitDepth matches the deepest LaTeX sectioning level
+const MaxSplitDepth = 6
+
+const splitIndexName = "index.html"
+
+// splitPDF replaces the artifact with a zip of per-chapter PDFs. The whole PDF is kept
+// when it can't be split, e.g. because it has no outline.
+func splitPDF(ctx context.Context, response models.Artifact, depth int) models.Artifact {
+	logging.PublishLog(ctx, "Splitting PDF into chapters...")
+
+	zipPath, err := splitChapters(ctx, response.Path, depth)
+	if err != nil {
+		splitMsg := fmt.Sprintf("Warning: splitting the PDF failed, delivering a single file: %s", err)
+		log.Print(splitMsg)
+		logging.PublishLog(ctx, splitMsg)
+		response.Degraded = true
+		response.Warnings = append(response.Warnings, splitMsg)
+		return response
+	}
+
+	response.Path = zipPath
+	return response
+}
+
+func splitChapters(ctx context.Context, pdfPath string, depth int) (string, error) {
+	base := strings.TrimSuffix(pdfPath, ".pdf")
+	outDir := base + "-chapters"
+	err := os.RemoveAll(outDir)
+	if err != nil {
+		return "", err
+	}
+	err = utils.MkdirAll(ctx, outDir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	chapters, err := pdf.Split(pdfPath, outDir, depth)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("split %s into %d files", pdfPath, len(chapters))
+
+	indexPath := filepath.Join(outDir, splitIndexName)
+	err = utils.WriteFile(ctx, indexPath, []byte(splitIndex(filepath.Base(base), chapters)), 0644)
+	if err != nil {
+		return "", err
+	}
+
+	paths := []string{indexPath}
+	for _, chapter := range chapters {
+		paths = append(paths, filepath.Join(outDir, chapter.File))
+	}
+
+	zipPath := base + ".zip"
+	err = zipFiles(ctx, paths, zipPath)
+	if err != nil {
+		return "", fmt.Errorf("error packaging chapters: %s", err)
+	}
+	return zipPath, nil
+}
+
+// splitIndex lists the chapters with their pages in the original document
+func splitIndex(title string, chapters []pdf.Chapter) string {
+	var rows strings.Builder
+	for _, chapter := range chapters {
+		pages := fmt.Sprint(chapter.From)
+		if chapter.Thru > chapter.From {
+			pages = fmt.Sprintf("%d-%d", chapter.From, chapter.Thru)
+		}
+		fmt.Fprintf(&rows, "<tr><td>%s</td><td><a href=\"%s\">%s</a></td><td>%s</td></tr>\n",
+			html.EscapeString(chapter.Number), html.EscapeString(chapter.File), html.EscapeString(chapter.Title), pages)
+	}
+
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%[1]s</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.3em 1em; text-align: left; border-bottom: 1px solid #ddd; }
+</style>
+</head>
+<body>
+<h1>%[1]s</h1>
+<table>
+<tr><th>#</th><th>Chapter</th><th>Pages</th></tr>
+%[2]s</table>
+</body>
+</html>
+`, html.EscapeString(title), rows.String())
+}
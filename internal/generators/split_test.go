@@ -0,0 +1,51 @@
+package generators
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/pdf"
+)
+
+func TestValidateSplit(t *testing.T) {
+	var tests = []struct {
+		name       string
+		opts       models.BuildOptions
+		shouldPass bool
+	}{
+		{"off", models.BuildOptions{}, true},
+		{"chapters", models.BuildOptions{Split: 1}, true},
+		{"sections of a pdf", models.BuildOptions{Split: 2, Output: models.OutputPDF}, true},
+		{"negative", models.BuildOptions{Split: -1}, false},
+		{"too deep", models.BuildOptions{Split: 7}, false},
+		{"epub", models.BuildOptions{Split: 1, Output: models.OutputEPUB}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateOptions(tt.opts)
+			if (err == nil) != tt.shouldPass {
+				t.Errorf("expected pass=%v, got %v", tt.shouldPass, err)
+			}
+		})
+	}
+}
+
+func TestSplitIndex(t *testing.T) {
+	chapters := []pdf.Chapter{
+		{Number: "00", Title: "Front matter", File: "00-front-matter.pdf", From: 1, Thru: 3},
+		{Number: "01", Title: "Tips & Tricks", File: "01-tips-tricks.pdf", From: 4, Thru: 4},
+	}
+
+	got := splitIndex("airflow", chapters)
+	for _, s := range []string{
+		"<title>airflow</title>",
+		`<a href="00-front-matter.pdf">Front matter</a></td><td>1-3</td>`,
+		`<a href="01-tips-tricks.pdf">Tips &amp; Tricks</a></td><td>4</td>`,
+	} {
+		if !strings.Contains(got, s) {
+			t.Errorf("expected %q in %s", s, got)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package generators
+
+import (
+	"context"
+	"sync"
+)
+
+type storedKey struct{}
+
+// Stored collects the keys of the artifacts the builds of a job put in the store or took
+// from the cache, the parts of a bundle and both sides of a diff included, so all of
+// them can be charged to whoever started the job
+type Stored struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+// WithStored returns a copy of ctx whose builds add their keys to s
+func WithStored(ctx context.Context, s *Stored) context.Context {
+	return context.WithValue(ctx, storedKey{}, s)
+}
+
+// StoredFromContext returns the Stored of ctx, if any
+func StoredFromContext(ctx context.Context) (*Stored, bool) {
+	s, ok := ctx.Value(storedKey{}).(*Stored)
+	return s, ok
+}
+
+// Keys returns the keys in the order they were stored, also when the job failed later on
+func (s *Stored) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.keys...)
+}
+
+// Add records a stored key
+func (s *Stored) Add(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, key)
+}
+
+func recordStored(ctx context.Context, key string) {
+	s, ok := StoredFromContext(ctx)
+	if ok && key != "" {
+		s.Add(key)
+	}
+}
@@ -0,0 +1,276 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	KindGenerate = "generate"
+	KindBundle   = "bundle"
+	KindDiff     = "diff"
+
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+var (
+	Kinds    = []string{KindGenerate, KindBundle, KindDiff}
+	Statuses = []string{StatusRunning, StatusSucceeded, StatusFailed, StatusCancelled}
+
+	ErrNotFound   = errors.New("job not found")
+	ErrNotRunning = errors.New("job is not running on this server")
+	// ErrCancelled is the cause of jobs cancelled through Cancel
+	ErrCancelled = errors.New("cancelled by request")
+
+	// IDPattern matches the ids NewID hands out
+	IDPattern = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+	jobsBucket = []byte("jobs")
+)
+
+// Stage is a timed step of a build, e.g. clone or build
+type Stage struct {
+	Name    string    `json:"name"`
+	Started time.Time `json:"started"`
+	Seconds float64   `json:"seconds"`
+}
+
+// Job is the record of a single request to build something
+type Job struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	// User owns the job, empty when auth is disabled
+	User    string              `json:"user,omitempty"`
+	URL     string              `json:"url"`
+	Options models.BuildOptions `json:"options"`
+	Parts   *models.RepoParts   `json:"parts,omitempty"`
+	Commit  string              `json:"commit,omitempty"`
+	// Format is the detected documentation format, Env the detected environment
+	Format string  `json:"format,omitempty"`
+	Env    string  `json:"env,omitempty"`
+	Status string  `json:"status"`
+	Error  string  `json:"error,omitempty"`
+	Stages []Stage `json:"stages"`
+	// Log is the store key of the job's log transcript, Artifact the id of what it built
+	Log      string    `json:"log,omitempty"`
+	Artifact string    `json:"artifact,omitempty"`
+	Created  time.Time `json:"created"`
+	Finished time.Time `json:"finished"`
+}
+
+// Filter selects jobs for List. Empty fields match everything, Before is the cursor
+// returned by a previous page.
+type Filter struct {
+	Status string
+	Kind   string
+	User   string
+	// Repo is owner/repo
+	Repo   string
+	Before string
+	Limit  int
+}
+
+func (f Filter) match(job Job) bool {
+	if f.Status != "" && job.Status != f.Status {
+		return false
+	}
+	if f.Kind != "" && job.Kind != f.Kind {
+		return false
+	}
+	if f.User != "" && job.User != f.User {
+		return false
+	}
+	if f.Repo != "" && (job.Parts == nil || job.Parts.Owner+"/"+job.Parts.Repo != f.Repo) {
+		return false
+	}
+	return true
+}
+
+// DB keeps the job history in a bbolt file. Jobs are keyed by their id, which sorts by
+// creation time.
+type DB struct {
+	db *bolt.DB
+
+	mu sync.Mutex
+	// running cancels the jobs started by this process
+	running map[string]context.CancelCauseFunc
+}
+
+// NewID returns a unique id that sorts after every id handed out before it
+func NewID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%016x%s", time.Now().UnixNano(), hex.EncodeToString(b))
+}
+
+// Open opens the history at path, creating it when missing
+func Open(path string) (*DB, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening job history %s: %s", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(jobsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DB{db: db, running: map[string]context.CancelCauseFunc{}}, nil
+}
+
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Save inserts or replaces job
+func (d *DB) Save(job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return d.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(job.ID), data)
+	})
+}
+
+func (d *DB) Get(id string) (Job, error) {
+	var job Job
+	err := d.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(jobsBucket).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &job)
+	})
+	return job, err
+}
+
+// List returns the jobs matching filter, newest first, and the cursor of the next page.
+// The cursor is empty on the last page.
+func (d *DB) List(filter Filter) ([]Job, string, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	limit = min(limit, MaxLimit)
+
+	jobs := []Job{}
+	next := ""
+	err := d.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(jobsBucket).Cursor()
+
+		var k, v []byte
+		if filter.Before == "" {
+			k, v = c.Last()
+		} else {
+			// the cursor is the last job of the previous page, start right before it
+			k, v = c.Seek([]byte(filter.Before))
+			if k == nil {
+				k, v = c.Last()
+			}
+			for k != nil && string(k) >= filter.Before {
+				k, v = c.Prev()
+			}
+		}
+
+		for ; k != nil; k, v = c.Prev() {
+			var job Job
+			err := json.Unmarshal(v, &job)
+			if err != nil {
+				return fmt.Errorf("error reading job %s: %s", k, err)
+			}
+			if !filter.match(job) {
+				continue
+			}
+			if len(jobs) == limit {
+				next = jobs[len(jobs)-1].ID
+				return nil
+			}
+			jobs = append(jobs, job)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return jobs, next, nil
+}
+
+// Cancel stops a running job. Its commands are killed and the job finishes as cancelled.
+func (d *DB) Cancel(id string) error {
+	d.mu.Lock()
+	cancel, ok := d.running[id]
+	d.mu.Unlock()
+	if !ok {
+		return ErrNotRunning
+	}
+	cancel(ErrCancelled)
+	return nil
+}
+
+// RecoverInterrupted fails the jobs still running when the server stopped, their builds
+// went with the process. It returns how many were failed.
+func (d *DB) RecoverInterrupted() (int, error) {
+	recovered := 0
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+
+		var interrupted []Job
+		err := bucket.ForEach(func(k []byte, v []byte) error {
+			var job Job
+			err := json.Unmarshal(v, &job)
+			if err != nil {
+				return fmt.Errorf("error reading job %s: %s", k, err)
+			}
+			if job.Status == StatusRunning {
+				interrupted = append(interrupted, job)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, job := range interrupted {
+			job.Status = StatusFailed
+			job.Error = "interrupted by a server restart"
+			job.Finished = time.Now().UTC()
+			data, err := json.Marshal(job)
+			if err != nil {
+				return err
+			}
+			err = bucket.Put([]byte(job.ID), data)
+			if err != nil {
+				return err
+			}
+		}
+		recovered = len(interrupted)
+		return nil
+	})
+	return recovered, err
+}
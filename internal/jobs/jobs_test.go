@@ -0,0 +1,233 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jeffbrennan/pdfgen/internal/logging"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/store"
+)
+
+func openTestDB(t *testing.T) *DB {
+	db, err := Open(filepath.Join(t.TempDir(), "jobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestList(t *testing.T) {
+	db := openTestDB(t)
+
+	airflow := &models.RepoParts{Owner: "apache", Repo: "airflow"}
+	fastapi := &models.RepoParts{Owner: "tiangolo", Repo: "fastapi"}
+	seed := []Job{
+		{Kind: KindGenerate, Status: StatusSucceeded, Parts: airflow, User: "ada"},
+		{Kind: KindGenerate, Status: StatusFailed, Parts: fastapi, User: "ada"},
+		{Kind: KindDiff, Status: StatusSucceeded, Parts: airflow},
+		{Kind: KindBundle, Status: StatusRunning},
+		{Kind: KindGenerate, Status: StatusSucceeded, Parts: fastapi},
+	}
+	var ids []string
+	for _, job := range seed {
+		job.ID = NewID()
+		err := db.Save(job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, job.ID)
+	}
+
+	var tests = []struct {
+		name     string
+		filter   Filter
+		expected []string
+		next     string
+	}{
+		{"everything newest first", Filter{}, []string{ids[4], ids[3], ids[2], ids[1], ids[0]}, ""},
+		{"by status", Filter{Status: StatusSucceeded}, []string{ids[4], ids[2], ids[0]}, ""},
+		{"by kind", Filter{Kind: KindGenerate}, []string{ids[4], ids[1], ids[0]}, ""},
+		{"by repo", Filter{Repo: "apache/airflow"}, []string{ids[2], ids[0]}, ""},
+		{"by user", Filter{User: "ada"}, []string{ids[1], ids[0]}, ""},
+		{"combined", Filter{Kind: KindGenerate, Repo: "tiangolo/fastapi", Status: StatusFailed}, []string{ids[1]}, ""},
+		{"first page", Filter{Limit: 2}, []string{ids[4], ids[3]}, ids[3]},
+		{"second page", Filter{Limit: 2, Before: ids[3]}, []string{ids[2], ids[1]}, ids[1]},
+		{"last page", Filter{Limit: 2, Before: ids[1]}, []string{ids[0]}, ""},
+		{"filtered page", Filter{Status: StatusSucceeded, Limit: 1, Before: ids[4]}, []string{ids[2]}, ids[2]},
+		{"no match", Filter{Repo: "apache/spark"}, []string{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs, next, err := db.List(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, job := range jobs {
+				got = append(got, job.ID)
+			}
+			if len(got) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, got)
+			}
+			for i := range got {
+				if got[i] != tt.expected[i] {
+					t.Fatalf("expected %v, got %v", tt.expected, got)
+				}
+			}
+			if next != tt.next {
+				t.Errorf("expected next %q, got %q", tt.next, next)
+			}
+		})
+	}
+}
+
+func TestRecoverInterrupted(t *testing.T) {
+	db := openTestDB(t)
+
+	running := Job{ID: NewID(), Kind: KindGenerate, Status: StatusRunning}
+	done := Job{ID: NewID(), Kind: KindGenerate, Status: StatusSucceeded}
+	for _, job := range []Job{running, done} {
+		err := db.Save(job)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recovered, err := db.RecoverInterrupted()
+	if err != nil || recovered != 1 {
+		t.Fatalf("expected 1 recovered job, got %d: %v", recovered, err)
+	}
+
+	job, err := db.Get(running.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != StatusFailed || job.Error == "" || job.Finished.IsZero() {
+		t.Errorf("expected the running job to be failed, got %+v", job)
+	}
+	job, err = db.Get(done.ID)
+	if err != nil || job.Status != StatusSucceeded {
+		t.Errorf("expected the finished job to be untouched, got %+v: %v", job, err)
+	}
+
+	_, err = db.Get(NewID())
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	db := openTestDB(t)
+	artifacts := store.NewFS(t.TempDir())
+
+	ctx, tracker := db.Start(context.Background(), artifacts, KindGenerate, "", "https://github.com/apache/airflow/tree/main/docs", models.BuildOptions{Cover: true})
+	// a job running at the same time keeps its messages to itself
+	failedCtx, failed := db.Start(context.Background(), artifacts, KindDiff, "", "https://github.com/apache/airflow", models.BuildOptions{})
+	logging.PublishLog(failedCtx, "Building v1...")
+
+	FromContext(ctx).SetParts(&models.RepoParts{Owner: "apache", Repo: "airflow"})
+	FromContext(ctx).Stage("clone")
+	logging.PublishLog(ctx, "Cloning apache/airflow...")
+	FromContext(ctx).SetCommit("abc123")
+	FromContext(ctx).SetFormat(models.Sphinx)
+	FromContext(ctx).Stage("env")
+	FromContext(ctx).SetEnv("python", "uv")
+
+	job, err := db.Get(tracker.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != StatusRunning || len(job.Stages) != 2 || job.Stages[0].Seconds == 0 {
+		t.Errorf("expected a running job with a finished clone stage, got %+v", job)
+	}
+
+	tracker.Finish(models.Artifact{ID: "artifact"}, nil)
+	logging.PublishLog(ctx, "published after the job finished")
+
+	job, err = db.Get(tracker.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != StatusSucceeded || job.Artifact != "artifact" || job.Commit != "abc123" ||
+		job.Format != "sphinx" || job.Env != "python/uv" || job.Parts.Repo != "airflow" || !job.Options.Cover {
+		t.Errorf("unexpected job %+v", job)
+	}
+	if job.Stages[1].Seconds == 0 || job.Finished.IsZero() {
+		t.Errorf("expected the last stage and the job to be finished, got %+v", job)
+	}
+
+	r, _, err := artifacts.Get(job.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transcript, _ := io.ReadAll(r)
+	r.Close()
+	if !strings.Contains(string(transcript), "Cloning apache/airflow...") || strings.Contains(string(transcript), "after the job finished") ||
+		strings.Contains(string(transcript), "Building v1...") {
+		t.Errorf("unexpected transcript %q", transcript)
+	}
+
+	failed.Finish(models.Artifact{}, errors.New("error building v1: boom"))
+	job, err = db.Get(failed.ID())
+	if err != nil || job.Status != StatusFailed || job.Error != "error building v1: boom" {
+		t.Errorf("expected a failed job, got %+v: %v", job, err)
+	}
+	r, _, err = artifacts.Get(job.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transcript, _ = io.ReadAll(r)
+	r.Close()
+	if !strings.Contains(string(transcript), "Building v1...") || strings.Contains(string(transcript), "Cloning apache/airflow...") {
+		t.Errorf("unexpected transcript %q", transcript)
+	}
+
+	// without a history every call is a no-op
+	var disabled *DB
+	_, nilTracker := disabled.Start(context.Background(), artifacts, KindGenerate, "", "", models.BuildOptions{})
+	nilTracker.Stage("clone")
+	nilTracker.Finish(models.Artifact{}, nil)
+	if FromContext(context.Background()) != nil || nilTracker.ID() != "" {
+		t.Errorf("expected no tracker")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	db := openTestDB(t)
+	artifacts := store.NewFS(t.TempDir())
+
+	ctx, tracker := db.Start(context.Background(), artifacts, KindGenerate, "", "https://github.com/apache/airflow", models.BuildOptions{})
+	err := db.Cancel(tracker.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-ctx.Done()
+	if !errors.Is(context.Cause(ctx), ErrCancelled) {
+		t.Errorf("expected ErrCancelled as the cause, got %v", context.Cause(ctx))
+	}
+
+	tracker.Finish(models.Artifact{}, errors.New("sphinx-build cancelled: "+ErrCancelled.Error()))
+	job, err := db.Get(tracker.ID())
+	if err != nil || job.Status != StatusCancelled {
+		t.Errorf("expected a cancelled job, got %+v: %v", job, err)
+	}
+	if err := db.Cancel(tracker.ID()); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("expected a finished job not to be cancellable, got %v", err)
+	}
+
+	// a build that succeeded before the cancellation landed keeps its artifact
+	ctx, tracker = db.Start(context.Background(), artifacts, KindGenerate, "", "https://github.com/apache/airflow", models.BuildOptions{})
+	db.Cancel(tracker.ID())
+	tracker.Finish(models.Artifact{ID: "artifact"}, nil)
+	job, err = db.Get(tracker.ID())
+	if err != nil || job.Status != StatusSucceeded {
+		t.Errorf("expected a succeeded job, got %+v: %v", job, err)
+	}
+}
@@ -0,0 +1,176 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffbrennan/pdfgen/internal/logging"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/store"
+)
+
+type trackerKey struct{}
+
+// Tracker records a running job, saving it after every change so a crash leaves the
+// job behind as running. A nil Tracker records nothing, which is what builds get when
+// the history is disabled or they are part of a larger job.
+type Tracker struct {
+	mu         sync.Mutex
+	db         *DB
+	artifacts  store.ArtifactStore
+	job        Job
+	transcript *logging.Transcript
+	ctx        context.Context
+	cancel     context.CancelCauseFunc
+}
+
+// Start records a new running job of user. The returned context carries the tracker and
+// the job's log transcript, and is cancelled by Cancel.
+func (d *DB) Start(ctx context.Context, artifacts store.ArtifactStore, kind string, user string, url string, opts models.BuildOptions) (context.Context, *Tracker) {
+	if d == nil {
+		return ctx, nil
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	t := &Tracker{
+		db:        d,
+		artifacts: artifacts,
+		ctx:       ctx,
+		cancel:    cancel,
+		job: Job{
+			ID:      NewID(),
+			Kind:    kind,
+			User:    user,
+			URL:     url,
+			Options: opts,
+			Status:  StatusRunning,
+			Stages:  []Stage{},
+			Created: time.Now().UTC(),
+		},
+		transcript: &logging.Transcript{},
+	}
+	ctx = logging.WithTranscript(ctx, t.transcript)
+
+	d.mu.Lock()
+	d.running[t.job.ID] = cancel
+	d.mu.Unlock()
+
+	t.save()
+	return WithTracker(ctx, t), t
+}
+
+// WithTracker returns a copy of ctx carrying t
+func WithTracker(ctx context.Context, t *Tracker) context.Context {
+	return context.WithValue(ctx, trackerKey{}, t)
+}
+
+// FromContext returns the tracker of ctx, nil if it has none
+func FromContext(ctx context.Context) *Tracker {
+	t, _ := ctx.Value(trackerKey{}).(*Tracker)
+	return t
+}
+
+func (t *Tracker) ID() string {
+	if t == nil {
+		return ""
+	}
+	return t.job.ID
+}
+
+// Stage ends the current stage and starts the next one
+func (t *Tracker) Stage(name string) {
+	t.update(func(job *Job) {
+		endStage(job)
+		job.Stages = append(job.Stages, Stage{Name: name, Started: time.Now().UTC()})
+	})
+}
+
+func (t *Tracker) SetParts(parts *models.RepoParts) {
+	t.update(func(job *Job) {
+		p := *parts
+		job.Parts = &p
+	})
+}
+
+func (t *Tracker) SetCommit(commit string) {
+	t.update(func(job *Job) { job.Commit = commit })
+}
+
+func (t *Tracker) SetFormat(format models.DocumentationFormat) {
+	t.update(func(job *Job) { job.Format = models.DocumentationName[format] })
+}
+
+// SetEnv records the detected environment, e.g. "python" or "python/uv"
+func (t *Tracker) SetEnv(env ...string) {
+	t.update(func(job *Job) { job.Env = strings.Join(env, "/") })
+}
+
+// Finish records the outcome and stores the log transcript. A job that failed after its
+// context was cancelled is recorded as cancelled.
+func (t *Tracker) Finish(artifact models.Artifact, err error) {
+	if t == nil {
+		return
+	}
+	t.db.mu.Lock()
+	delete(t.db.running, t.job.ID)
+	t.db.mu.Unlock()
+	cancelled := t.ctx.Err() != nil
+	t.cancel(nil)
+
+	logKey := "logs/" + t.job.ID + ".log"
+	putErr := t.artifacts.Put(logKey, strings.NewReader(t.transcript.String()), store.Info{
+		ContentType: "text/plain; charset=utf-8",
+	})
+	if putErr != nil {
+		log.Printf("error storing the log of job %s: %s", t.job.ID, putErr)
+	}
+
+	t.update(func(job *Job) {
+		endStage(job)
+		job.Finished = time.Now().UTC()
+		job.Artifact = artifact.ID
+		if putErr == nil {
+			job.Log = logKey
+		}
+		switch {
+		case err != nil && cancelled:
+			job.Status = StatusCancelled
+			job.Error = err.Error()
+		case err != nil:
+			job.Status = StatusFailed
+			job.Error = err.Error()
+		default:
+			job.Status = StatusSucceeded
+		}
+	})
+}
+
+func endStage(job *Job) {
+	if len(job.Stages) == 0 {
+		return
+	}
+	last := &job.Stages[len(job.Stages)-1]
+	if last.Seconds == 0 {
+		last.Seconds = time.Since(last.Started).Seconds()
+	}
+}
+
+func (t *Tracker) update(change func(job *Job)) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	change(&t.job)
+	t.save()
+}
+
+// save logs failures, a build isn't failed because its history couldn't be written
+func (t *Tracker) save() {
+	err := t.db.Save(t.job)
+	if err != nil {
+		log.Printf("error saving job %s: %s", t.job.ID, err)
+	}
+}
@@ -0,0 +1,233 @@
+package latex
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Kind string
+
+const (
+	Error                    Kind = "error"
+	UndefinedControlSequence Kind = "undefined control sequence"
+	MissingPackage           Kind = "missing package"
+	MissingFont              Kind = "missing font"
+	EmergencyStop            Kind = "emergency stop"
+)
+
+const (
+	maxContextLines          = 3
+	undefinedControlSequence = "Undefined control sequence."
+	emergencyStopMessage     = "Emergency stop."
+	interruptionMessage      = "Interruption."
+	noLegalEndMessage        = "*** (job aborted, no legal \\end found)"
+)
+
+// Diagnostic is a single problem reported in a TeX log
+type Diagnostic struct {
+	Kind    Kind
+	Message string
+	File    string
+	Line    int
+	Context string
+}
+
+type Diagnostics struct {
+	Errors     []Diagnostic
+	OutputFile string
+	Pages      int
+	// FrontMatter counts the pages shipped before the page counter was last reset to 1,
+	// i.e. the title page and roman numbered front matter of a book class document
+	FrontMatter int
+}
+
+var (
+	fileOpenRe      = regexp.MustCompile(`\((\.?/?[^()\s]+\.(?:tex|sty|cls|aux|toc|ind))`)
+	lineRe          = regexp.MustCompile(`^l\.(\d+)\s?(.*)$`)
+	missingFileRe   = regexp.MustCompile("File `([^']+)' not found")
+	missingFontRe   = regexp.MustCompile(`(?i)font .*(not loadable|cannot be found|not found)`)
+	outputWrittenRe = regexp.MustCompile(`^Output written on (.+?) \((\d+) pages?`)
+	shipoutRe       = regexp.MustCompile(`\[(\d+)`)
+)
+
+// ParseLog extracts errors and the output summary from a pdflatex/xelatex/lualatex log
+func ParseLog(data []byte) *Diagnostics {
+	diags := &Diagnostics{}
+
+	// TeX wraps log lines at 79 characters which would split file names and messages
+	lines := unwrap(data)
+
+	currentFile := ""
+	shipped := 0
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		for _, page := range shipouts(line) {
+			if page == 1 && shipped > 0 {
+				diags.FrontMatter = shipped
+			}
+			shipped++
+		}
+
+		for _, match := range fileOpenRe.FindAllStringSubmatch(line, -1) {
+			if strings.HasSuffix(match[1], ".tex") {
+				currentFile = strings.TrimPrefix(match[1], "./")
+			}
+		}
+
+		if m := outputWrittenRe.FindStringSubmatch(line); m != nil {
+			diags.OutputFile = m[1]
+			diags.Pages, _ = strconv.Atoi(m[2])
+			continue
+		}
+
+		if line == noLegalEndMessage {
+			diags.Errors = append(diags.Errors, Diagnostic{Kind: EmergencyStop, Message: "job aborted, no legal \\end found", File: currentFile})
+			continue
+		}
+
+		if !strings.HasPrefix(line, "! ") {
+			continue
+		}
+
+		message := strings.TrimPrefix(line, "! ")
+		diag := Diagnostic{Kind: classify(message), Message: message, File: currentFile}
+
+		// the error location follows as "l.<n> <source up to the error>"
+		var context []string
+		beforeBreak := ""
+		for j := i + 1; j < len(lines) && j <= i+8; j++ {
+			if m := lineRe.FindStringSubmatch(lines[j]); m != nil {
+				diag.Line, _ = strconv.Atoi(m[1])
+				beforeBreak = strings.TrimSpace(m[2])
+				context = append(context, beforeBreak)
+				if j+1 < len(lines) && strings.TrimSpace(lines[j+1]) != "" {
+					context = append(context, strings.TrimSpace(lines[j+1]))
+				}
+				break
+			}
+			if strings.HasPrefix(lines[j], "! ") {
+				break
+			}
+			if len(context) < maxContextLines && strings.TrimSpace(lines[j]) != "" {
+				context = append(context, strings.TrimSpace(lines[j]))
+			}
+		}
+		diag.Context = strings.Join(context, " ")
+
+		if diag.Kind == UndefinedControlSequence {
+			// the offending macro is the last token before the break
+			fields := strings.Fields(beforeBreak)
+			if len(fields) > 0 {
+				diag.Message = fmt.Sprintf("%s %s", undefinedControlSequence, fields[len(fields)-1])
+			}
+		}
+
+		diags.Errors = append(diags.Errors, diag)
+	}
+
+	return diags
+}
+
+// shipouts returns the page counters TeX prints as "[<n>" when a page is shipped out,
+// optionally followed by the files embedded on that page
+func shipouts(line string) []int {
+	if strings.HasPrefix(line, "! ") || strings.HasPrefix(line, "l.") {
+		return nil
+	}
+
+	var pages []int
+	for _, m := range shipoutRe.FindAllStringSubmatchIndex(line, -1) {
+		start, end := m[0], m[1]
+		if start > 0 && line[start-1] != ' ' && line[start-1] != ']' {
+			continue
+		}
+		if end < len(line) && !strings.ContainsRune(" ]{<", rune(line[end])) {
+			continue
+		}
+		n, err := strconv.Atoi(line[m[2]:m[3]])
+		if err == nil {
+			pages = append(pages, n)
+		}
+	}
+	return pages
+}
+
+func classify(message string) Kind {
+	switch {
+	case message == undefinedControlSequence:
+		return UndefinedControlSequence
+	case message == emergencyStopMessage || message == interruptionMessage:
+		return EmergencyStop
+	case missingFontRe.MatchString(message):
+		return MissingFont
+	case missingFileRe.MatchString(message):
+		file := missingFileRe.FindStringSubmatch(message)[1]
+		if strings.HasSuffix(file, ".tfm") || strings.HasSuffix(file, ".pfb") ||
+			strings.HasSuffix(file, ".otf") || strings.HasSuffix(file, ".ttf") {
+			return MissingFont
+		}
+		return MissingPackage
+	default:
+		return Error
+	}
+}
+
+func unwrap(data []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+
+	previousWrapped := false
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if previousWrapped && len(lines) > 0 && !strings.HasPrefix(line, "! ") {
+			lines[len(lines)-1] += line
+		} else {
+			lines = append(lines, line)
+		}
+		previousWrapped = len(line) == 79
+	}
+	return lines
+}
+
+// First returns the most useful error: emergency stops only describe the aftermath,
+// so any other error takes priority
+func (d *Diagnostics) First() *Diagnostic {
+	for i := range d.Errors {
+		if d.Errors[i].Kind != EmergencyStop {
+			return &d.Errors[i]
+		}
+	}
+	if len(d.Errors) > 0 {
+		return &d.Errors[0]
+	}
+	return nil
+}
+
+func (d *Diagnostics) HasOutput() bool {
+	return d.OutputFile != "" && d.Pages > 0
+}
+
+func (d Diagnostic) String() string {
+	location := d.File
+	if location == "" {
+		location = "<unknown>"
+	}
+	if d.Line > 0 {
+		location = fmt.Sprintf("%s:%d", location, d.Line)
+	}
+
+	msg := fmt.Sprintf("%s: %s", location, d.Message)
+	if d.Kind == MissingPackage || d.Kind == MissingFont {
+		msg = fmt.Sprintf("%s (%s)", msg, d.Kind)
+	}
+	if d.Context != "" && d.Kind != UndefinedControlSequence {
+		msg = fmt.Sprintf("%s near %q", msg, d.Context)
+	}
+	return msg
+}
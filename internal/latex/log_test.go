@@ -0,0 +1,118 @@
+package latex
+
+import (
+	"strings"
+	"testing"
+)
+
+const undefinedLog = `This is pdfTeX, Version 3.141592653-2.6-1.40.24 (TeX Live 2022/Debian) (preloaded format=pdflatex)
+entering extended mode
+(./airflow.tex
+LaTeX2e <2022-11-01> patch level 1
+(/usr/share/texlive/texmf-dist/tex/latex/base/report.cls
+Document Class: report 2022/07/02 v1.4n Standard LaTeX document class
+) (./sphinxmanual.cls)
+! Undefined control sequence.
+l.42 \sphinxfoo
+               {bar}
+? 
+[1] [2]
+Output written on airflow.pdf (2 pages, 12345 bytes).
+Transcript written on airflow.log.
+`
+
+const missingPackageLog = `This is pdfTeX, Version 3.141592653-2.6-1.40.24
+(./airflow.tex
+! LaTeX Error: File ` + "`fncychap.sty'" + ` not found.
+
+Type X to quit or <RETURN> to proceed,
+or enter new name. (Default extension: sty)
+
+Enter file name: 
+! Emergency stop.
+<read *> 
+         
+l.7 \usepackage
+               [Bjarne]{fncychap}^^M
+No pages of output.
+Transcript written on airflow.log.
+`
+
+const missingFontLog = `This is XeTeX, Version 3.141592653-2.6-0.999994
+(./manual.tex
+! Package fontspec Error: The font "FreeSerif" cannot be found.
+
+l.12 \setmainfont{FreeSerif}
+`
+
+func TestParseLog(t *testing.T) {
+	var tests = []struct {
+		name      string
+		log       string
+		kind      Kind
+		file      string
+		line      int
+		hasOutput bool
+		contains  string
+	}{
+		{"undefined control sequence", undefinedLog, UndefinedControlSequence, "airflow.tex", 42, true, `\sphinxfoo`},
+		{"missing package", missingPackageLog, MissingPackage, "airflow.tex", 0, false, "fncychap.sty"},
+		{"missing font", missingFontLog, MissingFont, "manual.tex", 12, false, "FreeSerif"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diags := ParseLog([]byte(tt.log))
+			first := diags.First()
+			if first == nil {
+				t.Fatalf("expected an error")
+			}
+			if first.Kind != tt.kind {
+				t.Errorf("expected kind %s, got %s", tt.kind, first.Kind)
+			}
+			if first.File != tt.file {
+				t.Errorf("expected file %s, got %s", tt.file, first.File)
+			}
+			if first.Line != tt.line {
+				t.Errorf("expected line %d, got %d", tt.line, first.Line)
+			}
+			if diags.HasOutput() != tt.hasOutput {
+				t.Errorf("expected output %v, got %v", tt.hasOutput, diags.HasOutput())
+			}
+			if !strings.Contains(first.String(), tt.contains) {
+				t.Errorf("expected %q in %q", tt.contains, first.String())
+			}
+		})
+	}
+}
+
+func TestParseLogClean(t *testing.T) {
+	diags := ParseLog([]byte("(./a.tex [1] [2] [3])\nOutput written on a.pdf (3 pages, 999 bytes).\n"))
+	if len(diags.Errors) != 0 {
+		t.Errorf("expected no errors, got %v", diags.Errors)
+	}
+	if diags.OutputFile != "a.pdf" || diags.Pages != 3 {
+		t.Errorf("unexpected output %s (%d pages)", diags.OutputFile, diags.Pages)
+	}
+}
+
+func TestParseLogFrontMatter(t *testing.T) {
+	var tests = []struct {
+		name        string
+		log         string
+		frontMatter int
+	}{
+		{"no reset", "(./a.tex [1] [2] [3])\n", 0},
+		{"title and roman toc", "(./a.tex [1{/usr/share/texmf/pdftex.map}] [1] [2]\n[1] [2 <./img.png>] [3])\n", 3},
+		{"ignores error context", "(./a.tex [1] [2]\n! Undefined control sequence.\nl.3 \\foo [1]\n[3])\n", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diags := ParseLog([]byte(tt.log))
+			if diags.FrontMatter != tt.frontMatter {
+				t.Errorf("expected %d front matter pages, got %d", tt.frontMatter, diags.FrontMatter)
+			}
+		})
+	}
+}
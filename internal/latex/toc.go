@@ -0,0 +1,144 @@
+package latex
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// TOCEntry is one line of a .toc file. Page is the printed page label, Anchor the
+// hyperref destination name when hyperref is loaded.
+type TOCEntry struct {
+	Level  int
+	Title  string
+	Page   string
+	Anchor string
+}
+
+var tocLevels = map[string]int{
+	"part":          0,
+	"chapter":       1,
+	"section":       2,
+	"subsection":    3,
+	"subsubsection": 4,
+	"paragraph":     5,
+	"subparagraph":  6,
+}
+
+// textSymbols are the commands Sphinx uses for characters that are special in LaTeX
+var textSymbols = strings.NewReplacer(
+	`\textquotesingle{}`, "'",
+	`\textquotesingle`, "'",
+	`\textbackslash{}`, `\\`,
+	`\textbackslash`, `\\`,
+	`\textasciitilde{}`, `\~`,
+	`\textasciitilde`, `\~`,
+	`\textasciicircum{}`, `\^`,
+	`\textasciicircum`, `\^`,
+)
+
+var (
+	numberlineRe = regexp.MustCompile(`\\numberline\s*\{([^{}]*)\}`)
+)
+
+// ParseTOC reads the \contentsline entries LaTeX writes to <job>.toc
+func ParseTOC(data []byte) []TOCEntry {
+	var entries []TOCEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		rest, ok := strings.CutPrefix(line, `\contentsline`)
+		if !ok {
+			continue
+		}
+
+		args := braceArgs(rest, 4)
+		if len(args) < 3 {
+			continue
+		}
+		level, ok := tocLevels[args[0]]
+		if !ok {
+			continue
+		}
+
+		entry := TOCEntry{Level: level, Title: PlainText(args[1]), Page: PlainText(args[2])}
+		if len(args) == 4 {
+			entry.Anchor = args[3]
+		}
+		if entry.Title != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// braceArgs splits up to n leading {...} groups, keeping nested braces intact
+func braceArgs(s string, n int) []string {
+	var args []string
+	depth := 0
+	start := -1
+	for i := 0; i < len(s) && len(args) < n; i++ {
+		switch s[i] {
+		case '\\':
+			// escaped braces do not nest
+			i++
+		case '{':
+			if depth == 0 {
+				start = i + 1
+			}
+			depth++
+		case '}':
+			depth--
+			if depth == 0 && start >= 0 {
+				args = append(args, s[start:i])
+				start = -1
+			}
+			if depth < 0 {
+				return args
+			}
+		case ' ', '\t':
+		default:
+			if depth == 0 {
+				return args
+			}
+		}
+	}
+	return args
+}
+
+// PlainText strips LaTeX markup, e.g. from a TOC title for use in PDF bookmarks
+func PlainText(s string) string {
+	s = numberlineRe.ReplaceAllString(s, "$1 ")
+	s = textSymbols.Replace(s)
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && isLetter(s[i+1]):
+			// drop the command name, its arguments stay as plain text
+			i++
+			for i+1 < len(s) && (isLetter(s[i+1]) || s[i+1] == '*') {
+				i++
+			}
+			for i+1 < len(s) && s[i+1] == ' ' {
+				i++
+			}
+		case c == '\\' && i+1 < len(s):
+			i++
+			b.WriteByte(s[i])
+		case c == '{' || c == '}':
+		case c == '~':
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '@'
+}
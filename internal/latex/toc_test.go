@@ -0,0 +1,51 @@
+package latex
+
+import (
+	"reflect"
+	"testing"
+)
+
+const sphinxTOC = `\babel@toc {english}{}\relax 
+\contentsline {chapter}{\numberline {1}Installation}{3}{chapter.1}%
+\contentsline {section}{\numberline {1.1}Using \sphinxcode {\sphinxupquote {pip}}}{3}{section.1.1}%
+\contentsline {subsection}{\numberline {1.1.1}Flags \& options}{4}{subsection.1.1.1}%
+\contentsline {chapter}{Index}{27}{section*.5}%
+\contentsline {figure}{\numberline {1}A figure}{5}{figure.1}%
+`
+
+func TestParseTOC(t *testing.T) {
+	expected := []TOCEntry{
+		{Level: 1, Title: "1 Installation", Page: "3", Anchor: "chapter.1"},
+		{Level: 2, Title: "1.1 Using pip", Page: "3", Anchor: "section.1.1"},
+		{Level: 3, Title: "1.1.1 Flags & options", Page: "4", Anchor: "subsection.1.1.1"},
+		{Level: 1, Title: "Index", Page: "27", Anchor: "section*.5"},
+	}
+
+	got := ParseTOC([]byte(sphinxTOC))
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	var tests = []struct {
+		name     string
+		tex      string
+		expected string
+	}{
+		{"plain", "Overview", "Overview"},
+		{"escaped", `50\% of \$ \_private \{x\}`, "50% of $ _private {x}"},
+		{"symbols", `it\textquotesingle{}s a \textasciitilde{}path\textbackslash{}`, `it's a ~path\`},
+		{"nested commands", `\sphinxstyleliteralintitle {\sphinxupquote {run()}}`, "run()"},
+		{"tie", `Fig.~1`, "Fig. 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PlainText(tt.tex)
+			if got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
@@ -1,17 +1,50 @@
 package logging
 
-import "sync"
-
-var (
-	LogChannel = make(chan string)
-	LogMu      sync.Mutex
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
 )
 
-func PublishLog(message string) {
-	LogMu.Lock()
-	defer LogMu.Unlock()
+var LogChannel = make(chan string)
+
+type transcriptKey struct{}
+
+// PublishLog sends message to the log stream and to the transcript of ctx, if any
+func PublishLog(ctx context.Context, message string) {
+	if t, ok := ctx.Value(transcriptKey{}).(*Transcript); ok {
+		t.add(message)
+	}
 	select {
 	case LogChannel <- message:
 	default:
 	}
 }
+
+// Transcript keeps the messages published under the contexts that carry it, the log of
+// a single job. The log stream has the messages of every build.
+type Transcript struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+// WithTranscript returns a copy of ctx whose messages are kept in t
+func WithTranscript(ctx context.Context, t *Transcript) context.Context {
+	return context.WithValue(ctx, transcriptKey{}, t)
+}
+
+func (t *Transcript) add(message string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lines = append(t.lines, time.Now().UTC().Format(time.RFC3339)+" "+message)
+}
+
+func (t *Transcript) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.lines) == 0 {
+		return ""
+	}
+	return strings.Join(t.lines, "\n") + "\n"
+}
@@ -26,13 +26,145 @@ type RepoStats struct {
 	AgeYears float64
 }
 
-type PDFGenResponse struct {
+// Artifact is the single file a build hands back, whatever the output format
+type Artifact struct {
 	Parts    *RepoParts
 	DirParts *DirectoryParts
-	PdfPath  string
-	PdfBytes []byte
+	// Path is the file while it is being built, empty for cached builds
+	Path string
+	// ID names the artifact in the store, Key is its file there: <ID>/<file name>
+	ID          string
+	Key         string
+	ContentType string
+	// Extension includes the leading dot, e.g. ".pdf"
+	Extension string
+	// Degraded is set when LaTeX reported errors but still produced a PDF
+	Degraded bool
+	Warnings []string
+	// Documents lists every document the project defines, not only the ones built
+	Documents []Document
+	Renderer  string
+	// Commit is the SHA that was built
+	Commit string
+	// Headings and FrontMatter describe a single PDF for post-processing
+	Headings    []Heading
+	FrontMatter int
 }
 
+type Document struct {
+	Name   string
+	Title  string
+	Author string
+}
+
+// Heading is a section of a built PDF used for its outline. Dest is the named
+// destination the producer wrote, Label the printed page number.
+type Heading struct {
+	Title string
+	Level int
+	Dest  string
+	Label string
+}
+
+const (
+	DocumentsMerge = "merge"
+	DocumentsZip   = "zip"
+)
+
+const (
+	RendererLatex = "latex"
+	RendererHTML  = "html"
+)
+
+const (
+	OutputPDF      = "pdf"
+	OutputEPUB     = "epub"
+	OutputHTML     = "html"
+	OutputMarkdown = "markdown"
+)
+
+var Outputs = []string{OutputPDF, OutputEPUB, OutputHTML, OutputMarkdown}
+
+// ContentTypes covers every artifact extension pdfgen produces
+var ContentTypes = map[string]string{
+	".pdf":  "application/pdf",
+	".zip":  "application/zip",
+	".epub": "application/epub+zip",
+	".html": "text/html; charset=utf-8",
+	".md":   "text/markdown; charset=utf-8",
+	".json": "application/json",
+}
+
+// Layout changes the page setup for note-taking, zero values keep the project's own
+type Layout struct {
+	// Paper is one of Papers
+	Paper string
+	// Margin is one of Margins, MarginWide leaves an outer margin for annotations
+	Margin string
+	// FontSize is the base font size in points
+	FontSize int
+	// Columns is 1 or 2
+	Columns     int
+	LineNumbers bool
+	// Theme is one of Themes
+	Theme string
+}
+
+const (
+	PaperA4     = "a4"
+	PaperLetter = "letter"
+	PaperA5     = "a5"
+	PaperTablet = "tablet"
+
+	MarginNormal = "normal"
+	MarginNarrow = "narrow"
+	MarginWide   = "wide"
+
+	ThemeLight    = "light"
+	ThemeContrast = "contrast"
+)
+
+var (
+	Papers  = []string{PaperA4, PaperLetter, PaperA5, PaperTablet}
+	Margins = []string{MarginNormal, MarginNarrow, MarginWide}
+	Themes  = []string{ThemeLight, ThemeContrast}
+)
+
+// BuildOptions are the per-request choices made through the API
+type BuildOptions struct {
+	// Documents selects how projects with several documents are delivered:
+	// DocumentsMerge (default), DocumentsZip, or the name of a single document
+	Documents string
+	// Renderer is RendererLatex or RendererHTML. When empty, LaTeX is used with an
+	// HTML fallback if enabled
+	Renderer string
+	// Output is one of Outputs, OutputPDF by default
+	Output string
+	// Cover prepends a page identifying the source, Headers adds it to every page
+	Cover   bool
+	Headers bool
+	Layout  Layout
+	// Split delivers one PDF per outline entry down to this depth, 0 keeps a single PDF
+	Split int
+	// Ref pins the build to a branch, tag, or commit instead of the url's branch
+	Ref string
+}
+
+// BundleItem is one project of a bundle, Title names its part of the bound PDF
+type BundleItem struct {
+	URL   string
+	Title string
+}
+
+const (
+	// NumberingContinuous numbers the pages of a bundle straight through
+	NumberingContinuous = "continuous"
+	// NumberingPart restarts at 1 for every part, prefixed with the part number
+	NumberingPart = "part"
+)
+
+var Numberings = []string{NumberingContinuous, NumberingPart}
+
 type DocumentationFormat int
 type PythonEnv int
 type EnvType int
@@ -61,3 +193,14 @@ var DocumentationName = map[DocumentationFormat]string{
 	Docusaurus: "docusaurus",
 	GitBook:    "gitbook",
 }
+
+var EnvName = map[EnvType]string{
+	PYTHON: "python",
+	NODE:   "node",
+}
+
+var PythonEnvName = map[PythonEnv]string{
+	PIP:    "pip",
+	POETRY: "poetry",
+	UV:     "uv",
+}
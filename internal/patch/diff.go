@@ -0,0 +1,199 @@
+package patch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type fileDiff struct {
+	path  string
+	hunks []hunk
+}
+
+type hunk struct {
+	oldStart int
+	oldLines []string
+	newLines []string
+	// lines the header announces that are not read yet
+	oldLeft int
+	newLeft int
+}
+
+func (h *hunk) open() bool {
+	return h != nil && (h.oldLeft > 0 || h.newLeft > 0)
+}
+
+// parseUnifiedDiff reads the output of diff -u or git diff. Only modifications of
+// existing files are supported. Hunks are read for as many lines as their headers
+// announce, so removed "-- " and added "++ " lines are not taken for file headers.
+func parseUnifiedDiff(diff string) ([]fileDiff, error) {
+	var fileDiffs []fileDiff
+	var current *fileDiff
+	var currentHunk *hunk
+
+	lines := strings.Split(strings.ReplaceAll(diff, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if !currentHunk.open() {
+			switch {
+			case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+				path := diffPath(lines[i+1][4:])
+				if path == "/dev/null" || diffPath(line[4:]) == "/dev/null" {
+					return nil, fmt.Errorf("diff patches cannot create or delete files")
+				}
+				fileDiffs = append(fileDiffs, fileDiff{path: path})
+				current = &fileDiffs[len(fileDiffs)-1]
+				currentHunk = nil
+				i++
+			case strings.HasPrefix(line, "@@"):
+				if current == nil {
+					return nil, fmt.Errorf("hunk before file header: %s", line)
+				}
+				h, err := parseHunkHeader(line)
+				if err != nil {
+					return nil, err
+				}
+				current.hunks = append(current.hunks, h)
+				currentHunk = &current.hunks[len(current.hunks)-1]
+			case currentHunk != nil && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "-") || strings.HasPrefix(line, "+")):
+				return nil, fmt.Errorf("hunk is longer than its header: %s", line)
+			}
+			// anything else is a git header such as "diff --git" and "index" lines,
+			// or a "\ No newline at end of file" marker
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, " ") || line == "":
+			// blank context lines are sometimes stripped of their leading space
+			if currentHunk.oldLeft == 0 || currentHunk.newLeft == 0 {
+				return nil, fmt.Errorf("hunk is longer than its header: %s", line)
+			}
+			if line != "" {
+				line = line[1:]
+			}
+			currentHunk.oldLines = append(currentHunk.oldLines, line)
+			currentHunk.newLines = append(currentHunk.newLines, line)
+			currentHunk.oldLeft--
+			currentHunk.newLeft--
+		case strings.HasPrefix(line, "-"):
+			if currentHunk.oldLeft == 0 {
+				return nil, fmt.Errorf("hunk is longer than its header: %s", line)
+			}
+			currentHunk.oldLines = append(currentHunk.oldLines, line[1:])
+			currentHunk.oldLeft--
+		case strings.HasPrefix(line, "+"):
+			if currentHunk.newLeft == 0 {
+				return nil, fmt.Errorf("hunk is longer than its header: %s", line)
+			}
+			currentHunk.newLines = append(currentHunk.newLines, line[1:])
+			currentHunk.newLeft--
+		case strings.HasPrefix(line, `\`):
+			// \ No newline at end of file
+			continue
+		default:
+			return nil, fmt.Errorf("unexpected diff line: %s", line)
+		}
+	}
+
+	if currentHunk.open() {
+		return nil, fmt.Errorf("hunk is shorter than its header")
+	}
+	if len(fileDiffs) == 0 {
+		return nil, fmt.Errorf("diff contains no files")
+	}
+	for _, fd := range fileDiffs {
+		if len(fd.hunks) == 0 {
+			return nil, fmt.Errorf("diff for %s contains no hunks", fd.path)
+		}
+	}
+
+	return fileDiffs, nil
+}
+
+func diffPath(header string) string {
+	path := strings.SplitN(header, "\t", 2)[0]
+	path = strings.TrimSpace(path)
+	if strings.HasPrefix(path, "a/") || strings.HasPrefix(path, "b/") {
+		path = path[2:]
+	}
+	return path
+}
+
+// parseHunkHeader reads "@@ -l,s +l,s @@", a missing line count is 1
+func parseHunkHeader(line string) (hunk, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return hunk{}, fmt.Errorf("invalid hunk header: %s", line)
+	}
+	oldStart, oldCount, err := parseRange(fields[1][1:])
+	if err != nil {
+		return hunk{}, fmt.Errorf("invalid hunk header: %s", line)
+	}
+	_, newCount, err := parseRange(fields[2][1:])
+	if err != nil {
+		return hunk{}, fmt.Errorf("invalid hunk header: %s", line)
+	}
+	return hunk{oldStart: oldStart, oldLeft: oldCount, newLeft: newCount}, nil
+}
+
+func parseRange(r string) (int, int, error) {
+	start, count, found := strings.Cut(r, ",")
+	if !found {
+		count = "1"
+	}
+	s, err := strconv.Atoi(start)
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return 0, 0, fmt.Errorf("invalid line count: %s", count)
+	}
+	return s, n, nil
+}
+
+// apply replaces each hunk's old lines with its new lines. Hunks are located by
+// content, starting at the position in the header, so small line drifts still apply.
+func (fd fileDiff) apply(content string) (string, error) {
+	lines := strings.Split(content, "\n")
+	offset := 0
+	for i, h := range fd.hunks {
+		pos := findBlock(lines, h.oldLines, h.oldStart-1+offset)
+		if pos == -1 {
+			return "", fmt.Errorf("hunk %d does not apply", i+1)
+		}
+
+		updated := append([]string{}, lines[:pos]...)
+		updated = append(updated, h.newLines...)
+		updated = append(updated, lines[pos+len(h.oldLines):]...)
+		lines = updated
+		offset += len(h.newLines) - len(h.oldLines)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func findBlock(lines []string, block []string, hint int) int {
+	matchesAt := func(pos int) bool {
+		if pos < 0 || pos+len(block) > len(lines) {
+			return false
+		}
+		for i, line := range block {
+			if lines[pos+i] != line {
+				return false
+			}
+		}
+		return true
+	}
+
+	for delta := 0; delta <= len(lines); delta++ {
+		if matchesAt(hint - delta) {
+			return hint - delta
+		}
+		if matchesAt(hint + delta) {
+			return hint + delta
+		}
+	}
+	return -1
+}
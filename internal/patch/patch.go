@@ -0,0 +1,376 @@
+package patch
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+type Kind string
+
+const (
+	Literal Kind = "literal"
+	Regex   Kind = "regex"
+	Diff    Kind = "diff"
+)
+
+// Patch is a single source change applied to a checkout before building.
+// Files is a glob relative to the repo root and may use ** to cross directories;
+// diff patches take their targets from the diff headers instead.
+type Patch struct {
+	Name     string `yaml:"name"`
+	Type     Kind   `yaml:"type"`
+	Files    string `yaml:"files"`
+	Old      string `yaml:"old"`
+	New      string `yaml:"new"`
+	Diff     string `yaml:"diff"`
+	Count    int    `yaml:"count"`    // expected matches across all files, 0 means at least one
+	Optional bool   `yaml:"optional"` // report no matches as skipped instead of failed
+}
+
+type Status string
+
+const (
+	Applied Status = "applied"
+	Skipped Status = "skipped"
+	Failed  Status = "failed"
+)
+
+type Result struct {
+	Patch   Patch
+	Status  Status
+	Files   []string
+	Matches int
+	Err     error
+}
+
+type Report struct {
+	DryRun  bool
+	Results []Result
+}
+
+// Engine applies patches under Root and remembers the original contents so Revert can
+// leave the checkout clean for the next pull
+type Engine struct {
+	Root      string
+	DryRun    bool
+	originals map[string][]byte
+}
+
+func NewEngine(root string, dryRun bool) *Engine {
+	return &Engine{Root: root, DryRun: dryRun, originals: map[string][]byte{}}
+}
+
+func (p Patch) kind() Kind {
+	if p.Type == "" {
+		return Literal
+	}
+	return p.Type
+}
+
+func (p Patch) Label() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	if p.kind() == Diff {
+		return "diff"
+	}
+	return fmt.Sprintf("%s %s", p.kind(), p.Files)
+}
+
+func (p Patch) Validate() error {
+	switch p.kind() {
+	case Literal, Regex:
+		if p.Files == "" || p.Old == "" {
+			return fmt.Errorf("%s patch requires files and old", p.kind())
+		}
+		if !IsRelativePath(p.Files) {
+			return fmt.Errorf("path must stay inside the repo: %s", p.Files)
+		}
+		if p.kind() == Regex {
+			_, err := regexp.Compile(p.Old)
+			if err != nil {
+				return fmt.Errorf("invalid regex: %s", err)
+			}
+		}
+	case Diff:
+		if p.Diff == "" {
+			return fmt.Errorf("diff patch requires diff")
+		}
+		fileDiffs, err := parseUnifiedDiff(p.Diff)
+		if err != nil {
+			return err
+		}
+		for _, fd := range fileDiffs {
+			if !IsRelativePath(fd.path) {
+				return fmt.Errorf("path must stay inside the repo: %s", fd.path)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown patch type: %s", p.Type)
+	}
+
+	if p.Count < 0 {
+		return fmt.Errorf("count must not be negative")
+	}
+	return nil
+}
+
+func (e *Engine) Apply(patches []Patch) *Report {
+	report := &Report{DryRun: e.DryRun}
+	for _, p := range patches {
+		report.Results = append(report.Results, e.apply(p))
+	}
+	return report
+}
+
+func (e *Engine) apply(p Patch) Result {
+	result := Result{Patch: p}
+	fail := func(err error) Result {
+		result.Status = Failed
+		result.Err = err
+		return result
+	}
+
+	err := p.Validate()
+	if err != nil {
+		return fail(err)
+	}
+
+	// compute every change first so a patch is applied to all of its files or none
+	changes, matches, err := e.plan(p)
+	if err != nil {
+		return fail(err)
+	}
+
+	files := make([]string, 0, len(changes))
+	for file := range changes {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	result.Files = files
+	result.Matches = matches
+
+	if matches == 0 {
+		if p.Optional {
+			result.Status = Skipped
+			return result
+		}
+		return fail(fmt.Errorf("no matches"))
+	}
+
+	if p.Count > 0 && matches != p.Count {
+		return fail(fmt.Errorf("expected %d matches, found %d", p.Count, matches))
+	}
+
+	if !e.DryRun {
+		for _, file := range files {
+			err = e.write(file, changes[file])
+			if err != nil {
+				return fail(err)
+			}
+		}
+	}
+
+	result.Status = Applied
+	return result
+}
+
+func (e *Engine) plan(p Patch) (map[string][]byte, int, error) {
+	changes := map[string][]byte{}
+	total := 0
+
+	if p.kind() == Diff {
+		fileDiffs, err := parseUnifiedDiff(p.Diff)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, fd := range fileDiffs {
+			content, err := e.read(fd.path)
+			if err != nil {
+				return nil, 0, err
+			}
+			updated, err := fd.apply(string(content))
+			if err != nil {
+				return nil, 0, fmt.Errorf("%s: %s", fd.path, err)
+			}
+			changes[fd.path] = []byte(updated)
+			total += len(fd.hunks)
+		}
+		return changes, total, nil
+	}
+
+	targets, err := Glob(e.Root, p.Files)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var re *regexp.Regexp
+	if p.kind() == Regex {
+		re = regexp.MustCompile(p.Old)
+	}
+
+	for _, target := range targets {
+		contentBytes, err := e.read(target)
+		if err != nil {
+			return nil, 0, err
+		}
+		content := string(contentBytes)
+
+		var n int
+		var updated string
+		if re != nil {
+			n = len(re.FindAllStringIndex(content, -1))
+			updated = re.ReplaceAllString(content, p.New)
+		} else {
+			n = strings.Count(content, p.Old)
+			updated = strings.ReplaceAll(content, p.Old, p.New)
+		}
+
+		if n == 0 {
+			continue
+		}
+		changes[target] = []byte(updated)
+		total += n
+	}
+
+	return changes, total, nil
+}
+
+// target returns the path of relPath under Root. Only regular files that stay inside
+// Root are patched, a checkout can carry symlinks to anywhere.
+func (e *Engine) target(relPath string) (string, os.FileInfo, error) {
+	path := filepath.Join(e.Root, relPath)
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return "", nil, fmt.Errorf("%s is not a regular file", relPath)
+	}
+
+	root, err := filepath.EvalSymlinks(e.Root)
+	if err != nil {
+		return "", nil, err
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", nil, err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || !IsRelativePath(rel) {
+		return "", nil, fmt.Errorf("%s is outside of the repo", relPath)
+	}
+	return path, info, nil
+}
+
+func (e *Engine) read(relPath string) ([]byte, error) {
+	path, _, err := e.target(relPath)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// writeFile replaces the contents of relPath without following a symlink put in its place
+func (e *Engine) writeFile(relPath string, content []byte) error {
+	path, info, err := e.target(relPath)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC|syscall.O_NOFOLLOW, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (e *Engine) write(relPath string, content []byte) error {
+	if _, ok := e.originals[relPath]; !ok {
+		original, err := e.read(relPath)
+		if err != nil {
+			return err
+		}
+		e.originals[relPath] = original
+	}
+
+	log.Printf("patching %s", relPath)
+	return e.writeFile(relPath, content)
+}
+
+// Revert restores every file the engine changed
+func (e *Engine) Revert() error {
+	var errs []error
+	for relPath, original := range e.originals {
+		err := e.writeFile(relPath, original)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delete(e.originals, relPath)
+	}
+	return errors.Join(errs...)
+}
+
+func (r Result) String() string {
+	msg := fmt.Sprintf("patch %q %s", r.Patch.Label(), r.Status)
+	if r.Status == Applied {
+		msg += fmt.Sprintf(" (%d matches in %d files)", r.Matches, len(r.Files))
+	}
+	if r.Err != nil {
+		msg += ": " + r.Err.Error()
+	}
+	return msg
+}
+
+func (r *Report) Counts() (applied int, skipped int, failed int) {
+	for _, result := range r.Results {
+		switch result.Status {
+		case Applied:
+			applied++
+		case Skipped:
+			skipped++
+		case Failed:
+			failed++
+		}
+	}
+	return applied, skipped, failed
+}
+
+func (r *Report) Summary() string {
+	applied, skipped, failed := r.Counts()
+	prefix := "Patches"
+	if r.DryRun {
+		prefix = "Patches (dry run)"
+	}
+	return fmt.Sprintf("%s: %d applied, %d skipped, %d failed", prefix, applied, skipped, failed)
+}
+
+func (r *Report) Err() error {
+	var errs []error
+	for _, result := range r.Results {
+		if result.Status == Failed {
+			errs = append(errs, fmt.Errorf("patch %q: %s", result.Patch.Label(), result.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func IsRelativePath(path string) bool {
+	if filepath.IsAbs(path) {
+		return false
+	}
+	clean := filepath.Clean(path)
+	return clean != ".." && !strings.HasPrefix(clean, "../")
+}
@@ -0,0 +1,285 @@
+package patch
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func setupRoot(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	files := map[string]string{
+		"docs/conf.py":            "version = defs[\"version\"]\nrelease = defs[\"version\"]\n",
+		"src/exts/a/subst.py":     "x = 1\ny = 2\nz = 3\n",
+		"src/exts/b/subst.py":     "x = 1\n",
+		"src/exts/b/untouched.py": "x = 1\n",
+	}
+	for path, contents := range files {
+		full := filepath.Join(root, path)
+		err := os.MkdirAll(filepath.Dir(full), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(full, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func readFile(t *testing.T, root string, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(root, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestApply(t *testing.T) {
+	var tests = []struct {
+		name    string
+		patch   Patch
+		status  Status
+		matches int
+		files   []string
+	}{
+		{
+			"literal replaces every match",
+			Patch{Files: "docs/conf.py", Old: `defs["version"]`, New: `defs.get("version")`},
+			Applied, 2, []string{"docs/conf.py"},
+		},
+		{
+			"expected count mismatch fails",
+			Patch{Files: "docs/conf.py", Old: `defs["version"]`, New: "v", Count: 1},
+			Failed, 2, []string{"docs/conf.py"},
+		},
+		{
+			"no match fails",
+			Patch{Files: "docs/conf.py", Old: "missing", New: "v"},
+			Failed, 0, []string{},
+		},
+		{
+			"optional no match is skipped",
+			Patch{Files: "docs/conf.py", Old: "missing", New: "v", Optional: true},
+			Skipped, 0, []string{},
+		},
+		{
+			"missing optional file is skipped",
+			Patch{Files: "docs/other.py", Old: "missing", New: "v", Optional: true},
+			Skipped, 0, []string{},
+		},
+		{
+			"regex with glob across directories",
+			Patch{Type: Regex, Files: "src/**/subst.py", Old: `(?m)^x = (\d)$`, New: "x = ${1}0"},
+			Applied, 2, []string{"src/exts/a/subst.py", "src/exts/b/subst.py"},
+		},
+		{
+			"unified diff",
+			Patch{Type: Diff, Diff: `--- a/src/exts/a/subst.py
++++ b/src/exts/a/subst.py
+@@ -1,3 +1,3 @@
+ x = 1
+-y = 2
++y = 20
+ z = 3
+`},
+			Applied, 1, []string{"src/exts/a/subst.py"},
+		},
+		{
+			"diff that does not apply fails",
+			Patch{Type: Diff, Diff: `--- a/src/exts/a/subst.py
++++ b/src/exts/a/subst.py
+@@ -1,2 +1,2 @@
+-nope
++yes
+`},
+			Failed, 0, nil,
+		},
+		{
+			"diff hunk longer than its header fails",
+			Patch{Type: Diff, Diff: `--- a/src/exts/a/subst.py
++++ b/src/exts/a/subst.py
+@@ -1,1 +1,1 @@
+-x = 1
++x = 10
++y = 2
+`},
+			Failed, 0, nil,
+		},
+		{
+			"diff hunk shorter than its header fails",
+			Patch{Type: Diff, Diff: `--- a/src/exts/a/subst.py
++++ b/src/exts/a/subst.py
+@@ -1,3 +1,3 @@
+-x = 1
++x = 10
+`},
+			Failed, 0, nil,
+		},
+		{
+			"path outside the repo fails",
+			Patch{Files: "../conf.py", Old: "x"},
+			Failed, 0, nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := setupRoot(t)
+			result := NewEngine(root, false).Apply([]Patch{tt.patch}).Results[0]
+			if result.Status != tt.status {
+				t.Fatalf("expected %s, got %s (%v)", tt.status, result.Status, result.Err)
+			}
+			if result.Matches != tt.matches {
+				t.Errorf("expected %d matches, got %d", tt.matches, result.Matches)
+			}
+			if tt.files != nil && !reflect.DeepEqual(result.Files, tt.files) {
+				t.Errorf("expected files %v, got %v", tt.files, result.Files)
+			}
+		})
+	}
+}
+
+func TestApplyDiffContents(t *testing.T) {
+	root := setupRoot(t)
+	report := NewEngine(root, false).Apply([]Patch{{Type: Diff, Diff: `diff --git a/src/exts/a/subst.py b/src/exts/a/subst.py
+--- a/src/exts/a/subst.py
++++ b/src/exts/a/subst.py
+@@ -2,2 +2,3 @@
+ y = 2
++w = 4
+ z = 3
+`}})
+	if report.Err() != nil {
+		t.Fatal(report.Err())
+	}
+
+	expected := "x = 1\ny = 2\nw = 4\nz = 3\n"
+	if got := readFile(t, root, "src/exts/a/subst.py"); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestApplyDiffHeaderLikeLines(t *testing.T) {
+	root := setupRoot(t)
+	err := os.WriteFile(filepath.Join(root, "docs/query.sql"), []byte("-- old\nselect 1\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the removed and added lines read as "--- " and "+++ " headers on their own
+	report := NewEngine(root, false).Apply([]Patch{{Type: Diff, Diff: `--- a/docs/query.sql
++++ b/docs/query.sql
+@@ -1,2 +1,2 @@
+--- old
++++ new
+ select 1
+`}})
+	if report.Err() != nil {
+		t.Fatal(report.Err())
+	}
+
+	expected := "++ new\nselect 1\n"
+	if got := readFile(t, root, "docs/query.sql"); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestDryRunAndRevert(t *testing.T) {
+	root := setupRoot(t)
+	original := readFile(t, root, "docs/conf.py")
+	patches := []Patch{{Files: "docs/*.py", Old: "version", New: "release"}}
+
+	report := NewEngine(root, true).Apply(patches)
+	if report.Err() != nil {
+		t.Fatal(report.Err())
+	}
+	if readFile(t, root, "docs/conf.py") != original {
+		t.Errorf("dry run should not modify files")
+	}
+
+	engine := NewEngine(root, false)
+	report = engine.Apply(patches)
+	if report.Err() != nil {
+		t.Fatal(report.Err())
+	}
+	if readFile(t, root, "docs/conf.py") == original {
+		t.Errorf("patch should modify files")
+	}
+
+	err := engine.Revert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readFile(t, root, "docs/conf.py") != original {
+		t.Errorf("revert should restore the original contents")
+	}
+}
+
+func TestSymlinksAreNotPatched(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "secret.py")
+	err := os.WriteFile(outside, []byte("x = 1\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name   string
+		link   string
+		target string
+		patch  Patch
+	}{
+		{"literal on a symlink", "docs/link.py", outside, Patch{Files: "docs/link.py", Old: "x", New: "y"}},
+		{"glob matching a symlink", "docs/link.py", outside, Patch{Files: "docs/*.py", Old: "x", New: "y"}},
+		{"diff on a symlink", "docs/link.py", outside, Patch{Type: Diff, Diff: `--- a/docs/link.py
++++ b/docs/link.py
+@@ -1 +1 @@
+-x = 1
++y = 1
+`}},
+		{"file under a symlinked directory", "docs/linked", filepath.Dir(outside), Patch{Files: "docs/linked/secret.py", Old: "x", New: "y"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := setupRoot(t)
+			err := os.Symlink(tt.target, filepath.Join(root, tt.link))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result := NewEngine(root, false).Apply([]Patch{tt.patch}).Results[0]
+			if result.Status != Failed {
+				t.Errorf("expected %s, got %s", Failed, result.Status)
+			}
+			if got := readFile(t, filepath.Dir(outside), "secret.py"); got != "x = 1\n" {
+				t.Errorf("file outside the repo was changed to %q", got)
+			}
+		})
+	}
+
+	// a file swapped for a symlink after patching is not followed on revert
+	root := setupRoot(t)
+	engine := NewEngine(root, false)
+	report := engine.Apply([]Patch{{Files: "docs/conf.py", Old: "version", New: "release"}})
+	if report.Err() != nil {
+		t.Fatal(report.Err())
+	}
+	path := filepath.Join(root, "docs/conf.py")
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, path); err != nil {
+		t.Fatal(err)
+	}
+	if engine.Revert() == nil {
+		t.Errorf("expected revert through a symlink to fail")
+	}
+	if got := readFile(t, filepath.Dir(outside), "secret.py"); got != "x = 1\n" {
+		t.Errorf("file outside the repo was changed to %q", got)
+	}
+}
@@ -0,0 +1,184 @@
+package pdf
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+const highlightWidth = 6
+
+// HighlightPages frames every page in notes and attaches its note as the frame's popup
+// text. A bookmark titled title lists the highlighted pages after the existing outline.
+func HighlightPages(path string, notes map[int]string, title string) error {
+	ctx, err := readContext(path, newConfiguration(model.VALIDATE))
+	if err != nil {
+		return err
+	}
+	ctx.EnsureVersionForWriting()
+
+	dims, err := ctx.PageDims()
+	if err != nil {
+		return err
+	}
+
+	var pages []int
+	for page := range notes {
+		if page >= 1 && page <= ctx.PageCount {
+			pages = append(pages, page)
+		}
+	}
+	sort.Ints(pages)
+
+	for _, page := range pages {
+		d, _, _, err := ctx.PageDict(page, false)
+		if err != nil {
+			return err
+		}
+		contents, err := types.EscapedUTF16String(notes[page])
+		if err != nil {
+			return err
+		}
+		dim := dims[page-1]
+		inset := float64(highlightWidth) / 2
+		annot := types.Dict(map[string]types.Object{
+			"Type":     types.Name("Annot"),
+			"Subtype":  types.Name("Square"),
+			"Rect":     types.NewNumberArray(inset, inset, dim.Width-inset, dim.Height-inset),
+			"C":        types.NewNumberArray(1, 0.55, 0),
+			"BS":       types.Dict(map[string]types.Object{"W": types.Integer(highlightWidth)}),
+			"Contents": types.StringLiteral(*contents),
+			"T":        types.StringLiteral("pdfgen"),
+			// print the frame too
+			"F": types.Integer(4),
+		})
+		ir, err := ctx.IndRefForNewObject(annot)
+		if err != nil {
+			return err
+		}
+		annots, _ := ctx.DereferenceArray(d["Annots"])
+		d["Annots"] = append(annots, *ir)
+	}
+
+	if len(pages) > 0 {
+		err = appendOutlineItem(ctx, title, pages)
+		if err != nil {
+			return fmt.Errorf("error adding outline: %s", err)
+		}
+	}
+
+	return api.WriteContextFile(ctx, path)
+}
+
+func outlineTitle(title string) (types.StringLiteral, error) {
+	s, err := types.EscapedUTF16String(title)
+	if err != nil {
+		return "", err
+	}
+	return types.StringLiteral(*s), nil
+}
+
+func pageDest(ctx *model.Context, page int) (types.Array, error) {
+	_, ref, _, err := ctx.PageDict(page, false)
+	if err != nil {
+		return nil, err
+	}
+	return types.Array{*ref, types.Name("Fit")}, nil
+}
+
+// appendOutlineItem adds a closed top-level bookmark with one child per page after the
+// existing outline. pdfcpu only writes outlines in page order, so the items are built here.
+func appendOutlineItem(ctx *model.Context, title string, pages []int) error {
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+
+	outlines, err := ctx.DereferenceDict(root["Outlines"])
+	if err != nil {
+		return err
+	}
+	var outlinesRef *types.IndirectRef
+	if outlines == nil {
+		outlines = types.Dict(map[string]types.Object{"Type": types.Name("Outlines")})
+		outlinesRef, err = ctx.IndRefForNewObject(outlines)
+		if err != nil {
+			return err
+		}
+		root["Outlines"] = *outlinesRef
+	} else {
+		ref, ok := root["Outlines"].(types.IndirectRef)
+		if !ok {
+			return fmt.Errorf("outlines must be an indirect object")
+		}
+		outlinesRef = &ref
+	}
+
+	itemTitle, err := outlineTitle(title)
+	if err != nil {
+		return err
+	}
+	dest, err := pageDest(ctx, pages[0])
+	if err != nil {
+		return err
+	}
+	item := types.Dict(map[string]types.Object{
+		"Title":  itemTitle,
+		"Parent": *outlinesRef,
+		"Dest":   dest,
+		// closed, with this many hidden children
+		"Count": types.Integer(-len(pages)),
+	})
+	itemRef, err := ctx.IndRefForNewObject(item)
+	if err != nil {
+		return err
+	}
+
+	var prev types.Dict
+	var prevRef *types.IndirectRef
+	for _, page := range pages {
+		kidTitle, err := outlineTitle(fmt.Sprintf("Page %d", page))
+		if err != nil {
+			return err
+		}
+		dest, err := pageDest(ctx, page)
+		if err != nil {
+			return err
+		}
+		kid := types.Dict(map[string]types.Object{"Title": kidTitle, "Parent": *itemRef, "Dest": dest})
+		kidRef, err := ctx.IndRefForNewObject(kid)
+		if err != nil {
+			return err
+		}
+		if prev == nil {
+			item["First"] = *kidRef
+		} else {
+			prev["Next"] = *kidRef
+			kid["Prev"] = *prevRef
+		}
+		prev, prevRef = kid, kidRef
+	}
+	item["Last"] = *prevRef
+
+	if last, ok := outlines["Last"].(types.IndirectRef); ok {
+		lastItem, err := ctx.DereferenceDict(last)
+		if err != nil {
+			return err
+		}
+		lastItem["Next"] = *itemRef
+		item["Prev"] = last
+	} else {
+		outlines["First"] = *itemRef
+	}
+	outlines["Last"] = *itemRef
+
+	count := 0
+	if c := outlines.IntEntry("Count"); c != nil && *c > 0 {
+		count = *c
+	}
+	outlines["Count"] = types.Integer(count + 1)
+	return nil
+}
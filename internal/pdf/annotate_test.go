@@ -0,0 +1,72 @@
+package pdf
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+func TestHighlightPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doc.pdf")
+	writeTestPDF(t, path, 4)
+	err := Finalize(path, Metadata{}, Structure{Headings: []Heading{
+		{Title: "1 Install", Level: 1, Label: "1"},
+		{Title: "2 Usage", Level: 1, Label: "3"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = HighlightPages(path, map[int]string{2: "3 new lines", 4: "changed (since v1)", 9: "past the end"}, "Changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := readContext(path, newConfiguration(model.VALIDATE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for page, expected := range map[int]int{1: 0, 2: 1, 3: 0, 4: 1} {
+		d, _, _, err := ctx.PageDict(page, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		annots, _ := ctx.DereferenceArray(d["Annots"])
+		if len(annots) != expected {
+			t.Errorf("page %d: expected %d annotations, got %d", page, expected, len(annots))
+		}
+		if expected == 0 {
+			continue
+		}
+		annot, err := ctx.DereferenceDict(annots[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := types.StringOrHexLiteral(annot["Contents"])
+		if err != nil || contents == nil || (*contents != "3 new lines" && *contents != "changed (since v1)") {
+			t.Errorf("page %d: unexpected contents %v %v", page, contents, err)
+		}
+	}
+
+	outline, err := Outline(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []OutlineEntry{
+		{"1 Install", 1, 1},
+		{"2 Usage", 1, 3},
+		{"Changes", 1, 2},
+		{"Page 2", 2, 2},
+		{"Page 4", 2, 4},
+	}
+	if len(outline) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, outline)
+	}
+	for i := range expected {
+		if outline[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], outline[i])
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package pdf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+const (
+	contentsTitle   = "Contents"
+	contentsPerPage = 40
+	contentsMargin  = 72.0
+	contentsLeading = 16.0
+	contentsSize    = 11.0
+	contentsIndent  = 18.0
+	// Helvetica digits are 556/1000 em wide and the hyphen 333/1000, enough for page labels
+	digitWidth  = 0.556
+	hyphenWidth = 0.333
+	maxTitleLen = 80
+)
+
+// contentsEntry is one line of the combined table of contents
+type contentsEntry struct {
+	Title string
+	Level int
+	Page  int
+	Label string
+}
+
+// Bind merges parts behind a combined table of contents listing every part and its
+// top-level bookmarks. Page labels continue through the parts, or restart at 1 for every
+// part with the part number as prefix, e.g. 2-14.
+func Bind(parts []Part, outPath string, restartNumbering bool) error {
+	if len(parts) == 0 {
+		return fmt.Errorf("nothing to bind")
+	}
+
+	var entries []contentsEntry
+	starts := make([]int, len(parts))
+	page := 0
+	for i, part := range parts {
+		ctx, err := readContext(part.Path, newConfiguration(model.VALIDATE))
+		if err != nil {
+			return err
+		}
+		starts[i] = page + 1
+
+		entries = append(entries, contentsEntry{Title: part.Title, Level: 0, Page: starts[i]})
+		bookmarks, _ := pdfcpu.Bookmarks(ctx)
+		for _, bm := range bookmarks {
+			if bm.PageFrom < 1 || bm.PageFrom > ctx.PageCount {
+				continue
+			}
+			entries = append(entries, contentsEntry{Title: bm.Title, Level: 1, Page: page + bm.PageFrom})
+		}
+		page += ctx.PageCount
+	}
+
+	contentsPages := (len(entries) + contentsPerPage - 1) / contentsPerPage
+	for i := range entries {
+		entries[i].Page += contentsPages
+		entries[i].Label = bodyLabel(entries[i].Page, contentsPages, starts, restartNumbering)
+	}
+
+	width, height, err := firstPageSize(parts[0].Path)
+	if err != nil {
+		return err
+	}
+	contentsPath := filepath.Join(filepath.Dir(outPath), strings.TrimSuffix(filepath.Base(outPath), ".pdf")+".contents.pdf")
+	err = os.WriteFile(contentsPath, contentsPDF(entries, width, height), 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(contentsPath)
+
+	err = Merge(append([]Part{{Path: contentsPath, Title: contentsTitle}}, parts...), outPath)
+	if err != nil {
+		return err
+	}
+
+	ctx, err := readContext(outPath, newConfiguration(model.VALIDATE))
+	if err != nil {
+		return err
+	}
+	err = linkContents(ctx, entries, width, height)
+	if err != nil {
+		return fmt.Errorf("error linking contents: %s", err)
+	}
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+	root["PageLabels"] = bindPageLabels(contentsPages, starts, restartNumbering)
+	return api.WriteContextFile(ctx, outPath)
+}
+
+// bodyLabel is the page label of a physical page after the contents
+func bodyLabel(page int, contentsPages int, starts []int, restart bool) string {
+	bodyPage := page - contentsPages
+	if !restart {
+		return fmt.Sprint(bodyPage)
+	}
+	part := 0
+	for i, start := range starts {
+		if bodyPage >= start {
+			part = i
+		}
+	}
+	return fmt.Sprintf("%d-%d", part+1, bodyPage-starts[part]+1)
+}
+
+func bindPageLabels(contentsPages int, starts []int, restart bool) types.Dict {
+	nums := types.Array{types.Integer(0), types.Dict(map[string]types.Object{"S": types.Name("r")})}
+	if !restart {
+		nums = append(nums, types.Integer(contentsPages), types.Dict(map[string]types.Object{"S": types.Name("D")}))
+	} else {
+		for i, start := range starts {
+			nums = append(nums, types.Integer(contentsPages+start-1), types.Dict(map[string]types.Object{
+				"S": types.Name("D"),
+				"P": types.StringLiteral(fmt.Sprintf("%d-", i+1)),
+			}))
+		}
+	}
+	return types.Dict(map[string]types.Object{"Nums": nums})
+}
+
+func firstPageSize(path string) (float64, float64, error) {
+	ctx, err := readContext(path, newConfiguration(model.VALIDATE))
+	if err != nil {
+		return 0, 0, err
+	}
+	dims, err := ctx.PageDims()
+	if err != nil || len(dims) == 0 {
+		return 0, 0, fmt.Errorf("error reading page size of %s: %v", path, err)
+	}
+	return dims[0].Width, dims[0].Height, nil
+}
+
+// pdfText encodes s as a WinAnsi string literal, characters outside Latin-1 become ?
+func pdfText(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20:
+			b.WriteByte(' ')
+		case r < 0x7f || (r >= 0xa0 && r <= 0xff):
+			b.WriteByte(byte(r))
+		default:
+			b.WriteByte('?')
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+func labelWidth(label string) float64 {
+	w := 0.0
+	for _, r := range label {
+		if r == '-' {
+			w += hyphenWidth
+		} else {
+			w += digitWidth
+		}
+	}
+	return w * contentsSize
+}
+
+// entryY is the baseline of the i-th line on its contents page
+func entryY(i int, height float64) float64 {
+	return height - contentsMargin - 2*contentsLeading - float64(i%contentsPerPage)*contentsLeading
+}
+
+// contentsPDF writes the table of contents as a standalone PDF with Helvetica text
+func contentsPDF(entries []contentsEntry, width float64, height float64) []byte {
+	var pages []string
+	for first := 0; first < len(entries); first += contentsPerPage {
+		var c strings.Builder
+		fmt.Fprintf(&c, "BT /F2 18 Tf %.2f %.2f Td %s Tj ET\n", contentsMargin, height-contentsMargin, pdfText(contentsTitle))
+		for i := first; i < len(entries) && i < first+contentsPerPage; i++ {
+			e := entries[i]
+			font := "/F1"
+			if e.Level == 0 {
+				font = "/F2"
+			}
+			title := e.Title
+			if len([]rune(title)) > maxTitleLen {
+				title = string([]rune(title)[:maxTitleLen-3]) + "..."
+			}
+			y := entryY(i, height)
+			fmt.Fprintf(&c, "BT %s %.0f Tf %.2f %.2f Td %s Tj ET\n",
+				font, contentsSize, contentsMargin+float64(e.Level)*contentsIndent, y, pdfText(title))
+			fmt.Fprintf(&c, "BT /F1 %.0f Tf %.2f %.2f Td %s Tj ET\n",
+				contentsSize, width-contentsMargin-labelWidth(e.Label), y, pdfText(e.Label))
+		}
+		pages = append(pages, c.String())
+	}
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+	}
+	var kids []string
+	for _, content := range pages {
+		pageObj := len(objects) + 1
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageObj))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Contents %d 0 R "+
+				"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> >>", width, height, pageObj+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		)
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+	return pdfFile(objects)
+}
+
+// pdfFile serializes objects numbered from 1, the first being the catalog
+func pdfFile(objects []string) []byte {
+	var b strings.Builder
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return []byte(b.String())
+}
+
+// linkContents makes every line of the contents a link to its page
+func linkContents(ctx *model.Context, entries []contentsEntry, width float64, height float64) error {
+	for i, e := range entries {
+		d, _, _, err := ctx.PageDict(i/contentsPerPage+1, false)
+		if err != nil {
+			return err
+		}
+		_, targetRef, _, err := ctx.PageDict(e.Page, false)
+		if err != nil {
+			return err
+		}
+
+		y := entryY(i, height)
+		annot := types.Dict(map[string]types.Object{
+			"Type":    types.Name("Annot"),
+			"Subtype": types.Name("Link"),
+			"Rect":    types.NewNumberArray(contentsMargin, y-3, width-contentsMargin, y+contentsSize),
+			"Border":  types.NewIntegerArray(0, 0, 0),
+			"Dest":    types.Array{*targetRef, types.Name("Fit")},
+		})
+		ir, err := ctx.IndRefForNewObject(annot)
+		if err != nil {
+			return err
+		}
+
+		annots, _ := ctx.DereferenceArray(d["Annots"])
+		d["Annots"] = append(annots, *ir)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package pdf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+func TestBind(t *testing.T) {
+	dir := t.TempDir()
+	core := filepath.Join(dir, "core.pdf")
+	providers := filepath.Join(dir, "providers.pdf")
+	writeTestPDF(t, core, 3)
+	writeTestPDF(t, providers, 2)
+	err := Finalize(core, Metadata{}, Structure{Headings: []Heading{
+		{Title: "1 Install", Level: 1, Label: "1"},
+		{Title: "2 Usage", Level: 1, Label: "2"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name    string
+		restart bool
+		labels  []string
+	}{
+		{"continuous", false, []string{"1", "1", "2", "4"}},
+		{"restart per part", true, []string{"1-1", "1-1", "1-2", "2-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bound := filepath.Join(t.TempDir(), "bundle.pdf")
+			err := Bind([]Part{{core, "Airflow"}, {providers, "Providers"}}, bound, tt.restart)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, err := readContext(bound, newConfiguration(model.VALIDATE))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// one contents page in front of 5 pages
+			if ctx.PageCount != 6 {
+				t.Fatalf("expected 6 pages, got %d", ctx.PageCount)
+			}
+
+			annots, err := pageAnnotations(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedPages := []int{2, 2, 3, 5}
+			if len(annots) != len(expectedPages) {
+				t.Fatalf("expected %d contents links, got %d", len(expectedPages), len(annots))
+			}
+			for i, annot := range annots {
+				if page := linkTarget(ctx, annot); page != expectedPages[i] {
+					t.Errorf("link %d: expected page %d, got %d", i, expectedPages[i], page)
+				}
+			}
+
+			root, err := ctx.Catalog()
+			if err != nil {
+				t.Fatal(err)
+			}
+			labels, err := ctx.DereferenceDict(root["PageLabels"])
+			if err != nil || labels == nil {
+				t.Fatalf("expected page labels: %v", err)
+			}
+			nums := labels.ArrayEntry("Nums")
+			if !tt.restart && (len(nums) != 4 || nums[2] != types.Integer(1)) {
+				t.Errorf("expected decimal labels from page 2, got %s", nums)
+			}
+			if tt.restart && (len(nums) != 6 || nums[4] != types.Integer(4)) {
+				t.Errorf("expected the second part's labels from page 5, got %s", nums)
+			}
+
+			f, err := os.Open(bound)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			bookmarks, err := api.Bookmarks(f, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(bookmarks) != 3 || bookmarks[0].Title != "Contents" || bookmarks[1].Title != "Airflow" || len(bookmarks[1].Kids) != 2 {
+				t.Errorf("unexpected outline %+v", bookmarks)
+			}
+		})
+	}
+}
+
+func TestBodyLabel(t *testing.T) {
+	starts := []int{1, 4}
+	var tests = []struct {
+		page     int
+		restart  bool
+		expected string
+	}{
+		{2, false, "1"},
+		{6, false, "5"},
+		{2, true, "1-1"},
+		{4, true, "1-3"},
+		{5, true, "2-1"},
+	}
+
+	for _, tt := range tests {
+		actual := bodyLabel(tt.page, 1, starts, tt.restart)
+		if actual != tt.expected {
+			t.Errorf("bodyLabel(%d, %v) = %q, expected %q", tt.page, tt.restart, actual, tt.expected)
+		}
+	}
+}
+
+func TestPDFText(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected string
+	}{
+		{"Install", "(Install)"},
+		{`a (b) \c`, `(a \(b\) \\c)`},
+		{"Überblick → API", "(\xdcberblick ? API)"},
+	}
+
+	for _, tt := range tests {
+		actual := pdfText(tt.input)
+		if actual != tt.expected {
+			t.Errorf("pdfText(%q) = %q, expected %q", tt.input, actual, tt.expected)
+		}
+	}
+}
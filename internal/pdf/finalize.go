@@ -0,0 +1,314 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Metadata is written to the Info dictionary and mirrored as XMP
+type Metadata struct {
+	Title     string
+	Author    string
+	Subject   string
+	Keywords  []string
+	Repo      string
+	Commit    string
+	Generator string
+	BuildTime time.Time
+}
+
+// Heading is a section the outline can point at. Dest names the destination the producer
+// wrote for it, Label is its printed page number and is used when Dest can't be resolved.
+type Heading struct {
+	Title string
+	Level int
+	Dest  string
+	Label string
+}
+
+// Structure describes the document's sections as known by the generator
+type Structure struct {
+	Headings []Heading
+	// FrontMatter is the number of pages before arabic page numbering starts
+	FrontMatter int
+	// Title is used for a single bookmark when nothing better is known
+	Title string
+}
+
+// custom Info dictionary keys, the same values are written to the pdfgen XMP namespace
+const (
+	InfoRepository = "Repository"
+	InfoCommit     = "Commit"
+	InfoGenerator  = "Generator"
+	InfoBuildTime  = "BuildTime"
+)
+
+// Finalize rewrites path in place with meta applied. Page labels and an outline are only
+// added when the producer did not write its own.
+func Finalize(path string, meta Metadata, structure Structure) error {
+	ctx, err := readContext(path, newConfiguration(model.VALIDATE))
+	if err != nil {
+		return err
+	}
+	ctx.EnsureVersionForWriting()
+
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+
+	frontMatter, hasLabels, err := existingFrontMatter(ctx, root)
+	if err != nil {
+		return err
+	}
+	if !hasLabels && structure.FrontMatter > 0 && structure.FrontMatter < ctx.PageCount {
+		frontMatter = structure.FrontMatter
+		err = setPageLabels(ctx, root, frontMatter)
+		if err != nil {
+			return fmt.Errorf("error setting page labels: %s", err)
+		}
+	}
+
+	if !hasOutline(ctx) {
+		bookmarks := outline(ctx, structure, frontMatter)
+		if len(bookmarks) > 0 {
+			err = pdfcpu.AddBookmarks(ctx, bookmarks, true)
+			if err != nil {
+				return fmt.Errorf("error adding outline: %s", err)
+			}
+		}
+	}
+
+	err = pdfcpu.PropertiesAdd(ctx, infoProperties(meta))
+	if err != nil {
+		return fmt.Errorf("error writing info dictionary: %s", err)
+	}
+
+	err = setXMP(ctx, root, meta)
+	if err != nil {
+		return fmt.Errorf("error writing xmp metadata: %s", err)
+	}
+
+	return api.WriteContextFile(ctx, path)
+}
+
+func infoProperties(meta Metadata) map[string]string {
+	props := map[string]string{
+		"Title":        meta.Title,
+		"Author":       meta.Author,
+		"Subject":      meta.Subject,
+		"Keywords":     strings.Join(meta.Keywords, ", "),
+		InfoRepository: meta.Repo,
+		InfoCommit:     meta.Commit,
+		InfoGenerator:  meta.Generator,
+	}
+	if !meta.BuildTime.IsZero() {
+		props[InfoBuildTime] = meta.BuildTime.UTC().Format(time.RFC3339)
+	}
+	for k, v := range props {
+		if v == "" {
+			delete(props, k)
+		}
+	}
+	return props
+}
+
+// existingFrontMatter reads the page index where decimal labels start from the
+// producer's own page labels, e.g. hyperref's
+func existingFrontMatter(ctx *model.Context, root types.Dict) (int, bool, error) {
+	o, found := root.Find("PageLabels")
+	if !found || o == nil {
+		return 0, false, nil
+	}
+	labels, err := ctx.DereferenceDict(o)
+	if err != nil || labels == nil {
+		return 0, false, err
+	}
+	nums, err := ctx.DereferenceArray(labels["Nums"])
+	if err != nil {
+		return 0, true, err
+	}
+
+	for i := 0; i+1 < len(nums); i += 2 {
+		start, ok := nums[i].(types.Integer)
+		if !ok {
+			continue
+		}
+		label, err := ctx.DereferenceDict(nums[i+1])
+		if err != nil || label == nil {
+			continue
+		}
+		if style := label.NameEntry("S"); style != nil && *style == "D" {
+			return start.Value(), true, nil
+		}
+	}
+	return 0, true, nil
+}
+
+func setPageLabels(ctx *model.Context, root types.Dict, frontMatter int) error {
+	labels := types.Dict(map[string]types.Object{
+		"Nums": types.Array{
+			types.Integer(0), types.Dict(map[string]types.Object{"S": types.Name("r")}),
+			types.Integer(frontMatter), types.Dict(map[string]types.Object{"S": types.Name("D")}),
+		},
+	})
+	ir, err := ctx.IndRefForNewObject(labels)
+	if err != nil {
+		return err
+	}
+	root["PageLabels"] = *ir
+	return nil
+}
+
+func hasOutline(ctx *model.Context) bool {
+	bookmarks, err := pdfcpu.Bookmarks(ctx)
+	return err == nil && len(bookmarks) > 0
+}
+
+// outline nests the resolvable headings by level, falling back to a single bookmark
+// for the whole document
+func outline(ctx *model.Context, structure Structure, frontMatter int) []pdfcpu.Bookmark {
+	type entry struct {
+		bookmark pdfcpu.Bookmark
+		level    int
+	}
+
+	// build children before parents so the nested slices are complete when appended
+	var stack []entry
+	var top []pdfcpu.Bookmark
+	pop := func() {
+		last := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if len(stack) == 0 {
+			top = append(top, last.bookmark)
+			return
+		}
+		parent := &stack[len(stack)-1].bookmark
+		parent.Kids = append(parent.Kids, last.bookmark)
+	}
+
+	for _, heading := range structure.Headings {
+		page := headingPage(ctx, heading, frontMatter)
+		if page < 1 || page > ctx.PageCount {
+			continue
+		}
+		for len(stack) > 0 && stack[len(stack)-1].level >= heading.Level {
+			pop()
+		}
+		stack = append(stack, entry{
+			bookmark: pdfcpu.Bookmark{Title: heading.Title, PageFrom: page},
+			level:    heading.Level,
+		})
+	}
+	for len(stack) > 0 {
+		pop()
+	}
+
+	if len(top) == 0 && structure.Title != "" && ctx.PageCount > 0 {
+		top = []pdfcpu.Bookmark{{Title: structure.Title, PageFrom: 1}}
+	}
+	return top
+}
+
+// headingPage returns the 1-based physical page of heading, 0 if unknown
+func headingPage(ctx *model.Context, heading Heading, frontMatter int) int {
+	if heading.Dest != "" {
+		if page := namedDestPage(ctx, heading.Dest); page > 0 {
+			return page
+		}
+	}
+
+	n, err := strconv.Atoi(heading.Label)
+	if err != nil {
+		// roman labels are front matter which the outline skips
+		return 0
+	}
+	return frontMatter + n
+}
+
+func setXMP(ctx *model.Context, root types.Dict, meta Metadata) error {
+	sd := &types.StreamDict{Dict: types.NewDict(), Content: xmpPacket(meta)}
+	sd.InsertName("Type", "Metadata")
+	sd.InsertName("Subtype", "XML")
+	err := sd.Encode()
+	if err != nil {
+		return err
+	}
+
+	ir, err := ctx.IndRefForNewObject(*sd)
+	if err != nil {
+		return err
+	}
+	root["Metadata"] = *ir
+	return nil
+}
+
+func xmlText(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// xmpPacket renders the metadata as an XMP packet with Dublin Core, PDF, XMP basic,
+// and pdfgen properties
+func xmpPacket(meta Metadata) []byte {
+	var b strings.Builder
+	b.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/">` + "\n")
+	b.WriteString(`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` + "\n")
+	b.WriteString(`<rdf:Description rdf:about=""` +
+		` xmlns:dc="http://purl.org/dc/elements/1.1/"` +
+		` xmlns:pdf="http://ns.adobe.com/pdf/1.3/"` +
+		` xmlns:xmp="http://ns.adobe.com/xap/1.0/"` +
+		` xmlns:pdfgen="https://github.com/jeffbrennan/pdfgen/ns/1.0/">` + "\n")
+
+	if meta.Title != "" {
+		fmt.Fprintf(&b, "<dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:title>\n", xmlText(meta.Title))
+	}
+	if meta.Author != "" {
+		fmt.Fprintf(&b, "<dc:creator><rdf:Seq><rdf:li>%s</rdf:li></rdf:Seq></dc:creator>\n", xmlText(meta.Author))
+	}
+	if meta.Subject != "" {
+		fmt.Fprintf(&b, "<dc:description><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:description>\n", xmlText(meta.Subject))
+	}
+	if meta.Repo != "" {
+		fmt.Fprintf(&b, "<dc:source>%s</dc:source>\n", xmlText(meta.Repo))
+	}
+	if len(meta.Keywords) > 0 {
+		b.WriteString("<dc:subject><rdf:Bag>")
+		for _, keyword := range meta.Keywords {
+			fmt.Fprintf(&b, "<rdf:li>%s</rdf:li>", xmlText(keyword))
+		}
+		b.WriteString("</rdf:Bag></dc:subject>\n")
+		fmt.Fprintf(&b, "<pdf:Keywords>%s</pdf:Keywords>\n", xmlText(strings.Join(meta.Keywords, ", ")))
+	}
+	if meta.Generator != "" {
+		fmt.Fprintf(&b, "<xmp:CreatorTool>%s</xmp:CreatorTool>\n", xmlText(meta.Generator))
+		fmt.Fprintf(&b, "<pdfgen:generator>%s</pdfgen:generator>\n", xmlText(meta.Generator))
+	}
+	if !meta.BuildTime.IsZero() {
+		buildTime := meta.BuildTime.UTC().Format(time.RFC3339)
+		fmt.Fprintf(&b, "<xmp:CreateDate>%s</xmp:CreateDate>\n", buildTime)
+		fmt.Fprintf(&b, "<xmp:MetadataDate>%s</xmp:MetadataDate>\n", buildTime)
+		fmt.Fprintf(&b, "<pdfgen:buildTime>%s</pdfgen:buildTime>\n", buildTime)
+	}
+	if meta.Repo != "" {
+		fmt.Fprintf(&b, "<pdfgen:repository>%s</pdfgen:repository>\n", xmlText(meta.Repo))
+	}
+	if meta.Commit != "" {
+		fmt.Fprintf(&b, "<pdfgen:commit>%s</pdfgen:commit>\n", xmlText(meta.Commit))
+	}
+
+	b.WriteString("</rdf:Description>\n</rdf:RDF>\n</x:xmpmeta>\n")
+	b.WriteString(`<?xpacket end="w"?>`)
+	return []byte(b.String())
+}
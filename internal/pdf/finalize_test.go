@@ -0,0 +1,151 @@
+package pdf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+func TestFinalize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doc.pdf")
+	writeTestPDF(t, path, 5)
+
+	meta := Metadata{
+		Title:     "Airflow Documentation",
+		Author:    "Apache Software Foundation",
+		Subject:   "Documentation for apache/airflow",
+		Keywords:  []string{"apache", "airflow"},
+		Repo:      "https://github.com/apache/airflow",
+		Commit:    "0123456789abcdef",
+		Generator: "pdfgen sphinx/latex",
+		BuildTime: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	structure := Structure{
+		FrontMatter: 2,
+		Headings: []Heading{
+			{Title: "Contents", Level: 1, Label: "i"},
+			{Title: "1 Install", Level: 1, Label: "1"},
+			{Title: "1.1 pip", Level: 2, Label: "2"},
+			{Title: "2 Usage", Level: 1, Label: "3"},
+			{Title: "Appendix", Level: 1, Label: "9"},
+		},
+	}
+
+	err := Finalize(path, meta, structure)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := readContext(path, newConfiguration(model.VALIDATE))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("info", func(t *testing.T) {
+		expected := map[string]string{
+			InfoRepository: meta.Repo,
+			InfoCommit:     meta.Commit,
+			InfoGenerator:  meta.Generator,
+			InfoBuildTime:  "2024-05-01T12:00:00Z",
+		}
+		for k, v := range expected {
+			if ctx.Properties[k] != v {
+				t.Errorf("expected %s=%q, got %q", k, v, ctx.Properties[k])
+			}
+		}
+		if ctx.Title != meta.Title || ctx.Author != meta.Author {
+			t.Errorf("unexpected title/author %q/%q", ctx.Title, ctx.Author)
+		}
+	})
+
+	t.Run("xmp", func(t *testing.T) {
+		root, err := ctx.Catalog()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sd, _, err := ctx.DereferenceStreamDict(root["Metadata"])
+		if err != nil || sd == nil {
+			t.Fatalf("no metadata stream: %v", err)
+		}
+		err = sd.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range []string{"<pdfgen:commit>0123456789abcdef</pdfgen:commit>", "Airflow Documentation", "<xmp:CreateDate>2024-05-01T12:00:00Z"} {
+			if !strings.Contains(string(sd.Content), s) {
+				t.Errorf("expected %q in xmp", s)
+			}
+		}
+	})
+
+	t.Run("page labels", func(t *testing.T) {
+		root, err := ctx.Catalog()
+		if err != nil {
+			t.Fatal(err)
+		}
+		frontMatter, ok, err := existingFrontMatter(ctx, root)
+		if err != nil || !ok || frontMatter != 2 {
+			t.Errorf("expected labels with 2 front matter pages, got %d %v %v", frontMatter, ok, err)
+		}
+	})
+
+	t.Run("outline", func(t *testing.T) {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		bookmarks, err := api.Bookmarks(f, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the roman entry and the entry past the last page are dropped
+		if len(bookmarks) != 2 {
+			t.Fatalf("expected 2 top-level bookmarks, got %+v", bookmarks)
+		}
+		if bookmarks[0].Title != "1 Install" || bookmarks[0].PageFrom != 3 {
+			t.Errorf("unexpected first bookmark: %+v", bookmarks[0])
+		}
+		if len(bookmarks[0].Kids) != 1 || bookmarks[0].Kids[0].PageFrom != 4 {
+			t.Errorf("unexpected children: %+v", bookmarks[0].Kids)
+		}
+		if bookmarks[1].Title != "2 Usage" || bookmarks[1].PageFrom != 5 {
+			t.Errorf("unexpected second bookmark: %+v", bookmarks[1])
+		}
+	})
+}
+
+func TestFinalizeKeepsOutline(t *testing.T) {
+	dir := t.TempDir()
+	guide := filepath.Join(dir, "guide.pdf")
+	merged := filepath.Join(dir, "merged.pdf")
+	writeTestPDF(t, guide, 2)
+	err := Merge([]Part{{guide, "User Guide"}, {guide, "Again"}}, merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Finalize(merged, Metadata{Title: "Merged"}, Structure{Title: "Merged"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	bookmarks, err := api.Bookmarks(f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bookmarks) != 2 || bookmarks[0].Title != "User Guide" {
+		t.Errorf("expected the merge outline to be kept, got %+v", bookmarks)
+	}
+}
@@ -0,0 +1,91 @@
+package pdf
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+func init() {
+	// keep pdfcpu from writing its config into the server user's home
+	api.DisableConfigDir()
+}
+
+// Part is one input of a merge, shown in the outline under Title
+type Part struct {
+	Path  string
+	Title string
+}
+
+func newConfiguration(cmd model.CommandMode) *model.Configuration {
+	conf := model.NewDefaultConfiguration()
+	conf.Cmd = cmd
+	conf.ValidationMode = model.ValidationRelaxed
+	return conf
+}
+
+func readContext(path string, conf *model.Configuration) (*model.Context, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ctx, err := api.ReadAndValidate(f, conf)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", path, err)
+	}
+	return ctx, nil
+}
+
+// Merge concatenates parts into outPath. Every part gets a top-level bookmark with its
+// title and keeps its own outline nested underneath.
+func Merge(parts []Part, outPath string) error {
+	if len(parts) == 0 {
+		return fmt.Errorf("nothing to merge")
+	}
+
+	conf := newConfiguration(model.MERGECREATE)
+	conf.CreateBookmarks = true
+
+	ctxDest, err := readContext(parts[0].Path, conf)
+	if err != nil {
+		return err
+	}
+
+	err = pdfcpu.EnsureOutlines(ctxDest, parts[0].Title, false)
+	if err != nil {
+		return err
+	}
+	ctxDest.EnsureVersionForWriting()
+
+	for _, part := range parts[1:] {
+		ctxSrc, err := readContext(part.Path, conf)
+		if err != nil {
+			return err
+		}
+
+		err = pdfcpu.MergeXRefTables(part.Title, ctxSrc, ctxDest, false, false)
+		if err != nil {
+			return fmt.Errorf("error merging %s: %s", part.Path, err)
+		}
+	}
+
+	err = api.OptimizeContext(ctxDest)
+	if err != nil {
+		return err
+	}
+
+	return api.WriteContextFile(ctxDest, outPath)
+}
+
+func PageCount(path string) (int, error) {
+	ctx, err := readContext(path, newConfiguration(model.VALIDATE))
+	if err != nil {
+		return 0, err
+	}
+	return ctx.PageCount, nil
+}
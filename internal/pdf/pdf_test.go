@@ -0,0 +1,81 @@
+package pdf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// writeTestPDF writes a minimal PDF with one line of text per page
+func writeTestPDF(t *testing.T, path string, pages int) {
+	t.Helper()
+
+	var objects []string
+	kids := ""
+	for i := 0; i < pages; i++ {
+		pageObj := 4 + i*2
+		kids += fmt.Sprintf("%d 0 R ", pageObj)
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids, pages),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	)
+	for i := 0; i < pages; i++ {
+		content := fmt.Sprintf("BT /F1 12 Tf 72 720 Td (Page %d) Tj ET", i+1)
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents %d 0 R /Resources << /Font << /F1 3 0 R >> >> >>", 5+i*2),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		)
+	}
+
+	err := os.WriteFile(path, pdfFile(objects), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	guide := filepath.Join(dir, "guide.pdf")
+	reference := filepath.Join(dir, "reference.pdf")
+	merged := filepath.Join(dir, "merged.pdf")
+	writeTestPDF(t, guide, 2)
+	writeTestPDF(t, reference, 3)
+
+	err := Merge([]Part{{guide, "User Guide"}, {reference, "API Reference"}}, merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pages, err := PageCount(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages != 5 {
+		t.Errorf("expected 5 pages, got %d", pages)
+	}
+
+	f, err := os.Open(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	bookmarks, err := api.Bookmarks(f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bookmarks) != 2 {
+		t.Fatalf("expected 2 top-level bookmarks, got %d", len(bookmarks))
+	}
+	if bookmarks[0].Title != "User Guide" || bookmarks[0].PageFrom != 1 {
+		t.Errorf("unexpected first bookmark: %+v", bookmarks[0])
+	}
+	if bookmarks[1].Title != "API Reference" || bookmarks[1].PageFrom != 3 {
+		t.Errorf("unexpected second bookmark: %+v", bookmarks[1])
+	}
+}
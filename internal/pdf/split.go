@@ -0,0 +1,336 @@
+package pdf
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// localTargetKey marks a link whose target stays in the same chapter until the chapter's
+// own page objects exist
+const localTargetKey = "PdfgenLocalPage"
+
+// Chapter is one file of a split, covering pages From through Thru of the source
+type Chapter struct {
+	Number string
+	Title  string
+	File   string
+	From   int
+	Thru   int
+
+	bookmark *pdfcpu.Bookmark
+}
+
+func (c Chapter) Pages() int {
+	return c.Thru - c.From + 1
+}
+
+// Split writes one PDF per outline entry down to depth into outDir. Pages before the
+// first entry become a front matter chapter. Links between chapters are rewritten to
+// point at the other file.
+func Split(path string, outDir string, depth int) ([]Chapter, error) {
+	if depth < 1 {
+		return nil, fmt.Errorf("split depth must be at least 1, got %d", depth)
+	}
+
+	ctx, err := readContext(path, newConfiguration(model.SPLIT))
+	if err != nil {
+		return nil, err
+	}
+
+	bookmarks, err := pdfcpu.Bookmarks(ctx)
+	if err != nil || len(bookmarks) == 0 {
+		return nil, fmt.Errorf("the PDF has no outline to split along")
+	}
+
+	chapters := splitPoints(bookmarks, depth, ctx.PageCount)
+	chapterOf := make([]int, ctx.PageCount+1)
+	for i, chapter := range chapters {
+		for page := chapter.From; page <= chapter.Thru; page++ {
+			chapterOf[page] = i
+		}
+	}
+
+	err = rewriteLinks(ctx, chapters, chapterOf)
+	if err != nil {
+		return nil, fmt.Errorf("error rewriting links: %s", err)
+	}
+	// every link now has an explicit target, and ExtractPages patches the source's named
+	// destinations in place, which breaks the extraction of the next chapter
+	delete(ctx.Names, "Dests")
+
+	for _, chapter := range chapters {
+		err = writeChapter(ctx, chapter, filepath.Join(outDir, chapter.File))
+		if err != nil {
+			return nil, fmt.Errorf("error writing %s: %s", chapter.File, err)
+		}
+	}
+	return chapters, nil
+}
+
+// splitPoints lists the chapters in page order. An entry starting on the same page as
+// the previous one, e.g. a section opening its chapter, doesn't start a new file.
+func splitPoints(bookmarks []pdfcpu.Bookmark, depth int, pageCount int) []Chapter {
+	var chapters []Chapter
+	var walk func(bms []pdfcpu.Bookmark, prefix string, level int)
+	walk = func(bms []pdfcpu.Bookmark, prefix string, level int) {
+		for i := range bms {
+			number := fmt.Sprintf("%02d", i+1)
+			if prefix != "" {
+				number = prefix + "." + number
+			}
+
+			bm := &bms[i]
+			last := 0
+			if len(chapters) > 0 {
+				last = chapters[len(chapters)-1].From
+			}
+			if bm.PageFrom > last && bm.PageFrom <= pageCount {
+				chapters = append(chapters, Chapter{Number: number, Title: bm.Title, From: bm.PageFrom, bookmark: bm})
+			}
+			if level < depth {
+				walk(bm.Kids, number, level+1)
+			}
+		}
+	}
+	walk(bookmarks, "", 1)
+
+	if len(chapters) == 0 || chapters[0].From > 1 {
+		chapters = append([]Chapter{{Number: "00", Title: "Front matter", From: 1}}, chapters...)
+	}
+	for i := range chapters {
+		chapters[i].Thru = pageCount
+		if i+1 < len(chapters) {
+			chapters[i].Thru = chapters[i+1].From - 1
+		}
+		chapters[i].File = chapters[i].Number + "-" + Slug(chapters[i].Title) + ".pdf"
+	}
+	return chapters
+}
+
+// Slug turns a title into a file name friendly form, e.g. "1.2 Getting Started" -> "1-2-getting-started"
+func Slug(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+
+	slug := []rune(b.String())
+	if len(slug) > 60 {
+		slug = []rune(strings.TrimRight(string(slug[:60]), "-"))
+	}
+	if len(slug) == 0 {
+		return "untitled"
+	}
+	return string(slug)
+}
+
+// destObjectPage resolves a link destination, explicit or named, to a page number
+func destObjectPage(ctx *model.Context, o types.Object) int {
+	switch dest := o.(type) {
+	case types.Array:
+		if len(dest) == 0 {
+			return 0
+		}
+		ir, ok := dest[0].(types.IndirectRef)
+		if !ok {
+			return 0
+		}
+		page, err := ctx.PageNumber(ir.ObjectNumber.Value())
+		if err != nil {
+			return 0
+		}
+		return page
+	case types.Name:
+		return namedDestPage(ctx, dest.Value())
+	case types.StringLiteral, types.HexLiteral:
+		name, err := types.StringOrHexLiteral(dest)
+		if err != nil {
+			return 0
+		}
+		return namedDestPage(ctx, *name)
+	case types.IndirectRef:
+		resolved, err := ctx.Dereference(dest)
+		if err != nil {
+			return 0
+		}
+		return destObjectPage(ctx, resolved)
+	case types.Dict:
+		// a destination dictionary wraps the array in D
+		return destObjectPage(ctx, dest["D"])
+	}
+	return 0
+}
+
+func namedDestPage(ctx *model.Context, name string) int {
+	if ctx.Names["Dests"] != nil {
+		if o, ok := ctx.Names["Dests"].Value(name); ok {
+			return destObjectPage(ctx, o)
+		}
+	}
+
+	// PDF 1.1 documents keep named destinations in the catalog's Dests dictionary
+	root, err := ctx.Catalog()
+	if err != nil {
+		return 0
+	}
+	dests, err := ctx.DereferenceDict(root["Dests"])
+	if err != nil || dests == nil {
+		return 0
+	}
+	if o, ok := dests.Find(name); ok {
+		return destObjectPage(ctx, o)
+	}
+	return 0
+}
+
+// linkTarget returns the page an internal link points at, 0 for anything else
+func linkTarget(ctx *model.Context, annot types.Dict) int {
+	if dest, ok := annot.Find("Dest"); ok {
+		return destObjectPage(ctx, dest)
+	}
+
+	action, err := ctx.DereferenceDict(annot["A"])
+	if err != nil || action == nil {
+		return 0
+	}
+	if s := action.NameEntry("S"); s == nil || *s != "GoTo" {
+		return 0
+	}
+	return destObjectPage(ctx, action["D"])
+}
+
+func pageAnnotations(ctx *model.Context, pageNr int) ([]types.Dict, error) {
+	d, _, _, err := ctx.PageDict(pageNr, false)
+	if err != nil {
+		return nil, err
+	}
+	annots, err := ctx.DereferenceArray(d["Annots"])
+	if err != nil || annots == nil {
+		return nil, err
+	}
+
+	var dicts []types.Dict
+	for _, o := range annots {
+		annot, err := ctx.DereferenceDict(o)
+		if err != nil || annot == nil {
+			continue
+		}
+		if subtype := annot.NameEntry("Subtype"); subtype != nil && *subtype == "Link" {
+			dicts = append(dicts, annot)
+		}
+	}
+	return dicts, nil
+}
+
+// rewriteLinks points links within a chapter at a placeholder resolved after extraction,
+// and links into other chapters at the page of the other chapter's file
+func rewriteLinks(ctx *model.Context, chapters []Chapter, chapterOf []int) error {
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		annots, err := pageAnnotations(ctx, pageNr)
+		if err != nil {
+			return err
+		}
+
+		for _, annot := range annots {
+			target := linkTarget(ctx, annot)
+			if target < 1 || target >= len(chapterOf) {
+				continue
+			}
+
+			delete(annot, "Dest")
+			delete(annot, "A")
+			chapter := chapters[chapterOf[target]]
+			if chapterOf[target] == chapterOf[pageNr] {
+				annot[localTargetKey] = types.Integer(target - chapter.From + 1)
+				continue
+			}
+			annot["A"] = types.Dict(map[string]types.Object{
+				"S":         types.Name("GoToR"),
+				"F":         types.StringLiteral(chapter.File),
+				"D":         types.Array{types.Integer(target - chapter.From), types.Name("Fit")},
+				"NewWindow": types.Boolean(false),
+			})
+		}
+	}
+	return nil
+}
+
+// chapterBookmarks keeps the outline below a chapter, shifted to the chapter's pages
+func chapterBookmarks(bms []pdfcpu.Bookmark, chapter Chapter) []pdfcpu.Bookmark {
+	var out []pdfcpu.Bookmark
+	for _, bm := range bms {
+		if bm.PageFrom < chapter.From || bm.PageFrom > chapter.Thru {
+			continue
+		}
+		out = append(out, pdfcpu.Bookmark{
+			Title:    bm.Title,
+			PageFrom: bm.PageFrom - chapter.From + 1,
+			Kids:     chapterBookmarks(bm.Kids, chapter),
+		})
+	}
+	return out
+}
+
+func writeChapter(ctx *model.Context, chapter Chapter, outPath string) error {
+	pages := make([]int, 0, chapter.Pages())
+	for page := chapter.From; page <= chapter.Thru; page++ {
+		pages = append(pages, page)
+	}
+
+	ctxChapter, err := pdfcpu.ExtractPages(ctx, pages, false)
+	if err != nil {
+		return err
+	}
+	// ExtractPages doesn't count the pages it adds
+	ctxChapter.PageCount = len(pages)
+
+	for pageNr := 1; pageNr <= ctxChapter.PageCount; pageNr++ {
+		annots, err := pageAnnotations(ctxChapter, pageNr)
+		if err != nil {
+			return err
+		}
+		for _, annot := range annots {
+			local := annot.IntEntry(localTargetKey)
+			if local == nil {
+				continue
+			}
+			delete(annot, localTargetKey)
+
+			_, pageRef, _, err := ctxChapter.PageDict(*local, false)
+			if err != nil || pageRef == nil {
+				continue
+			}
+			annot["Dest"] = types.Array{*pageRef, types.Name("Fit")}
+		}
+	}
+
+	if chapter.bookmark != nil {
+		bookmarks := []pdfcpu.Bookmark{{
+			Title:    chapter.Title,
+			PageFrom: 1,
+			Kids:     chapterBookmarks(chapter.bookmark.Kids, chapter),
+		}}
+		err = pdfcpu.AddBookmarks(ctxChapter, bookmarks, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	return api.WriteContextFile(ctxChapter, outPath)
+}
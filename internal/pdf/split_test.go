@@ -0,0 +1,205 @@
+package pdf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// addTestLinks puts a link to each of targets on page
+func addTestLinks(t *testing.T, path string, page int, targets ...int) {
+	t.Helper()
+
+	ctx, err := readContext(path, newConfiguration(model.VALIDATE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _, _, err := ctx.PageDict(page, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var annots types.Array
+	for i, target := range targets {
+		_, targetRef, _, err := ctx.PageDict(target, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		y := float64(700 - i*20)
+		annot := types.Dict(map[string]types.Object{
+			"Type":    types.Name("Annot"),
+			"Subtype": types.Name("Link"),
+			"Rect":    types.NewNumberArray(72, y, 200, y+12),
+			"Dest":    types.Array{*targetRef, types.Name("Fit")},
+		})
+		ir, err := ctx.IndRefForNewObject(annot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		annots = append(annots, *ir)
+	}
+	d["Annots"] = annots
+
+	err = api.WriteContextFile(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "doc.pdf")
+	writeTestPDF(t, path, 6)
+	addTestLinks(t, path, 2, 4, 6)
+
+	err := Finalize(path, Metadata{Title: "Doc"}, Structure{
+		FrontMatter: 1,
+		Headings: []Heading{
+			{Title: "1 Getting Started", Level: 1, Label: "1"},
+			{Title: "1.1 Install", Level: 2, Label: "1"},
+			{Title: "1.2 Configure", Level: 2, Label: "3"},
+			{Title: "2 Usage & API", Level: 1, Label: "4"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		depth    int
+		expected []Chapter
+	}{
+		{1, []Chapter{
+			{Number: "00", File: "00-front-matter.pdf", From: 1, Thru: 1},
+			{Number: "01", File: "01-1-getting-started.pdf", From: 2, Thru: 4},
+			{Number: "02", File: "02-2-usage-api.pdf", From: 5, Thru: 6},
+		}},
+		{2, []Chapter{
+			{Number: "00", File: "00-front-matter.pdf", From: 1, Thru: 1},
+			{Number: "01", File: "01-1-getting-started.pdf", From: 2, Thru: 3},
+			{Number: "01.02", File: "01.02-1-2-configure.pdf", From: 4, Thru: 4},
+			{Number: "02", File: "02-2-usage-api.pdf", From: 5, Thru: 6},
+		}},
+	}
+
+	for _, tt := range tests {
+		outDir := t.TempDir()
+		chapters, err := Split(path, outDir, tt.depth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chapters) != len(tt.expected) {
+			t.Fatalf("depth %d: expected %d chapters, got %+v", tt.depth, len(tt.expected), chapters)
+		}
+		for i, chapter := range chapters {
+			expected := tt.expected[i]
+			if chapter.Number != expected.Number || chapter.File != expected.File ||
+				chapter.From != expected.From || chapter.Thru != expected.Thru {
+				t.Errorf("depth %d: expected %+v, got %+v", tt.depth, expected, chapter)
+			}
+
+			pages, err := PageCount(filepath.Join(outDir, chapter.File))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pages != chapter.Pages() {
+				t.Errorf("%s: expected %d pages, got %d", chapter.File, chapter.Pages(), pages)
+			}
+		}
+	}
+
+	t.Run("links", func(t *testing.T) {
+		outDir := t.TempDir()
+		_, err := Split(path, outDir, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, err := readContext(filepath.Join(outDir, "01-1-getting-started.pdf"), newConfiguration(model.VALIDATE))
+		if err != nil {
+			t.Fatal(err)
+		}
+		annots, err := pageAnnotations(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(annots) != 2 {
+			t.Fatalf("expected 2 links, got %d", len(annots))
+		}
+
+		// page 4 of the source is page 3 of the chapter
+		if page := linkTarget(ctx, annots[0]); page != 3 {
+			t.Errorf("expected the local link to go to page 3, got %d", page)
+		}
+		if _, ok := annots[0].Find(localTargetKey); ok {
+			t.Errorf("placeholder left in %s", annots[0])
+		}
+
+		action, err := ctx.DereferenceDict(annots[1]["A"])
+		if err != nil || action == nil {
+			t.Fatalf("expected a GoToR action, got %s", annots[1])
+		}
+		file, _ := types.StringOrHexLiteral(action["F"])
+		if s := action.NameEntry("S"); s == nil || *s != "GoToR" || file == nil || *file != "02-2-usage-api.pdf" {
+			t.Errorf("unexpected cross-file link %s", action)
+		}
+		if dest, ok := action["D"].(types.Array); !ok || dest[0] != types.Integer(1) {
+			t.Errorf("expected the second page of the next chapter, got %s", action["D"])
+		}
+	})
+
+	t.Run("outline", func(t *testing.T) {
+		outDir := t.TempDir()
+		_, err := Split(path, outDir, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.Open(filepath.Join(outDir, "01-1-getting-started.pdf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		bookmarks, err := api.Bookmarks(f, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bookmarks) != 1 || len(bookmarks[0].Kids) != 2 || bookmarks[0].Kids[1].PageFrom != 3 {
+			t.Errorf("unexpected chapter outline %+v", bookmarks)
+		}
+	})
+}
+
+func TestSplitWithoutOutline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doc.pdf")
+	writeTestPDF(t, path, 2)
+
+	_, err := Split(path, t.TempDir(), 1)
+	if err == nil {
+		t.Error("expected an error splitting a PDF without an outline")
+	}
+}
+
+func TestSlug(t *testing.T) {
+	tests := []struct {
+		title    string
+		expected string
+	}{
+		{"1.2 Getting Started", "1-2-getting-started"},
+		{"  What's new?  ", "what-s-new"},
+		{"Überblick & API", "überblick-api"},
+		{"", "untitled"},
+		{"!!!", "untitled"},
+	}
+
+	for _, tt := range tests {
+		actual := Slug(tt.title)
+		if actual != tt.expected {
+			t.Errorf("Slug(%q) = %q, expected %q", tt.title, actual, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package pdf
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+// OutlineEntry is a bookmark with its depth, 1 for top-level entries
+type OutlineEntry struct {
+	Title string
+	Level int
+	Page  int
+}
+
+// Outline flattens the bookmarks in document order
+func Outline(path string) ([]OutlineEntry, error) {
+	ctx, err := readContext(path, newConfiguration(model.VALIDATE))
+	if err != nil {
+		return nil, err
+	}
+	bookmarks, err := pdfcpu.Bookmarks(ctx)
+	if err != nil {
+		return nil, nil
+	}
+
+	var entries []OutlineEntry
+	var walk func(bms []pdfcpu.Bookmark, level int)
+	walk = func(bms []pdfcpu.Bookmark, level int) {
+		for _, bm := range bms {
+			entries = append(entries, OutlineEntry{Title: bm.Title, Level: level, Page: bm.PageFrom})
+			walk(bm.Kids, level+1)
+		}
+	}
+	walk(bookmarks, 1)
+	return entries, nil
+}
+
+// PageTexts extracts the text of every page with pdftotext
+func PageTexts(ctx context.Context, path string) ([]string, error) {
+	out, err := utils.RunCommand(ctx, []string{"pdftotext", "-enc", "UTF-8", "-layout", path, "-"}, "")
+	if err != nil {
+		return nil, fmt.Errorf("error extracting text from %s: %s", path, err)
+	}
+
+	// pages end with a form feed
+	pages := strings.Split(string(out), "\f")
+	if len(pages) > 0 && strings.TrimSpace(pages[len(pages)-1]) == "" {
+		pages = pages[:len(pages)-1]
+	}
+	return pages, nil
+}
@@ -0,0 +1,144 @@
+package recipe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/patch"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+// RepoFile is the recipe a target repo can ship at its root
+const RepoFile = ".pdfgen.yml"
+
+// Recipe declares per-repo build overrides. All paths are relative to the repo root.
+type Recipe struct {
+	DocDir          string            `yaml:"doc_dir"`
+	ConfPath        string            `yaml:"conf_path"`
+	Group           string            `yaml:"group"`
+	ExtraPackages   []string          `yaml:"extra_packages"`
+	Env             map[string]string `yaml:"env"`
+	PreBuild        []string          `yaml:"pre_build"`
+	Patches         []patch.Patch     `yaml:"patches"`
+	LatexEngine     string            `yaml:"latex_engine"`
+	SphinxOverrides map[string]string `yaml:"sphinx_overrides"`
+}
+
+// Load reads the recipe shipped in the repo and the catalog entry for owner/repo.
+// When both exist the catalog wins field by field, since it is maintained server side.
+func Load(cfg config.RecipesConfig, parts *models.RepoParts, rootDir string) (*Recipe, error) {
+	repoRecipe, err := loadFile(filepath.Join(rootDir, RepoFile))
+	if err != nil {
+		return nil, err
+	}
+
+	catalogRecipe := &Recipe{}
+	if cfg.CatalogDir != "" {
+		catalogRecipe, err = loadFile(CatalogPath(cfg, parts))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rec := Merge(repoRecipe, catalogRecipe)
+	err = rec.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipe for %s/%s: %s", parts.Owner, parts.Repo, err)
+	}
+
+	return rec, nil
+}
+
+func CatalogPath(cfg config.RecipesConfig, parts *models.RepoParts) string {
+	return filepath.Join(cfg.CatalogDir, parts.Owner, parts.Repo+".yml")
+}
+
+func loadFile(path string) (*Recipe, error) {
+	rec := &Recipe{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return rec, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading recipe %s: %s", path, err)
+	}
+
+	log.Printf("Loading recipe: %s", path)
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err = dec.Decode(rec)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error parsing recipe %s: %s", path, err)
+	}
+
+	return rec, nil
+}
+
+// Merge returns base with every non-empty field of override applied on top.
+// Maps are merged key by key, lists from override are appended.
+func Merge(base *Recipe, override *Recipe) *Recipe {
+	out := *base
+	if override.DocDir != "" {
+		out.DocDir = override.DocDir
+	}
+	if override.ConfPath != "" {
+		out.ConfPath = override.ConfPath
+	}
+	if override.Group != "" {
+		out.Group = override.Group
+	}
+	if override.LatexEngine != "" {
+		out.LatexEngine = override.LatexEngine
+	}
+
+	out.ExtraPackages = append(append([]string{}, base.ExtraPackages...), override.ExtraPackages...)
+	out.PreBuild = append(append([]string{}, base.PreBuild...), override.PreBuild...)
+	out.Patches = append(append([]patch.Patch{}, base.Patches...), override.Patches...)
+	out.Env = mergeMaps(base.Env, override.Env)
+	out.SphinxOverrides = mergeMaps(base.SphinxOverrides, override.SphinxOverrides)
+
+	return &out
+}
+
+func mergeMaps(base map[string]string, override map[string]string) map[string]string {
+	out := map[string]string{}
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range override {
+		out[k] = v
+	}
+	return out
+}
+
+func (r *Recipe) Validate() error {
+	var errs []error
+
+	for _, path := range []string{r.DocDir, r.ConfPath} {
+		if path != "" && !patch.IsRelativePath(path) {
+			errs = append(errs, fmt.Errorf("path must stay inside the repo: %s", path))
+		}
+	}
+
+	if r.LatexEngine != "" && !utils.Contains(config.LatexEngines, r.LatexEngine) {
+		errs = append(errs, fmt.Errorf("unknown latex engine: %s", r.LatexEngine))
+	}
+
+	for i, p := range r.Patches {
+		err := p.Validate()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("patch %d: %s", i, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,108 @@
+package recipe
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/patch"
+)
+
+func writeFile(t *testing.T, path string, contents string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	catalogDir := t.TempDir()
+	rootDir := t.TempDir()
+	parts := &models.RepoParts{Provider: "github.com", Owner: "apache", Repo: "airflow"}
+
+	writeFile(t, filepath.Join(rootDir, RepoFile), `
+doc_dir: airflow-core/docs
+group: docs
+latex_engine: pdflatex
+env:
+  A: repo
+  B: repo
+sphinx_overrides:
+  language: en
+`)
+	writeFile(t, filepath.Join(catalogDir, "apache", "airflow.yml"), `
+latex_engine: xelatex
+env:
+  B: catalog
+patches:
+  - files: conf.py
+    old: foo
+    new: bar
+`)
+
+	rec, err := Load(config.RecipesConfig{CatalogDir: catalogDir}, parts, rootDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.DocDir != "airflow-core/docs" {
+		t.Errorf("expected repo doc dir, got %s", rec.DocDir)
+	}
+	if rec.LatexEngine != "xelatex" {
+		t.Errorf("expected catalog engine to win, got %s", rec.LatexEngine)
+	}
+	if !reflect.DeepEqual(rec.Env, map[string]string{"A": "repo", "B": "catalog"}) {
+		t.Errorf("unexpected env: %v", rec.Env)
+	}
+	if len(rec.Patches) != 1 {
+		t.Errorf("expected 1 patch, got %d", len(rec.Patches))
+	}
+}
+
+func TestLoadMissingIsEmpty(t *testing.T) {
+	parts := &models.RepoParts{Owner: "jeffbrennan", Repo: "pdfgen"}
+	rec, err := Load(config.RecipesConfig{CatalogDir: t.TempDir()}, parts, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.DocDir != "" || len(rec.Patches) != 0 || len(rec.PreBuild) != 0 {
+		t.Errorf("expected empty recipe, got %+v", rec)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var tests = []struct {
+		name       string
+		input      *Recipe
+		shouldPass bool
+	}{
+		{"empty recipe should pass", &Recipe{}, true},
+		{"nested doc dir should pass", &Recipe{DocDir: "airflow-core/docs"}, true},
+		{"parent doc dir should fail", &Recipe{DocDir: "../other"}, false},
+		{"absolute conf path should fail", &Recipe{ConfPath: "/etc"}, false},
+		{"unknown engine should fail", &Recipe{LatexEngine: "troff"}, false},
+		{"patch without old should fail", &Recipe{Patches: []patch.Patch{{Files: "conf.py"}}}, false},
+		{"patch escaping repo should fail", &Recipe{Patches: []patch.Patch{{Files: "a/../../b", Old: "x"}}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.input.Validate()
+			if (err != nil) && (tt.shouldPass) {
+				t.Errorf("should pass: got %v", err)
+			}
+
+			if (err == nil) && !(tt.shouldPass) {
+				t.Errorf("should fail")
+			}
+		})
+	}
+}
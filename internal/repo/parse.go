@@ -1,13 +1,19 @@
 package repo
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/url"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
+	"github.com/jeffbrennan/pdfgen/internal/config"
 	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
 )
 
 func ParseGithubAPIResponse(responseBody []byte) (*models.RepoStats, error) {
@@ -25,98 +31,140 @@ func ParseGithubAPIResponse(responseBody []byte) (*models.RepoStats, error) {
 
 }
 
-func ParseRepoDir(parts *models.RepoParts) (*models.DirectoryParts, error) {
-	var docDir string
-	var baseDir string
-
-	rootDir := fmt.Sprintf(
-		"%s/%s", "./repos", parts.Repo,
-	)
-
-	if parts.Directory == "" {
-		baseDir = rootDir
-		docDir = "docs/"
-	} else {
+// ParseRepoDir splits the docs directory of parts into the checkout root, the directory
+// builds run in, and the docs directory relative to it. The result never leaves the checkout.
+func ParseRepoDir(ctx context.Context, cfg config.RepoConfig, parts *models.RepoParts) (*models.DirectoryParts, error) {
+	if !ownerPattern.MatchString(parts.Owner) {
+		return nil, fmt.Errorf("invalid owner: %q", parts.Owner)
+	}
+	err := validateRepoName(parts.Repo)
+	if err != nil {
+		return nil, err
+	}
+	err = ValidateDirectory(parts.Directory)
+	if err != nil {
+		return nil, err
+	}
 
+	rootDir := utils.CheckoutDir(ctx, cfg, parts)
+	baseDir := rootDir
+	docDir := "docs/"
+	if parts.Directory != "" {
 		docParts := strings.Split(parts.Directory, "/")
 		// strip last element
-		baseDir = rootDir + "/" + strings.Join(docParts[:len(docParts)-1], "/")
+		baseDir = filepath.Join(rootDir, filepath.Join(docParts[:len(docParts)-1]...))
 		log.Printf("Base dir: %s", baseDir)
 
 		docDir = docParts[len(docParts)-1] + "/"
 	}
 
+	rel, err := filepath.Rel(rootDir, baseDir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return nil, fmt.Errorf("directory %q leaves the checkout", parts.Directory)
+	}
+
 	dirParts := &models.DirectoryParts{
-		Root: rootDir, //  .../airflow
-		Base: baseDir, // .../airflow/airflow-core/
+		Root: rootDir, //  .../apache/airflow
+		Base: baseDir, // .../apache/airflow/airflow-core/
 		Doc:  docDir,  // docs/
 	}
 
 	return dirParts, nil
 }
-func ParseRepoURL(url string) (*models.RepoParts, error) {
-	if !strings.HasPrefix(url, "https://") {
-		return nil, fmt.Errorf("invalid URL: %s", url)
-	}
-
-	if !strings.Contains(url, "github.com") {
-		return nil, fmt.Errorf("unsupported provider: %s", url)
-	}
 
-	url = strings.TrimSuffix(strings.TrimPrefix(url, "https://"), "/")
-	url = strings.Replace(url, "/tree/", "/", 1)
+var (
+	ownerPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]{0,38}$`)
+	repoPattern  = regexp.MustCompile(`^[A-Za-z0-9._-]{1,100}$`)
+	// segmentPattern is a single directory of a docs path. It ends up in file names passed
+	// to shells and TeX, so nothing either would interpret is allowed.
+	segmentPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,100}$`)
+)
 
-	// variants
-	// github.com/apache/airflow/tree/main/airflow-core/docs # 6
-	// github.com/apache/airflow #2
-	// github.com/apache/airflow/airflow-core/docs # 4
-	parts := strings.Split(url, "/")
-	nSlashes := len(parts)
+const maxDirectoryDepth = 16
 
-	// need at least provder, owner, repo
-	if nSlashes < 3 {
-		return nil, fmt.Errorf("invalid URL: %s", url)
+func validateRepoName(repo string) error {
+	if !repoPattern.MatchString(repo) || repo == "." || repo == ".." {
+		return fmt.Errorf("invalid repo: %q", repo)
 	}
+	return nil
+}
 
-	provider := parts[0]
-	owner := parts[1]
-	repo := parts[2]
-	if nSlashes == 3 {
-		return &models.RepoParts{
-			Provider: provider,
-			Owner:    owner,
-			Repo:     repo,
-		}, nil
+// ValidateDirectory accepts a relative docs path of plain segments, "" is the repo root
+func ValidateDirectory(dir string) error {
+	if dir == "" {
+		return nil
 	}
-
-	branch := ""
-	branchPadding := 0
-	if parts[4] == "tree" {
-		branch = parts[5]
-		branchPadding = 2
-	} else {
-		branch = "main"
+	segments := strings.Split(dir, "/")
+	if len(segments) > maxDirectoryDepth {
+		return fmt.Errorf("directory %q is nested too deep", dir)
 	}
+	for _, segment := range segments {
+		if !segmentPattern.MatchString(segment) || segment == "." || segment == ".." {
+			return fmt.Errorf("invalid directory: %q", dir)
+		}
+	}
+	return nil
+}
 
-	if nSlashes == 4+branchPadding {
-		return &models.RepoParts{
-			Provider:  provider,
-			Owner:     owner,
-			Repo:      repo,
-			Branch:    branch,
-			Directory: "docs",
-		}, nil
+// ParseRepoURL accepts github.com repo URLs in three forms:
+//
+//	https://github.com/apache/airflow
+//	https://github.com/apache/airflow/tree/main/airflow-core/docs
+//	https://github.com/apache/airflow/airflow-core/docs
+//
+// Only /tree/ URLs name a branch, the others build the default branch. A branch without
+// a directory builds docs.
+func ParseRepoURL(rawURL string) (*models.RepoParts, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.Opaque != "" {
+		return nil, fmt.Errorf("invalid URL: %s", rawURL)
+	}
+	if u.User != nil || u.Port() != "" || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid URL: %s", rawURL)
+	}
+	if !strings.EqualFold(u.Hostname(), "github.com") {
+		return nil, fmt.Errorf("unsupported provider: %s", u.Hostname())
+	}
 
+	segments := strings.Split(strings.TrimSuffix(strings.TrimPrefix(u.Path, "/"), "/"), "/")
+	// need at least owner and repo
+	if len(segments) < 2 {
+		return nil, fmt.Errorf("invalid URL: %s", rawURL)
 	}
 
-	directory := strings.Join(parts[4+branchPadding:], "/")
+	parts := &models.RepoParts{
+		Provider: "github.com",
+		Owner:    segments[0],
+		Repo:     strings.TrimSuffix(segments[1], ".git"),
+	}
+	if !ownerPattern.MatchString(parts.Owner) {
+		return nil, fmt.Errorf("invalid owner: %q", parts.Owner)
+	}
+	err = validateRepoName(parts.Repo)
+	if err != nil {
+		return nil, err
+	}
 
-	return &models.RepoParts{
-		Provider:  provider,
-		Owner:     owner,
-		Repo:      repo,
-		Branch:    branch,
-		Directory: directory,
-	}, nil
+	rest := segments[2:]
+	if len(rest) > 0 && rest[0] == "tree" {
+		if len(rest) < 2 {
+			return nil, fmt.Errorf("invalid URL: %s: missing branch", rawURL)
+		}
+		parts.Branch = rest[1]
+		err = ValidateRef(parts.Branch)
+		if err != nil {
+			return nil, err
+		}
+		rest = rest[2:]
+		parts.Directory = "docs"
+	}
 
+	if len(rest) > 0 {
+		parts.Directory = strings.Join(rest, "/")
+	}
+	err = ValidateDirectory(parts.Directory)
+	if err != nil {
+		return nil, err
+	}
+	return parts, nil
 }
@@ -0,0 +1,150 @@
+package repo
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+func TestParseRepoURL(t *testing.T) {
+	var tests = []struct {
+		name       string
+		url        string
+		expected   models.RepoParts
+		shouldPass bool
+	}{
+		{"repo", "https://github.com/apache/airflow", models.RepoParts{Provider: "github.com", Owner: "apache", Repo: "airflow"}, true},
+		{"trailing slash", "https://github.com/apache/airflow/", models.RepoParts{Provider: "github.com", Owner: "apache", Repo: "airflow"}, true},
+		{"git suffix", "https://github.com/apache/airflow.git", models.RepoParts{Provider: "github.com", Owner: "apache", Repo: "airflow"}, true},
+		{
+			"branch and directory", "https://github.com/apache/airflow/tree/v2-10-stable/airflow-core/docs",
+			models.RepoParts{Provider: "github.com", Owner: "apache", Repo: "airflow", Branch: "v2-10-stable", Directory: "airflow-core/docs"}, true,
+		},
+		{"branch only", "https://github.com/apache/airflow/tree/dev", models.RepoParts{Provider: "github.com", Owner: "apache", Repo: "airflow", Branch: "dev", Directory: "docs"}, true},
+		{
+			"directory without branch", "https://github.com/apache/airflow/airflow-core/docs",
+			models.RepoParts{Provider: "github.com", Owner: "apache", Repo: "airflow", Directory: "airflow-core/docs"}, true,
+		},
+		{"four segments", "https://github.com/apache/airflow/docs", models.RepoParts{Provider: "github.com", Owner: "apache", Repo: "airflow", Directory: "docs"}, true},
+		{"http should fail", "http://github.com/apache/airflow", models.RepoParts{}, false},
+		{"other host should fail", "https://gitlab.com/apache/airflow", models.RepoParts{}, false},
+		{"host in path should fail", "https://evil.example/github.com/apache/airflow", models.RepoParts{}, false},
+		{"userinfo should fail", "https://github.com@evil.example/apache/airflow", models.RepoParts{}, false},
+		{"port should fail", "https://github.com:8443/apache/airflow", models.RepoParts{}, false},
+		{"query should fail", "https://github.com/apache/airflow?x=1", models.RepoParts{}, false},
+		{"owner only should fail", "https://github.com/apache", models.RepoParts{}, false},
+		{"missing branch should fail", "https://github.com/apache/airflow/tree", models.RepoParts{}, false},
+		{"option branch should fail", "https://github.com/apache/airflow/tree/--upload-pack=x", models.RepoParts{}, false},
+		{"dot repo should fail", "https://github.com/apache/../docs", models.RepoParts{}, false},
+		{"traversal should fail", "https://github.com/apache/airflow/tree/main/../../etc", models.RepoParts{}, false},
+		{"encoded traversal should fail", "https://github.com/apache/airflow/docs/%2e%2e/%2e%2e", models.RepoParts{}, false},
+		{"empty segment should fail", "https://github.com/apache/airflow//docs", models.RepoParts{}, false},
+		{"shell metacharacters should fail", "https://github.com/apache/airflow/docs$(id)", models.RepoParts{}, false},
+		{"space should fail", "https://github.com/apache/airflow/my%20docs", models.RepoParts{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, err := ParseRepoURL(tt.url)
+			if (err != nil) && tt.shouldPass {
+				t.Fatalf("should pass: got %v", err)
+			}
+			if (err == nil) && !tt.shouldPass {
+				t.Fatalf("should fail, got %+v", parts)
+			}
+			if tt.shouldPass && *parts != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, *parts)
+			}
+		})
+	}
+}
+
+func TestParseRepoDir(t *testing.T) {
+	cfg := config.RepoConfig{Dir: "./repos"}
+	ctx := utils.WithWorkspace(context.Background(), "job")
+
+	var tests = []struct {
+		name       string
+		directory  string
+		base       string
+		doc        string
+		shouldPass bool
+	}{
+		{"root", "", "repos/apache/airflow/job", "docs/", true},
+		{"docs", "docs", "repos/apache/airflow/job", "docs/", true},
+		{"nested", "airflow-core/docs", "repos/apache/airflow/job/airflow-core", "docs/", true},
+		{"traversal should fail", "../other/docs", "", "", false},
+		{"absolute should fail", "/etc", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dirParts, err := ParseRepoDir(ctx, cfg, &models.RepoParts{Owner: "apache", Repo: "airflow", Directory: tt.directory})
+			if (err != nil) && tt.shouldPass {
+				t.Fatalf("should pass: got %v", err)
+			}
+			if (err == nil) && !tt.shouldPass {
+				t.Fatalf("should fail, got %+v", dirParts)
+			}
+			if !tt.shouldPass {
+				return
+			}
+			if dirParts.Base != tt.base || dirParts.Doc != tt.doc {
+				t.Errorf("expected %s and %s, got %s and %s", tt.base, tt.doc, dirParts.Base, dirParts.Doc)
+			}
+		})
+	}
+}
+
+func FuzzParseRepoURL(f *testing.F) {
+	for _, seed := range []string{
+		"https://github.com/apache/airflow",
+		"https://github.com/apache/airflow/tree/main/airflow-core/docs",
+		"https://github.com/apache/airflow/docs",
+		"https://github.com/apache/airflow/tree/main/../../etc",
+		"https://github.com/a/b/%2e%2e/c;rm -rf",
+		"https://github.com/apache/airflow/tree",
+	} {
+		f.Add(seed)
+	}
+
+	cfg := config.RepoConfig{Dir: "/srv/repos"}
+	ctx := utils.WithWorkspace(context.Background(), "job")
+	f.Fuzz(func(t *testing.T, url string) {
+		parts, err := ParseRepoURL(url)
+		if err != nil {
+			return
+		}
+
+		if parts.Provider != "github.com" {
+			t.Errorf("unexpected provider %q", parts.Provider)
+		}
+		for _, name := range []string{parts.Owner, parts.Repo, parts.Directory, parts.Branch} {
+			if strings.ContainsAny(name, " \t\n;&|$`'\"\\<>(){}*?!~#%") {
+				t.Errorf("unsafe name %q in %+v", name, parts)
+			}
+			for _, segment := range strings.Split(name, "/") {
+				if segment == "." || segment == ".." {
+					t.Errorf("traversal in %q of %+v", name, parts)
+				}
+			}
+		}
+
+		dirParts, err := ParseRepoDir(ctx, cfg, parts)
+		if err != nil {
+			t.Fatalf("parsed url %q has an invalid directory: %s", url, err)
+		}
+		root := utils.CheckoutDir(ctx, cfg, parts)
+		if filepath.Dir(filepath.Dir(filepath.Dir(root))) != cfg.Dir {
+			t.Errorf("checkout %s is outside %s", root, cfg.Dir)
+		}
+		if dirParts.Base != root && !strings.HasPrefix(dirParts.Base, root+"/") {
+			t.Errorf("base %s is outside checkout %s", dirParts.Base, root)
+		}
+	})
+}
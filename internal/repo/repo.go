@@ -1,53 +1,68 @@
 package repo
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
 
+	"github.com/jeffbrennan/pdfgen/internal/config"
 	"github.com/jeffbrennan/pdfgen/internal/logging"
 	"github.com/jeffbrennan/pdfgen/internal/models"
 	"github.com/jeffbrennan/pdfgen/internal/utils"
 )
 
-func GetGithubAPIResponseRepo(owner string, repo string) ([]byte, error) {
-	url := fmt.Sprintf("https://api.github.com/repos/%v/%v", owner, repo)
-	log.Printf("requesting a response from %s...", url)
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
+// GetGithubAPIResponseRepo returns the GitHub API's description of a repo. Cancelling
+// ctx stops the request.
+func GetGithubAPIResponseRepo(ctx context.Context, owner string, repo string) ([]byte, error) {
+	token, err := utils.LoadSecret("GITHUB_TOKEN")
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("GITHUB_TOKEN not set: %s", err)
 	}
+	return getGithubAPI(ctx, fmt.Sprintf("https://api.github.com/repos/%v/%v", owner, repo), token)
+}
 
-	token, err := utils.LoadSecret("GITHUB_TOKEN")
+func getGithubAPI(ctx context.Context, url string, token string) ([]byte, error) {
+	log.Printf("requesting a response from %s...", url)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		log.Fatal("GITHUB_TOKEN not set")
+		return nil, err
 	}
 
 	req.Header.Set("Accept", "application/vnd.github+json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
 	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
-	resp, err := client.Do(req)
-
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("error requesting %s: %s", url, err)
 	}
 
 	defer resp.Body.Close()
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("error reading %s: %s", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
 	}
 
 	return bodyText, nil
 }
-func UpdateRepo(parts *models.RepoParts) error {
-	// pulls or clones depending on if the repo exists
-	baseDir := "./repos"
-	targetDir := fmt.Sprintf(
-		"%s/%s", baseDir, parts.Repo,
-	)
+
+// UpdateRepo clones the repo of parts or brings its existing checkout up to date, pinned
+// to ref if set and to the branch of parts otherwise
+func UpdateRepo(ctx context.Context, cfg config.RepoConfig, parts *models.RepoParts, ref string) error {
+	if ref != "" {
+		err := ValidateRef(ref)
+		if err != nil {
+			return err
+		}
+	}
+	targetDir := utils.CheckoutDir(ctx, cfg, parts)
 
 	updateRepoMsg := fmt.Sprintf(
 		"Updating %s/%s/%s...",
@@ -57,50 +72,101 @@ func UpdateRepo(parts *models.RepoParts) error {
 	)
 
 	log.Print(updateRepoMsg)
-	logging.PublishLog(updateRepoMsg)
+	logging.PublishLog(ctx, updateRepoMsg)
 
-	_, err := utils.RunCommand([]string{"ls"}, targetDir)
+	_, err := utils.RunCommand(ctx, []string{"ls"}, targetDir)
 	if err == nil {
 		log.Printf("Directory %s already exists", targetDir)
-		err = pullRepo(targetDir)
-		if err != nil {
-			return err
+		if ref == "" {
+			ref = parts.Branch
 		}
-		return nil
+		return pullRepo(ctx, targetDir, ref)
 	}
 
-	_, err = utils.RunCommand([]string{"mkdir", "-p", baseDir}, "")
+	_, err = utils.RunCommand(ctx, []string{"mkdir", "-p", filepath.Dir(targetDir)}, "")
 	if err != nil {
 		return err
 	}
 
-	return cloneRepo(parts, baseDir)
+	err = cloneRepo(ctx, parts, targetDir)
+	if err != nil {
+		return err
+	}
+	if ref != "" {
+		return checkoutRef(ctx, targetDir, ref)
+	}
+	return nil
 }
 
-func pullRepo(targetDir string) error {
-	_, err := utils.RunCommand([]string{"git", "pull"}, targetDir)
-	return err
+// pullRepo moves an existing checkout to the latest commit of ref, the remote's default
+// branch without one. Checkouts are shallow, single branch and often detached, so the
+// ref is fetched on its own rather than pulled.
+func pullRepo(ctx context.Context, targetDir string, ref string) error {
+	// drop edits left behind by an interrupted build so the checkout cannot conflict
+	_, err := utils.RunCommand(ctx, []string{"git", "reset", "--hard", "-q"}, targetDir)
+	if err != nil {
+		return err
+	}
+
+	if ref == "" {
+		return fetchRef(ctx, targetDir, "HEAD")
+	}
+	return checkoutRef(ctx, targetDir, ref)
 }
 
-func cloneRepo(parts *models.RepoParts, baseDir string) error {
+func cloneRepo(ctx context.Context, parts *models.RepoParts, targetDir string) error {
 	baseURL := fmt.Sprintf(
 		"https://%s/%s/%s.git",
 		parts.Provider,
 		parts.Owner,
 		parts.Repo,
 	)
-	_, err := utils.RunCommand(
-		[]string{
-			"git",
-			"clone",
-			baseURL,
-			"-b",
-			parts.Branch,
-			"--single-branch",
-			"--depth",
-			"1",
-		},
-		baseDir,
-	)
+	args := []string{"git", "clone", "--single-branch", "--depth", "1"}
+	// without a branch the clone is of the default branch
+	if parts.Branch != "" {
+		args = append(args, "-b", parts.Branch)
+	}
+	_, err := utils.RunCommand(ctx, append(args, "--", baseURL, targetDir), "")
 	return err
 }
+
+var refPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]*$`)
+
+// ValidateRef accepts branch names, tags, and commit SHAs, nothing git could read as an option
+func ValidateRef(ref string) error {
+	if !refPattern.MatchString(ref) || strings.Contains(ref, "..") || strings.HasSuffix(ref, ".lock") || len(ref) > 255 {
+		return fmt.Errorf("invalid ref: %q", ref)
+	}
+	return nil
+}
+
+// checkoutRef pins the checkout to a branch, tag, or commit other than the cloned branch
+func checkoutRef(ctx context.Context, targetDir string, ref string) error {
+	checkoutMsg := fmt.Sprintf("Checking out %s...", ref)
+	log.Print(checkoutMsg)
+	logging.PublishLog(ctx, checkoutMsg)
+	return fetchRef(ctx, targetDir, ref)
+}
+
+// fetchRef detaches the checkout at ref. The clone is shallow, so only that ref is fetched.
+func fetchRef(ctx context.Context, targetDir string, ref string) error {
+	_, err := utils.RunCommand(ctx, []string{"git", "fetch", "--depth", "1", "origin", ref}, targetDir)
+	if err != nil {
+		return fmt.Errorf("error fetching %s: %s", ref, err)
+	}
+	_, err = utils.RunCommand(ctx, []string{"git", "checkout", "--detach", "FETCH_HEAD"}, targetDir)
+	if err != nil {
+		return fmt.Errorf("error checking out %s: %s", ref, err)
+	}
+	return nil
+}
+
+// HeadCommit returns the SHA of the checked out commit
+func HeadCommit(ctx context.Context, cfg config.RepoConfig, parts *models.RepoParts) (string, error) {
+	targetDir := utils.CheckoutDir(ctx, cfg, parts)
+	out, err := utils.RunCommand(ctx, []string{"git", "rev-parse", "HEAD"}, targetDir)
+	if err != nil {
+		return "", fmt.Errorf("error reading head commit: %s", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
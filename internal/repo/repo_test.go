@@ -0,0 +1,176 @@
+package repo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+func TestValidateRef(t *testing.T) {
+	var tests = []struct {
+		name       string
+		ref        string
+		shouldPass bool
+	}{
+		{"branch", "main", true},
+		{"nested branch", "release/2.9", true},
+		{"tag", "v2.9.1", true},
+		{"sha", "0123456789abcdef0123456789abcdef01234567", true},
+		{"empty", "", false},
+		{"option", "--upload-pack=touch", false},
+		{"range", "main..dev", false},
+		{"space", "main dev", false},
+		{"lock file", "main.lock", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRef(tt.ref)
+			if (err == nil) != tt.shouldPass {
+				t.Errorf("expected pass=%v, got %v", tt.shouldPass, err)
+			}
+		})
+	}
+}
+
+func TestUpdateRepo(t *testing.T) {
+	t.Setenv("GIT_AUTHOR_NAME", "pdfgen")
+	t.Setenv("GIT_AUTHOR_EMAIL", "pdfgen@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "pdfgen")
+	t.Setenv("GIT_COMMITTER_EMAIL", "pdfgen@example.com")
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %s: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	commit := func(dir string, message string) string {
+		t.Helper()
+		err := os.WriteFile(filepath.Join(dir, "README"), []byte(message), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		git(dir, "add", "README")
+		git(dir, "commit", "-q", "-m", message)
+		return git(dir, "rev-parse", "HEAD")
+	}
+
+	origin := t.TempDir()
+	git(origin, "init", "-q", "-b", "main")
+	v1 := commit(origin, "v1")
+	git(origin, "tag", "v1")
+	git(origin, "checkout", "-q", "-b", "other")
+	other := commit(origin, "other")
+	git(origin, "checkout", "-q", "main")
+	main := commit(origin, "main")
+
+	// the checkout is a shallow clone of main, like cloneRepo leaves it
+	cfg := config.RepoConfig{Dir: t.TempDir()}
+	parts := &models.RepoParts{Provider: "github.com", Owner: "apache", Repo: "airflow"}
+	ctx := utils.WithWorkspace(context.Background(), "job")
+	checkout := utils.CheckoutDir(ctx, cfg, parts)
+	out, err := exec.Command("git", "clone", "-q", "--single-branch", "--depth", "1", "-b", "main", "file://"+origin, checkout).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	var tests = []struct {
+		name       string
+		branch     string
+		ref        string
+		expected   string
+		shouldPass bool
+	}{
+		{"pinned tag", "", "v1", v1, true},
+		{"unpinned after a pinned ref", "", "", main, true},
+		{"branch outside the clone", "", "other", other, true},
+		{"branch of the url", "other", "", other, true},
+		{"ref beats the branch of the url", "other", "main", main, true},
+		{"unknown ref should fail", "", "missing", "", false},
+		{"invalid ref should fail", "", "--upload-pack=touch", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// edits of an earlier build don't get in the way
+			err := os.WriteFile(filepath.Join(checkout, "README"), []byte("edited"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p := *parts
+			p.Branch = tt.branch
+			err = UpdateRepo(ctx, cfg, &p, tt.ref)
+			if (err != nil) && tt.shouldPass {
+				t.Fatalf("should pass: got %v", err)
+			}
+			if (err == nil) && !tt.shouldPass {
+				t.Fatalf("should fail")
+			}
+			if !tt.shouldPass {
+				return
+			}
+			if head := git(checkout, "rev-parse", "HEAD"); head != tt.expected {
+				t.Errorf("expected %s checked out, got %s", tt.expected, head)
+			}
+		})
+	}
+}
+
+func TestGetGithubAPI(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/apache/airflow":
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"stargazers_count": 40000}`))
+		case "/repos/apache/slow":
+			<-release
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "Not Found"}`))
+		}
+	}))
+	defer server.Close()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var tests = []struct {
+		name       string
+		ctx        context.Context
+		path       string
+		shouldPass bool
+	}{
+		{"repo", context.Background(), "/repos/apache/airflow", true},
+		{"missing repo should fail", context.Background(), "/repos/apache/missing", false},
+		{"cancelled request should fail", cancelled, "/repos/apache/slow", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := getGithubAPI(tt.ctx, server.URL+tt.path, "token")
+			if (err != nil) && tt.shouldPass {
+				t.Fatalf("should pass: got %v", err)
+			}
+			if (err == nil) && !tt.shouldPass {
+				t.Fatalf("should fail, got %s", body)
+			}
+		})
+	}
+}
@@ -1,19 +1,23 @@
 package repo
 
 import (
+	"context"
 	"fmt"
 	"log"
 
+	"github.com/jeffbrennan/pdfgen/internal/config"
 	"github.com/jeffbrennan/pdfgen/internal/models"
 )
 
-func ValidateRepo(parts *models.RepoParts) error {
+// ValidateRepo checks the repo against the star and age thresholds of cfg. Cancelling ctx
+// stops the request to the GitHub API.
+func ValidateRepo(ctx context.Context, cfg config.ValidationConfig, parts *models.RepoParts) error {
 	// guard against improper usage only accepting large, established projects
-	minNumStars := 100
-	minNumStarsNewRepo := 1000
-	minRepoAgeYears := 1.0
+	minNumStars := cfg.MinStars
+	minNumStarsNewRepo := cfg.MinStarsNewRepo
+	minRepoAgeYears := cfg.MinAgeYears
 
-	response, err := GetGithubAPIResponseRepo(parts.Owner, parts.Repo)
+	response, err := GetGithubAPIResponseRepo(ctx, parts.Owner, parts.Repo)
 	if err != nil {
 		return err
 	}
@@ -1,8 +1,10 @@
 package repo
 
 import (
+	"context"
 	"testing"
 
+	"github.com/jeffbrennan/pdfgen/internal/config"
 	"github.com/jeffbrennan/pdfgen/internal/models"
 )
 
@@ -30,7 +32,7 @@ func TestValidateRepo(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := ValidateRepo(tt.input)
+			err := ValidateRepo(context.Background(), config.Default().Validation, tt.input)
 			if (err != nil) && (tt.shouldPass) {
 				t.Errorf("should pass: got %v", err)
 			}
@@ -0,0 +1,322 @@
+package search
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// snippetRadius is how many characters of context a snippet keeps on each side of the match
+	snippetRadius = 80
+
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+var (
+	documentsBucket = []byte("documents")
+	pagesBucket     = []byte("pages")
+	postingsBucket  = []byte("postings")
+)
+
+// Document describes an indexed artifact. Key is its cache key, which is also how the
+// artifact is downloaded.
+type Document struct {
+	Key       string    `json:"key"`
+	Repo      string    `json:"repo"`
+	Ref       string    `json:"ref"`
+	Commit    string    `json:"commit"`
+	Directory string    `json:"directory"`
+	Title     string    `json:"title"`
+	Pages     int       `json:"pages"`
+	Indexed   time.Time `json:"indexed"`
+}
+
+// Hit is a page matching every term of a query
+type Hit struct {
+	Document
+	Page    int     `json:"page"`
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+}
+
+// Index is a full-text index of artifact pages in a bbolt file. Terms map to postings of
+// key/page with the term's count on that page.
+type Index struct {
+	db *bolt.DB
+}
+
+// Open opens the index at path, creating it when missing
+func Open(path string) (*Index, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening search index %s: %s", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{documentsBucket, pagesBucket, postingsBucket} {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Index{db: db}, nil
+}
+
+func (idx *Index) Close() error {
+	return idx.db.Close()
+}
+
+// Tokenize splits text into lowercase words of letters and digits
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := words[:0]
+	for _, word := range words {
+		if len([]rune(word)) >= 2 {
+			tokens = append(tokens, word)
+		}
+	}
+	return tokens
+}
+
+func pageKey(key string, page int) []byte {
+	return []byte(fmt.Sprintf("%s/%05d", key, page))
+}
+
+func parsePageKey(k []byte) (string, int, error) {
+	key, page, ok := strings.Cut(string(k), "/")
+	if !ok {
+		return "", 0, fmt.Errorf("malformed posting %q", k)
+	}
+	var n int
+	_, err := fmt.Sscanf(page, "%d", &n)
+	return key, n, err
+}
+
+// Has reports whether key is indexed
+func (idx *Index) Has(key string) bool {
+	found := false
+	idx.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(documentsBucket).Get([]byte(key)) != nil
+		return nil
+	})
+	return found
+}
+
+// Add indexes the text of every page of doc, pages[0] being page 1. A document that is
+// already indexed under the same key is replaced.
+func (idx *Index) Add(doc Document, pages []string) error {
+	doc.Pages = len(pages)
+	doc.Indexed = time.Now().UTC()
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		err := remove(tx, doc.Key)
+		if err != nil {
+			return err
+		}
+
+		postings := tx.Bucket(postingsBucket)
+		for i, text := range pages {
+			counts := map[string]uint32{}
+			for _, token := range Tokenize(text) {
+				counts[token]++
+			}
+			for token, count := range counts {
+				termBucket, err := postings.CreateBucketIfNotExists([]byte(token))
+				if err != nil {
+					return err
+				}
+				err = termBucket.Put(pageKey(doc.Key, i+1), binary.BigEndian.AppendUint32(nil, count))
+				if err != nil {
+					return err
+				}
+			}
+			err = tx.Bucket(pagesBucket).Put(pageKey(doc.Key, i+1), []byte(text))
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(documentsBucket).Put([]byte(doc.Key), data)
+	})
+}
+
+// Remove drops key from the index
+func (idx *Index) Remove(key string) error {
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		return remove(tx, key)
+	})
+}
+
+func remove(tx *bolt.Tx, key string) error {
+	documents := tx.Bucket(documentsBucket)
+	data := documents.Get([]byte(key))
+	if data == nil {
+		return nil
+	}
+	var doc Document
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return err
+	}
+
+	pages := tx.Bucket(pagesBucket)
+	postings := tx.Bucket(postingsBucket)
+	for page := 1; page <= doc.Pages; page++ {
+		k := pageKey(key, page)
+		for _, token := range Tokenize(string(pages.Get(k))) {
+			termBucket := postings.Bucket([]byte(token))
+			if termBucket == nil {
+				continue
+			}
+			err = termBucket.Delete(k)
+			if err != nil {
+				return err
+			}
+			if termBucket.Stats().KeyN == 0 {
+				err = postings.DeleteBucket([]byte(token))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		err = pages.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return documents.Delete([]byte(key))
+}
+
+// Search returns the pages containing every word of query, best matches first. Pages
+// are scored by tf-idf summed over the query's words.
+func (idx *Index) Search(query string, limit int) ([]Hit, error) {
+	terms := Tokenize(query)
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("query has no words to search for")
+	}
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	limit = min(limit, MaxLimit)
+
+	var hits []Hit
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		postings := tx.Bucket(postingsBucket)
+		total := float64(tx.Bucket(pagesBucket).Stats().KeyN)
+
+		scores := map[string]float64{}
+		for i, term := range terms {
+			termBucket := postings.Bucket([]byte(term))
+			if termBucket == nil {
+				scores = nil
+				break
+			}
+			idf := math.Log(1 + total/float64(termBucket.Stats().KeyN))
+
+			next := map[string]float64{}
+			termBucket.ForEach(func(k []byte, v []byte) error {
+				previous, ok := scores[string(k)]
+				if i > 0 && !ok {
+					return nil
+				}
+				next[string(k)] = previous + float64(binary.BigEndian.Uint32(v))*idf
+				return nil
+			})
+			scores = next
+		}
+
+		for k, score := range scores {
+			key, page, err := parsePageKey([]byte(k))
+			if err != nil {
+				return err
+			}
+			hits = append(hits, Hit{Document: Document{Key: key}, Page: page, Score: score})
+		}
+		sort.Slice(hits, func(i, j int) bool {
+			if hits[i].Score != hits[j].Score {
+				return hits[i].Score > hits[j].Score
+			}
+			if hits[i].Key != hits[j].Key {
+				return hits[i].Key < hits[j].Key
+			}
+			return hits[i].Page < hits[j].Page
+		})
+		if len(hits) > limit {
+			hits = hits[:limit]
+		}
+
+		documents := tx.Bucket(documentsBucket)
+		pages := tx.Bucket(pagesBucket)
+		for i := range hits {
+			var doc Document
+			err := json.Unmarshal(documents.Get([]byte(hits[i].Key)), &doc)
+			if err != nil {
+				return fmt.Errorf("error reading indexed document %s: %s", hits[i].Key, err)
+			}
+			hits[i].Document = doc
+			hits[i].Snippet = Snippet(string(pages.Get(pageKey(hits[i].Key, hits[i].Page))), query)
+		}
+		return nil
+	})
+	return hits, err
+}
+
+// Snippet cuts the text around the query, preferring the whole query as a phrase over its
+// first word
+func Snippet(text string, query string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	lower := strings.ToLower(text)
+
+	at := strings.Index(lower, strings.ToLower(strings.Join(strings.Fields(query), " ")))
+	if at < 0 {
+		for _, term := range Tokenize(query) {
+			if at = strings.Index(lower, term); at >= 0 {
+				break
+			}
+		}
+	}
+	if at < 0 {
+		at = 0
+	}
+
+	// the lowercase text can have different byte offsets, so cut on runes of the original
+	runes := []rune(text)
+	center := len([]rune(lower[:at]))
+	from := max(center-snippetRadius, 0)
+	thru := min(center+snippetRadius, len(runes))
+
+	snippet := string(runes[from:thru])
+	if from > 0 {
+		snippet = "…" + snippet
+	}
+	if thru < len(runes) {
+		snippet += "…"
+	}
+	return snippet
+}
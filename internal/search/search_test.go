@@ -0,0 +1,133 @@
+package search
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func openTestIndex(t *testing.T) *Index {
+	t.Helper()
+	idx, err := Open(filepath.Join(t.TempDir(), "index", "search.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { idx.Close() })
+
+	err = idx.Add(Document{Key: "airflow", Repo: "apache/airflow", Ref: "main"}, []string{
+		"Pools\nPools limit the number of running tasks.",
+		"Pool slots\nEach task occupies one or more pool slots. Pool slots are freed when a task finishes.",
+		"Sensors wait for an external event.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = idx.Add(Document{Key: "cosmos", Repo: "astronomer/cosmos", Ref: "v1.8.0"}, []string{
+		"Rendering dbt projects as task groups.",
+		"Use a pool to limit concurrent dbt runs.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+func TestSearch(t *testing.T) {
+	idx := openTestIndex(t)
+
+	var tests = []struct {
+		name       string
+		query      string
+		expected   []string
+		shouldPass bool
+	}{
+		{"phrase ranks the densest page first", "pool slots", []string{"airflow/2"}, true},
+		{"single word across repos", "pool", []string{"airflow/2", "cosmos/2"}, true},
+		{"case and punctuation are ignored", "DBT,", []string{"cosmos/1", "cosmos/2"}, true},
+		{"every word must match", "pool sensors", nil, true},
+		{"unknown word", "kubernetes", nil, true},
+		{"no words", "?!", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits, err := idx.Search(tt.query, 0)
+			if (err == nil) != tt.shouldPass {
+				t.Fatalf("expected pass=%v, got %v", tt.shouldPass, err)
+			}
+
+			var got []string
+			for _, hit := range hits {
+				got = append(got, fmt.Sprintf("%s/%d", hit.Key, hit.Page))
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSearchHitDetails(t *testing.T) {
+	idx := openTestIndex(t)
+
+	hits, err := idx.Search("pool slots", 1)
+	if err != nil || len(hits) != 1 {
+		t.Fatalf("expected one hit, got %v %v", hits, err)
+	}
+	hit := hits[0]
+	if hit.Repo != "apache/airflow" || hit.Ref != "main" || hit.Pages != 3 || hit.Page != 2 {
+		t.Errorf("unexpected hit %+v", hit)
+	}
+	if !strings.HasPrefix(hit.Snippet, "Pool slots Each task") {
+		t.Errorf("unexpected snippet %q", hit.Snippet)
+	}
+}
+
+func TestAddReplacesAndRemove(t *testing.T) {
+	idx := openTestIndex(t)
+
+	err := idx.Add(Document{Key: "airflow", Repo: "apache/airflow", Ref: "main"}, []string{"Executors run tasks."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hits, _ := idx.Search("pool", 0)
+	if len(hits) != 1 || hits[0].Key != "cosmos" {
+		t.Errorf("expected the replaced pages to be gone, got %v", hits)
+	}
+	hits, _ = idx.Search("executors", 0)
+	if len(hits) != 1 || hits[0].Key != "airflow" {
+		t.Errorf("expected the new page, got %v", hits)
+	}
+
+	err = idx.Remove("cosmos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Has("cosmos") || !idx.Has("airflow") {
+		t.Errorf("expected only cosmos to be removed")
+	}
+	hits, _ = idx.Search("pool", 0)
+	if len(hits) != 0 {
+		t.Errorf("expected no hits, got %v", hits)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	if got := Snippet("Pools  limit\ntasks", "tasks"); got != "Pools limit tasks" {
+		t.Errorf("expected the whole short text, got %q", got)
+	}
+	if got := Snippet("Sensors wait", "pool"); got != "Sensors wait" {
+		t.Errorf("expected the start of the text without a match, got %q", got)
+	}
+
+	long := strings.Repeat("filler ", 40) + "the Pool Slots setting" + strings.Repeat(" filler", 40)
+	got := Snippet(long, "pool slots")
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") || !strings.Contains(got, "the Pool Slots setting") {
+		t.Errorf("expected a cut around the phrase, got %q", got)
+	}
+	if n := len([]rune(got)); n != 2*snippetRadius+2 {
+		t.Errorf("expected %d runes, got %d", 2*snippetRadius+2, n)
+	}
+}
@@ -0,0 +1,460 @@
+package server
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jeffbrennan/pdfgen/internal/auth"
+	"github.com/jeffbrennan/pdfgen/internal/cache"
+	"github.com/jeffbrennan/pdfgen/internal/generators"
+	"github.com/jeffbrennan/pdfgen/internal/jobs"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/repo"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+// openAPISpec describes every route of APIRoutes, the tests hold the two together
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// APIRoutes adds the JSON API to r, which is expected to be mounted at APIPrefix
+func (s *Server) APIRoutes(r *mux.Router) {
+	r.HandleFunc("/openapi.json", OpenAPIHandler).Methods("GET")
+	r.HandleFunc("/formats", FormatsHandler).Methods("GET")
+	r.HandleFunc("/detect", s.Require(auth.ScopeBuild, s.DetectHandler)).Methods("POST")
+	r.HandleFunc("/jobs", s.Require(auth.ScopeReadArtifacts, s.APIJobsHandler)).Methods("GET")
+	r.HandleFunc("/jobs", s.Require(auth.ScopeBuild, s.CreateJobHandler)).Methods("POST")
+	r.HandleFunc("/jobs/{id}", s.Require(auth.ScopeReadArtifacts, s.APIJobHandler)).Methods("GET")
+	r.HandleFunc("/jobs/{id}", s.Require(auth.ScopeBuild, s.CancelJobHandler)).Methods("DELETE")
+	r.HandleFunc("/jobs/{id}/log", s.Require(auth.ScopeReadArtifacts, s.JobLogHandler)).Methods("GET")
+	r.HandleFunc("/artifacts/{id}", s.Require(auth.ScopeReadArtifacts, s.APIArtifactHandler)).Methods("GET")
+	r.HandleFunc("/artifacts/{id}/content", s.Require(auth.ScopeReadArtifacts, s.ArtifactHandler)).Methods("GET", "HEAD")
+	// anything else would fall through to the static files
+	r.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusNotFound, fmt.Sprintf("no endpoint %s %s", r.Method, r.URL.Path))
+	})
+}
+
+type apiLayout struct {
+	Paper       string `json:"paper,omitempty"`
+	Margin      string `json:"margin,omitempty"`
+	FontSize    int    `json:"font_size,omitempty"`
+	Columns     int    `json:"columns,omitempty"`
+	LineNumbers bool   `json:"line_numbers,omitempty"`
+	Theme       string `json:"theme,omitempty"`
+}
+
+// apiOptions are models.BuildOptions as the JSON API spells them
+type apiOptions struct {
+	Documents string    `json:"documents,omitempty"`
+	Renderer  string    `json:"renderer,omitempty"`
+	Output    string    `json:"output,omitempty"`
+	Cover     bool      `json:"cover,omitempty"`
+	Headers   bool      `json:"headers,omitempty"`
+	Layout    apiLayout `json:"layout"`
+	Split     int       `json:"split,omitempty"`
+	Ref       string    `json:"ref,omitempty"`
+}
+
+func (o apiOptions) buildOptions() models.BuildOptions {
+	return models.BuildOptions{
+		Documents: o.Documents,
+		Renderer:  o.Renderer,
+		Output:    o.Output,
+		Cover:     o.Cover,
+		Headers:   o.Headers,
+		Layout:    models.Layout(o.Layout),
+		Split:     o.Split,
+		Ref:       o.Ref,
+	}
+}
+
+func newAPIOptions(opts models.BuildOptions) apiOptions {
+	return apiOptions{
+		Documents: opts.Documents,
+		Renderer:  opts.Renderer,
+		Output:    opts.Output,
+		Cover:     opts.Cover,
+		Headers:   opts.Headers,
+		Layout:    apiLayout(opts.Layout),
+		Split:     opts.Split,
+		Ref:       opts.Ref,
+	}
+}
+
+type apiRepo struct {
+	Provider  string `json:"provider"`
+	Owner     string `json:"owner"`
+	Repo      string `json:"repo"`
+	Branch    string `json:"branch,omitempty"`
+	Directory string `json:"directory,omitempty"`
+}
+
+// apiJob is a jobs.Job with its options, repo, and error as the JSON API spells them
+type apiJob struct {
+	jobs.Job
+	Options apiOptions `json:"options"`
+	Parts   *apiRepo   `json:"parts,omitempty"`
+	// Error is set for failed and cancelled jobs, Stage is the stage they stopped in
+	Error *apiError `json:"error,omitempty"`
+}
+
+func newAPIJob(job jobs.Job) apiJob {
+	j := apiJob{Job: job, Options: newAPIOptions(job.Options)}
+	if job.Parts != nil {
+		p := apiRepo(*job.Parts)
+		j.Parts = &p
+	}
+	if job.Error != "" {
+		j.Error = &apiError{Code: codeBuildFailed, Message: job.Error}
+		if job.Status == jobs.StatusCancelled {
+			j.Error.Code = codeCancelled
+		}
+		if len(job.Stages) > 0 {
+			j.Error.Stage = job.Stages[len(job.Stages)-1].Name
+		}
+	}
+	return j
+}
+
+type apiJobsResponse struct {
+	Jobs []apiJob `json:"jobs"`
+	Next string   `json:"next"`
+}
+
+type apiBundleItem struct {
+	URL   string `json:"url"`
+	Title string `json:"title,omitempty"`
+}
+
+// createJobRequest starts a job of Kind, generate by default. Generate and diff jobs
+// build URL, bundles build Items.
+type createJobRequest struct {
+	Kind    string     `json:"kind"`
+	URL     string     `json:"url"`
+	Options apiOptions `json:"options"`
+	// Items, Name, and Numbering are for bundles
+	Items     []apiBundleItem `json:"items"`
+	Name      string          `json:"name"`
+	Numbering string          `json:"numbering"`
+	// Base, Head, and Format are for diffs
+	Base   string `json:"base"`
+	Head   string `json:"head"`
+	Format string `json:"format"`
+}
+
+type detectRequest struct {
+	URL string `json:"url"`
+	Ref string `json:"ref"`
+}
+
+type apiDocument struct {
+	Name   string `json:"name"`
+	Title  string `json:"title,omitempty"`
+	Author string `json:"author,omitempty"`
+}
+
+type apiArtifact struct {
+	ID          string        `json:"id"`
+	File        string        `json:"file"`
+	ContentType string        `json:"content_type"`
+	Size        int64         `json:"size"`
+	Created     time.Time     `json:"created"`
+	Commit      string        `json:"commit,omitempty"`
+	Renderer    string        `json:"renderer,omitempty"`
+	Degraded    bool          `json:"degraded"`
+	Warnings    []string      `json:"warnings"`
+	Documents   []apiDocument `json:"documents"`
+	// Content is where the artifact itself is downloaded
+	Content string `json:"content"`
+}
+
+// optionValues are the values the build options take. documents also takes the name of
+// a single document.
+type optionValues struct {
+	Documents   []string `json:"documents"`
+	Renderers   []string `json:"renderers"`
+	Outputs     []string `json:"outputs"`
+	Papers      []string `json:"papers"`
+	Margins     []string `json:"margins"`
+	Themes      []string `json:"themes"`
+	FontSizes   []int    `json:"font_sizes"`
+	Columns     []int    `json:"columns"`
+	MaxSplit    int      `json:"max_split"`
+	Numberings  []string `json:"numberings"`
+	DiffFormats []string `json:"diff_formats"`
+}
+
+type formatsResponse struct {
+	Formats []generators.Format `json:"formats"`
+	Kinds   []string            `json:"kinds"`
+	Options optionValues        `json:"options"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// decodeJSON reads the JSON body of r into v, writing the error response when it can't
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		writeError(w, r, http.StatusUnsupportedMediaType, "request body must be application/json")
+		return false
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body is larger than %d bytes", tooLarge.Limit))
+		return false
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %s", err))
+		return false
+	}
+	return true
+}
+
+// OpenAPIHandler serves the OpenAPI 3 document of the JSON API
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// FormatsHandler lists the documentation formats pdfgen builds and the values of every
+// build option
+func FormatsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, formatsResponse{
+		Formats: generators.Formats,
+		Kinds:   jobs.Kinds,
+		Options: optionValues{
+			Documents:   []string{models.DocumentsMerge, models.DocumentsZip},
+			Renderers:   []string{models.RendererLatex, models.RendererHTML},
+			Outputs:     models.Outputs,
+			Papers:      models.Papers,
+			Margins:     models.Margins,
+			Themes:      models.Themes,
+			FontSizes:   generators.FontSizes,
+			Columns:     []int{1, 2},
+			MaxSplit:    generators.MaxSplitDepth,
+			Numberings:  models.Numberings,
+			DiffFormats: generators.DiffFormats,
+		},
+	})
+}
+
+// DetectHandler clones a repo and reports its documentation format and environment
+// without building it
+func (s *Server) DetectHandler(w http.ResponseWriter, r *http.Request) {
+	var req detectRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.URL == "" {
+		writeError(w, r, http.StatusBadRequest, "url is required")
+		return
+	}
+	ctx := withWorkspace(r.Context(), nil)
+	if parts, err := repo.ParseRepoURL(req.URL); err == nil {
+		defer utils.CleanupDir(ctx, s.cfg.Repo, parts)
+	}
+	// clones take longer than server.write_timeout, like builds
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	detection, err := generators.Detect(ctx, s.cfg, req.URL, req.Ref)
+	var stageErr *generators.StageError
+	if errors.As(err, &stageErr) && stageErr.Stage == "validate" {
+		writeAPIError(w, r, http.StatusBadRequest, apiError{Message: err.Error(), Stage: stageErr.Stage})
+		return
+	}
+	if err != nil {
+		e := apiError{Code: codeDetectFailed, Message: err.Error()}
+		if stageErr != nil {
+			e.Stage = stageErr.Stage
+		}
+		writeAPIError(w, r, http.StatusUnprocessableEntity, e)
+		return
+	}
+	writeJSON(w, http.StatusOK, detection)
+}
+
+// CreateJobHandler starts a job in the background and answers with it right away. The
+// job is polled at its Location and cancelled with DELETE.
+func (s *Server) CreateJobHandler(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		writeError(w, r, http.StatusServiceUnavailable, "job history is disabled, jobs can't be followed")
+		return
+	}
+	var req createJobRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Kind == "" {
+		req.Kind = jobs.KindGenerate
+	}
+	build, url, err := s.jobBuild(req)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	end, ok := s.beginBuild(w, r)
+	if !ok {
+		return
+	}
+
+	// the job outlives the request, it only stops when it is cancelled
+	ctx, tracker := s.history.Start(context.WithoutCancel(r.Context()), s.artifacts, req.Kind, auth.User(r.Context()), url, req.Options.buildOptions())
+	ctx = withWorkspace(generators.WithStored(ctx, &generators.Stored{}), tracker)
+	go func() {
+		defer end()
+		response, err := build(ctx)
+		if err == nil && req.Kind == jobs.KindGenerate {
+			// indexed before the job is done, like GenerateHandler does
+			s.indexArtifact(response)
+		}
+		tracker.Finish(response, err)
+		s.chargeStorage(ctx)
+		if err != nil {
+			log.Printf("job %s failed: %s", tracker.ID(), err)
+		}
+	}()
+
+	job, err := s.history.Get(tracker.ID())
+	if err != nil {
+		log.Printf("error reading job %s: %s", tracker.ID(), err)
+		writeError(w, r, http.StatusInternalServerError, "error reading job")
+		return
+	}
+	w.Header().Set("Location", APIPrefix+"/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, newAPIJob(job))
+}
+
+// jobBuild validates req and returns its build along with the url its job records
+func (s *Server) jobBuild(req createJobRequest) (func(context.Context) (models.Artifact, error), string, error) {
+	opts := req.Options.buildOptions()
+	switch req.Kind {
+	case jobs.KindGenerate:
+		parts, err := repo.ParseRepoURL(req.URL)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid url: %s", err)
+		}
+		err = generators.ValidateOptions(opts)
+		if err != nil {
+			return nil, "", err
+		}
+		return func(ctx context.Context) (models.Artifact, error) {
+			// the checkout goes whether the build succeeded, failed, or was cancelled
+			defer utils.CleanupDir(ctx, s.cfg.Repo, parts)
+			return generators.HandleGeneration(ctx, s.cfg, s.artifacts, req.URL, opts)
+		}, req.URL, nil
+
+	case jobs.KindBundle:
+		var items []models.BundleItem
+		var urls []string
+		for _, item := range req.Items {
+			_, err := repo.ParseRepoURL(item.URL)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid url %q: %s", item.URL, err)
+			}
+			items = append(items, models.BundleItem{URL: item.URL, Title: item.Title})
+			urls = append(urls, item.URL)
+		}
+		err := generators.ValidateBundle(items, req.Numbering, opts)
+		if err != nil {
+			return nil, "", err
+		}
+		return func(ctx context.Context) (models.Artifact, error) {
+			return generators.HandleBundle(ctx, s.cfg, s.artifacts, req.Name, items, req.Numbering, opts)
+		}, strings.Join(urls, " "), nil
+
+	case jobs.KindDiff:
+		_, err := repo.ParseRepoURL(req.URL)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid url: %s", err)
+		}
+		err = generators.ValidateDiff(req.Base, req.Head, req.Format, opts)
+		if err != nil {
+			return nil, "", err
+		}
+		return func(ctx context.Context) (models.Artifact, error) {
+			return generators.HandleDiff(ctx, s.cfg, s.artifacts, req.URL, req.Base, req.Head, req.Format, opts)
+		}, req.URL, nil
+	}
+	return nil, "", fmt.Errorf("kind must be one of %s", strings.Join(jobs.Kinds, ", "))
+}
+
+// APIJobsHandler lists jobs like JobsHandler
+func (s *Server) APIJobsHandler(w http.ResponseWriter, r *http.Request) {
+	list, next, ok := s.listJobs(w, r)
+	if !ok {
+		return
+	}
+	response := apiJobsResponse{Jobs: []apiJob{}, Next: next}
+	for _, job := range list {
+		response.Jobs = append(response.Jobs, newAPIJob(job))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) APIJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.findJob(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIJob(job))
+}
+
+// APIArtifactHandler describes a stored artifact, its content is served separately
+func (s *Server) APIArtifactHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !artifactIDPattern.MatchString(id) {
+		writeError(w, r, http.StatusBadRequest, "invalid artifact id")
+		return
+	}
+	artifact, created, ok := cache.Find(s.artifacts, id)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "artifact not found")
+		return
+	}
+	info, err := s.artifacts.Stat(artifact.Key)
+	if err != nil {
+		log.Printf("error reading artifact %s: %s", id, err)
+		writeError(w, r, http.StatusInternalServerError, "error reading artifact")
+		return
+	}
+
+	response := apiArtifact{
+		ID:          artifact.ID,
+		File:        path.Base(artifact.Key),
+		ContentType: artifact.ContentType,
+		Size:        info.Size,
+		Created:     created,
+		Commit:      artifact.Commit,
+		Renderer:    artifact.Renderer,
+		Degraded:    artifact.Degraded,
+		Warnings:    []string{},
+		Documents:   []apiDocument{},
+		Content:     APIPrefix + "/artifacts/" + artifact.ID + "/content",
+	}
+	response.Warnings = append(response.Warnings, artifact.Warnings...)
+	for _, doc := range artifact.Documents {
+		response.Documents = append(response.Documents, apiDocument(doc))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
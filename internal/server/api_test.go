@@ -0,0 +1,337 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jeffbrennan/pdfgen/internal/auth"
+	"github.com/jeffbrennan/pdfgen/internal/cache"
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/jobs"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/store"
+)
+
+type openAPI map[string]any
+
+func loadOpenAPI(t *testing.T) openAPI {
+	var spec openAPI
+	err := json.Unmarshal(openAPISpec, &spec)
+	if err != nil {
+		t.Fatalf("openapi.json is invalid: %s", err)
+	}
+	if !strings.HasPrefix(fmt.Sprint(spec["openapi"]), "3.") {
+		t.Fatalf("expected an OpenAPI 3 document, got %v", spec["openapi"])
+	}
+	return spec
+}
+
+// resolve follows a local $ref like #/components/schemas/Job
+func (spec openAPI) resolve(node map[string]any) map[string]any {
+	ref, ok := node["$ref"].(string)
+	if !ok {
+		return node
+	}
+	var current any = map[string]any(spec)
+	for _, key := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		m, _ := current.(map[string]any)
+		current = m[key]
+	}
+	resolved, ok := current.(map[string]any)
+	if !ok {
+		panic("unresolved $ref " + ref)
+	}
+	return spec.resolve(resolved)
+}
+
+// validate checks v against the parts of JSON schema the document uses
+func (spec openAPI) validate(schema map[string]any, v any, at string) []string {
+	schema = spec.resolve(schema)
+	var errs []string
+	if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, v) {
+		errs = append(errs, fmt.Sprintf("%s: %v is not one of %v", at, v, enum))
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return append(errs, fmt.Sprintf("%s: expected an object, got %T", at, v))
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: missing %s", at, name))
+			}
+		}
+		for name, value := range obj {
+			property, ok := properties[name].(map[string]any)
+			if !ok {
+				if schema["additionalProperties"] == false {
+					errs = append(errs, fmt.Sprintf("%s: undocumented property %s", at, name))
+				}
+				continue
+			}
+			errs = append(errs, spec.validate(property, value, at+"."+name)...)
+		}
+	case "array":
+		list, ok := v.([]any)
+		if !ok {
+			return append(errs, fmt.Sprintf("%s: expected an array, got %T", at, v))
+		}
+		for i, item := range list {
+			errs = append(errs, spec.validate(schema["items"].(map[string]any), item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return append(errs, fmt.Sprintf("%s: expected a string, got %T", at, v))
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(s) {
+			errs = append(errs, fmt.Sprintf("%s: %q doesn't match %s", at, s, pattern))
+		}
+	case "integer":
+		n, ok := v.(float64)
+		if !ok || n != math.Trunc(n) {
+			errs = append(errs, fmt.Sprintf("%s: expected an integer, got %v", at, v))
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			errs = append(errs, fmt.Sprintf("%s: expected a number, got %T", at, v))
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			errs = append(errs, fmt.Sprintf("%s: expected a boolean, got %T", at, v))
+		}
+	}
+	return errs
+}
+
+// operation finds the documented operation serving method on the route template path
+func (spec openAPI) operation(path string, method string) (map[string]any, bool) {
+	item, ok := spec["paths"].(map[string]any)[path].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	op, ok := item[strings.ToLower(method)].(map[string]any)
+	return op, ok
+}
+
+func newAPIRouter(srv *Server) *mux.Router {
+	r := mux.NewRouter()
+	srv.APIRoutes(r.PathPrefix(APIPrefix).Subrouter())
+	r.Use(srv.Protect)
+	return r
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	spec := loadOpenAPI(t)
+	r := newAPIRouter(New(config.Default(), nil, nil, nil, nil))
+
+	routed := map[string]bool{}
+	err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// the prefix and the catch-all for unknown endpoints
+			return nil
+		}
+		for _, method := range methods {
+			routed[method+" "+strings.TrimPrefix(template, APIPrefix)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	documented := map[string]bool{}
+	for path, item := range spec["paths"].(map[string]any) {
+		for method := range item.(map[string]any) {
+			if method != "parameters" {
+				documented[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+
+	for op := range routed {
+		if !documented[op] {
+			t.Errorf("%s is routed but not documented", op)
+		}
+	}
+	for op := range documented {
+		if !routed[op] {
+			t.Errorf("%s is documented but not routed", op)
+		}
+	}
+}
+
+func TestAPI(t *testing.T) {
+	spec := loadOpenAPI(t)
+	dir := t.TempDir()
+	artifacts := store.NewFS(filepath.Join(dir, "artifacts"))
+	history, err := jobs.Open(filepath.Join(dir, "jobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+
+	cfg := config.Default()
+	cfg.Auth = config.AuthConfig{
+		Enabled: true,
+		DB:      filepath.Join(dir, "auth.db"),
+		Tokens: []config.TokenConfig{
+			{User: "ada", Token: "build-token-0123456789", Scopes: []string{auth.ScopeBuild, auth.ScopeReadArtifacts}},
+			{User: "bob", Token: "bob-token-012345678901", Scopes: []string{auth.ScopeBuild}, Quota: config.QuotaConfig{StorageMB: 1}},
+		},
+	}
+	cfg.RateLimit = config.RateLimitConfig{}
+	tokens, err := auth.Open(cfg.Auth, artifacts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tokens.Close()
+
+	pdfPath := filepath.Join(dir, "airflow.pdf")
+	err = os.WriteFile(pdfPath, []byte("%PDF-1.7"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifact, err := cache.Store(artifacts, cache.NewID(), models.Artifact{
+		Path:      pdfPath,
+		Renderer:  models.RendererLatex,
+		Documents: []models.Document{{Name: "index", Title: "Airflow"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bob's token already stored more than its quota allows
+	bob, err := tokens.Authenticate("bob-token-012345678901")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tokens.ChargeStorage(bob, artifact.Key, 2<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, failed := history.Start(context.Background(), artifacts, jobs.KindGenerate, "ada", "https://github.com/apache/airflow", models.BuildOptions{
+		Output: models.OutputPDF,
+		Layout: models.Layout{FontSize: 11},
+	})
+	failed.SetParts(&models.RepoParts{Provider: "github.com", Owner: "apache", Repo: "airflow", Directory: "docs"})
+	failed.Stage("clone")
+	failed.Finish(models.Artifact{}, errors.New("error updating repo"))
+
+	r := newAPIRouter(New(cfg, artifacts, nil, history, tokens))
+
+	var tests = []struct {
+		name        string
+		method      string
+		path        string
+		token       string
+		body        string
+		status      int
+		expected    string
+		contentType string
+	}{
+		{"openapi", http.MethodGet, "/openapi.json", "", "", http.StatusOK, `"openapi"`, ""},
+		{"formats", http.MethodGet, "/formats", "", "", http.StatusOK, `"name":"sphinx"`, ""},
+		{"no token", http.MethodGet, "/jobs", "", "", http.StatusUnauthorized, `"code":"unauthorized"`, ""},
+		{"list jobs", http.MethodGet, "/jobs", "build-token-0123456789", "", http.StatusOK, `"font_size":11`, ""},
+		{"invalid filter", http.MethodGet, "/jobs?status=done", "build-token-0123456789", "", http.StatusBadRequest, `"code":"invalid_request"`, ""},
+		{"failed job", http.MethodGet, "/jobs/" + failed.ID(), "build-token-0123456789", "", http.StatusOK, `"stage":"clone"`, ""},
+		{"invalid job id", http.MethodGet, "/jobs/nope", "build-token-0123456789", "", http.StatusBadRequest, "invalid job id", ""},
+		{"unknown job", http.MethodGet, "/jobs/" + strings.Repeat("0", 24), "build-token-0123456789", "", http.StatusNotFound, `"code":"not_found"`, ""},
+		{"missing scope", http.MethodGet, "/jobs/" + failed.ID(), "bob-token-012345678901", "", http.StatusForbidden, "read-artifacts", ""},
+		{"cancel finished job", http.MethodDelete, "/jobs/" + failed.ID(), "build-token-0123456789", "", http.StatusConflict, "job already failed", ""},
+		{"form body", http.MethodPost, "/jobs", "build-token-0123456789", "url=https://github.com/apache/airflow", http.StatusUnsupportedMediaType, "application/json", "application/x-www-form-urlencoded"},
+		{"unknown field", http.MethodPost, "/jobs", "build-token-0123456789", `{"repo":"airflow"}`, http.StatusBadRequest, "unknown field", ""},
+		{"unknown kind", http.MethodPost, "/jobs", "build-token-0123456789", `{"kind":"print","url":"https://github.com/apache/airflow"}`, http.StatusBadRequest, "kind must be one of", ""},
+		{"invalid url", http.MethodPost, "/jobs", "build-token-0123456789", `{"url":"http://github.com/apache/airflow"}`, http.StatusBadRequest, "invalid url", ""},
+		{"invalid options", http.MethodPost, "/jobs", "build-token-0123456789", `{"url":"https://github.com/apache/airflow","options":{"output":"docx"}}`, http.StatusBadRequest, "unknown output", ""},
+		{"bundle without items", http.MethodPost, "/jobs", "build-token-0123456789", `{"kind":"bundle"}`, http.StatusBadRequest, "at least one url", ""},
+		{"over quota", http.MethodPost, "/jobs", "bob-token-012345678901", `{"url":"https://github.com/apache/airflow"}`, http.StatusTooManyRequests, `"quota":"storage_mb"`, ""},
+		{"detect invalid url", http.MethodPost, "/detect", "build-token-0123456789", `{"url":"https://gitlab.com/apache/airflow"}`, http.StatusBadRequest, `"stage":"validate"`, ""},
+		{"detect without url", http.MethodPost, "/detect", "build-token-0123456789", `{}`, http.StatusBadRequest, "url is required", ""},
+		{"artifact", http.MethodGet, "/artifacts/" + artifact.ID, "build-token-0123456789", "", http.StatusOK, `"title":"Airflow"`, ""},
+		{"invalid artifact id", http.MethodGet, "/artifacts/nope", "build-token-0123456789", "", http.StatusBadRequest, "invalid artifact id", ""},
+		{"unknown artifact", http.MethodGet, "/artifacts/" + strings.Repeat("0", 64), "build-token-0123456789", "", http.StatusNotFound, "artifact not found", ""},
+		{"artifact content", http.MethodGet, "/artifacts/" + artifact.ID + "/content", "build-token-0123456789", "", http.StatusOK, "%PDF-1.7", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, APIPrefix+tt.path, strings.NewReader(tt.body))
+			contentType := tt.contentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			req.Header.Set("Content-Type", contentType)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.expected) {
+				t.Errorf("expected %q in %s", tt.expected, w.Body)
+			}
+
+			var match mux.RouteMatch
+			if !r.Match(req, &match) {
+				t.Fatalf("no route for %s %s", tt.method, tt.path)
+			}
+			template, _ := match.Route.GetPathTemplate()
+			op, ok := spec.operation(strings.TrimPrefix(template, APIPrefix), tt.method)
+			if !ok {
+				t.Fatalf("%s %s is not documented", tt.method, template)
+			}
+			response, ok := op["responses"].(map[string]any)[strconv.Itoa(w.Code)].(map[string]any)
+			if !ok {
+				t.Fatalf("status %d of %s %s is not documented", w.Code, tt.method, template)
+			}
+			response = spec.resolve(response)
+			if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+				t.Errorf("expected a Retry-After header")
+			}
+
+			content, _ := response["content"].(map[string]any)
+			media, ok := content["application/json"].(map[string]any)
+			if !ok {
+				return
+			}
+			if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+				t.Fatalf("expected JSON, got %s", w.Header().Get("Content-Type"))
+			}
+			var body any
+			err := json.Unmarshal(w.Body.Bytes(), &body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range spec.validate(media["schema"].(map[string]any), body, "body") {
+				t.Error(e)
+			}
+		})
+	}
+}
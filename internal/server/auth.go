@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jeffbrennan/pdfgen/internal/auth"
+	"github.com/jeffbrennan/pdfgen/internal/generators"
+)
+
+// Require wraps h so it needs a bearer token granting scope. Every request passes when
+// auth is disabled.
+func (s *Server) Require(scope string, h http.HandlerFunc) http.HandlerFunc {
+	if s.tokens == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, err := s.tokens.Authenticate(bearerToken(r))
+		if errors.Is(err, auth.ErrUnauthorized) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="pdfgen"`)
+			writeError(w, r, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			log.Printf("error authenticating: %s", err)
+			writeError(w, r, http.StatusInternalServerError, "error authenticating")
+			return
+		}
+		if ok, wait := s.tokenLimiter.allow(t.ID, time.Now()); !ok {
+			tooManyRequests(w, r, wait, apiError{Message: "rate limit of token " + t.ID + " exceeded"})
+			return
+		}
+		if !t.Allows(scope) {
+			writeError(w, r, http.StatusForbidden, fmt.Sprintf("token lacks the %s scope", scope))
+			return
+		}
+		h(w, r.WithContext(auth.WithToken(r.Context(), t)))
+	}
+}
+
+// Build wraps a build handler so it needs the build scope and counts against the
+// token's quotas for as long as it runs
+func (s *Server) Build(h http.HandlerFunc) http.HandlerFunc {
+	if s.tokens == nil {
+		return h
+	}
+	return s.Require(auth.ScopeBuild, func(w http.ResponseWriter, r *http.Request) {
+		end, ok := s.beginBuild(w, r)
+		if !ok {
+			return
+		}
+		defer end()
+		h(w, r)
+	})
+}
+
+// beginBuild counts a build against the quotas of the request's token, writing the error
+// response when it is over one. end must be called once the build finished.
+func (s *Server) beginBuild(w http.ResponseWriter, r *http.Request) (end func(), ok bool) {
+	t, authenticated := auth.FromContext(r.Context())
+	if s.tokens == nil || !authenticated {
+		return func() {}, true
+	}
+	end, err := s.tokens.Begin(t)
+	var quotaErr *auth.QuotaError
+	if errors.As(err, &quotaErr) {
+		tooManyRequests(w, r, quotaErr.RetryAfter, apiError{
+			Code:    codeQuotaExceeded,
+			Message: err.Error(),
+			Details: map[string]any{"quota": quotaErr.Name, "limit": quotaErr.Limit},
+		})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("error checking the quota of token %s: %s", t.ID, err)
+		writeError(w, r, http.StatusInternalServerError, "error checking quota")
+		return nil, false
+	}
+	return end, true
+}
+
+// bearerToken reads the Authorization header. EventSource can't set headers, so event
+// streams may pass the token as access_token instead.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if header == "" && r.Header.Get("Accept") == "text/event-stream" {
+		return r.URL.Query().Get("access_token")
+	}
+	return ""
+}
+
+// canSee reports whether the token of r may see the job of user. Admins see every job,
+// everyone else only their own.
+func canSee(r *http.Request, user string) bool {
+	t, ok := auth.FromContext(r.Context())
+	return !ok || t.Allows(auth.ScopeAdmin) || t.User == user
+}
+
+// chargeStorage counts everything the builds of ctx stored against the storage quota of
+// the token of ctx, the parts of bundles and diffs and the builds of failed jobs included
+func (s *Server) chargeStorage(ctx context.Context) {
+	t, ok := auth.FromContext(ctx)
+	stored, hasStored := generators.StoredFromContext(ctx)
+	if !ok || !hasStored || s.tokens == nil {
+		return
+	}
+	for _, key := range stored.Keys() {
+		info, err := s.artifacts.Stat(key)
+		if err == nil {
+			err = s.tokens.ChargeStorage(t, key, info.Size)
+		}
+		if err != nil {
+			log.Printf("error charging %s to token %s: %s", key, t.ID, err)
+		}
+	}
+}
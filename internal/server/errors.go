@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// APIPrefix is where the versioned JSON API is served
+const APIPrefix = "/api/v1"
+
+// error codes of the JSON API, most follow from the status
+const (
+	codeInvalidRequest   = "invalid_request"
+	codeUnauthorized     = "unauthorized"
+	codeForbidden        = "forbidden"
+	codeNotFound         = "not_found"
+	codeConflict         = "conflict"
+	codeTooLarge         = "payload_too_large"
+	codeUnsupportedMedia = "unsupported_media_type"
+	codeRateLimited      = "rate_limited"
+	codeQuotaExceeded    = "quota_exceeded"
+	codeInternal         = "internal"
+	codeUnavailable      = "unavailable"
+	codeDetectFailed     = "detect_failed"
+	codeBuildFailed      = "build_failed"
+	codeCancelled        = "cancelled"
+)
+
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            codeInvalidRequest,
+	http.StatusUnauthorized:          codeUnauthorized,
+	http.StatusForbidden:             codeForbidden,
+	http.StatusNotFound:              codeNotFound,
+	http.StatusConflict:              codeConflict,
+	http.StatusRequestEntityTooLarge: codeTooLarge,
+	http.StatusUnsupportedMediaType:  codeUnsupportedMedia,
+	http.StatusTooManyRequests:       codeRateLimited,
+	http.StatusServiceUnavailable:    codeUnavailable,
+}
+
+// apiError is the body of every error of the JSON API. Stage is the build stage that
+// failed, Details holds whatever else the code calls for.
+type apiError struct {
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Stage   string         `json:"stage,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+func isAPI(r *http.Request) bool {
+	return r.URL.Path == APIPrefix || strings.HasPrefix(r.URL.Path, APIPrefix+"/")
+}
+
+// writeError answers with status and message, as text outside of the JSON API
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	writeAPIError(w, r, status, apiError{Message: message})
+}
+
+// writeAPIError answers with e, its code defaults to the one of status. Outside of the
+// JSON API only the message is sent.
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, e apiError) {
+	if !isAPI(r) {
+		http.Error(w, e.Message, status)
+		return
+	}
+	if e.Code == "" {
+		e.Code = statusCodes[status]
+	}
+	if e.Code == "" {
+		e.Code = codeInternal
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(e)
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jeffbrennan/pdfgen/internal/auth"
+	"github.com/jeffbrennan/pdfgen/internal/jobs"
+	"github.com/jeffbrennan/pdfgen/internal/store"
+	"github.com/jeffbrennan/pdfgen/internal/utils"
+)
+
+type jobsResponse struct {
+	Jobs []jobs.Job `json:"jobs"`
+	// Next is passed as before to get the next page, empty on the last one
+	Next string `json:"next"`
+}
+
+// JobsHandler lists past and running jobs, newest first. status, kind, user, and repo
+// (owner/repo) filter the list, limit and before page through it.
+func (s *Server) JobsHandler(w http.ResponseWriter, r *http.Request) {
+	list, next, ok := s.listJobs(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobsResponse{Jobs: list, Next: next})
+}
+
+// listJobs reads the filter of r and lists its page of jobs, writing the error response
+// when it can't
+func (s *Server) listJobs(w http.ResponseWriter, r *http.Request) ([]jobs.Job, string, bool) {
+	if s.history == nil {
+		writeError(w, r, http.StatusNotFound, "job history is disabled")
+		return nil, "", false
+	}
+
+	filter := jobs.Filter{
+		Status: r.FormValue("status"),
+		Kind:   r.FormValue("kind"),
+		User:   r.FormValue("user"),
+		Repo:   r.FormValue("repo"),
+		Before: r.FormValue("before"),
+	}
+	// only admins see the jobs of others
+	if t, ok := auth.FromContext(r.Context()); ok && !t.Allows(auth.ScopeAdmin) {
+		filter.User = t.User
+	}
+	if filter.Status != "" && !utils.Contains(jobs.Statuses, filter.Status) {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("status must be one of %s", strings.Join(jobs.Statuses, ", ")))
+		return nil, "", false
+	}
+	if filter.Kind != "" && !utils.Contains(jobs.Kinds, filter.Kind) {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("kind must be one of %s", strings.Join(jobs.Kinds, ", ")))
+		return nil, "", false
+	}
+	if filter.Before != "" && !jobs.IDPattern.MatchString(filter.Before) {
+		writeError(w, r, http.StatusBadRequest, "invalid before cursor")
+		return nil, "", false
+	}
+	limit, err := formInt(r, "limit")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return nil, "", false
+	}
+	filter.Limit = limit
+
+	list, next, err := s.history.List(filter)
+	if err != nil {
+		log.Printf("error listing jobs: %s", err)
+		writeError(w, r, http.StatusInternalServerError, "error listing jobs")
+		return nil, "", false
+	}
+	return list, next, true
+}
+
+// JobHandler returns a single job
+func (s *Server) JobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.findJob(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// JobLogHandler returns the log transcript of a finished job
+func (s *Server) JobLogHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.findJob(w, r)
+	if !ok {
+		return
+	}
+	if job.Log == "" {
+		writeError(w, r, http.StatusNotFound, "job has no log")
+		return
+	}
+
+	f, info, err := s.artifacts.Get(job.Log)
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, "job log was pruned")
+		return
+	}
+	if err != nil {
+		log.Printf("error reading log of job %s: %s", job.ID, err)
+		writeError(w, r, http.StatusInternalServerError, "error reading job log")
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", info.ContentType)
+	http.ServeContent(w, r, "", info.ModTime, f)
+}
+
+// CancelJobHandler stops a running job, killing its commands. The job is recorded as
+// cancelled once its build has stopped.
+func (s *Server) CancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.findJob(w, r)
+	if !ok {
+		return
+	}
+	if job.Status != jobs.StatusRunning {
+		writeError(w, r, http.StatusConflict, fmt.Sprintf("job already %s", job.Status))
+		return
+	}
+
+	err := s.history.Cancel(job.ID)
+	if err != nil {
+		writeError(w, r, http.StatusConflict, err.Error())
+		return
+	}
+	log.Printf("cancelled job %s", job.ID)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// findJob looks up the job named in the path, writing the error response when it can't
+func (s *Server) findJob(w http.ResponseWriter, r *http.Request) (jobs.Job, bool) {
+	if s.history == nil {
+		writeError(w, r, http.StatusNotFound, "job history is disabled")
+		return jobs.Job{}, false
+	}
+
+	id := mux.Vars(r)["id"]
+	if !jobs.IDPattern.MatchString(id) {
+		writeError(w, r, http.StatusBadRequest, "invalid job id")
+		return jobs.Job{}, false
+	}
+	job, err := s.history.Get(id)
+	if err == nil && !canSee(r, job.User) {
+		err = jobs.ErrNotFound
+	}
+	if errors.Is(err, jobs.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, err.Error())
+		return jobs.Job{}, false
+	}
+	if err != nil {
+		log.Printf("error reading job %s: %s", id, err)
+		writeError(w, r, http.StatusInternalServerError, "error reading job")
+		return jobs.Job{}, false
+	}
+	return job, true
+}
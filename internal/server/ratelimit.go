@@ -0,0 +1,167 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// StaticRoute names the route of the static files, which isn't rate limited
+const StaticRoute = "static"
+
+// limiter is a token bucket per key, refilled at rate tokens a second up to burst. A nil
+// limiter allows everything.
+type limiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(perMinute float64, burst int) *limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &limiter{rate: perMinute / 60, burst: float64(burst), buckets: map[string]*bucket{}}
+}
+
+// allow takes a token from the bucket of key. An empty bucket returns how long until
+// the next token.
+func (l *limiter) allow(key string, now time.Time) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep drops the buckets that refilled, they are no different from new ones
+func (l *limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// tooManyRequests answers 429 with e, telling the client when to retry in whole seconds
+func tooManyRequests(w http.ResponseWriter, r *http.Request, wait time.Duration, e apiError) {
+	seconds := max(1, int(math.Ceil(wait.Seconds())))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	e.Message = fmt.Sprintf("%s, retry in %ds", e.Message, seconds)
+	if e.Details == nil {
+		e.Details = map[string]any{}
+	}
+	e.Details["retry_after"] = seconds
+	writeAPIError(w, r, http.StatusTooManyRequests, e)
+}
+
+// clientIP is the address the request came from, or what the proxy in front saw
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		forwarded := r.Header.Values("X-Forwarded-For")
+		if len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			ip := strings.TrimSpace(hops[len(hops)-1])
+			if net.ParseIP(ip) != nil {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Protect is the middleware of every route: it bounds request bodies and rate limits
+// each client IP, except for the static files
+func (s *Server) Protect(next http.Handler) http.Handler {
+	maxBody := int64(s.cfg.Server.MaxBodyKB) << 10
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil && maxBody > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+		}
+
+		if !isStatic(r) {
+			ok, wait := s.ipLimiter.allow(clientIP(r, s.cfg.RateLimit.TrustProxy), time.Now())
+			if !ok {
+				tooManyRequests(w, r, wait, apiError{Message: "rate limit exceeded"})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isStatic(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	return route != nil && route.GetName() == StaticRoute
+}
+
+// openStream counts a log stream against the stream caps. The returned func closes it
+// again, ok is false when the request was rejected.
+func (s *Server) openStream(w http.ResponseWriter, r *http.Request) (func(), bool) {
+	ip := clientIP(r, s.cfg.RateLimit.TrustProxy)
+	maxStreams, maxPerIP := s.cfg.Server.MaxStreams, s.cfg.Server.MaxStreamsPerIP
+
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	if maxStreams > 0 && s.streams >= maxStreams {
+		tooManyRequests(w, r, streamRetry, apiError{Message: "too many log streams"})
+		return nil, false
+	}
+	if maxPerIP > 0 && s.streamsByIP[ip] >= maxPerIP {
+		tooManyRequests(w, r, streamRetry, apiError{Message: "too many log streams from " + ip})
+		return nil, false
+	}
+	s.streams++
+	s.streamsByIP[ip]++
+
+	return func() {
+		s.streamsMu.Lock()
+		defer s.streamsMu.Unlock()
+		s.streams--
+		s.streamsByIP[ip]--
+		if s.streamsByIP[ip] <= 0 {
+			delete(s.streamsByIP, ip)
+		}
+	}, true
+}
+
+// streamRetry is when a rejected log stream should try again
+const streamRetry = 10 * time.Second
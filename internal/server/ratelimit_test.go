@@ -0,0 +1,159 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jeffbrennan/pdfgen/internal/config"
+)
+
+func TestLimiter(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		name       string
+		perMinute  float64
+		burst      int
+		requests   []time.Duration
+		shouldPass []bool
+		retry      time.Duration
+	}{
+		{"within burst", 60, 3, []time.Duration{0, 0, 0}, []bool{true, true, true}, 0},
+		{"over burst", 60, 2, []time.Duration{0, 0, 0}, []bool{true, true, false}, time.Second},
+		{"refills", 60, 1, []time.Duration{0, 0, time.Second}, []bool{true, false, true}, 0},
+		{"refills at rate", 30, 1, []time.Duration{0, time.Second}, []bool{true, false}, time.Second},
+		{"disabled", 0, 0, []time.Duration{0, 0, 0}, []bool{true, true, true}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLimiter(tt.perMinute, tt.burst)
+			var retry time.Duration
+			for i, offset := range tt.requests {
+				var ok bool
+				ok, retry = l.allow("1.2.3.4", start.Add(offset))
+				if ok != tt.shouldPass[i] {
+					t.Fatalf("request %d: expected %v, got %v", i, tt.shouldPass[i], ok)
+				}
+			}
+			if retry != tt.retry {
+				t.Errorf("expected to retry in %s, got %s", tt.retry, retry)
+			}
+			if ok, _ := l.allow("5.6.7.8", start); !ok {
+				t.Errorf("expected other keys to have their own bucket")
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	var tests = []struct {
+		name       string
+		forwarded  []string
+		trustProxy bool
+		expected   string
+	}{
+		{"remote addr", nil, false, "10.0.0.1"},
+		{"untrusted proxy", []string{"1.2.3.4"}, false, "10.0.0.1"},
+		{"trusted proxy", []string{"1.2.3.4"}, true, "1.2.3.4"},
+		{"last hop", []string{"6.6.6.6, 1.2.3.4"}, true, "1.2.3.4"},
+		{"last header", []string{"6.6.6.6", "1.2.3.4"}, true, "1.2.3.4"},
+		{"invalid", []string{"nope"}, true, "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "10.0.0.1:4321"
+			for _, v := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if ip := clientIP(req, tt.trustProxy); ip != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, ip)
+			}
+		})
+	}
+}
+
+func TestProtect(t *testing.T) {
+	cfg := config.Default()
+	cfg.RateLimit = config.RateLimitConfig{PerIP: 1, Burst: 2}
+	cfg.Server.MaxBodyKB = 1
+	srv := New(cfg, nil, nil, nil, nil)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/form", func(w http.ResponseWriter, r *http.Request) {
+		if parseForm(w, r) {
+			io.WriteString(w, r.FormValue("url"))
+		}
+	})
+	r.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "static")
+	}).Name(StaticRoute)
+	r.Use(srv.Protect)
+
+	var tests = []struct {
+		name   string
+		path   string
+		ip     string
+		body   string
+		status int
+	}{
+		{"form", "/form", "1.2.3.4", "url=docs", http.StatusOK},
+		{"body too large", "/form", "1.2.3.4", "url=" + strings.Repeat("x", 1024), http.StatusRequestEntityTooLarge},
+		{"rate limited", "/form", "1.2.3.4", "url=docs", http.StatusTooManyRequests},
+		{"static files", "/index.html", "1.2.3.4", "", http.StatusOK},
+		{"other ip", "/form", "5.6.7.8", "url=docs", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.RemoteAddr = tt.ip + ":4321"
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body)
+			}
+			if tt.status == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "60" {
+				t.Errorf("expected Retry-After 60, got %q", w.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestOpenStream(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.MaxStreams = 2
+	cfg.Server.MaxStreamsPerIP = 1
+	srv := New(cfg, nil, nil, nil, nil)
+
+	open := func(ip string) (func(), int) {
+		req := httptest.NewRequest(http.MethodGet, "/stream-logs", nil)
+		req.RemoteAddr = ip + ":4321"
+		w := httptest.NewRecorder()
+		release, ok := srv.openStream(w, req)
+		if !ok && w.Header().Get("Retry-After") == "" {
+			t.Errorf("expected a Retry-After on rejected streams")
+		}
+		return release, w.Code
+	}
+
+	release, _ := open("1.2.3.4")
+	if _, code := open("1.2.3.4"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the per ip cap, got %d", code)
+	}
+	open("5.6.7.8")
+	if _, code := open("9.9.9.9"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the global cap, got %d", code)
+	}
+	release()
+	if _, code := open("1.2.3.4"); code != http.StatusOK {
+		t.Errorf("expected a closed stream to free its slot, got %d", code)
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+
+	"github.com/gorilla/mux"
+	"github.com/jeffbrennan/pdfgen/internal/cache"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/pdf"
+	"github.com/jeffbrennan/pdfgen/internal/search"
+	"github.com/jeffbrennan/pdfgen/internal/store"
+)
+
+var artifactIDPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+type searchHit struct {
+	search.Hit
+	// Link opens the artifact at the hit's page
+	Link string `json:"link"`
+}
+
+type searchResponse struct {
+	Query string      `json:"query"`
+	Hits  []searchHit `json:"hits"`
+}
+
+// SearchHandler finds pages of previously built PDFs containing every word of q
+func (s *Server) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if s.index == nil {
+		http.Error(w, "search is disabled", http.StatusNotFound)
+		return
+	}
+
+	q := r.FormValue("q")
+	if q == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	limit, err := formInt(r, "limit")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hits, err := s.index.Search(q, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := searchResponse{Query: q, Hits: []searchHit{}}
+	for _, hit := range hits {
+		// artifacts are pruned from the store, their pages go with them
+		if _, _, ok := cache.Find(s.artifacts, hit.Key); !ok {
+			err = s.index.Remove(hit.Key)
+			if err != nil {
+				log.Printf("error removing %s from the search index: %s", hit.Key, err)
+			}
+			continue
+		}
+		response.Hits = append(response.Hits, searchHit{
+			Hit:  hit,
+			Link: fmt.Sprintf("/artifacts/%s#page=%d", hit.Key, hit.Page),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ArtifactHandler serves a stored artifact by its id, inline so browsers honor #page=
+func (s *Server) ArtifactHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !artifactIDPattern.MatchString(id) {
+		writeError(w, r, http.StatusBadRequest, "invalid artifact id")
+		return
+	}
+	artifact, _, ok := cache.Find(s.artifacts, id)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "artifact not found")
+		return
+	}
+	s.serveArtifact(w, r, artifact, "inline")
+}
+
+// indexArtifact adds a stored PDF's pages to the search index. It reads the stored
+// copy, so it can run after the checkout is cleaned up.
+func (s *Server) indexArtifact(response models.Artifact) {
+	if s.index == nil || response.Extension != ".pdf" || s.index.Has(response.ID) {
+		return
+	}
+
+	tmp, err := os.MkdirTemp("", "pdfgen-index-")
+	if err != nil {
+		log.Printf("error indexing %s: %s", response.ID, err)
+		return
+	}
+	defer os.RemoveAll(tmp)
+	pdfPath := filepath.Join(tmp, path.Base(response.Key))
+	err = store.Download(s.artifacts, response.Key, pdfPath)
+	if err != nil {
+		log.Printf("error indexing %s: %s", response.ID, err)
+		return
+	}
+
+	pages, err := pdf.PageTexts(context.Background(), pdfPath)
+	if err != nil {
+		log.Printf("error indexing %s: %s", response.ID, err)
+		return
+	}
+
+	doc := search.Document{
+		Key:       response.ID,
+		Repo:      response.Parts.Owner + "/" + response.Parts.Repo,
+		Ref:       response.Parts.Branch,
+		Commit:    response.Commit,
+		Directory: response.Parts.Directory,
+		Title:     response.Parts.Repo,
+	}
+	if len(response.Documents) == 1 && response.Documents[0].Title != "" {
+		doc.Title = response.Documents[0].Title
+	}
+
+	err = s.index.Add(doc, pages)
+	if err != nil {
+		log.Printf("error indexing %s: %s", response.ID, err)
+		return
+	}
+	log.Printf("indexed %d pages of %s", len(pages), doc.Repo)
+}
@@ -1,42 +1,211 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"path/filepath"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/jeffbrennan/pdfgen/internal/auth"
+	"github.com/jeffbrennan/pdfgen/internal/config"
 	"github.com/jeffbrennan/pdfgen/internal/generators"
+	"github.com/jeffbrennan/pdfgen/internal/jobs"
 	"github.com/jeffbrennan/pdfgen/internal/logging"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/repo"
+	"github.com/jeffbrennan/pdfgen/internal/search"
+	"github.com/jeffbrennan/pdfgen/internal/store"
 	"github.com/jeffbrennan/pdfgen/internal/utils"
 )
 
-func StreamLogsHandler(w http.ResponseWriter, r *http.Request) {
+// errDisconnected cancels the builds of clients that went away
+var errDisconnected = errors.New("client disconnected")
+
+type Server struct {
+	cfg       *config.Config
+	artifacts store.ArtifactStore
+	// index is nil when search is disabled
+	index *search.Index
+	// history is nil when the job history is disabled
+	history *jobs.DB
+	// tokens is nil when auth is disabled
+	tokens *auth.DB
+
+	ipLimiter    *limiter
+	tokenLimiter *limiter
+
+	streamsMu   sync.Mutex
+	streams     int
+	streamsByIP map[string]int
+}
+
+func New(cfg *config.Config, artifacts store.ArtifactStore, index *search.Index, history *jobs.DB, tokens *auth.DB) *Server {
+	return &Server{
+		cfg:          cfg,
+		artifacts:    artifacts,
+		index:        index,
+		history:      history,
+		tokens:       tokens,
+		ipLimiter:    newLimiter(cfg.RateLimit.PerIP, cfg.RateLimit.Burst),
+		tokenLimiter: newLimiter(cfg.RateLimit.PerToken, cfg.RateLimit.Burst),
+		streamsByIP:  map[string]int{},
+	}
+}
+
+func (s *Server) StreamLogsHandler(w http.ResponseWriter, r *http.Request) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
 		return
 	}
 
+	release, ok := s.openStream(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 
+	// the stream outlives server.write_timeout, each write gets its own deadline instead
+	rc := http.NewResponseController(w)
+	write := func(format string, args ...any) bool {
+		if s.cfg.Server.WriteTimeout > 0 {
+			rc.SetWriteDeadline(time.Now().Add(s.cfg.Server.WriteTimeout))
+		}
+		_, err := fmt.Fprintf(w, format, args...)
+		flusher.Flush()
+		return err == nil
+	}
+
+	var expired <-chan time.Time
+	if s.cfg.Server.StreamMaxAge > 0 {
+		timer := time.NewTimer(s.cfg.Server.StreamMaxAge)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	// comments keep idle proxies from closing the stream and notice clients that left
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+
 	for {
+		var ok bool
 		select {
 		case msg := <-logging.LogChannel:
-			fmt.Fprintf(w, "data: %s\n\n", msg)
-			flusher.Flush()
+			ok = write("data: %s\n\n", msg)
+		case <-keepalive.C:
+			ok = write(": ping\n\n")
+		case <-expired:
+			return
 		case <-r.Context().Done():
 			return
 		}
+		if !ok {
+			return
+		}
 	}
 }
 
-func GeneratePDFHandler(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
+// streamKeepalive is how often an idle log stream is pinged
+const streamKeepalive = 30 * time.Second
+
+// GenerateHandler builds the docs behind url and returns the requested output format
+func (s *Server) GenerateHandler(w http.ResponseWriter, r *http.Request) {
+	if !parseForm(w, r) {
+		return
+	}
+
+	url := r.FormValue("url")
+	if url == "" {
+		http.Error(w, "URL is required", http.StatusBadRequest)
+		return
+	}
+
+	opts, err := formOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, tracker := s.startJob(w, r, jobs.KindGenerate, url, opts)
+	// the checkout goes whether the build succeeded, failed, or was cancelled
+	if parts, err := repo.ParseRepoURL(url); err == nil {
+		defer utils.CleanupDir(ctx, s.cfg.Repo, parts)
+	}
+	response, err := generators.HandleGeneration(ctx, s.cfg, s.artifacts, url, opts)
+	if err == nil {
+		// indexed before the job is done, so a finished job can be searched
+		s.indexArtifact(response)
+	}
+	tracker.Finish(response, err)
+	s.chargeStorage(ctx)
+	if err != nil {
+		log.Printf("Error generating output: %v", err)
+		http.Error(w, fmt.Sprintf("generation failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.serveArtifact(w, r, response, "attachment")
+}
+
+// BundleHandler builds several urls into one PDF. The url and title fields repeat, the
+// n-th title names the n-th url's part
+func (s *Server) BundleHandler(w http.ResponseWriter, r *http.Request) {
+	if !parseForm(w, r) {
+		return
+	}
+
+	urls := r.Form["url"]
+	titles := r.Form["title"]
+	if len(urls) == 0 {
+		http.Error(w, "URL is required", http.StatusBadRequest)
+		return
+	}
+	if len(titles) > len(urls) {
+		http.Error(w, "more titles than urls", http.StatusBadRequest)
+		return
+	}
+
+	var items []models.BundleItem
+	for i, url := range urls {
+		item := models.BundleItem{URL: url}
+		if i < len(titles) {
+			item.Title = titles[i]
+		}
+		items = append(items, item)
+	}
+
+	opts, err := formOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, tracker := s.startJob(w, r, jobs.KindBundle, strings.Join(urls, " "), opts)
+	response, err := generators.HandleBundle(ctx, s.cfg, s.artifacts, r.FormValue("name"), items, r.FormValue("numbering"), opts)
+	tracker.Finish(response, err)
+	s.chargeStorage(ctx)
 	if err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		log.Printf("Error generating bundle: %v", err)
+		http.Error(w, fmt.Sprintf("generation failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.serveArtifact(w, r, response, "attachment")
+}
+
+// DiffHandler builds the url at the base and head refs and returns the changes between
+// them as a report or an annotated PDF
+func (s *Server) DiffHandler(w http.ResponseWriter, r *http.Request) {
+	if !parseForm(w, r) {
 		return
 	}
 
@@ -46,19 +215,165 @@ func GeneratePDFHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response, err := generators.HandlePdfGeneration(url)
+	opts, err := formOptions(r)
 	if err != nil {
-		log.Printf("Error generating PDF: %v", err)
-		http.Error(w, fmt.Sprintf("PDF generation failed: %v", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	fileName := filepath.Base(response.PdfPath)
+	ctx, tracker := s.startJob(w, r, jobs.KindDiff, url, opts)
+	response, err := generators.HandleDiff(ctx, s.cfg, s.artifacts, url, r.FormValue("base"), r.FormValue("head"), r.FormValue("format"), opts)
+	tracker.Finish(response, err)
+	s.chargeStorage(ctx)
+	if err != nil {
+		log.Printf("Error generating diff: %v", err)
+		http.Error(w, fmt.Sprintf("diff failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	s.serveArtifact(w, r, response, "attachment")
+}
 
-	w.Header().Set("Content-Type", "application/pdf")
-	w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
-	w.WriteHeader(http.StatusOK)
-	w.Write(response.PdfBytes)
+// startJob records the request in the job history and sends its id in X-Pdfgen-Job.
+// The build is cancelled when the client disconnects or DELETE /jobs/{id} is called.
+// The build checks repos out in a workspace named after the job, and what it stores is
+// charged to the token of the request. The tracker is nil when the history is disabled.
+func (s *Server) startJob(w http.ResponseWriter, r *http.Request, kind string, url string, opts models.BuildOptions) (context.Context, *jobs.Tracker) {
+	// builds take longer than server.write_timeout, the deadline only bounds other requests
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(r.Context()))
+	context.AfterFunc(r.Context(), func() { cancel(errDisconnected) })
+
+	ctx, tracker := s.history.Start(ctx, s.artifacts, kind, auth.User(r.Context()), url, opts)
+	if tracker != nil {
+		w.Header().Set("X-Pdfgen-Job", tracker.ID())
+	}
+	ctx = generators.WithStored(ctx, &generators.Stored{})
+	return withWorkspace(ctx, tracker), tracker
+}
+
+// withWorkspace gives the builds of a job checkouts of their own, so concurrent jobs of
+// the same repo don't clean up or re-pin each other's checkout. Jobs outside the
+// history get a fresh id.
+func withWorkspace(ctx context.Context, tracker *jobs.Tracker) context.Context {
+	id := tracker.ID()
+	if id == "" {
+		id = jobs.NewID()
+	}
+	return utils.WithWorkspace(ctx, id)
+}
+
+// serveArtifact streams an artifact from the store. http.ServeContent answers Range
+// requests, so large downloads can resume.
+func (s *Server) serveArtifact(w http.ResponseWriter, r *http.Request, response models.Artifact, disposition string) {
+	f, info, err := s.artifacts.Get(response.Key)
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, "artifact not found")
+		return
+	}
+	if err != nil {
+		log.Printf("error reading artifact %s: %v", response.Key, err)
+		writeError(w, r, http.StatusInternalServerError, "error reading artifact")
+		return
+	}
+	defer f.Close()
+
+	fileName := path.Base(response.Key)
+	var docNames []string
+	for _, doc := range response.Documents {
+		docNames = append(docNames, doc.Name)
+	}
+
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("X-Pdfgen-Documents", strings.Join(docNames, ","))
+	w.Header().Set("X-Pdfgen-Renderer", response.Renderer)
+	w.Header().Set("X-Pdfgen-Artifact", response.ID)
+	w.Header().Set("Content-Disposition", disposition+"; filename="+fileName)
+	if response.Degraded {
+		w.Header().Set("X-Pdfgen-Degraded", "true")
+		for _, warning := range response.Warnings {
+			w.Header().Add("X-Pdfgen-Warning", warning)
+		}
+	}
+	http.ServeContent(w, r, fileName, info.ModTime, f)
+}
+
+// parseForm parses the form of r, answering 413 when the body is over server.max_body_kb
+func parseForm(w http.ResponseWriter, r *http.Request) bool {
+	err := r.ParseForm()
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body is larger than %d bytes", tooLarge.Limit))
+		return false
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid form data")
+		return false
+	}
+	return true
+}
+
+// formOptions reads the build options shared by every endpoint
+func formOptions(r *http.Request) (models.BuildOptions, error) {
+	layout, err := formLayout(r)
+	if err != nil {
+		return models.BuildOptions{}, err
+	}
+	split, err := formInt(r, "split")
+	if err != nil {
+		return models.BuildOptions{}, err
+	}
+
+	return models.BuildOptions{
+		Documents: r.FormValue("documents"),
+		Renderer:  r.FormValue("renderer"),
+		Output:    r.FormValue("output"),
+		Cover:     formBool(r, "cover"),
+		Headers:   formBool(r, "headers"),
+		Layout:    layout,
+		Split:     split,
+		Ref:       r.FormValue("ref"),
+	}, nil
+}
+
+// formBool reads a checkbox style field, "on" is what browsers send for a checked box
+func formBool(r *http.Request, key string) bool {
+	switch strings.ToLower(r.FormValue(key)) {
+	case "on", "true", "1", "yes":
+		return true
+	}
+	return false
+}
+
+func formInt(r *http.Request, key string) (int, error) {
+	v := r.FormValue(key)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number, got %q", key, v)
+	}
+	return n, nil
+}
+
+func formLayout(r *http.Request) (models.Layout, error) {
+	fontSize, err := formInt(r, "font_size")
+	if err != nil {
+		return models.Layout{}, err
+	}
+	columns, err := formInt(r, "columns")
+	if err != nil {
+		return models.Layout{}, err
+	}
 
-	utils.CleanupDir(response.Parts)
+	return models.Layout{
+		Paper:       r.FormValue("paper"),
+		Margin:      r.FormValue("margin"),
+		FontSize:    fontSize,
+		Columns:     columns,
+		LineNumbers: formBool(r, "line_numbers"),
+		Theme:       r.FormValue("theme"),
+	}, nil
 }
@@ -0,0 +1,313 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jeffbrennan/pdfgen/internal/auth"
+	"github.com/jeffbrennan/pdfgen/internal/cache"
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/generators"
+	"github.com/jeffbrennan/pdfgen/internal/jobs"
+	"github.com/jeffbrennan/pdfgen/internal/logging"
+	"github.com/jeffbrennan/pdfgen/internal/models"
+	"github.com/jeffbrennan/pdfgen/internal/store"
+)
+
+func TestArtifactHandler(t *testing.T) {
+	dir := t.TempDir()
+	pdfPath := filepath.Join(dir, "airflow.pdf")
+	content := "%PDF-1.7 " + strings.Repeat("x", 100)
+	err := os.WriteFile(pdfPath, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	artifacts := store.NewFS(filepath.Join(dir, "artifacts"))
+	id := cache.NewID()
+	_, err = cache.Store(artifacts, id, models.Artifact{Path: pdfPath, Renderer: models.RendererLatex})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := New(config.Default(), artifacts, nil, nil, nil)
+	r := mux.NewRouter()
+	r.HandleFunc("/artifacts/{id}", srv.ArtifactHandler)
+
+	var tests = []struct {
+		name     string
+		id       string
+		rng      string
+		status   int
+		expected string
+	}{
+		{"whole file", id, "", http.StatusOK, content},
+		{"resumed download", id, "bytes=5-", http.StatusPartialContent, content[5:]},
+		{"range", id, "bytes=0-3", http.StatusPartialContent, "%PDF"},
+		{"unknown id", cache.NewID(), "", http.StatusNotFound, ""},
+		{"malformed id", "abc.pdf", "", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/artifacts/"+tt.id, nil)
+			if tt.rng != "" {
+				req.Header.Set("Range", tt.rng)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body)
+			}
+			if tt.expected == "" {
+				return
+			}
+			body, _ := io.ReadAll(w.Body)
+			if string(body) != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, body)
+			}
+			if w.Header().Get("Content-Type") != "application/pdf" || w.Header().Get("Accept-Ranges") != "bytes" ||
+				w.Header().Get("Content-Disposition") != "inline; filename=airflow.pdf" {
+				t.Errorf("unexpected headers %v", w.Header())
+			}
+		})
+	}
+}
+
+func TestJobsHandler(t *testing.T) {
+	dir := t.TempDir()
+	history, err := jobs.Open(filepath.Join(dir, "jobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+
+	artifacts := store.NewFS(filepath.Join(dir, "artifacts"))
+	ctx, tracker := history.Start(context.Background(), artifacts, jobs.KindGenerate, "", "https://github.com/apache/airflow", models.BuildOptions{})
+	logging.PublishLog(ctx, "Generating PDF...")
+	tracker.Finish(models.Artifact{}, nil)
+	running, runningTracker := history.Start(context.Background(), artifacts, jobs.KindDiff, "", "https://github.com/apache/airflow", models.BuildOptions{})
+	defer runningTracker.Finish(models.Artifact{}, nil)
+
+	srv := New(config.Default(), artifacts, nil, history, nil)
+	r := mux.NewRouter()
+	r.HandleFunc("/jobs", srv.JobsHandler)
+	r.HandleFunc("/jobs/{id}", srv.JobHandler).Methods(http.MethodGet)
+	r.HandleFunc("/jobs/{id}/log", srv.JobLogHandler)
+	r.HandleFunc("/jobs/{id}", srv.CancelJobHandler).Methods(http.MethodDelete)
+
+	var tests = []struct {
+		name     string
+		method   string
+		path     string
+		status   int
+		expected string
+	}{
+		{"list", http.MethodGet, "/jobs?kind=generate&status=succeeded", http.StatusOK, tracker.ID()},
+		{"filtered out", http.MethodGet, "/jobs?kind=bundle", http.StatusOK, `"jobs":[]`},
+		{"unknown status", http.MethodGet, "/jobs?status=done", http.StatusBadRequest, ""},
+		{"malformed cursor", http.MethodGet, "/jobs?before=abc", http.StatusBadRequest, ""},
+		{"job", http.MethodGet, "/jobs/" + tracker.ID(), http.StatusOK, `"status":"succeeded"`},
+		{"log", http.MethodGet, "/jobs/" + tracker.ID() + "/log", http.StatusOK, "Generating PDF..."},
+		{"unknown job", http.MethodGet, "/jobs/" + jobs.NewID(), http.StatusNotFound, ""},
+		{"malformed id", http.MethodGet, "/jobs/abc", http.StatusBadRequest, ""},
+		{"cancel finished job", http.MethodDelete, "/jobs/" + tracker.ID(), http.StatusConflict, "job already succeeded"},
+		{"cancel running job", http.MethodDelete, "/jobs/" + runningTracker.ID(), http.StatusAccepted, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.expected) {
+				t.Errorf("expected %q in %s", tt.expected, w.Body)
+			}
+		})
+	}
+
+	if running.Err() == nil {
+		t.Errorf("expected the running job's context to be cancelled")
+	}
+}
+
+func TestAuth(t *testing.T) {
+	dir := t.TempDir()
+	artifacts := store.NewFS(filepath.Join(dir, "artifacts"))
+	history, err := jobs.Open(filepath.Join(dir, "jobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+
+	cfg := config.Default()
+	cfg.Auth = config.AuthConfig{
+		Enabled: true,
+		DB:      filepath.Join(dir, "auth.db"),
+		Tokens: []config.TokenConfig{
+			{User: "ops", Token: "admin-token-0123456789", Scopes: []string{auth.ScopeAdmin}},
+			{User: "ada", Token: "build-token-0123456789", Scopes: []string{auth.ScopeBuild}, Quota: config.QuotaConfig{BuildsPerDay: 1}},
+			{User: "bob", Token: "read-token-01234567890", Scopes: []string{auth.ScopeReadArtifacts}},
+		},
+	}
+	tokens, err := auth.Open(cfg.Auth, artifacts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tokens.Close()
+
+	_, adaJob := history.Start(context.Background(), artifacts, jobs.KindGenerate, "ada", "https://github.com/apache/airflow", models.BuildOptions{})
+	adaJob.Finish(models.Artifact{}, nil)
+
+	srv := New(cfg, artifacts, nil, history, tokens)
+	r := mux.NewRouter()
+	r.HandleFunc("/build", srv.Build(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "built for "+auth.User(r.Context()))
+	}))
+	r.HandleFunc("/jobs", srv.Require(auth.ScopeReadArtifacts, srv.JobsHandler))
+	r.HandleFunc("/jobs/{id}", srv.Require(auth.ScopeReadArtifacts, srv.JobHandler))
+	r.HandleFunc("/tokens", srv.Require(auth.ScopeAdmin, srv.TokensHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/tokens", srv.Require(auth.ScopeAdmin, srv.CreateTokenHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/tokens/{id}", srv.Require(auth.ScopeAdmin, srv.RevokeTokenHandler)).Methods(http.MethodDelete)
+
+	do := func(method string, path string, token string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	var tests = []struct {
+		name     string
+		method   string
+		path     string
+		token    string
+		status   int
+		expected string
+	}{
+		{"no token", http.MethodGet, "/jobs", "", http.StatusUnauthorized, "invalid or missing token"},
+		{"unknown token", http.MethodGet, "/jobs", "nope", http.StatusUnauthorized, ""},
+		{"missing scope", http.MethodGet, "/jobs", "build-token-0123456789", http.StatusForbidden, "token lacks the read-artifacts scope"},
+		{"build", http.MethodPost, "/build", "build-token-0123456789", http.StatusOK, "built for ada"},
+		{"builds per day", http.MethodPost, "/build", "build-token-0123456789", http.StatusTooManyRequests, "exceeded quota builds_per_day (1)"},
+		{"own jobs only", http.MethodGet, "/jobs", "read-token-01234567890", http.StatusOK, `"jobs":[]`},
+		{"job of another user", http.MethodGet, "/jobs/" + adaJob.ID(), "read-token-01234567890", http.StatusNotFound, ""},
+		{"admin sees every job", http.MethodGet, "/jobs?user=ada", "admin-token-0123456789", http.StatusOK, `"user":"ada"`},
+		{"admin reads any job", http.MethodGet, "/jobs/" + adaJob.ID(), "admin-token-0123456789", http.StatusOK, adaJob.ID()},
+		{"admin only", http.MethodGet, "/tokens", "read-token-01234567890", http.StatusForbidden, ""},
+		{"list tokens", http.MethodGet, "/tokens", "admin-token-0123456789", http.StatusOK, `"builds_today":1`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.method, tt.path, tt.token, "")
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.expected) {
+				t.Errorf("expected %q in %s", tt.expected, w.Body)
+			}
+		})
+	}
+
+	w := do(http.MethodPost, "/tokens", "admin-token-0123456789", "user=cy&scope=build,read-artifacts&concurrent_jobs=2")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected a token, got %d: %s", w.Code, w.Body)
+	}
+	var issued struct {
+		ID     string             `json:"id"`
+		Token  string             `json:"token"`
+		Scopes []string           `json:"scopes"`
+		Quota  config.QuotaConfig `json:"quota"`
+	}
+	err = json.NewDecoder(w.Body).Decode(&issued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issued.Scopes) != 2 || issued.Quota.ConcurrentJobs != 2 {
+		t.Errorf("unexpected token %+v", issued)
+	}
+	if w := do(http.MethodPost, "/build", issued.Token, ""); w.Body.String() != "built for cy" {
+		t.Errorf("expected the issued token to build, got %d: %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodPost, "/tokens", "admin-token-0123456789", "user=cy&scope=root"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown scope to fail, got %d", w.Code)
+	}
+
+	if w := do(http.MethodDelete, "/tokens/"+issued.ID, "admin-token-0123456789", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expected the token to be revoked, got %d: %s", w.Code, w.Body)
+	}
+	if w := do(http.MethodPost, "/build", issued.Token, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the revoked token to fail, got %d", w.Code)
+	}
+	list, err := tokens.Tokens()
+	if err != nil || len(list) == 0 || !list[0].Static {
+		t.Fatalf("expected the static tokens first, got %v: %v", list, err)
+	}
+	if w := do(http.MethodDelete, "/tokens/"+list[0].ID, "admin-token-0123456789", ""); w.Code != http.StatusConflict {
+		t.Errorf("expected static tokens to stay, got %d", w.Code)
+	}
+}
+
+func TestChargeStorage(t *testing.T) {
+	dir := t.TempDir()
+	artifacts := store.NewFS(filepath.Join(dir, "artifacts"))
+	cfg := config.Default()
+	cfg.Auth = config.AuthConfig{
+		Enabled: true,
+		DB:      filepath.Join(dir, "auth.db"),
+		Tokens:  []config.TokenConfig{{User: "ada", Token: "build-token-0123456789", Scopes: []string{auth.ScopeBuild}}},
+	}
+	tokens, err := auth.Open(cfg.Auth, artifacts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tokens.Close()
+	token, err := tokens.Authenticate("build-token-0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a bundle whose last part failed still stored the parts built before it
+	stored := &generators.Stored{}
+	for i, size := range []int{100, 200} {
+		pdfPath := filepath.Join(dir, fmt.Sprintf("part%d.pdf", i))
+		err := os.WriteFile(pdfPath, []byte(strings.Repeat("x", size)), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part, err := cache.Store(artifacts, cache.NewID(), models.Artifact{Path: pdfPath})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored.Add(part.Key)
+	}
+
+	srv := New(cfg, artifacts, nil, nil, tokens)
+	srv.chargeStorage(generators.WithStored(auth.WithToken(context.Background(), token), stored))
+
+	usage, err := tokens.Usage(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.StorageBytes != 300 {
+		t.Errorf("expected every stored part to be charged, got %d bytes", usage.StorageBytes)
+	}
+}
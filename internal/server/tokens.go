@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jeffbrennan/pdfgen/internal/auth"
+	"github.com/jeffbrennan/pdfgen/internal/config"
+)
+
+type tokenResponse struct {
+	auth.Token
+	Usage *auth.Usage `json:"usage,omitempty"`
+	// Secret is only returned when the token is issued
+	Secret string `json:"token,omitempty"`
+}
+
+// TokensHandler lists every token with what it used of its quota
+func (s *Server) TokensHandler(w http.ResponseWriter, r *http.Request) {
+	tokens, err := s.tokens.Tokens()
+	if err != nil {
+		log.Printf("error listing tokens: %s", err)
+		http.Error(w, "error listing tokens", http.StatusInternalServerError)
+		return
+	}
+
+	list := []tokenResponse{}
+	for _, t := range tokens {
+		usage, err := s.tokens.Usage(t)
+		if err != nil {
+			log.Printf("error reading the usage of token %s: %s", t.ID, err)
+		}
+		list = append(list, tokenResponse{Token: t, Usage: &usage})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]tokenResponse{"tokens": list})
+}
+
+// CreateTokenHandler issues a token for user with the given scopes. scope repeats or is
+// comma separated, quota fields that are left out come from auth.default_quota.
+func (s *Server) CreateTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !parseForm(w, r) {
+		return
+	}
+
+	var scopes []string
+	for _, v := range r.Form["scope"] {
+		for _, scope := range strings.Split(v, ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	quota, err := formQuota(r, s.cfg.Auth.DefaultQuota)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	t, secret, err := s.tokens.Issue(r.FormValue("user"), scopes, quota)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("issued token %s to %s", t.ID, t.User)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(tokenResponse{Token: t, Secret: secret})
+}
+
+// RevokeTokenHandler deletes an issued token, requests with it fail from then on
+func (s *Server) RevokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !auth.IDPattern.MatchString(id) {
+		http.Error(w, "invalid token id", http.StatusBadRequest)
+		return
+	}
+
+	err := s.tokens.Revoke(id)
+	switch {
+	case errors.Is(err, auth.ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, auth.ErrStatic):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("error revoking token %s: %s", id, err)
+		http.Error(w, "error revoking token", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("revoked token %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func formQuota(r *http.Request, defaults config.QuotaConfig) (config.QuotaConfig, error) {
+	quota := defaults
+	for key, field := range map[string]*int{
+		"builds_per_day":  &quota.BuildsPerDay,
+		"concurrent_jobs": &quota.ConcurrentJobs,
+		"storage_mb":      &quota.StorageMB,
+	} {
+		if !r.Form.Has(key) {
+			continue
+		}
+		n, err := formInt(r, key)
+		if err != nil {
+			return quota, err
+		}
+		if n < 0 {
+			return quota, errors.New(key + " must not be negative")
+		}
+		*field = n
+	}
+	return quota, nil
+}
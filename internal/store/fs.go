@@ -0,0 +1,165 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FS keeps artifacts as files under a directory
+type FS struct {
+	dir string
+}
+
+func NewFS(dir string) *FS {
+	return &FS{dir: dir}
+}
+
+func (s *FS) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+func (s *FS) Put(key string, r io.ReadSeeker, info Info) error {
+	err := validateKey(key)
+	if err != nil {
+		return err
+	}
+	path := s.path(key)
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	// write next to the final place and rename, so readers never see half a file
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return err
+	}
+
+	info.Key = key
+	info.Size = n
+	info.ModTime = time.Now().UTC()
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+sidecarSuffix, data, 0644)
+}
+
+func (s *FS) Get(key string) (io.ReadSeekCloser, Info, error) {
+	info, err := s.Stat(key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	f, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, Info{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Info{}, err
+	}
+	return f, info, nil
+}
+
+func (s *FS) Stat(key string) (Info, error) {
+	err := validateKey(key)
+	if err != nil {
+		return Info{}, err
+	}
+	data, err := os.ReadFile(s.path(key) + sidecarSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Info{}, ErrNotFound
+	}
+	if err != nil {
+		return Info{}, err
+	}
+
+	var info Info
+	err = json.Unmarshal(data, &info)
+	if err != nil {
+		return Info{}, err
+	}
+	return info, nil
+}
+
+func (s *FS) Delete(key string) error {
+	err := validateKey(key)
+	if err != nil {
+		return err
+	}
+	path := s.path(key)
+	for _, p := range []string{path + sidecarSuffix, path} {
+		err = os.Remove(p)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	// drop directories the artifact leaves empty, up to the store's own
+	for dir := filepath.Dir(path); dir != filepath.Clean(s.dir) && strings.HasPrefix(dir, filepath.Clean(s.dir)); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *FS) List(prefix string) ([]Info, error) {
+	// only the directory named by the prefix can hold matching keys
+	root := s.dir
+	if i := strings.LastIndex(prefix, "/"); i > 0 {
+		if validateKey(prefix[:i]) != nil {
+			return nil, nil
+		}
+		root = s.path(prefix[:i])
+	}
+
+	var infos []Info
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, sidecarSuffix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.dir, strings.TrimSuffix(path, sidecarSuffix))
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		stat, err := os.Stat(strings.TrimSuffix(path, sidecarSuffix))
+		if err != nil {
+			// a sidecar without its artifact is a half deleted entry
+			return nil
+		}
+		infos = append(infos, Info{Key: key, Size: stat.Size(), ModTime: stat.ModTime()})
+		return nil
+	})
+	return infos, err
+}
@@ -0,0 +1,436 @@
+package store
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+)
+
+const (
+	// unsignedPayload lets uploads stream instead of hashing the body up front
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	emptyPayload    = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3 keeps artifacts in a bucket of an S3 compatible service, e.g. AWS or MinIO.
+// Requests are signed with AWS Signature Version 4.
+type S3 struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+	// now is replaced in tests to sign with a fixed time
+	now func() time.Time
+}
+
+func NewS3(cfg config.S3Config) (*S3, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is required")
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &S3{
+		endpoint:  endpoint,
+		region:    region,
+		bucket:    cfg.Bucket,
+		prefix:    strings.Trim(cfg.Prefix, "/"),
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		client:    &http.Client{Timeout: 5 * time.Minute},
+		now:       time.Now,
+	}, nil
+}
+
+func (s *S3) objectName(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + "/" + key
+}
+
+func (s *S3) objectURL(name string) *url.URL {
+	u := *s.endpoint
+	u.Path = s.endpoint.Path + "/" + s.bucket + "/" + name
+	u.RawPath = s.endpoint.Path + "/" + uriEncode(s.bucket, false) + "/" + uriEncode(name, false)
+	return &u
+}
+
+func (s *S3) do(method string, u *url.URL, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	payloadHash := emptyPayload
+	if body != nil {
+		req.ContentLength = size
+		payloadHash = unsignedPayload
+	}
+	s.sign(req, payloadHash)
+	return s.client.Do(req)
+}
+
+// s3Error reads the error a request failed with, 404s become ErrNotFound
+func s3Error(resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+		return fmt.Errorf("s3 %s: %s: %s", resp.Request.Method, e.Code, e.Message)
+	}
+	return fmt.Errorf("s3 %s: %s", resp.Request.Method, resp.Status)
+}
+
+func (s *S3) putObject(name string, r io.Reader, size int64, contentType string) error {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(http.MethodPut, s.objectURL(name), r, size, header)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) deleteObject(name string) error {
+	resp, err := s.do(http.MethodDelete, s.objectURL(name), nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Put(key string, r io.ReadSeeker, info Info) error {
+	err := validateKey(key)
+	if err != nil {
+		return err
+	}
+	n, err := size(r)
+	if err != nil {
+		return err
+	}
+
+	name := s.objectName(key)
+	err = s.putObject(name, r, n, info.ContentType)
+	if err != nil {
+		return err
+	}
+
+	info.Key = key
+	info.Size = n
+	info.ModTime = s.now().UTC()
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.putObject(name+sidecarSuffix, bytes.NewReader(data), int64(len(data)), "application/json")
+}
+
+func (s *S3) Stat(key string) (Info, error) {
+	err := validateKey(key)
+	if err != nil {
+		return Info{}, err
+	}
+	resp, err := s.do(http.MethodGet, s.objectURL(s.objectName(key)+sidecarSuffix), nil, 0, nil)
+	if err != nil {
+		return Info{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Info{}, s3Error(resp)
+	}
+	defer resp.Body.Close()
+
+	var info Info
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	if err != nil {
+		return Info{}, fmt.Errorf("error reading metadata of %s: %s", key, err)
+	}
+	return info, nil
+}
+
+func (s *S3) Get(key string) (io.ReadSeekCloser, Info, error) {
+	info, err := s.Stat(key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	return &rangeReader{s: s, u: s.objectURL(s.objectName(key)), size: info.Size}, info, nil
+}
+
+func (s *S3) Delete(key string) error {
+	err := validateKey(key)
+	if err != nil {
+		return err
+	}
+	name := s.objectName(key)
+	err = s.deleteObject(name + sidecarSuffix)
+	if err != nil {
+		return err
+	}
+	return s.deleteObject(name)
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3) List(prefix string) ([]Info, error) {
+	objectPrefix := s.objectName(prefix)
+	if prefix == "" && s.prefix != "" {
+		objectPrefix = s.prefix + "/"
+	}
+
+	objects := map[string]Info{}
+	var sidecars []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {objectPrefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u := *s.endpoint
+		u.Path = s.endpoint.Path + "/" + s.bucket
+		u.RawQuery = query.Encode()
+
+		resp, err := s.do(http.MethodGet, &u, nil, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, s3Error(resp)
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading bucket listing: %s", err)
+		}
+
+		for _, object := range result.Contents {
+			if strings.HasSuffix(object.Key, sidecarSuffix) {
+				sidecars = append(sidecars, strings.TrimSuffix(object.Key, sidecarSuffix))
+				continue
+			}
+			objects[object.Key] = Info{Size: object.Size, ModTime: object.LastModified}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	var infos []Info
+	for _, name := range sidecars {
+		info, ok := objects[name]
+		if !ok {
+			continue
+		}
+		info.Key = name
+		if s.prefix != "" {
+			info.Key = strings.TrimPrefix(name, s.prefix+"/")
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// rangeReader reads an object with ranged GETs starting at the current offset, so seeking
+// doesn't download what is skipped
+type rangeReader struct {
+	s      *S3
+	u      *url.URL
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		header := http.Header{}
+		header.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+		resp, err := r.s.do(http.MethodGet, r.u, nil, 0, header)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			return 0, s3Error(resp)
+		}
+		r.body = resp.Body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+func (r *rangeReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// sign adds the AWS Signature Version 4 headers. Host, Range, and the x-amz-* headers
+// are signed.
+func (s *S3) sign(req *http.Request, payloadHash string) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lower := strings.ToLower(k)
+		if lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		// object urls are built with uriEncode, so the escaped path is already canonical
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters the way SigV4 expects
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
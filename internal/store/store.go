@@ -0,0 +1,121 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+)
+
+// sidecarSuffix names the metadata object stored next to every artifact
+const sidecarSuffix = ".meta.json"
+
+var ErrNotFound = errors.New("artifact not found")
+
+// Info describes a stored artifact. List only fills Key, Size, and ModTime.
+type Info struct {
+	Key         string            `json:"key"`
+	Size        int64             `json:"size"`
+	ModTime     time.Time         `json:"mod_time"`
+	ContentType string            `json:"content_type"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// ArtifactStore keeps finished artifacts. Keys are slash separated paths like
+// <id>/<file>, every artifact has a JSON sidecar with its Info.
+type ArtifactStore interface {
+	// Put stores r under key, replacing what was there. Size and ModTime of info are set
+	// by the store.
+	Put(key string, r io.ReadSeeker, info Info) error
+	// Get opens key for reading. The reader seeks, so it can serve Range requests.
+	Get(key string) (io.ReadSeekCloser, Info, error)
+	Stat(key string) (Info, error)
+	Delete(key string) error
+	// List returns the artifacts whose key starts with prefix
+	List(prefix string) ([]Info, error)
+}
+
+// New returns the backend chosen in cfg
+func New(cfg config.StoreConfig) (ArtifactStore, error) {
+	switch cfg.Backend {
+	case "fs":
+		return NewFS(cfg.Dir), nil
+	case "s3":
+		return NewS3(cfg.S3)
+	}
+	return nil, fmt.Errorf("unknown store backend %q", cfg.Backend)
+}
+
+// validateKey keeps keys inside the store: relative, clean, and without dot segments
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || strings.HasSuffix(key, sidecarSuffix) {
+		return fmt.Errorf("invalid artifact key %q", key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "." || segment == ".." || strings.ContainsAny(segment, "\\\x00") {
+			return fmt.Errorf("invalid artifact key %q", key)
+		}
+	}
+	return nil
+}
+
+// size finds the length of r and rewinds it
+func size(r io.ReadSeeker) (int64, error) {
+	n, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	return n, err
+}
+
+// Download copies the artifact at key to a local file
+func Download(s ArtifactStore, key string, dst string) error {
+	r, _, err := s.Get(key)
+	if err != nil {
+		return fmt.Errorf("error reading artifact %s: %s", key, err)
+	}
+	defer r.Close()
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("error reading artifact %s: %s", key, err)
+	}
+	return f.Close()
+}
+
+// Prune deletes every artifact older than retention and returns how many were deleted
+func Prune(s ArtifactStore, retention time.Duration) (int, error) {
+	if retention <= 0 {
+		return 0, nil
+	}
+	infos, err := s.List("")
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, info := range infos {
+		if time.Since(info.ModTime) <= retention {
+			continue
+		}
+		err = s.Delete(info.Key)
+		if err != nil {
+			log.Printf("error pruning %s: %s", info.Key, err)
+			continue
+		}
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStore runs the behavior every backend shares
+func testStore(t *testing.T, s ArtifactStore) {
+	content := "%PDF-1.7 " + strings.Repeat("0123456789", 100)
+	err := s.Put("abc/airflow.pdf", strings.NewReader(content), Info{
+		ContentType: "application/pdf",
+		Metadata:    map[string]string{"renderer": "latex"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Put("abd/cosmos.epub", strings.NewReader("epub"), Info{ContentType: "application/epub+zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := s.Stat("abc/airflow.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Key != "abc/airflow.pdf" || info.Size != int64(len(content)) || info.ContentType != "application/pdf" ||
+		info.Metadata["renderer"] != "latex" || time.Since(info.ModTime) > time.Minute {
+		t.Errorf("unexpected info %+v", info)
+	}
+
+	r, _, err := s.Get("abc/airflow.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil || string(data) != content {
+		t.Errorf("unexpected content %q, %v", data, err)
+	}
+
+	// seeking back and into the middle reads from there
+	_, err = r.Seek(995, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part := make([]byte, 10)
+	_, err = io.ReadFull(r, part)
+	if err != nil || string(part) != content[995:1005] {
+		t.Errorf("expected %q after seeking, got %q %v", content[995:1005], part, err)
+	}
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil || end != int64(len(content)) {
+		t.Errorf("expected to seek to %d, got %d %v", len(content), end, err)
+	}
+
+	infos, err := s.List("ab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, info := range infos {
+		keys = append(keys, info.Key)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "abc/airflow.pdf,abd/cosmos.epub" {
+		t.Errorf("unexpected listing %v", keys)
+	}
+	infos, err = s.List("abc/")
+	if err != nil || len(infos) != 1 || infos[0].Size != int64(len(content)) {
+		t.Errorf("unexpected listing %+v %v", infos, err)
+	}
+	infos, err = s.List("abd/cos")
+	if err != nil || len(infos) != 1 || infos[0].Key != "abd/cosmos.epub" {
+		t.Errorf("unexpected listing %+v %v", infos, err)
+	}
+	for _, prefix := range []string{"missing/", "abc/airflow.pdf/", "../abc/"} {
+		infos, err = s.List(prefix)
+		if err != nil || len(infos) != 0 {
+			t.Errorf("expected nothing under %q, got %+v %v", prefix, infos, err)
+		}
+	}
+
+	err = s.Delete("abc/airflow.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat("abc/airflow.pdf"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+	if _, _, err := s.Get("missing/file.pdf"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err := s.Delete("missing/file.pdf"); err != nil {
+		t.Errorf("expected deleting a missing artifact to succeed, got %v", err)
+	}
+
+	for _, key := range []string{"../escape.pdf", "/abs.pdf", "a/./b.pdf", "a//b.pdf", "a/b.pdf.meta.json", ""} {
+		if err := s.Put(key, strings.NewReader("x"), Info{}); err == nil {
+			t.Errorf("expected key %q to be rejected", key)
+		}
+	}
+}
+
+func TestFS(t *testing.T) {
+	dir := t.TempDir()
+	testStore(t, NewFS(dir))
+
+	// deleting the last artifact of an id removes its directory
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "abd" {
+		t.Errorf("expected only abd to remain, got %v", entries)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFS(dir)
+	for _, key := range []string{"old/a.pdf", "new/b.pdf"} {
+		err := s.Put(key, strings.NewReader(key), Info{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	past := time.Now().Add(-48 * time.Hour)
+	err := os.Chtimes(s.path("old/a.pdf"), past, past)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name      string
+		retention time.Duration
+		deleted   int
+	}{
+		{"disabled", 0, 0},
+		{"nothing that old", 72 * time.Hour, 0},
+		{"old artifact", 24 * time.Hour, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deleted, err := Prune(s, tt.retention)
+			if err != nil || deleted != tt.deleted {
+				t.Errorf("expected %d deleted, got %d %v", tt.deleted, deleted, err)
+			}
+		})
+	}
+
+	if _, err := s.Stat("new/b.pdf"); err != nil {
+		t.Errorf("expected the new artifact to stay, got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"sync"
+)
+
+type limitsKey struct{}
+
+// Limits are the rlimits of every command run with a context carrying them, 0 leaves a
+// resource unlimited
+type Limits struct {
+	CPUSeconds int
+	MemoryMB   int
+	FileSizeMB int
+	Processes  int
+}
+
+// WithLimits returns a copy of ctx whose commands run under limits
+func WithLimits(ctx context.Context, limits Limits) context.Context {
+	return context.WithValue(ctx, limitsKey{}, limits)
+}
+
+var (
+	prlimitOnce sync.Once
+	prlimitPath string
+)
+
+// limitArgs prefixes args with prlimit, which sets the limits and execs the command, so
+// they are inherited by everything it starts. Without prlimit commands run unlimited.
+func limitArgs(ctx context.Context, args []string) []string {
+	limits, ok := ctx.Value(limitsKey{}).(Limits)
+	if !ok || limits == (Limits{}) {
+		return args
+	}
+
+	prlimitOnce.Do(func() {
+		path, err := exec.LookPath("prlimit")
+		if err != nil {
+			log.Printf("prlimit not found, commands run without resource limits")
+			return
+		}
+		prlimitPath = path
+	})
+	if prlimitPath == "" {
+		return args
+	}
+
+	limited := []string{prlimitPath}
+	if limits.CPUSeconds > 0 {
+		// SIGXCPU at the soft limit names the limit, SIGKILL follows at the hard one
+		limited = append(limited, fmt.Sprintf("--cpu=%d:%d", limits.CPUSeconds, limits.CPUSeconds+5))
+	}
+	if limits.MemoryMB > 0 {
+		limited = append(limited, fmt.Sprintf("--as=%d", int64(limits.MemoryMB)<<20))
+	}
+	if limits.FileSizeMB > 0 {
+		limited = append(limited, fmt.Sprintf("--fsize=%d", int64(limits.FileSizeMB)<<20))
+	}
+	if limits.Processes > 0 {
+		limited = append(limited, fmt.Sprintf("--nproc=%d", limits.Processes))
+	}
+	return append(append(limited, "--"), args...)
+}
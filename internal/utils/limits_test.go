@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLimitArgs(t *testing.T) {
+	var tests = []struct {
+		name     string
+		limits   *Limits
+		expected string
+	}{
+		{"no limits", nil, "git pull"},
+		{"zero limits", &Limits{}, "git pull"},
+		{"every limit", &Limits{CPUSeconds: 60, MemoryMB: 2, FileSizeMB: 1, Processes: 100}, "--cpu=60:65 --as=2097152 --fsize=1048576 --nproc=100 -- git pull"},
+		{"some limits", &Limits{FileSizeMB: 1}, "--fsize=1048576 -- git pull"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.limits != nil {
+				ctx = WithLimits(ctx, *tt.limits)
+			}
+			args := limitArgs(ctx, []string{"git", "pull"})
+			if prlimitPath == "" && tt.limits != nil && *tt.limits != (Limits{}) {
+				t.Skip("prlimit not installed")
+			}
+			got := strings.Join(args, " ")
+			if tt.limits != nil && *tt.limits != (Limits{}) {
+				got = strings.Join(args[1:], " ")
+			}
+			if got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package utils
+
+import "os/exec"
+
+func exceededLimit(err error) string {
+	return ""
+}
+
+// setProcessGroup leaves cmd alone, without process groups only cmd itself is killed on
+// cancellation
+func setProcessGroup(cmd *exec.Cmd) {}
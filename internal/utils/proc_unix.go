@@ -0,0 +1,41 @@
+//go:build unix
+
+package utils
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// exceededLimit names the rlimit that killed a command, if the signal tells
+func exceededLimit(err error) string {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return ""
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return ""
+	}
+	switch status.Signal() {
+	case syscall.SIGXCPU:
+		return "limits.cpu_seconds"
+	case syscall.SIGXFSZ:
+		return "limits.file_size_mb"
+	}
+	return ""
+}
+
+// setProcessGroup starts cmd in a process group of its own, so cancelling it also kills
+// what it started: uv's python, sphinx's latex, latexmk's engine runs
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		if errors.Is(err, syscall.ESRCH) {
+			return nil
+		}
+		return err
+	}
+}
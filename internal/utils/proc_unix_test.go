@@ -0,0 +1,84 @@
+//go:build unix
+
+package utils
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCommandCancel(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := RunCommand(ctx, []string{"sh", "-c", "sleep 30 & echo $! > " + pidFile + "; wait"}, "")
+	if err == nil || !strings.Contains(err.Error(), "sh stopped: context canceled") {
+		t.Fatalf("expected a cancelled error, got %v", err)
+	}
+	// the backgrounded sleep holds stdout open, only killing the group returns early
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("expected the command to stop right away, took %s", time.Since(start))
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the killed child is gone or a zombie waiting for init
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err == nil && !strings.Contains(string(stat), ") Z ") {
+		t.Errorf("expected the grandchild %d to be killed, got %s", pid, stat)
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	out, err := RunCommandEnv(context.Background(), []string{"sh", "-c", "echo $GREETING"}, "", map[string]string{"GREETING": "hello"})
+	if err != nil || string(out) != "hello\n" {
+		t.Errorf("expected hello, got %q: %v", out, err)
+	}
+}
+
+func TestRunCommandLimits(t *testing.T) {
+	if _, err := exec.LookPath("prlimit"); err != nil {
+		t.Skip("prlimit not installed")
+	}
+	dir := t.TempDir()
+
+	var tests = []struct {
+		name     string
+		limits   Limits
+		args     []string
+		expected string
+	}{
+		{"cpu", Limits{CPUSeconds: 1}, []string{"sh", "-c", "while :; do :; done"}, "sh exceeded limit limits.cpu_seconds"},
+		{"file size", Limits{FileSizeMB: 1}, []string{"dd", "if=/dev/zero", "of=" + filepath.Join(dir, "big"), "bs=1M", "count=2"}, "dd exceeded limit limits.file_size_mb"},
+		{"within limits", Limits{CPUSeconds: 10, FileSizeMB: 1, Processes: 1024}, []string{"true"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := RunCommand(WithLimits(context.Background(), tt.limits), tt.args, "")
+			if tt.expected == "" {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("expected %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+)
+
+// SandboxHome is the home directory of sandboxed commands, inside their workspace so
+// caches like uv's last for the whole build and go away with the checkout
+const SandboxHome = ".pdfgen-home"
+
+// sandboxEnv is all of the server's environment a sandboxed command sees, the rest may
+// hold credentials
+var sandboxEnv = []string{"PATH", "LANG", "LC_ALL", "TZ"}
+
+// SandboxPolicy is what a sandboxed command may touch
+type SandboxPolicy struct {
+	// Workspace is the checkout, writable along with Writable
+	Workspace string
+	Writable  []string
+	// Hidden directories are replaced by empty ones and hidden files by /dev/null
+	Hidden []string
+	// Network is allowed while dependencies are installed, not while repository code builds
+	Network bool
+}
+
+// Sandbox isolates commands that run repository code from the server
+type Sandbox interface {
+	// Wrap returns the command line running args in dir under policy, along with its
+	// environment, which has env on top. A nil environment is the server's own.
+	Wrap(args []string, dir string, env map[string]string, policy SandboxPolicy) ([]string, []string, error)
+}
+
+// NewSandbox returns the sandbox of the configured backend
+func NewSandbox(cfg config.SandboxConfig) (Sandbox, error) {
+	switch cfg.Backend {
+	case "", "none":
+		return NoSandbox{}, nil
+	case "bwrap":
+		path, err := exec.LookPath("bwrap")
+		if err != nil {
+			return nil, fmt.Errorf("sandbox backend bwrap: %s", err)
+		}
+		return Bubblewrap{Path: path, UID: cfg.UID, GID: cfg.GID}, nil
+	}
+	return nil, fmt.Errorf("unknown sandbox backend: %s", cfg.Backend)
+}
+
+// NoSandbox runs commands as the server, for development
+type NoSandbox struct{}
+
+func (NoSandbox) Wrap(args []string, dir string, env map[string]string, policy SandboxPolicy) ([]string, []string, error) {
+	return args, environ(env), nil
+}
+
+// Bubblewrap runs commands in fresh namespaces with bwrap: a read-only root, no
+// network unless allowed, and an unprivileged user that only sees its environment
+type Bubblewrap struct {
+	Path string
+	UID  int
+	GID  int
+}
+
+func (b Bubblewrap) Wrap(args []string, dir string, env map[string]string, policy SandboxPolicy) ([]string, []string, error) {
+	if policy.Workspace == "" {
+		return nil, nil, fmt.Errorf("sandbox has no workspace")
+	}
+	home := filepath.Join(policy.Workspace, SandboxHome)
+	err := os.MkdirAll(home, 0755)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating sandbox home: %s", err)
+	}
+
+	wrapped := []string{
+		b.Path,
+		"--die-with-parent",
+		"--new-session",
+		"--unshare-all",
+		"--unshare-user",
+	}
+	if policy.Network {
+		wrapped = append(wrapped, "--share-net")
+	}
+	wrapped = append(wrapped,
+		"--uid", strconv.Itoa(b.UID),
+		"--gid", strconv.Itoa(b.GID),
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--tmpfs", "/tmp",
+	)
+
+	// hiding comes first, the workspace may lie in a hidden directory
+	for _, path := range policy.Hidden {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			wrapped = append(wrapped, "--tmpfs", path)
+		} else {
+			wrapped = append(wrapped, "--ro-bind", "/dev/null", path)
+		}
+	}
+	for _, path := range append([]string{policy.Workspace}, policy.Writable...) {
+		wrapped = append(wrapped, "--bind", path, path)
+	}
+
+	wrapped = append(wrapped, "--clearenv")
+	for _, k := range sandboxEnv {
+		if v, ok := os.LookupEnv(k); ok {
+			wrapped = append(wrapped, "--setenv", k, v)
+		}
+	}
+	wrapped = append(wrapped, "--setenv", "HOME", home, "--setenv", "TMPDIR", "/tmp")
+	var keys []string
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		wrapped = append(wrapped, "--setenv", k, env[k])
+	}
+
+	if dir != "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		wrapped = append(wrapped, "--chdir", abs)
+	}
+	return append(append(wrapped, "--"), args...), nil, nil
+}
+
+type sandboxKey struct{}
+
+type sandboxed struct {
+	sandbox Sandbox
+	policy  SandboxPolicy
+}
+
+// WithSandbox returns a copy of ctx whose commands run in sandbox under policy
+func WithSandbox(ctx context.Context, sandbox Sandbox, policy SandboxPolicy) context.Context {
+	return context.WithValue(ctx, sandboxKey{}, sandboxed{sandbox: sandbox, policy: policy})
+}
+
+// WithNetwork returns a copy of ctx whose sandboxed commands may use the network
+func WithNetwork(ctx context.Context) context.Context {
+	s, ok := ctx.Value(sandboxKey{}).(sandboxed)
+	if !ok {
+		return ctx
+	}
+	s.policy.Network = true
+	return context.WithValue(ctx, sandboxKey{}, s)
+}
+
+// sandboxArgs wraps args in the sandbox of ctx, commands without one run as the server
+func sandboxArgs(ctx context.Context, args []string, dir string, env map[string]string) ([]string, []string, error) {
+	s, ok := ctx.Value(sandboxKey{}).(sandboxed)
+	if !ok {
+		return args, environ(env), nil
+	}
+	return s.sandbox.Wrap(args, dir, env, s.policy)
+}
+
+// CheckPath makes sure the server reaches path without following a symlink that sandboxed
+// commands left in their workspace. Paths outside of the workspace, and every path when
+// ctx has no sandbox, are the server's own.
+func CheckPath(ctx context.Context, path string) error {
+	s, ok := ctx.Value(sandboxKey{}).(sandboxed)
+	if !ok || s.policy.Workspace == "" {
+		return nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(s.policy.Workspace, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+
+	current := s.policy.Workspace
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, name)
+		info, err := os.Lstat(current)
+		if errors.Is(err, fs.ErrNotExist) {
+			// the rest is created by the server
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("not following symlink %s", current)
+		}
+	}
+	return nil
+}
+
+// WriteFile is os.WriteFile for a path checked with CheckPath
+func WriteFile(ctx context.Context, path string, data []byte, perm os.FileMode) error {
+	err := CheckPath(ctx, path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, perm)
+}
+
+// Create is os.Create for a path checked with CheckPath
+func Create(ctx context.Context, path string) (*os.File, error) {
+	err := CheckPath(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+// MkdirAll is os.MkdirAll for a path checked with CheckPath
+func MkdirAll(ctx context.Context, path string, perm os.FileMode) error {
+	err := CheckPath(ctx, path)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(path, perm)
+}
+
+// environ is the server's environment with env on top, nil when there is nothing to add
+func environ(env map[string]string) []string {
+	if len(env) == 0 {
+		return nil
+	}
+	out := os.Environ()
+	for k, v := range env {
+		out = append(out, k+"="+v)
+	}
+	return out
+}
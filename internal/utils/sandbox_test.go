@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSandboxArgs(t *testing.T) {
+	workspace := t.TempDir()
+	hiddenDir := t.TempDir()
+	hiddenFile := filepath.Join(hiddenDir, "token")
+	err := os.WriteFile(hiddenFile, []byte("secret"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bwrap := Bubblewrap{Path: "bwrap", UID: 65534, GID: 65534}
+	policy := SandboxPolicy{Workspace: workspace, Hidden: []string{hiddenDir, hiddenFile, "/does/not/exist"}}
+
+	var tests = []struct {
+		name     string
+		sandbox  Sandbox
+		network  bool
+		contains []string
+		excludes []string
+	}{
+		{"no sandbox", nil, false, []string{"uv sync"}, []string{"bwrap"}},
+		{"none backend", NoSandbox{}, false, []string{"uv sync"}, []string{"bwrap"}},
+		{
+			"bwrap without network", bwrap, false,
+			[]string{
+				"--unshare-all --unshare-user --uid 65534 --gid 65534 --ro-bind / /",
+				"--tmpfs " + hiddenDir + " --ro-bind /dev/null " + hiddenFile + " --bind " + workspace + " " + workspace,
+				"--clearenv",
+				"--setenv HOME " + filepath.Join(workspace, SandboxHome),
+				"--setenv UV_NO_PROGRESS 1 --chdir " + workspace + " -- uv sync",
+			},
+			[]string{"--share-net", "/does/not/exist"},
+		},
+		{"bwrap with network", bwrap, true, []string{"--unshare-user --share-net"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.sandbox != nil {
+				ctx = WithSandbox(ctx, tt.sandbox, policy)
+			}
+			if tt.network {
+				ctx = WithNetwork(ctx)
+			}
+
+			args, _, err := sandboxArgs(ctx, []string{"uv", "sync"}, workspace, map[string]string{"UV_NO_PROGRESS": "1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := strings.Join(args, " ")
+			for _, want := range tt.contains {
+				if !strings.Contains(got, want) {
+					t.Errorf("expected %q in %q", want, got)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(got, unwanted) {
+					t.Errorf("expected no %q in %q", unwanted, got)
+				}
+			}
+		})
+	}
+}
+
+func TestBubblewrap(t *testing.T) {
+	path, err := exec.LookPath("bwrap")
+	if err != nil {
+		t.Skip("bwrap not installed")
+	}
+	workspace := t.TempDir()
+	hidden := t.TempDir()
+	err = os.WriteFile(filepath.Join(hidden, "token"), []byte("secret"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// /tmp is a fresh tmpfs in the sandbox, the package directory comes from the root
+	readOnly, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithSandbox(context.Background(), Bubblewrap{Path: path, UID: 65534, GID: 65534}, SandboxPolicy{
+		Workspace: workspace,
+		Hidden:    []string{hidden},
+	})
+
+	var tests = []struct {
+		name       string
+		script     string
+		shouldPass bool
+	}{
+		{"workspace is writable", "echo ok > out", true},
+		{"runs unprivileged", `test "$(id -u)" = 65534`, true},
+		{"hidden dirs are empty", "! test -e " + hidden + "/token", true},
+		{"root is read only", "touch " + readOnly + "/sandbox.out", false},
+		{"no network", "cat < /dev/tcp/1.1.1.1/80", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := RunCommand(ctx, []string{"bash", "-c", tt.script}, workspace)
+			if (err != nil) && tt.shouldPass {
+				t.Fatalf("should pass: got %v: %s", err, out)
+			}
+			if (err == nil) && !tt.shouldPass {
+				t.Fatalf("should fail")
+			}
+		})
+	}
+}
+
+func TestCheckPath(t *testing.T) {
+	workspace := t.TempDir()
+	outside := t.TempDir()
+	err := os.MkdirAll(filepath.Join(workspace, "docs", "_build"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"docs/latex":    outside,
+		"docs/out.pdf":  filepath.Join(outside, "secret"),
+		"docs/_build/x": "../../docs",
+	} {
+		err = os.Symlink(target, filepath.Join(workspace, filepath.FromSlash(link)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := WithSandbox(context.Background(), NoSandbox{}, SandboxPolicy{Workspace: workspace})
+
+	var tests = []struct {
+		name       string
+		ctx        context.Context
+		path       string
+		shouldPass bool
+	}{
+		{"new file in a directory", ctx, filepath.Join(workspace, "docs", "_build", "pdfgen.sty"), true},
+		{"new directories", ctx, filepath.Join(workspace, "docs", "_build", "pages", "0001.pdf"), true},
+		{"outside of the workspace", ctx, filepath.Join(outside, "secret"), true},
+		{"without a sandbox", context.Background(), filepath.Join(workspace, "docs", "out.pdf"), true},
+		{"symlinked file should fail", ctx, filepath.Join(workspace, "docs", "out.pdf"), false},
+		{"symlinked directory should fail", ctx, filepath.Join(workspace, "docs", "latex", "doc.tex"), false},
+		{"relative symlink should fail", ctx, filepath.Join(workspace, "docs", "_build", "x", "conf.py"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckPath(tt.ctx, tt.path)
+			if (err != nil) && tt.shouldPass {
+				t.Fatalf("should pass: got %v", err)
+			}
+			if (err == nil) && !tt.shouldPass {
+				t.Fatalf("should fail")
+			}
+		})
+	}
+}
@@ -1,12 +1,16 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/jeffbrennan/pdfgen/internal/config"
 	"github.com/jeffbrennan/pdfgen/internal/models"
 )
 
@@ -14,25 +18,90 @@ func LoadSecret(k string) (string, error) {
 	secretPath := fmt.Sprintf("/run/secrets/%s", k)
 	data, err := os.ReadFile(secretPath)
 	if err != nil {
-		log.Fatalf("failed to read secret from %s: %v", secretPath, err)
+		return "", fmt.Errorf("failed to read secret from %s: %v", secretPath, err)
 	}
 
-	secretValue := strings.TrimSpace(strings.Split(string(data), "=")[1])
-	return secretValue, nil
+	_, secretValue, ok := strings.Cut(string(data), "=")
+	if !ok {
+		return "", fmt.Errorf("secret %s is not KEY=value", secretPath)
+	}
+	return strings.TrimSpace(secretValue), nil
+}
+
+func RunCommand(ctx context.Context, args []string, workingDir string) ([]byte, error) {
+	return RunCommandEnv(ctx, args, workingDir, nil)
 }
 
-func RunCommand(args []string, workingDir string) ([]byte, error) {
-	cmd := exec.Command(args[0], args[1:]...)
+// RunCommandEnv runs a command with extra environment variables on top of the server's own.
+// Cancelling ctx kills the command along with every process it started, the Limits of
+// ctx apply to all of them. Commands run in the sandbox of ctx if it has one.
+func RunCommandEnv(ctx context.Context, args []string, workingDir string, env map[string]string) ([]byte, error) {
+	wrapped, environment, err := sandboxArgs(ctx, args, workingDir, env)
+	if err != nil {
+		return nil, err
+	}
+	limited := limitArgs(ctx, wrapped)
+	cmd := exec.CommandContext(ctx, limited[0], limited[1:]...)
 	if workingDir != "" {
 		cmd.Dir = workingDir
 	}
-	log.Printf("Executing: %s", strings.Join(cmd.Args, " "))
-	return cmd.Output()
+	cmd.Env = environment
+	setProcessGroup(cmd)
+	// a killed group closes its pipes, this only bounds a child that escaped it
+	cmd.WaitDelay = 10 * time.Second
+
+	log.Printf("Executing: %s", strings.Join(args, " "))
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return out, fmt.Errorf("%s stopped: %s", args[0], context.Cause(ctx))
+	}
+	if limit := exceededLimit(err); limit != "" {
+		return out, fmt.Errorf("%s exceeded limit %s", args[0], limit)
+	}
+	return out, err
+}
+
+type workspaceKey struct{}
+
+// sharedWorkspace holds the checkouts of builds that weren't given a workspace
+const sharedWorkspace = "shared"
+
+// WithWorkspace returns a copy of ctx whose builds check repos out under name, so jobs
+// building the same repo at once don't share a checkout
+func WithWorkspace(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, workspaceKey{}, name)
+}
+
+// CheckoutDir is where the repo of parts is checked out for the workspace of ctx, by
+// owner so forks of the same name don't share a checkout
+func CheckoutDir(ctx context.Context, cfg config.RepoConfig, parts *models.RepoParts) string {
+	workspace, _ := ctx.Value(workspaceKey{}).(string)
+	if workspace == "" {
+		workspace = sharedWorkspace
+	}
+	return filepath.Join(cfg.Dir, parts.Owner, parts.Repo, workspace)
 }
 
-func CleanupDir(parts *models.RepoParts) error {
-	repoPath := "/build/src/pdfgen/repos/" + parts.Repo
-	log.Printf("Cleaning up %s/%s/%s", parts.Provider, parts.Owner, parts.Repo)
-	_, err := RunCommand([]string{"rm", "-rf", repoPath}, "")
-	return err
+// CleanupDir removes the checkout of the workspace of ctx. ctx only names the workspace,
+// cancelled builds are cleaned up too.
+func CleanupDir(ctx context.Context, cfg config.RepoConfig, parts *models.RepoParts) error {
+	repoPath := CheckoutDir(ctx, cfg, parts)
+	log.Printf("Cleaning up %s/%s/%s in %s", parts.Provider, parts.Owner, parts.Repo, filepath.Base(repoPath))
+	err := os.RemoveAll(repoPath)
+	if err != nil {
+		return err
+	}
+	// the repo's and owner's directories go with their last checkout
+	os.Remove(filepath.Dir(repoPath))
+	os.Remove(filepath.Dir(filepath.Dir(repoPath)))
+	return nil
+}
+
+func Contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
 }
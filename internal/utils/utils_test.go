@@ -1,7 +1,13 @@
 package utils
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/jeffbrennan/pdfgen/internal/config"
+	"github.com/jeffbrennan/pdfgen/internal/models"
 )
 
 func TestValidateRepo(t *testing.T) {
@@ -25,3 +31,53 @@ func TestValidateRepo(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckoutDir(t *testing.T) {
+	cfg := config.RepoConfig{Dir: t.TempDir()}
+	parts := &models.RepoParts{Provider: "github.com", Owner: "apache", Repo: "airflow"}
+	first := WithWorkspace(context.Background(), "first")
+	second := WithWorkspace(context.Background(), "second")
+
+	var tests = []struct {
+		name     string
+		ctx      context.Context
+		expected string
+	}{
+		{"workspace", first, filepath.Join(cfg.Dir, "apache", "airflow", "first")},
+		{"other workspace", second, filepath.Join(cfg.Dir, "apache", "airflow", "second")},
+		{"without a workspace", context.Background(), filepath.Join(cfg.Dir, "apache", "airflow", "shared")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CheckoutDir(tt.ctx, cfg, parts); got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	// cleaning up one job's checkout leaves another job's checkout of the same repo alone
+	for _, ctx := range []context.Context{first, second} {
+		err := os.MkdirAll(CheckoutDir(ctx, cfg, parts), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := CleanupDir(first, cfg, parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(CheckoutDir(first, cfg, parts)); !os.IsNotExist(err) {
+		t.Errorf("expected the first checkout to be removed, got %v", err)
+	}
+	if _, err := os.Stat(CheckoutDir(second, cfg, parts)); err != nil {
+		t.Errorf("expected the second checkout to stay, got %v", err)
+	}
+
+	err = CleanupDir(second, cfg, parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Dir, "apache")); !os.IsNotExist(err) {
+		t.Errorf("expected the owner's directory to go with its last checkout, got %v", err)
+	}
+}